@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/batch"
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+
+	"github.com/spf13/cobra"
+)
+
+// batchCmd は、マニフェストに記載された複数のジョブを実行するコマンド定義です。
+var batchCmd = &cobra.Command{
+	Use:   "batch",
+	Short: "マニフェスト (JSONL) に記載された複数のジョブを実行します。",
+	Long: `
+マニフェスト (JSONL) に記載された複数のジョブを実行します。
+マニフェストの各行には、1件のジョブ (url_file, output と、任意で id, format, map_model,
+reduce_model, map_prompt, reduce_prompt, lang, topic, query, mode, citation_style,
+citation_policy, vars) を JSON で記載します。省略した項目には、このコマンドのフラグの値を使用します。
+
+ジョブの状態は --state-dir のディスク上のキューに記録されます。中断後に同じコマンドを再実行すると、
+完了済みのジョブを飛ばし、未完了のジョブから再開します。
+LLM 呼び出しとスクレイピングの同時実行数は、すべてのジョブで共有する上限
+(--llm-concurrency, --scrape-concurrency) で制限されます。
+`,
+	PreRunE: applyRunConfig,
+	RunE:    batchMainLogic,
+}
+
+// init関数でサブコマンド固有のフラグを定義します。
+func init() {
+	batchCmd.Flags().StringP("manifest", "m", "", "ジョブを1行ずつ記載したマニフェスト (JSONL) のパス")
+	batchCmd.Flags().String("state-dir", ".apg-batch", "ジョブの状態を記録するキューのディレクトリ")
+	batchCmd.Flags().String("summary", "", "ジョブごとの状態の集計 (JSON) の出力先 (省略時は <state-dir>/summary.json)")
+	batchCmd.Flags().Int("jobs", 2, "同時に実行するジョブの最大数")
+	batchCmd.Flags().Int("llm-concurrency", 8, "すべてのジョブで共有する LLM 呼び出しの最大同時実行数")
+	batchCmd.Flags().Int("scrape-concurrency", 10, "すべてのジョブで共有するスクレイピングの最大同時実行数")
+	batchCmd.Flags().Duration("job-timeout", defaultContextTimeout, "1件のジョブの最大実行時間")
+	batchCmd.Flags().Bool("retry-failed", false, "前回までの実行で失敗したジョブを再実行する")
+
+	addJobDefaultFlags(batchCmd)
+
+	batchCmd.MarkFlagRequired("manifest")
+}
+
+// newBatchConfigFromFlags は、batch サブコマンドのフラグから Runner の設定を生成します。
+func newBatchConfigFromFlags(cmd *cobra.Command) (batch.Config, error) {
+	flags := cmd.Flags()
+	jobs, err := flags.GetInt("jobs")
+	if err != nil {
+		return batch.Config{}, fmt.Errorf("jobsフラグの取得に失敗しました: %w", err)
+	}
+	llmConcurrency, err := flags.GetInt("llm-concurrency")
+	if err != nil {
+		return batch.Config{}, fmt.Errorf("llm-concurrencyフラグの取得に失敗しました: %w", err)
+	}
+	scrapeConcurrency, err := flags.GetInt("scrape-concurrency")
+	if err != nil {
+		return batch.Config{}, fmt.Errorf("scrape-concurrencyフラグの取得に失敗しました: %w", err)
+	}
+	jobTimeout, err := flags.GetDuration("job-timeout")
+	if err != nil {
+		return batch.Config{}, fmt.Errorf("job-timeoutフラグの取得に失敗しました: %w", err)
+	}
+	retryFailed, err := flags.GetBool("retry-failed")
+	if err != nil {
+		return batch.Config{}, fmt.Errorf("retry-failedフラグの取得に失敗しました: %w", err)
+	}
+
+	defaults, err := jobDefaultsFromFlags(cmd)
+	if err != nil {
+		return batch.Config{}, err
+	}
+
+	return batch.Config{
+		Jobs:               jobs,
+		LLMConcurrency:     llmConcurrency,
+		ScraperConcurrency: scrapeConcurrency,
+		JobTimeout:         jobTimeout,
+		RetryFailed:        retryFailed,
+		Defaults:           defaults,
+	}, nil
+}
+
+// batchMainLogic は batch サブコマンドのメインロジックを実行します。
+// SIGINT/SIGTERM を受け取ると実行中のジョブを中断し、次回の実行で再開できる状態でキューを保存して終了します。
+func batchMainLogic(cmd *cobra.Command, args []string) error {
+	cfg, err := newBatchConfigFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	manifestPath, err := cmd.Flags().GetString("manifest")
+	if err != nil {
+		return fmt.Errorf("manifestフラグの取得に失敗しました: %w", err)
+	}
+	stateDir, err := cmd.Flags().GetString("state-dir")
+	if err != nil {
+		return fmt.Errorf("state-dirフラグの取得に失敗しました: %w", err)
+	}
+	summaryPath, err := cmd.Flags().GetString("summary")
+	if err != nil {
+		return fmt.Errorf("summaryフラグの取得に失敗しました: %w", err)
+	}
+	if summaryPath == "" {
+		summaryPath = filepath.Join(stateDir, "summary.json")
+	}
+
+	entries, err := batch.LoadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	queue, err := batch.OpenQueue(stateDir)
+	if err != nil {
+		return err
+	}
+	runner, err := batch.NewRunner(cfg, queue)
+	if err != nil {
+		return err
+	}
+
+	stopTelemetry, err := startTelemetry(cmd)
+	if err != nil {
+		return err
+	}
+	defer stopTelemetry()
+
+	// SIGINT/SIGTERM で実行中のジョブを中断する (2回目のシグナルで強制終了)
+	ctx, stop := withInterrupt(cmd.Context())
+	defer stop()
+
+	states, runErr := runner.Run(ctx, entries)
+	if states == nil {
+		return runErr
+	}
+
+	summary := batch.NewSummary(states)
+	if err := batch.WriteSummary(summaryPath, summary); err != nil {
+		return err
+	}
+	for _, st := range summary.Jobs {
+		slog.Info("ジョブの状態", slog.String(logging.KeyJobID, st.ID), slog.String("status", st.Status), slog.Bool("skipped", st.Skipped), slog.String("output", st.Output), slog.String("error", st.Error))
+	}
+	slog.Info("バッチ処理の集計を書き出しました。", slog.String("path", summaryPath), slog.Int("succeeded", summary.Succeeded), slog.Int("failed", summary.Failed), slog.Int("pending", summary.Pending))
+
+	switch {
+	case errors.Is(runErr, context.Canceled):
+		if ie, ok := interruption(ctx); ok {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("バッチ処理が中断されました。同じコマンドを再実行すると未完了のジョブから再開します: %w", ie)
+		}
+		return fmt.Errorf("バッチ処理が中断されました。同じコマンドを再実行すると未完了のジョブから再開します")
+	case runErr != nil:
+		return runErr
+	case summary.Failed > 0:
+		return fmt.Errorf("%d件のジョブが失敗しました (詳細: %s)", summary.Failed, summaryPath)
+	}
+	return nil
+}
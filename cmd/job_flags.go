@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+
+	"github.com/spf13/cobra"
+)
+
+// addJobDefaultFlags は、serve と batch に共通する、ジョブで省略された項目の既定値のフラグを定義します (run と同じ意味のフラグ)。
+func addJobDefaultFlags(cmd *cobra.Command) {
+	cmd.Flags().DurationP("llm-timeout", "t", 5*time.Minute, "LLM処理のタイムアウト時間")
+	cmd.Flags().DurationP("scraper-timeout", "s", 15*time.Second, "WebスクレイピングのHTTPタイムアウト時間")
+	cmd.Flags().StringP("api-key", "k", "", "Gemini APIキー (環境変数 GEMINI_API_KEY が優先)")
+	cmd.Flags().IntP("parallel", "p", 5, "ジョブごとのWebスクレイピングの最大同時並列リクエスト数")
+	cmd.Flags().String("map-model", defaultMapModelName, "ジョブで省略された場合に Mapフェーズ に使用するAIモデル名")
+	cmd.Flags().String("reduce-model", defaultReduceModelName, "ジョブで省略された場合に Reduceフェーズ に使用するAIモデル名")
+	cmd.Flags().Int("map-concurrency", cleaner.DefaultMaxMapConcurrency, "ジョブごとの Mapフェーズ のLLM最大同時実行数")
+	cmd.Flags().String("lang", prompts.DefaultLanguage, "ジョブで省略された場合の出力言語")
+	cmd.Flags().String("citation-policy", cleaner.DefaultCitationPolicy, "ジョブで省略された場合の引用URLの扱い (flag, remove, off)")
+	cmd.Flags().String("citation-style", cleaner.CitationStyleSection, "ジョブで省略された場合の出典の表記方式 (section, footnote)")
+	cmd.Flags().String("mode", cleaner.ModeAuto, "ジョブで省略された場合の処理モード (auto, mapreduce, single)")
+	cmd.Flags().Int("single-pass-max-chars", 0, "auto モードで単一パスを選択する全ソースの合計文字数の上限 (0 の場合は Reduce モデルの入力トークン数の上限の 1/4)")
+	cmd.Flags().String("response-policy", cleaner.DefaultResponsePolicy, "応答がブロック・途中終了・空だった場合に順に適用するアクション (カンマ区切り)")
+	cmd.Flags().String("profile", "", "使用する設定プロファイル名 (組み込み: fast, quality)")
+}
+
+// jobDefaultsFromFlags は、addJobDefaultFlags で定義したフラグからジョブの既定の CmdOptions を生成します。
+// モデル名・モード・引用設定は、ジョブの値と合わせて受け付け時に検証します。
+func jobDefaultsFromFlags(cmd *cobra.Command) (pipeline.CmdOptions, error) {
+	flags := cmd.Flags()
+	llmTimeout, err := flags.GetDuration("llm-timeout")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("llm-timeoutフラグの取得に失敗しました: %w", err)
+	}
+	scraperTimeout, err := flags.GetDuration("scraper-timeout")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("scraper-timeoutフラグの取得に失敗しました: %w", err)
+	}
+	llmAPIKey, err := flags.GetString("api-key")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("api-keyフラグの取得に失敗しました: %w", err)
+	}
+	maxScraperParallel, err := flags.GetInt("parallel")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("parallelフラグの取得に失敗しました: %w", err)
+	}
+	if maxScraperParallel < 1 {
+		return pipeline.CmdOptions{}, fmt.Errorf("--parallel には1以上の値を指定する必要があります")
+	}
+	mapModel, err := flags.GetString("map-model")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("map-modelフラグの取得に失敗しました: %w", err)
+	}
+	reduceModel, err := flags.GetString("reduce-model")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("reduce-modelフラグの取得に失敗しました: %w", err)
+	}
+	mapConcurrency, err := flags.GetInt("map-concurrency")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("map-concurrencyフラグの取得に失敗しました: %w", err)
+	}
+	if mapConcurrency < 1 {
+		return pipeline.CmdOptions{}, fmt.Errorf("--map-concurrency には1以上の値を指定する必要があります")
+	}
+	lang, err := flags.GetString("lang")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("langフラグの取得に失敗しました: %w", err)
+	}
+	citationPolicy, err := flags.GetString("citation-policy")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("citation-policyフラグの取得に失敗しました: %w", err)
+	}
+	citationStyle, err := flags.GetString("citation-style")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("citation-styleフラグの取得に失敗しました: %w", err)
+	}
+	mode, err := flags.GetString("mode")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("modeフラグの取得に失敗しました: %w", err)
+	}
+	singlePassMaxChars, err := flags.GetInt("single-pass-max-chars")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("single-pass-max-charsフラグの取得に失敗しました: %w", err)
+	}
+	if singlePassMaxChars < 0 {
+		return pipeline.CmdOptions{}, fmt.Errorf("--single-pass-max-chars には0以上の値を指定する必要があります")
+	}
+	responsePolicySpec, err := flags.GetString("response-policy")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("response-policyフラグの取得に失敗しました: %w", err)
+	}
+	responsePolicy, err := cleaner.ParseResponsePolicy(responsePolicySpec)
+	if err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+
+	return pipeline.CmdOptions{
+		LLMAPIKey:          llmAPIKey,
+		LLMTimeout:         llmTimeout,
+		ScraperTimeout:     scraperTimeout,
+		MaxScraperParallel: maxScraperParallel,
+		MapModel:           mapModel,
+		ReduceModel:        reduceModel,
+		MapConcurrency:     mapConcurrency,
+		Language:           lang,
+		CitationPolicy:     citationPolicy,
+		CitationStyle:      citationStyle,
+		Mode:               mode,
+		SinglePassMaxChars: singlePassMaxChars,
+		ResponsePolicy:     responsePolicy,
+	}, nil
+}
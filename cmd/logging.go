@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+
+	"github.com/shouni/go-cli-base"
+	"github.com/spf13/cobra"
+)
+
+// addLogFlags は、ログの出力形式・レベル・出力先の永続フラグを追加します。
+func addLogFlags(rootCmd *cobra.Command) {
+	rootCmd.PersistentFlags().String("log-format", logging.FormatText, "ログの出力形式 (text, json)")
+	rootCmd.PersistentFlags().String("log-level", "info", "出力するログの最低レベル (debug, info, warn, error。--verbose 指定時の既定値は debug)")
+	rootCmd.PersistentFlags().String("log-file", "", "ログの出力先ファイル (追記。空の場合は標準エラー出力)")
+}
+
+// setupLogging は、--log-format, --log-level, --log-file に従って slog のデフォルトロガーを設定します。
+func setupLogging(cmd *cobra.Command) error {
+	format, err := cmd.Flags().GetString("log-format")
+	if err != nil {
+		return fmt.Errorf("log-formatフラグの取得に失敗しました: %w", err)
+	}
+	level, err := cmd.Flags().GetString("log-level")
+	if err != nil {
+		return fmt.Errorf("log-levelフラグの取得に失敗しました: %w", err)
+	}
+	file, err := cmd.Flags().GetString("log-file")
+	if err != nil {
+		return fmt.Errorf("log-fileフラグの取得に失敗しました: %w", err)
+	}
+	if clibase.Flags.Verbose && !cmd.Flags().Changed("log-level") {
+		level = "debug"
+	}
+
+	if err := logging.Setup(logging.Config{
+		Format:    format,
+		Level:     level,
+		File:      file,
+		AddSource: clibase.Flags.Verbose,
+	}); err != nil {
+		return err
+	}
+	if clibase.Flags.Verbose {
+		slog.Debug("Verbose mode enabled.")
+	}
+	return nil
+}
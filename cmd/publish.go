@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+
+	"github.com/spf13/cobra"
+)
+
+// publishCmd は、生成済みのMarkdownをLLMを再実行せずに出力し直すコマンド定義です。
+var publishCmd = &cobra.Command{
+	Use:   "publish",
+	Short: "生成済みのMarkdownファイルを指定の出力先・形式で再出力します。",
+	Long: `
+生成済みのMarkdownファイルを、LLMを再実行せずに指定の出力先・形式で再出力します。
+-iまたは--inputオプションで入力Markdown (ローカルパスまたはGCS URI) を指定してください。
+
+-oまたは--outputオプションでローカルパスまたはGCS URI (gs://...) を指定できます。
+--formatオプションで出力形式 (auto, markdown, html) を指定できます。
+`,
+	RunE: publishMainLogic,
+}
+
+// init関数でサブコマンド固有のフラグを定義します。
+func init() {
+	publishCmd.Flags().StringP("input", "i", "", "再出力する生成済みMarkdownファイルのパス (ローカルまたはGCS URI)")
+	publishCmd.Flags().StringP("output", "o", "", "出力先のファイルパスまたはGCS URI (\"-\" で標準出力へ全文を出力、省略時は標準出力にプレビュー)")
+	publishCmd.Flags().String("format", pipeline.FormatAuto, "出力形式 (auto, markdown, html)。auto はGCSまたは拡張子 .html の場合にHTMLを出力")
+
+	publishCmd.MarkFlagRequired("input")
+}
+
+// publishMainLogic は publish サブコマンドのメインロジックを実行します。
+func publishMainLogic(cmd *cobra.Command, args []string) error {
+	inputPath, err := cmd.Flags().GetString("input")
+	if err != nil {
+		return fmt.Errorf("inputフラグの取得に失敗しました: %w", err)
+	}
+	outputFilePath, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("outputフラグの取得に失敗しました: %w", err)
+	}
+	outputFormat, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return fmt.Errorf("formatフラグの取得に失敗しました: %w", err)
+	}
+	if _, err := pipeline.ResolveOutputFormat(outputFilePath, outputFormat); err != nil {
+		return err
+	}
+
+	opts := pipeline.CmdOptions{
+		OutputFilePath: outputFilePath,
+		OutputFormat:   outputFormat,
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), defaultContextTimeout)
+	defer cancel()
+
+	publisher, reader, closer, err := builder.BuildPublisher(ctx)
+	if closer != nil {
+		defer closer()
+	}
+	if err != nil {
+		return fmt.Errorf("Publisherの構築に失敗しました: %w", err)
+	}
+
+	markdown, err := pipeline.ReadMarkdown(ctx, reader, inputPath)
+	if err != nil {
+		return err
+	}
+
+	if err := publisher.Publish(ctx, opts, markdown); err != nil {
+		return fmt.Errorf("%sでエラーが発生しました: %w", pipeline.PhasePublish, err)
+	}
+	return nil
+}
@@ -15,7 +15,12 @@ func Execute() {
 	// CustomFlagFunc: アプリ固有の永続フラグを追加する関数
 	// CustomPreRunEFunc: PersistentPreRunEに追加するアプリ固有のロジック
 
-	clibase.Execute("action-perfect-get-on-go", nil, createPreRunE(nil), runCmd)
+	// 中断 (シグナル) を終了コードに反映するため、clibase.Execute ではなくルートコマンドを直接実行する
+	rootCmd := clibase.NewRootCmd("action-perfect-get-on-go", addGlobalFlags, createPreRunE(nil))
+	rootCmd.AddCommand(runCmd, publishCmd, serveCmd, batchCmd)
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(exitCode(err))
+	}
 }
 
 // init関数でサブコマンドの定義とフラグの設定を行う
@@ -23,18 +28,20 @@ func init() {
 
 }
 
+// addGlobalFlags は、すべてのサブコマンドで利用できるアプリケーション固有の永続フラグを追加します。
+func addGlobalFlags(rootCmd *cobra.Command) {
+	addLogFlags(rootCmd)
+	addTelemetryFlags(rootCmd)
+}
+
 // createPreRunE は、clibase共通のPersistentPreRunEロジックとアプリケーション固有のロジックを結合した関数を作成します。
 // clibaseパッケージで定義された関数ですが、もしここに追加の共通ロジックが必要な場合は再定義します。
 func createPreRunE(preRunE func(cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) error {
 	return func(cmd *cobra.Command, args []string) error {
-		// clibase 共通の PersistentPreRun 処理
-		if clibase.Flags.Verbose {
-			// Verboseモードではファイル名と行番号を含む詳細なログを出力
-			log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-			log.Println("INFO: Verbose mode enabled.")
-		} else {
-			// 通常モードでは日付と時刻のみを出力
-			log.SetFlags(log.Ldate | log.Ltime)
+		// clibase 共通の PersistentPreRun 処理: ログの出力形式・レベル・出力先を設定する
+		// (Verbose モードでは、--log-level が指定されていなければ debug レベルとソースの位置を出力する)
+		if err := setupLogging(cmd); err != nil {
+			return err
 		}
 
 		// アプリケーション固有の PersistentPreRunE 処理を実行
@@ -3,11 +3,19 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"os"
+	"strings"
 	"time"
 
-	"action-perfect-get-on-go/internal/builder"
-	"action-perfect-get-on-go/internal/pipeline"
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/config"
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
 
+	"github.com/shouni/go-cli-base"
 	"github.com/spf13/cobra"
 )
 
@@ -20,6 +28,12 @@ const defaultMapModelName = "gemini-2.5-flash"
 // Reduceフェーズ (最終構造化) のデフォルトモデル: 品質と論理性を優先
 const defaultReduceModelName = "gemini-2.5-pro"
 
+// --run-manifest の特別な値: auto は出力ファイルの隣に書き出し、off は書き出さない
+const (
+	runManifestAuto = "auto"
+	runManifestOff  = "off"
+)
+
 // runCmd は、メインのCLIコマンド定義です。
 var runCmd = &cobra.Command{
 	Use:   "run",
@@ -28,10 +42,15 @@ var runCmd = &cobra.Command{
 Webコンテンツの取得とAIクリーンアップを実行します。
 実行には、-fまたは--url-fileオプションでURLリストファイルを指定してください。
 
+-Cまたは--configオプションで設定ファイル (YAML/TOML) を、--profileオプションで
+名前付きプロファイル (組み込み: fast, quality) を指定できます。
+設定値の優先順位は「フラグ > 環境変数 (APG_*) > プロファイル > 設定ファイル > デフォルト値」です。
+
 -oまたは--outputオプションで出力ファイルパスを指定すると、ファイルに書き込まれ、
 標準出力には冒頭のプレビューが表示されます。指定しない場合は標準出力に出力されます。
 `,
-	RunE: runMainLogic,
+	PreRunE: applyRunConfig,
+	RunE:    runMainLogic,
 }
 
 // init関数でサブコマンド固有のフラグを定義します。
@@ -41,12 +60,66 @@ func init() {
 	runCmd.Flags().DurationP("scraper-timeout", "s", 15*time.Second, "WebスクレイピングのHTTPタイムアウト時間")
 	runCmd.Flags().StringP("api-key", "k", "", "Gemini APIキー (環境変数 GEMINI_API_KEY が優先)")
 	runCmd.Flags().StringP("url-file", "f", "", "処理対象のURLリストを記載したファイルパス")
-	runCmd.Flags().StringP("output", "o", "./output/output_reduce_final.md", "最終的な構造化Markdownを出力するファイルパス (省略時は標準出力)")
+	runCmd.Flags().StringP("output", "o", "./output/output_reduce_final.md", "最終的な構造化Markdownを出力するファイルパスまたはGCS URI (\"-\" で標準出力へ全文をストリーミング出力、空文字で標準出力にプレビュー)")
+	runCmd.Flags().String("format", pipeline.FormatAuto, "出力形式 (auto, markdown, html)。auto はGCSまたは拡張子 .html の場合にHTMLを出力")
 	runCmd.Flags().IntP("parallel", "p", 5, "Webスクレイピングの最大同時並列リクエスト数")
-	runCmd.Flags().String("map-model", defaultMapModelName, "Mapフェーズ に使用するAIモデル名")
-	runCmd.Flags().String("reduce-model", defaultReduceModelName, "Reduceフェーズ に使用するAIモデル名")
+	runCmd.Flags().String("map-model", defaultMapModelName, "Mapフェーズ に使用するAIモデル名 (カンマ区切りで失敗時のフォールバック順を指定可)")
+	runCmd.Flags().String("reduce-model", defaultReduceModelName, "Reduceフェーズ に使用するAIモデル名 (カンマ区切りで失敗時のフォールバック順を指定可。例: gemini-2.5-pro,gemini-2.5-flash)")
+	runCmd.Flags().Int("map-concurrency", cleaner.DefaultMaxMapConcurrency, "Mapフェーズ のLLM最大同時実行数")
+	runCmd.Flags().String("map-prompt", "", "Mapフェーズ のカスタムプロンプトテンプレートのパス (ローカルまたはGCS URI)")
+	runCmd.Flags().String("reduce-prompt", "", "Reduceフェーズ のカスタムプロンプトテンプレートのパス (ローカルまたはGCS URI)")
+	runCmd.Flags().String("lang", prompts.DefaultLanguage, "最終文書の出力言語 (例: ja, en, zh。言語名の直接指定も可)")
+	runCmd.Flags().String("topic", "", "文書のトピック (テンプレート変数 {{.Topic}} として参照可能)")
+	runCmd.Flags().StringP("query", "q", "", "調査クエリ。指定すると、関連情報のみを抽出し質問への回答を中心とした文書を生成します")
+	runCmd.Flags().StringArray("var", nil, "テンプレートに渡す任意の変数 (key=value 形式、複数指定可。{{.Vars.key}} で参照)")
+	runCmd.Flags().String("citation-policy", cleaner.DefaultCitationPolicy, "ソースに存在しない引用URLの扱い (flag: 警告マークを付与, remove: 削除して削除済みのマークを残す, off: 検証しない)")
+	runCmd.Flags().String("citation-style", cleaner.CitationStyleSection, "出典の表記方式 (section: セクションごとの関連URL, footnote: 主張ごとの脚注と参考文献セクション)")
+	runCmd.Flags().String("mode", cleaner.ModeAuto, "処理モード (auto: 全ソースが単一パスの上限以下で、カスタムプロンプトとルーティングの指定がなければ単一パス, mapreduce: 常に Map と Reduce, single: 常に単一パス)")
+	runCmd.Flags().Int("single-pass-max-chars", 0, "auto モードで単一パスを選択する全ソースの合計文字数の上限 (0 の場合は Reduce モデルの入力トークン数の上限の 1/4)")
+	runCmd.Flags().StringArray("route", nil, "モデルのルーティングルール (key=value をカンマ区切り、複数指定可、定義順に評価)。例: \"domains=docs.example.com,model=gemini-2.5-pro\", \"max_chars=3000,skip_map=true\"")
+	runCmd.Flags().String("response-policy", cleaner.DefaultResponsePolicy, "応答がブロック・途中終了・空だった場合に順に適用するアクション (カンマ区切り。retry: 設定を変えて再試行, relax-safety: セーフティ設定を緩和して再試行 (明示的に指定した場合のみ), split: セグメントを分割, skip: 除外して文書末尾に注記, fail: エラー終了)")
+	runCmd.Flags().String("record", "", "スクレイピング結果と LLM のリクエスト・レスポンスを記録するカセットのディレクトリ")
+	runCmd.Flags().String("replay", "", "記録済みのカセットのディレクトリ。スクレイピングと LLM 呼び出しをネットワークにアクセスせずに再生します")
+	runCmd.Flags().String("run-manifest", runManifestAuto, "実行記録 (JSON) の書き出し先 (auto: 出力ファイルの隣に <出力名>.manifest.json, off: 書き出さない, それ以外: ローカルパスまたはGCS URI)")
+	runCmd.Flags().String("profile", "", "使用する設定プロファイル名 (組み込み: fast, quality)")
+}
+
+// applyRunConfig は、設定ファイル・プロファイル・環境変数の値を、明示されていないフラグに適用します。
+// 適用対象はサブコマンド自身のフラグのみで、ルートの永続フラグ (--log-*, --otel-*, --config など) は対象外です。
+// url-file なども設定ファイルから供給できるよう、必須チェックは newCmdOptionsFromFlags で行います。
+func applyRunConfig(cmd *cobra.Command, args []string) error {
+	configPath := clibase.Flags.ConfigFile
+	if configPath == "" {
+		configPath = os.Getenv(config.EnvName("config"))
+	}
+
+	var file *config.File
+	if configPath != "" {
+		f, err := config.Load(configPath)
+		if err != nil {
+			return err
+		}
+		file = f
+		slog.Info("設定ファイルを読み込みました。", slog.String("path", configPath))
+	}
+
+	profile, err := cmd.Flags().GetString("profile")
+	if err != nil {
+		return fmt.Errorf("profileフラグの取得に失敗しました: %w", err)
+	}
+	if profile == "" {
+		profile = os.Getenv(config.EnvName("profile"))
+	}
+
+	settings, err := config.Resolve(file, profile)
+	if err != nil {
+		return err
+	}
+	if profile != "" {
+		slog.Info("設定プロファイルを適用します。", slog.String("profile", profile))
+	}
 
-	runCmd.MarkFlagRequired("url-file")
+	return config.Apply(cmd.LocalNonPersistentFlags(), settings, os.Getenv)
 }
 
 // newCmdOptionsFromFlags は cobra.Command のフラグから CmdOptions 構造体を生成します。
@@ -68,10 +141,20 @@ func newCmdOptionsFromFlags(cmd *cobra.Command) (pipeline.CmdOptions, error) {
 	if err != nil {
 		return pipeline.CmdOptions{}, fmt.Errorf("url-fileフラグの取得に失敗しました: %w", err)
 	}
+	if urlFile == "" {
+		return pipeline.CmdOptions{}, fmt.Errorf("--url-file (または設定ファイルの url_file) でURLリストファイルを指定する必要があります")
+	}
 	outputFilePath, err := cmd.Flags().GetString("output")
 	if err != nil {
 		return pipeline.CmdOptions{}, fmt.Errorf("outputフラグの取得に失敗しました: %w", err)
 	}
+	outputFormat, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("formatフラグの取得に失敗しました: %w", err)
+	}
+	if _, err := pipeline.ResolveOutputFormat(outputFilePath, outputFormat); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
 	maxScraperParallel, err := cmd.Flags().GetInt("parallel")
 	if err != nil {
 		return pipeline.CmdOptions{}, fmt.Errorf("parallelフラグの取得に失敗しました: %w", err)
@@ -90,12 +173,127 @@ func newCmdOptionsFromFlags(cmd *cobra.Command) (pipeline.CmdOptions, error) {
 		return pipeline.CmdOptions{}, fmt.Errorf("reduce-modelフラグの取得に失敗しました: %w", err)
 	}
 
+	mapConcurrency, err := cmd.Flags().GetInt("map-concurrency")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("map-concurrencyフラグの取得に失敗しました: %w", err)
+	}
+	if mapConcurrency < 1 {
+		return pipeline.CmdOptions{}, fmt.Errorf("--map-concurrency には1以上の値を指定する必要があります")
+	}
+
+	mapPromptPath, err := cmd.Flags().GetString("map-prompt")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("map-promptフラグの取得に失敗しました: %w", err)
+	}
+	reducePromptPath, err := cmd.Flags().GetString("reduce-prompt")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("reduce-promptフラグの取得に失敗しました: %w", err)
+	}
+
+	lang, err := cmd.Flags().GetString("lang")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("langフラグの取得に失敗しました: %w", err)
+	}
+
+	topic, err := cmd.Flags().GetString("topic")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("topicフラグの取得に失敗しました: %w", err)
+	}
+	query, err := cmd.Flags().GetString("query")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("queryフラグの取得に失敗しました: %w", err)
+	}
+	rawVars, err := cmd.Flags().GetStringArray("var")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("varフラグの取得に失敗しました: %w", err)
+	}
+	templateVars, err := parseTemplateVars(rawVars)
+	if err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+
+	citationPolicy, err := cmd.Flags().GetString("citation-policy")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("citation-policyフラグの取得に失敗しました: %w", err)
+	}
+	if err := cleaner.ValidateCitationPolicy(citationPolicy); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+
+	citationStyle, err := cmd.Flags().GetString("citation-style")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("citation-styleフラグの取得に失敗しました: %w", err)
+	}
+	if err := cleaner.ValidateCitationStyle(citationStyle); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+
+	mode, err := cmd.Flags().GetString("mode")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("modeフラグの取得に失敗しました: %w", err)
+	}
+	if err := cleaner.ValidateMode(mode); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+	singlePassMaxChars, err := cmd.Flags().GetInt("single-pass-max-chars")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("single-pass-max-charsフラグの取得に失敗しました: %w", err)
+	}
+	if singlePassMaxChars < 0 {
+		return pipeline.CmdOptions{}, fmt.Errorf("--single-pass-max-chars には0以上の値を指定する必要があります")
+	}
+
+	responsePolicySpec, err := cmd.Flags().GetString("response-policy")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("response-policyフラグの取得に失敗しました: %w", err)
+	}
+	responsePolicy, err := cleaner.ParseResponsePolicy(responsePolicySpec)
+	if err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+
+	recordDir, err := cmd.Flags().GetString("record")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("recordフラグの取得に失敗しました: %w", err)
+	}
+	replayDir, err := cmd.Flags().GetString("replay")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("replayフラグの取得に失敗しました: %w", err)
+	}
+	if recordDir != "" && replayDir != "" {
+		return pipeline.CmdOptions{}, fmt.Errorf("--record と --replay は同時に指定できません")
+	}
+
+	routeSpecs, err := cmd.Flags().GetStringArray("route")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("routeフラグの取得に失敗しました: %w", err)
+	}
+	routingRules := make([]cleaner.RoutingRule, 0, len(routeSpecs))
+	for _, spec := range routeSpecs {
+		rule, err := cleaner.ParseRoutingRule(spec)
+		if err != nil {
+			return pipeline.CmdOptions{}, err
+		}
+		routingRules = append(routingRules, rule)
+	}
+
+	runManifest, err := cmd.Flags().GetString("run-manifest")
+	if err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("run-manifestフラグの取得に失敗しました: %w", err)
+	}
+
 	if mapModel == "" {
 		return pipeline.CmdOptions{}, fmt.Errorf("--map-model には空でないAIモデル名を指定する必要があります")
 	}
 	if reduceModel == "" {
 		return pipeline.CmdOptions{}, fmt.Errorf("--reduce-model には空でないAIモデル名を指定する必要があります")
 	}
+	if err := cleaner.ValidateModelChain(mapModel); err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("--map-model: %w", err)
+	}
+	if err := cleaner.ValidateModelChain(reduceModel); err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("--reduce-model: %w", err)
+	}
 	// 構造体の初期化
 	opts := pipeline.CmdOptions{
 		LLMAPIKey:          llmAPIKey,
@@ -103,14 +301,63 @@ func newCmdOptionsFromFlags(cmd *cobra.Command) (pipeline.CmdOptions, error) {
 		ScraperTimeout:     scraperTimeout,
 		URLFile:            urlFile,
 		OutputFilePath:     outputFilePath,
+		OutputFormat:       outputFormat,
 		MaxScraperParallel: maxScraperParallel,
 		MapModel:           mapModel,
 		ReduceModel:        reduceModel,
+		MapConcurrency:     mapConcurrency,
+		MapPromptPath:      mapPromptPath,
+		ReducePromptPath:   reducePromptPath,
+		Language:           lang,
+		Topic:              topic,
+		Query:              strings.TrimSpace(query),
+		CitationPolicy:     citationPolicy,
+		CitationStyle:      citationStyle,
+		RoutingRules:       routingRules,
+		Mode:               mode,
+		SinglePassMaxChars: singlePassMaxChars,
+		ResponsePolicy:     responsePolicy,
+		RecordDir:          recordDir,
+		ReplayDir:          replayDir,
+		TemplateVars:       templateVars,
+		RunID:              pipeline.NewRunID(),
+		RunManifestPath:    resolveRunManifestPath(runManifest, outputFilePath),
 	}
 
 	return opts, nil
 }
 
+// resolveRunManifestPath は、--run-manifest の値から実行記録の書き出し先を決定します。
+// auto の場合は出力ファイルの隣 (出力が標準出力・プレビューの場合は書き出さない)、off の場合は空を返します。
+func resolveRunManifestPath(value, outputFilePath string) string {
+	switch strings.TrimSpace(value) {
+	case runManifestAuto, "":
+		return pipeline.DefaultManifestPath(outputFilePath)
+	case runManifestOff:
+		return ""
+	default:
+		return value
+	}
+}
+
+// parseTemplateVars は、--var で指定された key=value 形式の値をマップに変換します。
+// 同じキーが複数回指定された場合は、後の値が優先されます。
+func parseTemplateVars(rawVars []string) (map[string]string, error) {
+	vars := make(map[string]string, len(rawVars))
+	for _, raw := range rawVars {
+		key, value, ok := strings.Cut(raw, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("--var の形式が不正です: %q (key=value 形式で指定してください)", raw)
+		}
+		if err := prompts.ValidateVarKey(key); err != nil {
+			return nil, fmt.Errorf("--var: %w", err)
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
 // runMainLogicはCLIのメインロジックを実行し、フラグをAppに渡します。
 // フラグ取得処理は newCmdOptionsFromFlags に抽出されています。
 func runMainLogic(cmd *cobra.Command, args []string) error {
@@ -120,8 +367,21 @@ func runMainLogic(cmd *cobra.Command, args []string) error {
 		return err // フラグ取得エラーを直接返す
 	}
 
+	// このプロセスのすべてのログに実行IDを付与する
+	slog.SetDefault(slog.Default().With(slog.String(logging.KeyRunID, opts.RunID)))
+
+	stopTelemetry, err := startTelemetry(cmd)
+	if err != nil {
+		return err
+	}
+	defer stopTelemetry()
+
+	// SIGINT/SIGTERM で実行中の LLM 呼び出しとスクレイピングを中断する (2回目のシグナルで強制終了)
+	ctx, stop := withInterrupt(cmd.Context())
+	defer stop()
+
 	// LLMTimeout を含む、パイプライン全体の実行コンテキストを作成
-	ctx, cancel := context.WithTimeout(cmd.Context(), defaultContextTimeout)
+	ctx, cancel := context.WithTimeout(ctx, defaultContextTimeout)
 	defer cancel()
 
 	// 2. パイプラインの構築
@@ -136,6 +396,11 @@ func runMainLogic(cmd *cobra.Command, args []string) error {
 
 	// 3. パイプラインの実行
 	if err := p.Execute(ctx); err != nil {
+		if ie, ok := interruption(ctx); ok {
+			// 完了済みの Map の結果は、出力先の隣の部分結果ファイルに書き出されている
+			cmd.SilenceUsage = true
+			return fmt.Errorf("パイプラインの実行が中断されました: %w", ie)
+		}
 		return fmt.Errorf("パイプラインの実行中にエラーが発生しました: %w", err)
 	}
 
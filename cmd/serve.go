@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+	"github.com/shouni/action-perfect-get-on-go/internal/server"
+
+	"github.com/spf13/cobra"
+)
+
+// サーバー停止時に、処理中のHTTPリクエストの完了を待つ最大時間
+const serverShutdownTimeout = 10 * time.Second
+
+// serveCmd は、パイプラインをHTTPのジョブAPIとして公開するコマンド定義です。
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "パイプラインをHTTPのジョブAPIとして公開します。",
+	Long: `
+パイプラインをHTTPのジョブAPIとして公開します。
+
+POST /jobs でURLリストとプロンプト・モデルを指定してジョブを登録し、
+GET /jobs/{id} で進捗 (フェーズ、完了セグメント数) を、
+GET /jobs/{id}/result?format=markdown|html|json で結果を取得します。
+DELETE /jobs/{id} でジョブをキャンセルできます。
+
+ジョブは --workers で指定した数のワーカーで実行され、最大 --queue-size 件まで待機できます。
+完了したジョブは --job-ttl の間保持されます。
+すべてのリクエストには "Authorization: Bearer <トークン>" ヘッダーが必要です。
+トークンは --auth-token または環境変数 APG_AUTH_TOKEN で指定します。
+リクエストで省略した項目には、このコマンドのフラグ (設定ファイル・プロファイル・環境変数を含む) の値を使用します。
+`,
+	PreRunE: applyRunConfig,
+	RunE:    serveMainLogic,
+}
+
+// init関数でサブコマンド固有のフラグを定義します。
+func init() {
+	serveCmd.Flags().String("addr", "127.0.0.1:8080", "HTTPサーバーの待ち受けアドレス (外部に公開する場合は :8080 などを指定)")
+	serveCmd.Flags().String("auth-token", "", "APIのリクエストに要求するベアラートークン (必須。環境変数 APG_AUTH_TOKEN でも指定可)")
+	serveCmd.Flags().Int("workers", 2, "同時に実行するジョブの最大数")
+	serveCmd.Flags().Int("queue-size", 16, "実行待ちにできるジョブの最大数 (超えた場合は 503 を返す)")
+	serveCmd.Flags().Duration("job-timeout", defaultContextTimeout, "1件のジョブの最大実行時間")
+	serveCmd.Flags().Duration("job-ttl", time.Hour, "完了したジョブの状態と結果を保持する時間")
+
+	addJobDefaultFlags(serveCmd)
+}
+
+// newServerConfigFromFlags は、serve サブコマンドのフラグから JobManager の設定を生成します。
+func newServerConfigFromFlags(cmd *cobra.Command) (server.Config, error) {
+	flags := cmd.Flags()
+	workers, err := flags.GetInt("workers")
+	if err != nil {
+		return server.Config{}, fmt.Errorf("workersフラグの取得に失敗しました: %w", err)
+	}
+	queueSize, err := flags.GetInt("queue-size")
+	if err != nil {
+		return server.Config{}, fmt.Errorf("queue-sizeフラグの取得に失敗しました: %w", err)
+	}
+	jobTimeout, err := flags.GetDuration("job-timeout")
+	if err != nil {
+		return server.Config{}, fmt.Errorf("job-timeoutフラグの取得に失敗しました: %w", err)
+	}
+	jobTTL, err := flags.GetDuration("job-ttl")
+	if err != nil {
+		return server.Config{}, fmt.Errorf("job-ttlフラグの取得に失敗しました: %w", err)
+	}
+
+	defaults, err := jobDefaultsFromFlags(cmd)
+	if err != nil {
+		return server.Config{}, err
+	}
+
+	return server.Config{
+		Workers:    workers,
+		QueueSize:  queueSize,
+		JobTimeout: jobTimeout,
+		JobTTL:     jobTTL,
+		Defaults:   defaults,
+	}, nil
+}
+
+// serveMainLogic は serve サブコマンドのメインロジックを実行します。
+// SIGINT/SIGTERM を受け取ると、新規リクエストの受け付けを停止し、実行中と待機中のジョブをキャンセルして終了します。
+func serveMainLogic(cmd *cobra.Command, args []string) error {
+	cfg, err := newServerConfigFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	addr, err := cmd.Flags().GetString("addr")
+	if err != nil {
+		return fmt.Errorf("addrフラグの取得に失敗しました: %w", err)
+	}
+	token, err := cmd.Flags().GetString("auth-token")
+	if err != nil {
+		return fmt.Errorf("auth-tokenフラグの取得に失敗しました: %w", err)
+	}
+	if token == "" {
+		return fmt.Errorf("--auth-token または環境変数 APG_AUTH_TOKEN で、APIのリクエストに要求する認証トークンを指定してください")
+	}
+
+	stopTelemetry, err := startTelemetry(cmd)
+	if err != nil {
+		return err
+	}
+	defer stopTelemetry()
+
+	// SIGINT/SIGTERM で新規リクエストの受け付けを停止し、実行中のジョブをキャンセルする (2回目のシグナルで強制終了)
+	ctx, stop := withInterrupt(cmd.Context())
+	defer stop()
+
+	renderer, err := builder.NewHTMLRenderer()
+	if err != nil {
+		return err
+	}
+	manager, err := server.NewJobManager(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           server.NewHandler(manager, renderer, token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("HTTPサーバーを起動しました。", slog.String("addr", addr), slog.Int("workers", cfg.Workers))
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		stop()
+		manager.Wait()
+		return fmt.Errorf("HTTPサーバーが異常終了しました: %w", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("HTTPサーバーを停止します。実行中と待機中のジョブはキャンセルされます。")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("HTTPサーバーの停止に失敗しました: %w", err)
+	}
+	manager.Wait()
+	return nil
+}
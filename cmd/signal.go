@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// interruptedError は、シグナルにより実行が中断されたことを示すエラーです。
+// 終了コードはシェルの慣例に従い 128 + シグナル番号 (SIGINT: 130, SIGTERM: 143) です。
+type interruptedError struct {
+	sig os.Signal
+}
+
+func (e *interruptedError) Error() string {
+	return fmt.Sprintf("シグナル %s により中断されました", e.sig)
+}
+
+// ExitCode は、プロセスの終了コードを返します。
+func (e *interruptedError) ExitCode() int {
+	if s, ok := e.sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
+
+// withInterrupt は、SIGINT または SIGTERM を受け取るとキャンセルされるコンテキストを返します。
+// キャンセルの原因 (context.Cause) は *interruptedError です。中断処理の途中で2回目のシグナルを受け取った場合は、
+// 後処理を待たずに強制終了します。返された関数は、シグナルの監視を終了します。
+func withInterrupt(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-sigCh:
+			slog.Warn("シグナルを受信しました。実行中の処理を中断しています (もう一度送ると強制終了します)。", slog.String("signal", sig.String()))
+			cancel(&interruptedError{sig: sig})
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-sigCh:
+			forced := &interruptedError{sig: sig}
+			slog.Error("2回目のシグナルを受信したため、強制終了します。", slog.String("signal", sig.String()))
+			os.Exit(forced.ExitCode())
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(sigCh)
+			close(done)
+			cancel(nil)
+		})
+	}
+}
+
+// interruption は、ctx がシグナルによりキャンセルされていた場合に、その原因のエラーを返します。
+func interruption(ctx context.Context) (*interruptedError, bool) {
+	var ie *interruptedError
+	if errors.As(context.Cause(ctx), &ie) {
+		return ie, true
+	}
+	return nil, false
+}
+
+// exitCode は、コマンドのエラーに対応するプロセスの終了コードを返します。
+func exitCode(err error) int {
+	var coder interface{ ExitCode() int }
+	if errors.As(err, &coder) {
+		return coder.ExitCode()
+	}
+	return 1
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWithInterruptCancelsWithSignalCause(t *testing.T) {
+	ctx, stop := withInterrupt(context.Background())
+	defer stop()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context was not canceled after SIGINT")
+	}
+	ie, ok := interruption(ctx)
+	if !ok {
+		t.Fatalf("interruption() = false, cause = %v", context.Cause(ctx))
+	}
+	if !errors.Is(ctx.Err(), context.Canceled) || ie.ExitCode() != 130 {
+		t.Errorf("err, exit code = %v, %d, want canceled, 130", ctx.Err(), ie.ExitCode())
+	}
+}
+
+func TestWithInterruptStopIsNotAnInterruption(t *testing.T) {
+	ctx, stop := withInterrupt(context.Background())
+	stop()
+	stop()
+	if ctx.Err() == nil {
+		t.Fatal("context is still active after stop")
+	}
+	if _, ok := interruption(ctx); ok {
+		t.Error("interruption() = true after stop, want false")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "plain error", err: errors.New("失敗"), want: 1},
+		{name: "sigint", err: &interruptedError{sig: syscall.SIGINT}, want: 130},
+		{name: "wrapped sigterm", err: fmt.Errorf("実行: %w", &interruptedError{sig: syscall.SIGTERM}), want: 143},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("%s: exitCode() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
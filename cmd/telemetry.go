@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/telemetry"
+
+	"github.com/spf13/cobra"
+)
+
+// テレメトリの終了時に、送信中のスパンとメトリクスの書き出しを待つ最大時間
+const telemetryShutdownTimeout = 5 * time.Second
+
+// addTelemetryFlags は、テレメトリの永続フラグを追加します。
+func addTelemetryFlags(rootCmd *cobra.Command) {
+	rootCmd.PersistentFlags().String("otlp-endpoint", "", "トレースとメトリクスを送信する OTLP/HTTP のエンドポイントURL (例: http://localhost:4318。環境変数 OTEL_EXPORTER_OTLP_ENDPOINT でも指定可)")
+	rootCmd.PersistentFlags().String("metrics-addr", "", "Prometheus 形式のメトリクスを /metrics で公開するアドレス (例: :9464)")
+}
+
+// startTelemetry は、--otlp-endpoint と --metrics-addr に従ってテレメトリを設定し、終了処理の関数を返します。
+// どちらも指定されていない場合、スパンとメトリクスは記録されません (no-op)。
+func startTelemetry(cmd *cobra.Command) (func(), error) {
+	otlpEndpoint, err := cmd.Flags().GetString("otlp-endpoint")
+	if err != nil {
+		return nil, fmt.Errorf("otlp-endpointフラグの取得に失敗しました: %w", err)
+	}
+	metricsAddr, err := cmd.Flags().GetString("metrics-addr")
+	if err != nil {
+		return nil, fmt.Errorf("metrics-addrフラグの取得に失敗しました: %w", err)
+	}
+
+	shutdown, err := telemetry.Setup(cmd.Context(), telemetry.Config{
+		ServiceName:  cmd.Root().Name(),
+		OTLPEndpoint: otlpEndpoint,
+		MetricsAddr:  metricsAddr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Warn("テレメトリの終了処理に失敗しました。", slog.String("error", err.Error()))
+		}
+	}, nil
+}
@@ -0,0 +1,52 @@
+package batch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+)
+
+// jobDigest は、ジョブの入力のハッシュを返します。前回の実行以降にジョブの入力が変更されたかの判定に使用します。
+// マニフェストの行に加えて、フラグの既定値を補った実行設定と、URLリスト・カスタムプロンプトのファイルの内容を含めます。
+// そのため、行が同じでもファイルの内容や batch コマンドのフラグ (設定ファイル・環境変数を含む) を変更したジョブは再実行されます。
+// 実行ごとに変わる実行IDと、出力に影響しない APIキーは含めません。
+func jobDigest(ctx context.Context, reader pipeline.InputReader, e Entry, opts pipeline.CmdOptions) string {
+	opts.RunID = ""
+	opts.LLMAPIKey = ""
+
+	h := sha256.New()
+	h.Write([]byte(e.digest))
+	h.Write([]byte{0})
+	// CmdOptions はマップのキーを整列して JSON に変換されるため、同じ設定は常に同じ表現になる
+	if data, err := json.Marshal(opts); err == nil {
+		h.Write(data)
+	}
+	for _, path := range []string{opts.URLFile, opts.MapPromptPath, opts.ReducePromptPath} {
+		h.Write([]byte{0})
+		if path == "" {
+			continue
+		}
+		h.Write([]byte(path))
+		h.Write([]byte{0})
+		hashFile(ctx, h, reader, path)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile は、ファイルの内容を w に書き込みます。読み込めない場合は、その旨を示す値を書き込みます
+// (ジョブは実行され、パイプラインが読み込みのエラーを記録します)。
+func hashFile(ctx context.Context, w io.Writer, reader pipeline.InputReader, path string) {
+	rc, err := reader.Open(ctx, path)
+	if err != nil {
+		w.Write([]byte("\x00unreadable"))
+		return
+	}
+	defer rc.Close()
+	if _, err := io.Copy(w, rc); err != nil {
+		w.Write([]byte("\x00unreadable"))
+	}
+}
@@ -0,0 +1,90 @@
+package batch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// semaphore は、複数のジョブで共有する同時実行数の上限です。
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	<-s
+}
+
+// limitedModel は、LLM の呼び出しをすべてのジョブで共有する上限の範囲で実行する GenerativeModel です。
+type limitedModel struct {
+	inner llm.GenerativeModel
+	sem   semaphore
+}
+
+func (m *limitedModel) GenerateContent(ctx context.Context, prompt string, modelName string, opts ...llm.GenerateOption) (*llm.Response, error) {
+	if err := m.sem.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer m.sem.release()
+	return m.inner.GenerateContent(ctx, prompt, modelName, opts...)
+}
+
+func (m *limitedModel) GenerateContentStream(ctx context.Context, prompt string, modelName string, onChunk func(text string) error, opts ...llm.GenerateOption) (*llm.Response, error) {
+	if err := m.sem.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer m.sem.release()
+	return m.inner.GenerateContentStream(ctx, prompt, modelName, onChunk, opts...)
+}
+
+// limitedScraper は、URLごとのスクレイピングをすべてのジョブで共有する上限の範囲で実行する ScraperRunner です。
+// URLごとに inner を呼び出すため、ジョブ内の並列数 (--parallel) の上限はこのラッパーで適用します。
+type limitedScraper struct {
+	inner    pipeline.ScraperRunner
+	sem      semaphore
+	parallel int
+}
+
+// ScrapeInParallel は、ジョブ内と共有の上限を取得してからURLごとに取得し、成功した結果を入力順に返します。
+func (s *limitedScraper) ScrapeInParallel(ctx context.Context, urls []string) []extTypes.URLResult {
+	local := newSemaphore(max(s.parallel, 1))
+	perURL := make([][]extTypes.URLResult, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := local.acquire(ctx); err != nil {
+				return
+			}
+			defer local.release()
+			if err := s.sem.acquire(ctx); err != nil {
+				return
+			}
+			defer s.sem.release()
+			perURL[i] = s.inner.ScrapeInParallel(ctx, []string{u})
+		}()
+	}
+	wg.Wait()
+
+	var results []extTypes.URLResult
+	for _, r := range perURL {
+		results = append(results, r...)
+	}
+	return results
+}
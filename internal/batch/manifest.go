@@ -0,0 +1,99 @@
+package batch
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+)
+
+// idPattern は、ジョブIDとして許可する形式です (状態ファイル名に使用するため)。
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Entry は、マニフェスト (JSONL) の1行で指定する1件のジョブです。省略したフィールドには batch コマンドのフラグの値を使用します。
+type Entry struct {
+	// ID はジョブの識別子です。省略した場合は行の内容から生成します。
+	ID      string `json:"id,omitempty"`
+	URLFile string `json:"url_file"`
+	Output  string `json:"output"`
+	Format  string `json:"format,omitempty"`
+	// MapModel と ReduceModel は使用するモデル名です (カンマ区切りでフォールバック順を指定可)。
+	MapModel    string `json:"map_model,omitempty"`
+	ReduceModel string `json:"reduce_model,omitempty"`
+	// MapPrompt と ReducePrompt は、カスタムプロンプトテンプレートのパス (ローカルまたはGCS URI) です。
+	MapPrompt      string            `json:"map_prompt,omitempty"`
+	ReducePrompt   string            `json:"reduce_prompt,omitempty"`
+	Lang           string            `json:"lang,omitempty"`
+	Topic          string            `json:"topic,omitempty"`
+	Query          string            `json:"query,omitempty"`
+	Mode           string            `json:"mode,omitempty"`
+	CitationStyle  string            `json:"citation_style,omitempty"`
+	CitationPolicy string            `json:"citation_policy,omitempty"`
+	Vars           map[string]string `json:"vars,omitempty"`
+
+	// digest は、マニフェストの行の内容のハッシュです。ID の生成と、ジョブの入力のハッシュ (jobDigest) に使用します。
+	digest string
+}
+
+// LoadManifest は、JSONL 形式のマニフェストを読み込みます。空行と # で始まる行は無視します。
+// 必須項目の欠落やIDの重複がある場合は、行番号を含むエラーを返します。
+func LoadManifest(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("マニフェストのオープンに失敗しました '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	seen := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var e Entry
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("マニフェスト %d 行目のJSONを解析できませんでした: %w", lineNo, err)
+		}
+		sum := sha256.Sum256([]byte(line))
+		e.digest = hex.EncodeToString(sum[:])
+		if e.ID == "" {
+			e.ID = e.digest[:12]
+		}
+		if !idPattern.MatchString(e.ID) {
+			return nil, fmt.Errorf("マニフェスト %d 行目: id %q には英数字と . _ - のみ使用できます", lineNo, e.ID)
+		}
+		if e.URLFile == "" {
+			return nil, fmt.Errorf("マニフェスト %d 行目: url_file を指定する必要があります", lineNo)
+		}
+		if e.Output == "" || e.Output == "-" {
+			return nil, fmt.Errorf("マニフェスト %d 行目: output には出力先のファイルパスまたはGCS URIを指定する必要があります", lineNo)
+		}
+		if err := prompts.ValidateVars(e.Vars); err != nil {
+			return nil, fmt.Errorf("マニフェスト %d 行目: vars: %w", lineNo, err)
+		}
+		if prev, ok := seen[e.ID]; ok {
+			return nil, fmt.Errorf("マニフェスト %d 行目: id %q は %d 行目と重複しています", lineNo, e.ID, prev)
+		}
+		seen[e.ID] = lineNo
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("マニフェストの読み込みに失敗しました '%s': %w", path, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("マニフェストにジョブが一件も含まれていませんでした: %s", path)
+	}
+	return entries, nil
+}
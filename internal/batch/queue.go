@@ -0,0 +1,88 @@
+package batch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ジョブの状態
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// JobState は、ディスク上のキューに保存する1件のジョブの状態です。
+type JobState struct {
+	ID     string `json:"id"`
+	Digest string `json:"digest"`
+	Status string `json:"status"`
+	Output string `json:"output"`
+	// Attempts は、ジョブを開始した回数です (再起動による再実行を含む)。
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Skipped は、前回までの実行の結果を再利用し、今回は実行しなかったことを示します。
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// Queue は、ジョブの状態をディレクトリ内の JSON ファイル (jobs/<id>.json) として保存するディスク上のキューです。
+// 状態はジョブの開始・終了のたびに書き込まれるため、プロセスが中断されても次回の実行で未完了のジョブから再開できます。
+type Queue struct {
+	dir string
+}
+
+// OpenQueue は、dir をキューのディレクトリとして開きます。存在しない場合は作成します。
+func OpenQueue(dir string) (*Queue, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "jobs"), 0o755); err != nil {
+		return nil, fmt.Errorf("キューのディレクトリを作成できませんでした '%s': %w", dir, err)
+	}
+	return &Queue{dir: dir}, nil
+}
+
+// Dir は、キューのディレクトリを返します。
+func (q *Queue) Dir() string {
+	return q.dir
+}
+
+// Get は、ジョブの保存済みの状態を返します。保存されていない場合は false を返します。
+func (q *Queue) Get(id string) (JobState, bool, error) {
+	data, err := os.ReadFile(q.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return JobState{}, false, nil
+	}
+	if err != nil {
+		return JobState{}, false, fmt.Errorf("ジョブの状態を読み込めませんでした '%s': %w", id, err)
+	}
+	var st JobState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return JobState{}, false, fmt.Errorf("ジョブの状態を解析できませんでした '%s': %w", id, err)
+	}
+	return st, true, nil
+}
+
+// Put は、ジョブの状態を保存します。書き込み途中で中断されても壊れたファイルが残らないよう、一時ファイルからリネームします。
+func (q *Queue) Put(st JobState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("ジョブの状態をJSONに変換できませんでした '%s': %w", st.ID, err)
+	}
+	tmp := q.path(st.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("ジョブの状態を書き込めませんでした '%s': %w", st.ID, err)
+	}
+	if err := os.Rename(tmp, q.path(st.ID)); err != nil {
+		return fmt.Errorf("ジョブの状態を書き込めませんでした '%s': %w", st.ID, err)
+	}
+	return nil
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.dir, "jobs", id+".json")
+}
@@ -0,0 +1,320 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+)
+
+// interruptedMessage は、キャンセルにより中断され、次回の実行で再開するジョブに記録する説明です。
+const interruptedMessage = "実行が中断されました (次回の実行で再開します)"
+
+// Config は Runner の設定です。
+type Config struct {
+	// Jobs は、同時に実行するジョブの最大数です。
+	Jobs int
+	// LLMConcurrency は、すべてのジョブで共有する LLM 呼び出しの最大同時実行数です。
+	LLMConcurrency int
+	// ScraperConcurrency は、すべてのジョブで共有するスクレイピングの最大同時実行数 (URL数) です。
+	ScraperConcurrency int
+	// JobTimeout は、1件のジョブの最大実行時間です。
+	JobTimeout time.Duration
+	// RetryFailed は、前回までの実行で失敗したジョブを再実行するかどうかです。
+	RetryFailed bool
+	// Defaults は、マニフェストで省略された項目に使用する CmdOptions です。
+	Defaults pipeline.CmdOptions
+	// BuildOptions は、ジョブごとの BuildPipeline に追加で渡す関数オプションです。
+	BuildOptions []builder.Option
+}
+
+// Runner は、マニフェストのジョブをディスク上のキューに記録しながら実行します。
+type Runner struct {
+	cfg   Config
+	queue *Queue
+	llm   semaphore
+	scrap semaphore
+}
+
+// NewRunner は、Runner を作成します。
+func NewRunner(cfg Config, queue *Queue) (*Runner, error) {
+	if cfg.Jobs < 1 {
+		return nil, fmt.Errorf("ジョブの同時実行数には1以上の値を指定する必要があります: %d", cfg.Jobs)
+	}
+	if cfg.JobTimeout <= 0 {
+		return nil, fmt.Errorf("ジョブのタイムアウトには正の値を指定する必要があります: %s", cfg.JobTimeout)
+	}
+	if cfg.LLMConcurrency < 1 {
+		return nil, fmt.Errorf("LLMの同時実行数には1以上の値を指定する必要があります: %d", cfg.LLMConcurrency)
+	}
+	if cfg.ScraperConcurrency < 1 {
+		return nil, fmt.Errorf("スクレイピングの同時実行数には1以上の値を指定する必要があります: %d", cfg.ScraperConcurrency)
+	}
+	return &Runner{
+		cfg:   cfg,
+		queue: queue,
+		llm:   newSemaphore(cfg.LLMConcurrency),
+		scrap: newSemaphore(cfg.ScraperConcurrency),
+	}, nil
+}
+
+// options は、エントリに Config.Defaults の値を補った CmdOptions を返し、その内容を検証します。
+func (r *Runner) options(e Entry) (pipeline.CmdOptions, error) {
+	opts := r.cfg.Defaults
+	opts.URLFile = e.URLFile
+	opts.OutputFilePath = e.Output
+	opts.OutputFormat = pipeline.FormatAuto
+	opts.RecordDir = ""
+	opts.ReplayDir = ""
+	// 実行記録はジョブの出力ファイルの隣に書き出す
+	opts.RunID = pipeline.NewRunID()
+	opts.RunManifestPath = pipeline.DefaultManifestPath(e.Output)
+	override(&opts.OutputFormat, e.Format)
+	override(&opts.MapModel, e.MapModel)
+	override(&opts.ReduceModel, e.ReduceModel)
+	override(&opts.MapPromptPath, e.MapPrompt)
+	override(&opts.ReducePromptPath, e.ReducePrompt)
+	override(&opts.Language, e.Lang)
+	override(&opts.Topic, e.Topic)
+	override(&opts.Query, strings.TrimSpace(e.Query))
+	override(&opts.Mode, e.Mode)
+	override(&opts.CitationStyle, e.CitationStyle)
+	override(&opts.CitationPolicy, e.CitationPolicy)
+	if len(e.Vars) > 0 {
+		opts.TemplateVars = e.Vars
+	}
+
+	if _, err := pipeline.ResolveOutputFormat(opts.OutputFilePath, opts.OutputFormat); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+	if err := cleaner.ValidateModelChain(opts.MapModel); err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("map_model: %w", err)
+	}
+	if err := cleaner.ValidateModelChain(opts.ReduceModel); err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("reduce_model: %w", err)
+	}
+	if err := cleaner.ValidateMode(opts.Mode); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+	if err := cleaner.ValidateCitationStyle(opts.CitationStyle); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+	if err := cleaner.ValidateCitationPolicy(opts.CitationPolicy); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+	return opts, nil
+}
+
+// Run は、マニフェストのジョブを実行し、マニフェスト順のジョブの状態を返します。
+// 前回までに成功したジョブ (および RetryFailed でない場合の失敗したジョブ) は、入力 (マニフェストの行、フラグの既定値、
+// URLリストとカスタムプロンプトのファイルの内容) が変更されていなければ実行しません。
+// 前回の実行中に中断されたジョブは再実行します。ctx がキャンセルされた場合、未完了のジョブは次回の実行に持ち越します。
+func (r *Runner) Run(ctx context.Context, entries []Entry) ([]JobState, error) {
+	reader, closeReader := builder.BuildInputReader(ctx, r.cfg.BuildOptions...)
+	defer closeReader()
+
+	options := make([]pipeline.CmdOptions, len(entries))
+	digests := make([]string, len(entries))
+	for i, e := range entries {
+		opts, err := r.options(e)
+		if err != nil {
+			return nil, fmt.Errorf("ジョブ '%s' の設定が不正です: %w", e.ID, err)
+		}
+		options[i] = opts
+		digests[i] = jobDigest(ctx, reader, e, opts)
+	}
+
+	// 1. ディスク上のキューと照合し、実行するジョブを決定する
+	states := make([]JobState, len(entries))
+	var pending []int
+	for i, e := range entries {
+		prev, ok, err := r.queue.Get(e.ID)
+		if err != nil {
+			return nil, err
+		}
+		if ok && prev.Digest == digests[i] {
+			switch {
+			case prev.Status == StatusSucceeded, prev.Status == StatusFailed && !r.cfg.RetryFailed:
+				slog.Info("前回までの実行結果を再利用します。", slog.String(logging.KeyJobID, e.ID), slog.String("status", prev.Status))
+				prev.Skipped = true
+				states[i] = prev
+				continue
+			case prev.Status == StatusRunning:
+				slog.Info("前回の実行で中断されたジョブを再実行します。", slog.String(logging.KeyJobID, e.ID))
+			}
+			states[i] = prev
+		} else {
+			if ok {
+				slog.Info("前回の実行以降にジョブの入力が変更されたため、再実行します。", slog.String(logging.KeyJobID, e.ID))
+			}
+			states[i] = JobState{ID: e.ID, Digest: digests[i]}
+		}
+		states[i].Status = StatusPending
+		states[i].Output = e.Output
+		states[i].Skipped = false
+		if err := r.queue.Put(states[i]); err != nil {
+			return nil, err
+		}
+		pending = append(pending, i)
+	}
+	slog.Info("バッチ処理を開始します。", slog.Int("jobs", len(entries)), slog.Int("pending", len(pending)), slog.Int("workers", r.cfg.Jobs))
+
+	// 2. 上限付きのワーカーで実行する
+	work := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var saveErr error
+	for w := 0; w < r.cfg.Jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				st := r.runJob(ctx, states[i], options[i])
+				mu.Lock()
+				states[i] = st
+				if err := r.queue.Put(st); err != nil && saveErr == nil {
+					saveErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, i := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	if saveErr != nil {
+		return states, saveErr
+	}
+	return states, ctx.Err()
+}
+
+// runJob は、1件のジョブを実行し、終了後の状態を返します。開始時の状態はキューに保存します。
+func (r *Runner) runJob(ctx context.Context, st JobState, opts pipeline.CmdOptions) JobState {
+	if ctx.Err() != nil {
+		return st
+	}
+	ctx = logging.With(ctx, slog.String(logging.KeyJobID, st.ID), slog.String(logging.KeyRunID, opts.RunID))
+	started := time.Now()
+	st.Status = StatusRunning
+	st.Attempts++
+	st.Error = ""
+	st.StartedAt = &started
+	st.FinishedAt = nil
+	if err := r.queue.Put(st); err != nil {
+		slog.WarnContext(ctx, "ジョブの状態を保存できませんでした。", slog.String("error", err.Error()))
+	}
+	slog.InfoContext(ctx, "ジョブを開始します。", slog.Int("attempt", st.Attempts))
+
+	jobCtx, cancel := context.WithTimeout(ctx, r.cfg.JobTimeout)
+	err := r.execute(jobCtx, opts)
+	cancel()
+	finished := time.Now()
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// キャンセルによる中断は失敗として記録せず、次回の実行で再開する
+		st.Status = StatusPending
+		st.Error = interruptedMessage
+		slog.WarnContext(ctx, "ジョブを中断しました。")
+		return st
+	case err != nil:
+		st.Status = StatusFailed
+		st.Error = err.Error()
+		slog.ErrorContext(ctx, "ジョブが失敗しました。", slog.String("error", err.Error()))
+	default:
+		st.Status = StatusSucceeded
+		slog.InfoContext(ctx, "ジョブが完了しました。", slog.String("output", st.Output), slog.Duration("elapsed", finished.Sub(started)))
+	}
+	st.FinishedAt = &finished
+	return st
+}
+
+func (r *Runner) execute(ctx context.Context, opts pipeline.CmdOptions) error {
+	options := append([]builder.Option{
+		builder.WithMapProgress(silentProgress{}),
+		builder.WithModelWrapper(func(m llm.GenerativeModel) llm.GenerativeModel {
+			return &limitedModel{inner: m, sem: r.llm}
+		}),
+		builder.WithScraperWrapper(func(s pipeline.ScraperRunner) pipeline.ScraperRunner {
+			return &limitedScraper{inner: s, sem: r.scrap, parallel: opts.MaxScraperParallel}
+		}),
+	}, r.cfg.BuildOptions...)
+	p, closer, err := builder.BuildPipeline(ctx, opts, options...)
+	if closer != nil {
+		defer closer()
+	}
+	if err != nil {
+		return fmt.Errorf("パイプラインの構築に失敗しました: %w", err)
+	}
+	if err := p.Execute(ctx); err != nil {
+		return fmt.Errorf("パイプラインの実行中にエラーが発生しました: %w", err)
+	}
+	return nil
+}
+
+// Summary は、バッチ処理のジョブごとの状態の集計です。
+type Summary struct {
+	GeneratedAt time.Time  `json:"generated_at"`
+	Total       int        `json:"total"`
+	Succeeded   int        `json:"succeeded"`
+	Failed      int        `json:"failed"`
+	Pending     int        `json:"pending"`
+	Jobs        []JobState `json:"jobs"`
+}
+
+// NewSummary は、ジョブの状態を集計します。
+func NewSummary(states []JobState) Summary {
+	s := Summary{GeneratedAt: time.Now(), Total: len(states), Jobs: states}
+	for _, st := range states {
+		switch st.Status {
+		case StatusSucceeded:
+			s.Succeeded++
+		case StatusFailed:
+			s.Failed++
+		default:
+			s.Pending++
+		}
+	}
+	return s
+}
+
+// WriteSummary は、集計結果を JSON ファイルとして書き出します。
+func WriteSummary(path string, s Summary) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("バッチ処理の集計をJSONに変換できませんでした: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("バッチ処理の集計を書き込めませんでした '%s': %w", path, err)
+	}
+	return nil
+}
+
+// override は、value が空でない場合に dst を上書きします。
+func override(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+// silentProgress は、進捗を表示しない MapProgress です。複数のジョブを同時に実行するため、端末への進捗表示を行いません。
+type silentProgress struct{}
+
+func (silentProgress) Start(total int) {}
+func (silentProgress) Advance()        {}
+func (silentProgress) Finish()         {}
@@ -0,0 +1,179 @@
+package batch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/fakes"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+)
+
+const (
+	okURL      = "https://example.com/ok"
+	missingURL = "https://example.com/missing"
+)
+
+// testBatch は、フェイクの入出力・LLM クライアント・スクレイパーで Runner を実行するための環境です。
+type testBatch struct {
+	t        *testing.T
+	files    map[string]string
+	writer   *fakes.Writer
+	queue    *Queue
+	manifest string
+}
+
+func newTestBatch(t *testing.T, manifest string) *testBatch {
+	t.Helper()
+	queue, err := OpenQueue(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+	if err := os.WriteFile(path, []byte(manifest), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return &testBatch{
+		t:        t,
+		files:    map[string]string{"ok.txt": okURL, "missing.txt": missingURL},
+		writer:   fakes.NewWriter(),
+		queue:    queue,
+		manifest: path,
+	}
+}
+
+// run は、Defaults を modify で変更した設定でマニフェストのジョブを実行し、ID ごとの状態を返します。
+func (b *testBatch) run(retryFailed bool, modify func(*pipeline.CmdOptions)) map[string]JobState {
+	b.t.Helper()
+	defaults := pipeline.CmdOptions{
+		Language:       "ja",
+		CitationPolicy: cleaner.CitationPolicyFlag,
+		CitationStyle:  cleaner.CitationStyleSection,
+		Mode:           cleaner.ModeAuto,
+		MapModel:       "fake-map",
+		ReduceModel:    "fake-reduce",
+		MapConcurrency: 1,
+	}
+	if modify != nil {
+		modify(&defaults)
+	}
+	runner, err := NewRunner(Config{
+		Jobs:               2,
+		LLMConcurrency:     2,
+		ScraperConcurrency: 2,
+		JobTimeout:         time.Minute,
+		RetryFailed:        retryFailed,
+		Defaults:           defaults,
+		BuildOptions: []builder.Option{
+			builder.WithModel(fakes.NewModel()),
+			builder.WithMapRateLimit(time.Millisecond),
+			builder.WithScraper(fakes.NewScraper(map[string]string{okURL: "ゴルーチンは軽量なスレッドです。"})),
+			builder.WithReader(fakes.NewInputReader(b.files)),
+			builder.WithWriter(b.writer),
+		},
+	}, b.queue)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	entries, err := LoadManifest(b.manifest)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	states, err := runner.Run(context.Background(), entries)
+	if err != nil {
+		b.t.Fatalf("Run: %v", err)
+	}
+	byID := make(map[string]JobState, len(states))
+	for _, st := range states {
+		byID[st.ID] = st
+	}
+	return byID
+}
+
+func TestRunnerResumesAndRerunsChangedJobs(t *testing.T) {
+	b := newTestBatch(t, `{"id":"ok","url_file":"ok.txt","output":"out/ok.md"}`+"\n"+
+		`{"id":"fail","url_file":"missing.txt","output":"out/fail.md"}`+"\n")
+
+	first := b.run(false, nil)
+	if first["ok"].Status != StatusSucceeded || first["fail"].Status != StatusFailed {
+		t.Fatalf("first run = %+v", first)
+	}
+	if _, ok := b.writer.File("out/ok.md"); !ok {
+		t.Errorf("first run did not write out/ok.md (wrote %v)", b.writer.Paths())
+	}
+
+	second := b.run(false, nil)
+	for _, id := range []string{"ok", "fail"} {
+		if !second[id].Skipped || second[id].Attempts != 1 {
+			t.Errorf("second run %s = %+v, want the previous result reused", id, second[id])
+		}
+	}
+
+	retried := b.run(true, nil)
+	if retried["fail"].Skipped || retried["fail"].Attempts != 2 || !retried["ok"].Skipped {
+		t.Errorf("retry-failed run = %+v, want only the failed job rerun", retried)
+	}
+
+	b.files["ok.txt"] = okURL + "\n" + missingURL
+	changedFile := b.run(false, nil)
+	if changedFile["ok"].Skipped || changedFile["ok"].Status != StatusSucceeded {
+		t.Errorf("run after changing url_file = %+v, want the job rerun", changedFile["ok"])
+	}
+
+	changedDefaults := b.run(false, func(o *pipeline.CmdOptions) { o.Language = "en" })
+	if changedDefaults["ok"].Skipped || changedDefaults["fail"].Skipped {
+		t.Errorf("run after changing the defaults = %+v, want every job rerun", changedDefaults)
+	}
+}
+
+func TestRunnerRestartsInterruptedJobs(t *testing.T) {
+	b := newTestBatch(t, `{"id":"ok","url_file":"ok.txt","output":"out/ok.md"}`+"\n")
+	first := b.run(false, nil)
+
+	// 実行中に中断されたジョブ (状態ファイルが running のまま) を再現する
+	interrupted := first["ok"]
+	interrupted.Status = StatusRunning
+	interrupted.FinishedAt = nil
+	if err := b.queue.Put(interrupted); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := b.run(false, nil)
+	if st := resumed["ok"]; st.Skipped || st.Status != StatusSucceeded || st.Attempts != 2 {
+		t.Errorf("resumed run = %+v, want the interrupted job rerun", st)
+	}
+	saved, ok, err := b.queue.Get("ok")
+	if err != nil || !ok || saved.Status != StatusSucceeded {
+		t.Errorf("saved state = %+v, %v, %v, want succeeded", saved, ok, err)
+	}
+	if _, ok, err := b.queue.Get("unknown"); ok || err != nil {
+		t.Errorf("Get(unknown) = %v, %v, want not found", ok, err)
+	}
+}
+
+func TestLoadManifestValidatesEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "missing url_file", line: `{"output":"a.md"}`},
+		{name: "stdout output", line: `{"url_file":"u.txt","output":"-"}`},
+		{name: "invalid id", line: `{"id":"../x","url_file":"u.txt","output":"a.md"}`},
+		{name: "invalid vars key", line: `{"url_file":"u.txt","output":"a.md","vars":{"target-audience":"dev"}}`},
+		{name: "unknown field", line: `{"url_file":"u.txt","output":"a.md","model":"m"}`},
+		{name: "duplicate id", line: `{"id":"a","url_file":"u.txt","output":"a.md"}` + "\n" + `{"id":"a","url_file":"v.txt","output":"b.md"}`},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "jobs.jsonl")
+		if err := os.WriteFile(path, []byte(tt.line), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadManifest(path); err == nil {
+			t.Errorf("%s: LoadManifest() succeeded, want an error", tt.name)
+		}
+	}
+}
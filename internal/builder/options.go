@@ -0,0 +1,102 @@
+package builder
+
+import (
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+)
+
+// Option は、BuildPipeline と BuildPublisher が構築する依存関係を差し替える関数オプションです。
+// 指定されなかった依存関係は既定の実装 (Gemini、ReliableScraper、GCS/ローカルの入出力、go-text-format) で構築されます。
+type Option func(*buildConfig)
+
+// buildConfig は、関数オプションで指定された依存関係を保持します。nil のフィールドは既定の実装を使用します。
+type buildConfig struct {
+	executor     cleaner.LLMExecutor
+	scraper      pipeline.ScraperRunner
+	reader       pipeline.InputReader
+	writer       pipeline.Writer
+	htmlRenderer pipeline.MdToHtmlRunner
+	prompts      *cleaner.PromptBuilders
+	progress     cleaner.MapProgress
+	model        llm.GenerativeModel
+	rateLimit    time.Duration
+	// modelWrappers と scraperWrappers は、構築した LLM クライアントと ScraperRunner に指定順に適用するラッパーです。
+	modelWrappers   []func(llm.GenerativeModel) llm.GenerativeModel
+	scraperWrappers []func(pipeline.ScraperRunner) pipeline.ScraperRunner
+}
+
+func newBuildConfig(options []Option) buildConfig {
+	var cfg buildConfig
+	for _, opt := range options {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithExecutor は、Gemini クライアントを使用する LLMConcurrentExecutor の代わりに使用する LLMExecutor を指定します。
+// 指定した場合、--record / --replay による LLM の記録・再生は適用されません。
+func WithExecutor(executor cleaner.LLMExecutor) Option {
+	return func(c *buildConfig) { c.executor = executor }
+}
+
+// WithModel は、Gemini クライアントの代わりに使用する LLM クライアントを指定します。
+// WithExecutor と異なり、セグメント分割・ルーティング・フォールバック・応答ポリシー・応答の解析は通常どおり実行されます。
+// --record を指定した場合は、このクライアントの応答を記録します (--replay の場合は使用されません)。
+func WithModel(model llm.GenerativeModel) Option {
+	return func(c *buildConfig) { c.model = model }
+}
+
+// WithMapRateLimit は、Mapフェーズの LLM 呼び出しの開始間隔を指定します (既定は cleaner.DefaultLLMRateLimit)。
+// フェイクのクライアントを使用するテストなどで、待ち時間を短縮するために使用します。
+func WithMapRateLimit(d time.Duration) Option {
+	return func(c *buildConfig) { c.rateLimit = d }
+}
+
+// WithScraper は、ReliableScraper の代わりに使用する ScraperRunner を指定します。
+// 指定した場合、--record / --replay によるスクレイピング結果の記録・再生は適用されません。
+func WithScraper(scraper pipeline.ScraperRunner) Option {
+	return func(c *buildConfig) { c.scraper = scraper }
+}
+
+// WithReader は、URLリストとカスタムプロンプトの読み込みに使用する InputReader を指定します。
+func WithReader(reader pipeline.InputReader) Option {
+	return func(c *buildConfig) { c.reader = reader }
+}
+
+// WithWriter は、最終結果の出力に使用する Writer を指定します。
+func WithWriter(writer pipeline.Writer) Option {
+	return func(c *buildConfig) { c.writer = writer }
+}
+
+// WithHTMLRenderer は、HTML出力時に Markdown を変換する MdToHtmlRunner を指定します。
+func WithHTMLRenderer(renderer pipeline.MdToHtmlRunner) Option {
+	return func(c *buildConfig) { c.htmlRenderer = renderer }
+}
+
+// WithPrompts は、使用する PromptBuilder を指定します。
+// nil のフィールドは、CmdOptions のカスタムテンプレートのパスまたは組み込みテンプレートから構築されます。
+func WithPrompts(builders cleaner.PromptBuilders) Option {
+	return func(c *buildConfig) { c.prompts = &builders }
+}
+
+// WithMapProgress は、Mapフェーズの進捗 (完了セグメント数) の通知先を指定します。
+// 指定しない場合、標準エラー出力が端末でログが json 形式でなければ、進捗を1行で表示し、Reduce の応答をプレビュー表示します。
+// WithExecutor を指定した場合、Mapフェーズの進捗の通知先としては使用されません。
+func WithMapProgress(progress cleaner.MapProgress) Option {
+	return func(c *buildConfig) { c.progress = progress }
+}
+
+// WithModelWrapper は、LLM クライアントをラップする関数を追加します (複数指定した場合は指定順に適用)。
+// 複数のパイプラインで共有する同時実行数の制限などに使用します。WithExecutor を指定した場合は使用されません。
+func WithModelWrapper(wrap func(llm.GenerativeModel) llm.GenerativeModel) Option {
+	return func(c *buildConfig) { c.modelWrappers = append(c.modelWrappers, wrap) }
+}
+
+// WithScraperWrapper は、ScraperRunner をラップする関数を追加します (複数指定した場合は指定順に適用)。
+// WithScraper で指定した ScraperRunner にも適用されます。
+func WithScraperWrapper(wrap func(pipeline.ScraperRunner) pipeline.ScraperRunner) Option {
+	return func(c *buildConfig) { c.scraperWrappers = append(c.scraperWrappers, wrap) }
+}
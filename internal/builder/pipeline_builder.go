@@ -3,11 +3,17 @@ package builder
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
 
-	"action-perfect-get-on-go/internal/cleaner"
-	"action-perfect-get-on-go/internal/pipeline"
-	"action-perfect-get-on-go/internal/prompts"
+	"github.com/shouni/action-perfect-get-on-go/internal/cassette"
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+	"github.com/shouni/action-perfect-get-on-go/internal/progress"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+	"github.com/shouni/action-perfect-get-on-go/internal/telemetry"
 
 	"github.com/shouni/go-remote-io/pkg/gcsfactory"
 	textformat "github.com/shouni/go-text-format/pkg/builder"
@@ -16,122 +22,359 @@ import (
 
 // BuildPipeline は、必要なすべての依存関係を構築し、DIされた Pipeline インスタンスと
 // GCSクライアントのクリーンアップ関数 (Close) を返します。
-func BuildPipeline(ctx context.Context, opts pipeline.CmdOptions) (*pipeline.Pipeline, func(), error) {
+// options で依存関係を差し替えられます。GCSクライアントは gs:// のパスが実際に使用されたときに初期化されます。
+func BuildPipeline(ctx context.Context, opts pipeline.CmdOptions, options ...Option) (*pipeline.Pipeline, func(), error) {
+	bc := newBuildConfig(options)
 
 	// ----------------------------------------------------------------
-	// 1. GCS クライアントの初期化とクリーンアップ設定 (Factoryに委譲)
+	// 1. 入出力の初期化とクリーンアップ設定 (GCSクライアントは遅延初期化)
 	// ----------------------------------------------------------------
 
-	// Factoryを初期化し、GCSクライアントの初期化と管理を委譲する
-	GCSClient, err := gcsfactory.NewGCSClientFactory(ctx)
-	if err != nil {
-		return nil, nil, fmt.Errorf("Factoryの初期化に失敗しました: %w", err)
-	}
+	gcs := newLazyGCS(ctx)
+	closer := gcs.Close
 
-	// FactoryのCloseは func() error 型なので、戻り値の func() に合わせるためのラッパーを定義
-	closer := func() {
-		if closeErr := GCSClient.Close(); closeErr != nil {
-			slog.Error("Factoryのクローズ中にエラーが発生しました", slog.Any("error", closeErr))
-		}
+	urlReader, outputWriter := bc.reader, bc.writer
+	if urlReader == nil {
+		urlReader = gcs
+	}
+	if outputWriter == nil {
+		outputWriter = gcs
 	}
 
 	// ----------------------------------------------------------------
 	// 2. Webコンテンツ取得のための依存関係の具体化
 	// ----------------------------------------------------------------
 
-	// BuildReliableScraperExecutor を呼び出し、リトライ実行者を取得
-	scraperExecutor, err := textpipe.BuildReliableScraperExecutor(opts.ScraperTimeout, opts.MaxScraperParallel)
+	// 記録・再生用のカセット (--record / --replay)
+	tape, err := openCassette(opts)
 	if err != nil {
-		// 失敗時はFactoryを閉じる
-		return nil, closer, fmt.Errorf("ReliableScraperExecutorの初期化に失敗しました: %w", err)
+		return nil, closer, err
+	}
+
+	scraperExecutor := bc.scraper
+	if scraperExecutor == nil && tape != nil && tape.Mode() == cassette.ModeReplay {
+		// 再生時はスクレイパーを構築せず、カセットの記録のみを返す
+		scraperExecutor = cassette.NewScraper(tape, nil)
+	} else if scraperExecutor == nil {
+		// BuildReliableScraperExecutor を呼び出し、リトライ実行者を取得
+		reliableScraper, err := textpipe.BuildReliableScraperExecutor(opts.ScraperTimeout, opts.MaxScraperParallel)
+		if err != nil {
+			// 失敗時はFactoryを閉じる
+			return nil, closer, fmt.Errorf("ReliableScraperExecutorの初期化に失敗しました: %w", err)
+		}
+		scraperExecutor = reliableScraper
+		if tape != nil {
+			scraperExecutor = cassette.NewScraper(tape, scraperExecutor)
+		}
+	}
+
+	// テレメトリが有効な場合は、URLごとのスクレイピングをスパンとして記録する
+	if telemetry.Enabled() {
+		scraperExecutor = telemetry.WrapScraper(scraperExecutor, opts.MaxScraperParallel)
+	}
+	for _, wrap := range bc.scraperWrappers {
+		scraperExecutor = wrap(scraperExecutor)
 	}
 
 	// ----------------------------------------------------------------
 	// 3. ContentCleaner (LLMクリーンアップロジック) の構築
 	// ----------------------------------------------------------------
 
-	// プロンプトビルダーの初期化
-	mapBuilder := prompts.NewMapPromptBuilder()
-	if err := mapBuilder.Err(); err != nil {
-		return nil, closer, fmt.Errorf("Map Prompt Builderの初期化に失敗しました: %w", err)
+	// プロンプトビルダーの初期化 (カスタムテンプレートは起動時に検証する)
+	builders, err := buildPromptBuilders(ctx, urlReader, opts, bc.prompts)
+	if err != nil {
+		return nil, closer, err
 	}
-	reduceBuilder := prompts.NewReducePromptBuilder()
-	if err := reduceBuilder.Err(); err != nil {
-		return nil, closer, fmt.Errorf("Reduce Prompt Builderの初期化に失敗しました: %w", err)
+
+	// LLMExecutor の構築
+	executor, err := buildExecutor(ctx, opts, tape, bc)
+	if err != nil {
+		return nil, closer, err
 	}
 
-	// PromptBuilders を構造体にまとめる
-	builders := cleaner.PromptBuilders{
-		MapBuilder:    mapBuilder,
-		ReduceBuilder: reduceBuilder,
+	// Cleaner の構築
+	contentCleaner, err := cleaner.NewCleaner(builders, executor, cleaner.CleanerConfig{
+		Language:           opts.Language,
+		Topic:              opts.Topic,
+		Query:              opts.Query,
+		CitationPolicy:     opts.CitationPolicy,
+		CitationStyle:      opts.CitationStyle,
+		Routing:            opts.RoutingRules,
+		Mode:               opts.Mode,
+		SinglePassMaxChars: opts.SinglePassMaxChars,
+		ReduceModel:        opts.ReduceModel,
+		CustomPrompts:      hasCustomPrompts(opts, bc.prompts),
+		Vars:               opts.TemplateVars,
+	})
+	if err != nil {
+		return nil, closer, fmt.Errorf("Cleanerの初期化に失敗しました: %w", err)
 	}
 
-	// LLMExecutor の構築
+	// ----------------------------------------------------------------
+	// 4. パイプラインステージの実装とPipelineの構築 (DIの実行)
+	// ----------------------------------------------------------------
+
+	// 4.1 URLGenerator の構築
+	// urlReader (remoteio.InputReader) を NewDefaultURLGeneratorImpl に注入
+	urlGen := pipeline.NewDefaultURLGeneratorImpl(urlReader)
+
+	// 4.2 ContentFetcher の構築
+	fetcher := pipeline.NewWebContentFetcherImpl(scraperExecutor)
+
+	// 4.3 MarkdownGenerator の構築 (ContentCleanerを注入)
+	markdownGen := pipeline.NewLLMMarkdownGeneratorImpl(contentCleaner)
+
+	// 4.4 Publisher の構築 (WriterとHTML Runnerを注入)
+	publisher, err := buildPublisher(outputWriter, bc.htmlRenderer)
+	if err != nil {
+		return nil, closer, err
+	}
+
+	// 全てのステージとオプションをPipelineに注入し、クリーンアップ関数も一緒に返す
+	p := pipeline.NewPipeline(opts, urlGen, fetcher, markdownGen, publisher)
+	// 進捗の通知先が指定されておらず (run)、標準エラー出力に表示できる場合は、Reduce の応答を逐次プレビュー表示する
+	if bc.progress == nil && progress.Interactive() {
+		p.ReducePreview = progress.NewPreview(os.Stderr, "Reduce")
+	}
+	if opts.RunManifestPath != "" {
+		p.Manifest = pipeline.NewRunManifest(opts, builders)
+	}
+	return p, closer, nil
+}
+
+// buildExecutor は、Gemini クライアント (WithModel が指定された場合はそのクライアント) を使用する LLMExecutor を構築します。
+// WithExecutor が指定された場合はそれを返します。
+// tape が指定された場合、LLM クライアントを記録・再生用のクライアントでラップします (再生時は Gemini クライアントを作成しません)。
+// WithModelWrapper のラッパーは、記録・再生用のクライアントを含む最終的なクライアントに適用します。
+func buildExecutor(ctx context.Context, opts pipeline.CmdOptions, tape *cassette.Cassette, bc buildConfig) (cleaner.LLMExecutor, error) {
+	if bc.executor != nil {
+		return bc.executor, nil
+	}
 	cfg := cleaner.LLMExecutorConfig{
 		APIKeyOverride: opts.LLMAPIKey,
-		Concurrency:    cleaner.DefaultMaxMapConcurrency,
+		Concurrency:    opts.MapConcurrency,
 		MapModel:       opts.MapModel,
 		ReduceModel:    opts.ReduceModel,
+		ResponsePolicy: opts.ResponsePolicy,
+		RateLimit:      bc.rateLimit,
+		Client:         bc.model,
+	}
+	if tape != nil {
+		var inner llm.GenerativeModel
+		if tape.Mode() == cassette.ModeRecord {
+			inner = bc.model
+			if inner == nil {
+				client, err := llm.NewGeminiClientWithKey(ctx, opts.LLMAPIKey)
+				if err != nil {
+					return nil, fmt.Errorf("LLMクライアントの初期化に失敗しました。APIキーを確認してください: %w", err)
+				}
+				inner = client
+			}
+		}
+		cfg.Client = cassette.NewModel(tape, inner)
+	}
+	if len(bc.modelWrappers) > 0 {
+		if cfg.Client == nil {
+			client, err := llm.NewGeminiClientWithKey(ctx, opts.LLMAPIKey)
+			if err != nil {
+				return nil, fmt.Errorf("LLMクライアントの初期化に失敗しました。APIキーを確認してください: %w", err)
+			}
+			cfg.Client = client
+		}
+		for _, wrap := range bc.modelWrappers {
+			cfg.Client = wrap(cfg.Client)
+		}
+	}
+	// 通知先が指定されていない場合は、標準エラー出力が端末で、ログが json 形式でない場合のみ Mapフェーズの進捗を1行で表示する
+	if bc.progress != nil {
+		cfg.Progress = bc.progress
+	} else if progress.Interactive() {
+		cfg.Progress = progress.NewTerminal(os.Stderr, "Map")
 	}
 	executor, err := cleaner.NewLLMConcurrentExecutor(ctx, cfg)
 	if err != nil {
-		return nil, closer, fmt.Errorf("LLM Executorの初期化に失敗しました: %w", err)
+		return nil, fmt.Errorf("LLM Executorの初期化に失敗しました: %w", err)
 	}
+	return executor, nil
+}
 
-	// Cleaner の構築
-	contentCleaner, err := cleaner.NewCleaner(builders, executor)
-	if err != nil {
-		return nil, closer, fmt.Errorf("Cleanerの初期化に失敗しました: %w", err)
+// hasCustomPrompts は、カスタムの Map/Reduce プロンプト (テンプレートのパスまたは WithPrompts) が指定されているかどうかを返します。
+func hasCustomPrompts(opts pipeline.CmdOptions, override *cleaner.PromptBuilders) bool {
+	if opts.MapPromptPath != "" || opts.ReducePromptPath != "" {
+		return true
 	}
+	return override != nil && (override.MapBuilder != nil || override.ReduceBuilder != nil)
+}
 
-	// ----------------------------------------------------------------
-	// 4. Go-Text-Format Runner の構築 (新規)
-	// ----------------------------------------------------------------
+// openCassette は、--record / --replay で指定されたカセットを開きます。どちらも指定されていない場合は nil を返します。
+func openCassette(opts pipeline.CmdOptions) (*cassette.Cassette, error) {
+	switch {
+	case opts.ReplayDir != "":
+		tape, err := cassette.Open(opts.ReplayDir)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("カセットを再生します。スクレイピングと LLM 呼び出しはネットワークにアクセスしません。", slog.String("dir", opts.ReplayDir))
+		return tape, nil
+	case opts.RecordDir != "":
+		tape, err := cassette.Create(opts.RecordDir)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("スクレイピング結果と LLM のリクエスト・レスポンスをカセットに記録します。", slog.String("dir", opts.RecordDir))
+		return tape, nil
+	}
+	return nil, nil
+}
 
-	// 4.1. Text Format Builderの構築 (Converter/Rendererを内部で初期化)
-	textFormatBuilder, err := textformat.NewBuilder(textformat.BuilderConfig{
-		EnableUnsafeHTML: false,
-	})
+// buildPromptBuilders は、Map/Reduce の PromptBuilder を構築します。
+// opts にテンプレートのパス (ローカルまたはGCS URI) が指定されている場合はそれを読み込み、
+// 対応するテンプレートデータのフィールドに対して検証します。未指定の場合は組み込みテンプレートを使用します。
+// override (WithPrompts) の nil でないフィールドは、テンプレートのパスより優先して使用します。
+func buildPromptBuilders(ctx context.Context, reader pipeline.InputReader, opts pipeline.CmdOptions, override *cleaner.PromptBuilders) (cleaner.PromptBuilders, error) {
+	var builders cleaner.PromptBuilders
+	if override != nil {
+		builders = *override
+	}
+
+	if builders.MapBuilder == nil {
+		builders.MapBuilder = prompts.NewMapPromptBuilder()
+		if opts.MapPromptPath != "" {
+			text, err := loadPromptTemplate(ctx, reader, opts.MapPromptPath)
+			if err != nil {
+				return cleaner.PromptBuilders{}, err
+			}
+			builders.MapBuilder = prompts.NewMapPromptBuilderFromTemplate(opts.MapPromptPath, text)
+			slog.Info("カスタムMapプロンプトを使用します。", slog.String("path", opts.MapPromptPath))
+		}
+	}
+	if err := builders.MapBuilder.Err(); err != nil {
+		return cleaner.PromptBuilders{}, fmt.Errorf("Map Prompt Builderの初期化に失敗しました: %w", err)
+	}
+
+	if builders.ReduceBuilder == nil {
+		builders.ReduceBuilder = prompts.NewReducePromptBuilder()
+		if opts.ReducePromptPath != "" {
+			text, err := loadPromptTemplate(ctx, reader, opts.ReducePromptPath)
+			if err != nil {
+				return cleaner.PromptBuilders{}, err
+			}
+			builders.ReduceBuilder = prompts.NewReducePromptBuilderFromTemplate(opts.ReducePromptPath, text)
+			slog.Info("カスタムReduceプロンプトを使用します。", slog.String("path", opts.ReducePromptPath))
+		}
+	}
+	if err := builders.ReduceBuilder.Err(); err != nil {
+		return cleaner.PromptBuilders{}, fmt.Errorf("Reduce Prompt Builderの初期化に失敗しました: %w", err)
+	}
+
+	if builders.SinglePassBuilder == nil {
+		builders.SinglePassBuilder = prompts.NewSinglePassPromptBuilder()
+	}
+	if err := builders.SinglePassBuilder.Err(); err != nil {
+		return cleaner.PromptBuilders{}, fmt.Errorf("Single Pass Prompt Builderの初期化に失敗しました: %w", err)
+	}
+
+	if builders.RepairBuilder == nil {
+		builders.RepairBuilder = prompts.NewRepairPromptBuilder()
+	}
+	if err := builders.RepairBuilder.Err(); err != nil {
+		return cleaner.PromptBuilders{}, fmt.Errorf("Repair Prompt Builderの初期化に失敗しました: %w", err)
+	}
+
+	return builders, nil
+}
+
+// loadPromptTemplate は、InputReader を使ってプロンプトテンプレートファイルを読み込みます。
+func loadPromptTemplate(ctx context.Context, reader pipeline.InputReader, path string) (string, error) {
+	rc, err := reader.Open(ctx, path)
 	if err != nil {
-		return nil, closer, fmt.Errorf("Text Format Builderの初期化に失敗しました: %w", err)
+		return "", fmt.Errorf("プロンプトテンプレート '%s' のオープンに失敗しました: %w", path, err)
 	}
+	defer rc.Close()
 
-	// 4.2. MarkdownToHtmlRunner の構築 (Converter/Rendererを注入)
-	htmlRunner, err := textFormatBuilder.BuildMarkdownToHtmlRunner()
+	data, err := io.ReadAll(rc)
 	if err != nil {
-		return nil, closer, fmt.Errorf("MarkdownToHtmlRunnerの構築に失敗しました: %w", err)
+		return "", fmt.Errorf("プロンプトテンプレート '%s' の読み込みに失敗しました: %w", path, err)
 	}
+	return string(data), nil
+}
 
-	// ----------------------------------------------------------------
-	// 5. パイプラインステージの実装とPipelineの構築 (DIの実行)
-	// ----------------------------------------------------------------
+// BuildPublisher は、生成済みMarkdownの再出力 (publish サブコマンド) に必要な
+// Publisher と InputReader、およびGCSクライアントのクリーンアップ関数を返します。
+// LLMクライアントやスクレイパーは構築しません。options のうち WithReader、WithWriter、WithHTMLRenderer が適用されます。
+func BuildPublisher(ctx context.Context, options ...Option) (pipeline.Publisher, pipeline.InputReader, func(), error) {
+	bc := newBuildConfig(options)
 
-	// 4.1 URLGenerator の構築
-	urlReader, err := GCSClient.NewInputReader()
+	gcs := newLazyGCS(ctx)
+	reader, writer := bc.reader, bc.writer
+	if reader == nil {
+		reader = gcs
+	}
+	if writer == nil {
+		writer = gcs
+	}
+
+	publisher, err := buildPublisher(writer, bc.htmlRenderer)
 	if err != nil {
-		return nil, closer, fmt.Errorf("InputReaderの生成に失敗しました: %w", err)
+		return nil, nil, gcs.Close, err
 	}
-	// urlReader (remoteio.InputReader) を NewDefaultURLGeneratorImpl に注入
-	urlGen := pipeline.NewDefaultURLGeneratorImpl(urlReader)
 
-	// 4.2 ContentFetcher の構築
-	fetcher := pipeline.NewWebContentFetcherImpl(scraperExecutor)
+	return publisher, reader, gcs.Close, nil
+}
+
+// BuildInputReader は、ローカルファイルとGCSオブジェクトを読み込む InputReader と、GCSクライアントのクリーンアップ関数を返します。
+// options のうち WithReader が適用されます。
+func BuildInputReader(ctx context.Context, options ...Option) (pipeline.InputReader, func()) {
+	bc := newBuildConfig(options)
+	if bc.reader != nil {
+		return bc.reader, func() {}
+	}
+	gcs := newLazyGCS(ctx)
+	return gcs, gcs.Close
+}
+
+// buildPublisher は、Writer と Go-Text-Format Runner を注入した Publisher を構築します。
+// htmlRenderer が指定された場合は Go-Text-Format Runner の代わりに使用します。
+func buildPublisher(outputWriter pipeline.Writer, htmlRenderer pipeline.MdToHtmlRunner) (*pipeline.UniversalPublisherImpl, error) {
+	if htmlRenderer == nil {
+		var err error
+		if htmlRenderer, err = NewHTMLRenderer(); err != nil {
+			return nil, err
+		}
+	}
+	return pipeline.NewUniversalPublisherImpl(outputWriter, htmlRenderer), nil
+}
 
-	// 4.3 OutputGenerator の構築 (ContentCleanerとWriterを注入)
-	rawOutputWriter, err := GCSClient.NewOutputWriter()
+// NewHTMLRenderer は、Go-Text-Format を使用して Markdown を完全なHTMLドキュメントに変換する MdToHtmlRunner を構築します。
+func NewHTMLRenderer() (pipeline.MdToHtmlRunner, error) {
+	// Text Format Builderの構築 (Converter/Rendererを内部で初期化)
+	textFormatBuilder, err := textformat.NewBuilder(textformat.BuilderConfig{
+		EnableUnsafeHTML: false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Text Format Builderの初期化に失敗しました: %w", err)
+	}
+
+	// MarkdownToHtmlRunner の構築 (Converter/Rendererを注入)
+	htmlRunner, err := textFormatBuilder.BuildMarkdownToHtmlRunner()
 	if err != nil {
-		return nil, closer, fmt.Errorf("OutputWriterの生成に失敗しました: %w", err)
+		return nil, fmt.Errorf("MarkdownToHtmlRunnerの構築に失敗しました: %w", err)
+	}
+	return htmlRunner, nil
+}
+
+// newOutputWriter は、Factoryから GCS とローカルの両方に書き込める Writer を生成します。
+func newOutputWriter(factory gcsfactory.Factory) (pipeline.Writer, error) {
+	rawOutputWriter, err := factory.NewOutputWriter()
+	if err != nil {
+		return nil, fmt.Errorf("OutputWriterの生成に失敗しました: %w", err)
 	}
 
 	// 具象型 (UniversalIOWriter) は pipeline.Writer (GCSとLocalの両機能を結合したもの) を満たす。
 	outputWriter, ok := rawOutputWriter.(pipeline.Writer)
 	if !ok {
 		// Factoryが予期せぬ型を返した場合のガード
-		return nil, closer, fmt.Errorf("生成されたWriterが pipeline.Writer インターフェース (GCS/Localの両機能) を満たしていません")
+		return nil, fmt.Errorf("生成されたWriterが pipeline.Writer インターフェース (GCS/Localの両機能) を満たしていません")
 	}
-
-	outputGen := pipeline.NewLLMOutputGeneratorImpl(contentCleaner, outputWriter, htmlRunner)
-
-	// 全てのステージとオプションをPipelineに注入し、クリーンアップ関数も一緒に返す
-	return pipeline.NewPipeline(opts, urlGen, fetcher, outputGen), closer, nil
+	return outputWriter, nil
 }
@@ -0,0 +1,400 @@
+package builder_test
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/fakes"
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+)
+
+// update が指定された場合、golden ファイルを実際の出力で更新します (go test ./internal/builder -update)。
+var update = flag.Bool("update", false, "golden ファイルを実際の出力で更新します")
+
+const urlFile = "urls.txt"
+
+// testPages は、フェイクのスクレイパーが返す抽出済み本文です。
+var testPages = map[string]string{
+	"https://example.com/go-concurrency": "Go のゴルーチンは軽量なスレッドで、チャネルを介して値を受け渡します。\n\nsync.WaitGroup はゴルーチンの完了待ちに使用します。",
+	"https://docs.example.org/context":   "context パッケージは、キャンセルとタイムアウトをゴルーチン間で伝播します。\n\nWithCancel は親のキャンセルを子に伝えます。",
+}
+
+// testURLList は、URLリストファイルの内容です。最後のURLはフェイクに登録されておらず、取得に失敗します。
+const testURLList = `# テスト用URLリスト
+https://example.com/go-concurrency
+https://docs.example.org/context
+https://unreachable.example.net/
+`
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	// パイプラインのログはテスト結果に不要なため破棄する
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+func baseOptions() pipeline.CmdOptions {
+	return pipeline.CmdOptions{
+		URLFile:        urlFile,
+		OutputFormat:   pipeline.FormatAuto,
+		Language:       "ja",
+		CitationPolicy: cleaner.CitationPolicyRemove,
+		CitationStyle:  cleaner.CitationStyleSection,
+		Mode:           cleaner.ModeAuto,
+		MapModel:       "fake-map",
+		ReduceModel:    "fake-reduce",
+		MapConcurrency: 2,
+	}
+}
+
+// buildTestPipeline は、フェイクの LLM クライアント・スクレイパー・入出力で Pipeline を構築します。
+// LLM クライアントの層だけを置き換えるため、クリーンアップの処理は実際の LLMConcurrentExecutor で実行されます。
+func buildTestPipeline(t *testing.T, opts pipeline.CmdOptions, model *fakes.Model, scraper *fakes.Scraper, writer *fakes.Writer) *pipeline.Pipeline {
+	t.Helper()
+	p, closer, err := builder.BuildPipeline(context.Background(), opts,
+		builder.WithModel(model),
+		builder.WithMapRateLimit(time.Millisecond),
+		builder.WithMapProgress(nopProgress{}),
+		builder.WithScraper(scraper),
+		builder.WithReader(fakes.NewInputReader(map[string]string{urlFile: testURLList})),
+		builder.WithWriter(writer),
+	)
+	if err != nil {
+		t.Fatalf("BuildPipeline: %v", err)
+	}
+	t.Cleanup(closer)
+	return p
+}
+
+// nopProgress は、Mapフェーズの進捗を表示しない cleaner.MapProgress です。
+type nopProgress struct{}
+
+func (nopProgress) Start(int) {}
+func (nopProgress) Advance()  {}
+func (nopProgress) Finish()   {}
+
+func TestPipelineExecuteGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*pipeline.CmdOptions)
+	}{
+		{
+			name: "mapreduce_markdown",
+			modify: func(o *pipeline.CmdOptions) {
+				o.Mode = cleaner.ModeMapReduce
+				o.OutputFilePath = "out/report.md"
+			},
+		},
+		{
+			name: "single_pass_markdown",
+			modify: func(o *pipeline.CmdOptions) {
+				o.Topic = "Go の並行処理"
+				o.OutputFilePath = "out/report.md"
+			},
+		},
+		{
+			name: "mapreduce_html",
+			modify: func(o *pipeline.CmdOptions) {
+				o.Mode = cleaner.ModeMapReduce
+				o.OutputFilePath = "out/report.html"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := baseOptions()
+			tt.modify(&opts)
+
+			scraper := fakes.NewScraper(testPages)
+			writer := fakes.NewWriter()
+			p := buildTestPipeline(t, opts, fakes.NewModel(), scraper, writer)
+
+			if err := p.Execute(context.Background()); err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+
+			if got := len(scraper.Requested()); got != 3 {
+				t.Errorf("requested URLs = %d, want 3", got)
+			}
+			got, ok := writer.File(opts.OutputFilePath)
+			if !ok {
+				t.Fatalf("%s was not written (written: %v)", opts.OutputFilePath, writer.Paths())
+			}
+			assertGolden(t, tt.name, got)
+		})
+	}
+}
+
+func TestPipelineExecuteNoContent(t *testing.T) {
+	opts := baseOptions()
+	opts.OutputFilePath = "out/report.md"
+
+	writer := fakes.NewWriter()
+	p := buildTestPipeline(t, opts, fakes.NewModel(), fakes.NewScraper(nil), writer)
+
+	err := p.Execute(context.Background())
+	if err == nil || !strings.Contains(err.Error(), pipeline.PhaseContent) {
+		t.Fatalf("Execute error = %v, want %s error", err, pipeline.PhaseContent)
+	}
+	if paths := writer.Paths(); len(paths) != 0 {
+		t.Errorf("unexpected output: %v", paths)
+	}
+}
+
+func TestPipelineExecuteRecoversBlockedResponses(t *testing.T) {
+	opts := baseOptions()
+	opts.Mode = cleaner.ModeMapReduce
+	opts.MapModel = "blocked-map,fake-map"
+	opts.OutputFilePath = "out/report.md"
+
+	// 先頭のモデルは常にブロックし、context のソースはフォールバック先でもブロックする
+	model := fakes.NewModel()
+	model.Respond = func(call fakes.Call) (*llm.Response, error) {
+		if call.Phase != fakes.PhaseMap {
+			return nil, nil
+		}
+		if call.Model == "blocked-map" || strings.Contains(call.Prompt, "docs.example.org/context") {
+			return nil, llm.NewResponseError("SAFETY", false, "応答がブロックされました")
+		}
+		return nil, nil
+	}
+	writer := fakes.NewWriter()
+	p := buildTestPipeline(t, opts, model, fakes.NewScraper(testPages), writer)
+
+	if err := p.Execute(context.Background()); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	got, ok := writer.File(opts.OutputFilePath)
+	if !ok {
+		t.Fatalf("%s was not written (written: %v)", opts.OutputFilePath, writer.Paths())
+	}
+	if !strings.Contains(got, "https://example.com/go-concurrency") {
+		t.Errorf("output lacks the recovered source:\n%s", got)
+	}
+	// 応答ポリシー (retry, split, skip) で回復できなかったソースは、末尾の注記に列挙される
+	if !strings.Contains(got, "<https://docs.example.org/context> (セグメント 2, 終了理由: SAFETY)") {
+		t.Errorf("output lacks the omitted source notice:\n%s", got)
+	}
+
+	var retried bool
+	for _, call := range model.Calls() {
+		if call.Phase == fakes.PhaseMap && call.Options > 0 {
+			retried = true
+		}
+	}
+	if !retried {
+		t.Errorf("the blocked segment was not retried with different settings")
+	}
+}
+
+func TestPipelineExecuteWritesManifest(t *testing.T) {
+	opts := baseOptions()
+	opts.Mode = cleaner.ModeMapReduce
+	opts.OutputFilePath = "out/report.md"
+	opts.LLMAPIKey = "secret-key"
+	opts.RunID = "run-1"
+	opts.RunManifestPath = pipeline.DefaultManifestPath(opts.OutputFilePath)
+
+	writer := fakes.NewWriter()
+	p := buildTestPipeline(t, opts, fakes.NewModel(), fakes.NewScraper(testPages), writer)
+
+	if err := p.Execute(context.Background()); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	raw, ok := writer.File("out/report.manifest.json")
+	if !ok {
+		t.Fatalf("manifest was not written (written: %v)", writer.Paths())
+	}
+	if strings.Contains(raw, "secret-key") {
+		t.Errorf("manifest contains the API key:\n%s", raw)
+	}
+	var m pipeline.RunManifest
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		t.Fatalf("manifest is not valid JSON: %v", err)
+	}
+	if m.RunID != "run-1" || m.Status != pipeline.ManifestStatusSucceeded {
+		t.Errorf("run_id, status = %q, %q, want run-1, %s", m.RunID, m.Status, pipeline.ManifestStatusSucceeded)
+	}
+	if len(m.URLs) != 3 || m.URLs[2].Status != "failed" {
+		t.Errorf("urls = %+v, want 3 entries with the last one failed", m.URLs)
+	}
+	if len(m.Segments) == 0 || len(m.Prompts) == 0 || len(m.Phases) != 4 {
+		t.Errorf("segments, prompts, phases = %d, %d, %d", len(m.Segments), len(m.Prompts), len(m.Phases))
+	}
+}
+
+func TestPipelineExecuteStreamsReduceOutput(t *testing.T) {
+	t.Run("local file", func(t *testing.T) {
+		opts := baseOptions()
+		opts.Mode = cleaner.ModeMapReduce
+		opts.OutputFilePath = "out/report.md"
+		model := fakes.NewModel()
+		writer := fakes.NewWriter()
+		p := buildTestPipeline(t, opts, model, fakes.NewScraper(testPages), writer)
+
+		if err := p.Execute(context.Background()); err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		streamed, ok := writer.File("out/report.stream.md")
+		if !ok || !strings.HasPrefix(streamed, "# ") {
+			t.Fatalf("stream file = %q, %v (written: %v), want the raw Reduce response", streamed, ok, writer.Paths())
+		}
+		if doc, ok := writer.File(opts.OutputFilePath); !ok || !strings.HasPrefix(doc, "# ") {
+			t.Errorf("output = %q, %v, want the validated document", doc, ok)
+		}
+		var streamedReduce bool
+		for _, call := range model.Calls() {
+			streamedReduce = streamedReduce || (call.Phase == fakes.PhaseReduce && call.Stream)
+		}
+		if !streamedReduce {
+			t.Error("Reduce was not generated with streaming")
+		}
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		opts := baseOptions()
+		opts.Mode = cleaner.ModeMapReduce
+		opts.OutputFilePath = pipeline.StdoutPath
+		writer := fakes.NewWriter()
+		p := buildTestPipeline(t, opts, fakes.NewModel(), fakes.NewScraper(testPages), writer)
+
+		stdout := captureStdout(t, func() {
+			if err := p.Execute(context.Background()); err != nil {
+				t.Errorf("Execute: %v", err)
+			}
+		})
+		heading, _, _ := strings.Cut(stdout, "\n")
+		if !strings.HasPrefix(heading, "# ") || strings.Count(stdout, heading+"\n") != 1 {
+			t.Errorf("stdout = %q, want the streamed Reduce response exactly once", stdout)
+		}
+		if paths := writer.Paths(); len(paths) != 0 {
+			t.Errorf("unexpected files for stdout output: %v", paths)
+		}
+	})
+}
+
+// captureStdout は、fn の実行中に標準出力へ書き込まれた内容を返します。
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	defer func() { os.Stdout = orig }()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestPipelineExecuteWritesPartialResultsOnlyWhenCanceled(t *testing.T) {
+	tests := []struct {
+		name        string
+		ctx         func() (context.Context, context.CancelFunc)
+		cancel      bool
+		wantPartial bool
+		wantStatus  string
+	}{
+		{
+			name:        "canceled during reduce",
+			ctx:         func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			cancel:      true,
+			wantPartial: true,
+			wantStatus:  pipeline.ManifestStatusCanceled,
+		},
+		{
+			name: "deadline exceeded during reduce",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 500*time.Millisecond)
+			},
+			wantStatus: pipeline.ManifestStatusFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := baseOptions()
+			opts.Mode = cleaner.ModeMapReduce
+			opts.OutputFilePath = "out/report.md"
+			opts.RunManifestPath = pipeline.DefaultManifestPath(opts.OutputFilePath)
+
+			// Mapフェーズは完了させ、Reduce の呼び出し中に中断 (または期限切れ) させる
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			model := fakes.NewModel()
+			model.Respond = func(call fakes.Call) (*llm.Response, error) {
+				if call.Phase != fakes.PhaseReduce {
+					return nil, nil
+				}
+				if tt.cancel {
+					cancel()
+				}
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			writer := fakes.NewWriter()
+			p := buildTestPipeline(t, opts, model, fakes.NewScraper(testPages), writer)
+
+			if err := p.Execute(ctx); err == nil {
+				t.Fatal("Execute succeeded, want an error")
+			}
+
+			partial, ok := writer.File("out/report.partial.md")
+			if ok != tt.wantPartial {
+				t.Fatalf("partial written = %v, want %v (written: %v)", ok, tt.wantPartial, writer.Paths())
+			}
+			if ok && !strings.Contains(partial, "## セグメント 1: https://example.com/go-concurrency") {
+				t.Errorf("partial results lack the completed segment:\n%s", partial)
+			}
+			raw, ok := writer.File("out/report.manifest.json")
+			if !ok {
+				t.Fatalf("manifest was not written (written: %v)", writer.Paths())
+			}
+			var m pipeline.RunManifest
+			if err := json.Unmarshal([]byte(raw), &m); err != nil {
+				t.Fatalf("manifest is not valid JSON: %v", err)
+			}
+			if m.Status != tt.wantStatus || (m.Outputs.Partial != "") != tt.wantPartial {
+				t.Errorf("status, outputs.partial = %q, %q, want %s", m.Status, m.Outputs.Partial, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// assertGolden は、got を testdata/golden/<name>.golden と比較します。-update の場合は golden ファイルを書き換えます。
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("golden ファイルの更新に失敗しました: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("golden ファイルの読み込みに失敗しました (-update で生成できます): %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("output does not match %s (-update で更新できます)\n--- got ---\n%s\n--- want ---\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,118 @@
+// Package cassette は、実行時のスクレイピング結果と LLM のリクエスト・レスポンスをディレクトリ (カセット) に記録し、
+// ネットワークにアクセスせずに決定的に再生する機能を提供します。
+// ScraperRunner と llm.GenerativeModel をラップするため、パイプラインの他の部分は記録・再生を意識しません。
+//
+// カセットのレイアウト:
+//
+//	<dir>/pages/<sha256(URL)>.json      スクレイピング結果 (URL、本文またはエラー)
+//	<dir>/llm/<sha256(リクエスト)>.json  LLM のリクエスト (モデル、生成設定、プロンプト) と、呼び出し順の応答またはエラー
+package cassette
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	pagesDir = "pages"
+	llmDir   = "llm"
+)
+
+// Mode は、カセットの使用方法です。
+type Mode int
+
+const (
+	// ModeRecord は、実際の呼び出し結果をカセットに書き込みます。
+	ModeRecord Mode = iota
+	// ModeReplay は、カセットから記録済みの結果を読み込みます。
+	ModeReplay
+)
+
+// Cassette は、記録・再生に使用するディレクトリです。複数の Goroutine から安全に使用できます。
+type Cassette struct {
+	dir  string
+	mode Mode
+	mu   sync.Mutex
+}
+
+// Create は、記録用のカセットを作成します。ディレクトリが存在しない場合は作成し、既存の記録は同じリクエストの場合に上書きされます。
+func Create(dir string) (*Cassette, error) {
+	for _, sub := range []string{pagesDir, llmDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("カセットディレクトリ '%s' の作成に失敗しました: %w", dir, err)
+		}
+	}
+	return &Cassette{dir: dir, mode: ModeRecord}, nil
+}
+
+// Open は、再生用のカセットを開きます。ディレクトリが存在しない場合はエラーを返します。
+func Open(dir string) (*Cassette, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("カセットディレクトリ '%s' を開けません: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("カセットのパス '%s' はディレクトリではありません", dir)
+	}
+	return &Cassette{dir: dir, mode: ModeReplay}, nil
+}
+
+// Dir は、カセットのディレクトリを返します。
+func (c *Cassette) Dir() string {
+	return c.dir
+}
+
+// Mode は、カセットの使用方法を返します。
+func (c *Cassette) Mode() Mode {
+	return c.mode
+}
+
+// hashKey は、記録のファイル名に使用するハッシュを返します。
+func hashKey(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// path は、種類とキーに対応する記録ファイルのパスを返します。
+func (c *Cassette) path(kind, key string) string {
+	return filepath.Join(c.dir, kind, key+".json")
+}
+
+// load は、記録ファイルを読み込みます。存在しない場合は os.ErrNotExist をラップしたエラーを返します。
+func (c *Cassette) load(kind, key string, v any) error {
+	data, err := os.ReadFile(c.path(kind, key))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("カセットの記録 '%s' の解析に失敗しました: %w", c.path(kind, key), err)
+	}
+	return nil
+}
+
+// save は、記録ファイルを書き込みます。
+func (c *Cassette) save(kind, key string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("カセットの記録のエンコードに失敗しました: %w", err)
+	}
+	if err := os.WriteFile(c.path(kind, key), data, 0o644); err != nil {
+		return fmt.Errorf("カセットの記録 '%s' の書き込みに失敗しました: %w", c.path(kind, key), err)
+	}
+	return nil
+}
+
+// isNotRecorded は、エラーが記録の欠落を示すかどうかを返します。
+func isNotRecorded(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
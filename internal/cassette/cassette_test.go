@@ -0,0 +1,95 @@
+package cassette
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/fakes"
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+)
+
+func TestModelRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	recordTape, err := Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := fakes.NewModel()
+	inner.Respond = func(call fakes.Call) (*llm.Response, error) {
+		if call.Options == 0 {
+			return nil, llm.NewResponseError("SAFETY", false, "blocked")
+		}
+		return &llm.Response{Text: "再試行の応答", FinishReason: "STOP"}, nil
+	}
+	recorder := NewModel(recordTape, inner)
+	if _, err := recorder.GenerateContent(ctx, "プロンプト", "fake-model"); llm.Classify(err) != llm.FailureSafety {
+		t.Fatalf("recording: error = %v, want a safety block", err)
+	}
+	if _, err := recorder.GenerateContent(ctx, "プロンプト", "fake-model", llm.WithTemperature(0.2)); err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+	if _, err := recorder.GenerateContent(ctx, "プロンプト", "fake-model", llm.WithTemperature(0.2)); err != nil {
+		t.Fatalf("recording: %v", err)
+	}
+
+	replayTape, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	player := NewModel(replayTape, nil)
+
+	_, err = player.GenerateContent(ctx, "プロンプト", "fake-model")
+	if llm.Classify(err) != llm.FailureSafety {
+		t.Errorf("replay: error = %v, want the recorded safety block", err)
+	}
+	for i := range 2 {
+		resp, err := player.GenerateContent(ctx, "プロンプト", "fake-model", llm.WithTemperature(0.2))
+		if err != nil || resp.Text != "再試行の応答" {
+			t.Fatalf("replay call %d = %v, %v, want the recorded response", i+1, resp, err)
+		}
+	}
+	if _, err := player.GenerateContent(ctx, "プロンプト", "fake-model", llm.WithTemperature(0.2)); err == nil || !strings.Contains(err.Error(), "使い切りました") {
+		t.Errorf("replay beyond the recorded calls: error = %v, want an exhausted error", err)
+	}
+	if _, err := player.GenerateContent(ctx, "別のプロンプト", "fake-model"); err == nil || !strings.Contains(err.Error(), "記録されていない") {
+		t.Errorf("replay of an unrecorded request: error = %v, want a not recorded error", err)
+	}
+}
+
+func TestScraperReplaysFetchTimes(t *testing.T) {
+	dir := t.TempDir()
+	urls := []string{"https://example.com/a", "https://example.com/missing"}
+
+	recordTape, err := Create(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := &cleaner.FetchTimes{}
+	recorder := NewScraper(recordTape, fakes.NewScraper(map[string]string{urls[0]: "本文"}))
+	if got := recorder.ScrapeInParallel(cleaner.WithFetchTimes(context.Background(), recorded), urls); len(got) != 1 {
+		t.Fatalf("recording returned %d results, want 1", len(got))
+	}
+	recordedAt, ok := recorded.At(urls[0])
+	if !ok {
+		t.Fatal("recording did not set the fetch time")
+	}
+
+	replayTape, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := &cleaner.FetchTimes{}
+	got := NewScraper(replayTape, nil).ScrapeInParallel(cleaner.WithFetchTimes(context.Background(), replayed), urls)
+	if len(got) != 1 || got[0].URL != urls[0] || got[0].Content != "本文" {
+		t.Fatalf("replay = %+v, want only the recorded page", got)
+	}
+	at, ok := replayed.At(urls[0])
+	if !ok || !at.Equal(recordedAt) {
+		t.Errorf("replayed fetch time = %v, %v, want %v", at, ok, recordedAt.Format(time.RFC3339Nano))
+	}
+}
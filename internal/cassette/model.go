@@ -0,0 +1,198 @@
+package cassette
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+
+	"google.golang.org/genai"
+)
+
+// llmRecord は、同一のリクエスト (モデル、生成設定、プロンプト) に対する呼び出しの記録です。
+type llmRecord struct {
+	Model   string `json:"model"`
+	Options string `json:"options,omitempty"`
+	Prompt  string `json:"prompt"`
+	// Interactions は、呼び出し順の応答またはエラーです。
+	Interactions []interaction `json:"interactions"`
+}
+
+// interaction は、1回の呼び出しの結果です。Response と Error のどちらか一方が設定されます。
+type interaction struct {
+	Response *responseRecord `json:"response,omitempty"`
+	Error    *errorRecord    `json:"error,omitempty"`
+}
+
+type responseRecord struct {
+	Text         string `json:"text"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	PromptTokens int    `json:"prompt_tokens,omitempty"`
+	OutputTokens int    `json:"output_tokens,omitempty"`
+	TotalTokens  int    `json:"total_tokens,omitempty"`
+}
+
+type errorRecord struct {
+	Message string `json:"message"`
+	// Class は llm.Classify による失敗の分類です。再生時に同じ分類のエラーを復元するために使用します。
+	Class        string `json:"class"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	Blocked      bool   `json:"blocked,omitempty"`
+}
+
+// Model は、llm.GenerativeModel をラップしてリクエストと応答を記録・再生する GenerativeModel です。
+// リクエストはモデル名・生成設定・プロンプトで照合し、同じリクエストが複数回送られた場合は記録順に応答を返します
+// (記録を使い切った後の呼び出しは、記録時より呼び出しが多いことを示すエラーになります)。
+type Model struct {
+	cassette *Cassette
+	inner    llm.GenerativeModel
+	// records は、このセッションで記録・再生中のリクエストごとの記録です (キーはファイル名のハッシュ)。
+	records map[string]*llmRecord
+	// cursors は、再生時にリクエストごとに次に返す応答の位置です。
+	cursors map[string]int
+}
+
+var _ llm.GenerativeModel = (*Model)(nil)
+
+// NewModel は、カセットのモードに応じて記録または再生を行う Model を作成します。
+// 再生時は inner を使用しないため nil を指定できます。
+func NewModel(c *Cassette, inner llm.GenerativeModel) *Model {
+	return &Model{
+		cassette: c,
+		inner:    inner,
+		records:  make(map[string]*llmRecord),
+		cursors:  make(map[string]int),
+	}
+}
+
+// GenerateContent は、記録時は inner を呼び出して結果を記録し、再生時は記録済みの応答を返します。
+func (m *Model) GenerateContent(ctx context.Context, prompt string, modelName string, opts ...llm.GenerateOption) (*llm.Response, error) {
+	options := llm.OptionsKey(opts...)
+	if m.cassette.mode == ModeReplay {
+		return m.replay(ctx, modelName, options, prompt)
+	}
+	resp, err := m.inner.GenerateContent(ctx, prompt, modelName, opts...)
+	m.record(modelName, options, prompt, resp, err)
+	return resp, err
+}
+
+// GenerateContentStream は、記録時は inner のストリーミング生成をそのまま中継して結果を記録し、
+// 再生時は記録済みの応答テキストを1つのチャンクとして onChunk に渡します。
+func (m *Model) GenerateContentStream(ctx context.Context, prompt string, modelName string, onChunk func(text string) error, opts ...llm.GenerateOption) (*llm.Response, error) {
+	options := llm.OptionsKey(opts...)
+	if m.cassette.mode == ModeReplay {
+		resp, err := m.replay(ctx, modelName, options, prompt)
+		if err != nil {
+			return nil, err
+		}
+		if err := onChunk(resp.Text); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+	resp, err := m.inner.GenerateContentStream(ctx, prompt, modelName, onChunk, opts...)
+	m.record(modelName, options, prompt, resp, err)
+	return resp, err
+}
+
+// record は、1回の呼び出しの結果をリクエストの記録に追加して書き込みます。キャンセルによる失敗は記録しません。
+func (m *Model) record(model, options, prompt string, resp *llm.Response, err error) {
+	class := llm.Classify(err)
+	if err != nil && class == llm.FailureCanceled {
+		return
+	}
+
+	var it interaction
+	if err != nil {
+		it.Error = &errorRecord{Message: err.Error(), Class: string(class)}
+		var respErr *llm.ResponseError
+		if errors.As(err, &respErr) {
+			it.Error.FinishReason = respErr.FinishReason
+			it.Error.Blocked = respErr.Blocked
+		}
+	} else {
+		it.Response = &responseRecord{
+			Text:         resp.Text,
+			FinishReason: resp.FinishReason,
+			PromptTokens: resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.OutputTokens,
+			TotalTokens:  resp.Usage.TotalTokens,
+		}
+	}
+
+	key := hashKey(model, options, prompt)
+	m.cassette.mu.Lock()
+	defer m.cassette.mu.Unlock()
+	rec, ok := m.records[key]
+	if !ok {
+		rec = &llmRecord{Model: model, Options: options, Prompt: prompt}
+		m.records[key] = rec
+	}
+	rec.Interactions = append(rec.Interactions, it)
+	if saveErr := m.cassette.save(llmDir, key, rec); saveErr != nil {
+		slog.Warn("LLM の応答をカセットに記録できませんでした。", slog.String("model", model), slog.String("error", saveErr.Error()))
+	}
+}
+
+// replay は、リクエストに対応する記録済みの応答を返します。記録がない場合はエラーを返します。
+func (m *Model) replay(ctx context.Context, model, options, prompt string) (*llm.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key := hashKey(model, options, prompt)
+	m.cassette.mu.Lock()
+	defer m.cassette.mu.Unlock()
+	rec, ok := m.records[key]
+	if !ok {
+		rec = &llmRecord{}
+		if err := m.cassette.load(llmDir, key, rec); err != nil {
+			if isNotRecorded(err) {
+				return nil, fmt.Errorf("カセットに記録されていない LLM リクエストです (model: %s, key: %s)。プロンプトまたは入力が記録時から変更されています", model, key[:12])
+			}
+			return nil, err
+		}
+		m.records[key] = rec
+	}
+	if len(rec.Interactions) == 0 {
+		return nil, fmt.Errorf("カセットの LLM 記録に応答がありません (model: %s, key: %s)", model, key[:12])
+	}
+
+	i := m.cursors[key]
+	if i >= len(rec.Interactions) {
+		return nil, fmt.Errorf("カセットに記録された LLM の応答を使い切りました (model: %s, key: %s, 記録数: %d)。記録時より多く呼び出されています", model, key[:12], len(rec.Interactions))
+	}
+	m.cursors[key] = i + 1
+
+	it := rec.Interactions[i]
+	if it.Error != nil {
+		return nil, it.Error.restore()
+	}
+	if it.Response == nil {
+		return nil, fmt.Errorf("カセットの LLM 記録が不正です (model: %s, key: %s)", model, key[:12])
+	}
+	return &llm.Response{
+		Text:         it.Response.Text,
+		FinishReason: it.Response.FinishReason,
+		Usage: llm.Usage{
+			PromptTokens: it.Response.PromptTokens,
+			OutputTokens: it.Response.OutputTokens,
+			TotalTokens:  it.Response.TotalTokens,
+		},
+	}, nil
+}
+
+// restore は、記録したエラーを、同じ分類 (llm.Classify) になるエラーとして復元します。
+func (e *errorRecord) restore() error {
+	switch llm.FailureClass(e.Class) {
+	case llm.FailureSafety, llm.FailureTruncated, llm.FailureEmpty:
+		return llm.NewResponseError(e.FinishReason, e.Blocked, e.Message)
+	case llm.FailureQuota:
+		return genai.APIError{Code: http.StatusTooManyRequests, Message: e.Message}
+	default:
+		return errors.New(e.Message)
+	}
+}
@@ -0,0 +1,112 @@
+package cassette
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// pageRecord は、1URL分のスクレイピング結果の記録です。
+type pageRecord struct {
+	URL     string `json:"url"`
+	Content string `json:"content,omitempty"`
+	// Error は、取得に失敗した場合の説明です。
+	Error string `json:"error,omitempty"`
+	// FetchedAt は、記録時にコンテンツを取得した日時です。プロンプトの取得日 (FetchDate) を記録時と一致させるために再生時に使用します。
+	FetchedAt *time.Time `json:"fetched_at,omitempty"`
+}
+
+// failedPageMessage は、取得に失敗したURLに記録する説明です (ScraperRunner は成功した結果のみを返すため、理由は記録されません)。
+const failedPageMessage = "記録時にコンテンツを取得できませんでした"
+
+// Scraper は、ScraperRunner をラップしてスクレイピング結果を記録・再生する ScraperRunner です。
+type Scraper struct {
+	cassette *Cassette
+	inner    pipeline.ScraperRunner
+}
+
+var _ pipeline.ScraperRunner = (*Scraper)(nil)
+
+// NewScraper は、カセットのモードに応じて記録または再生を行う Scraper を作成します。
+// 再生時は inner を使用しないため nil を指定できます。
+func NewScraper(c *Cassette, inner pipeline.ScraperRunner) *Scraper {
+	return &Scraper{cassette: c, inner: inner}
+}
+
+// ScrapeInParallel は、記録時は inner の結果を記録して返し、再生時は記録済みの本文を入力順に返します。
+// ctx に取得日時の記録先 (cleaner.WithFetchTimes) が設定されている場合は、記録時・再生時ともにカセットに記録した取得日時を設定します。
+// 取得日はプロンプトに含まれるため、再生を別の日に行っても記録時と同じリクエストとして照合されます。
+func (s *Scraper) ScrapeInParallel(ctx context.Context, urls []string) []extTypes.URLResult {
+	if s.cassette.mode == ModeReplay {
+		return s.replay(ctx, urls)
+	}
+
+	results := s.inner.ScrapeInParallel(ctx, urls)
+	if ctx.Err() != nil {
+		// キャンセルによる取得失敗は、記録時の実際の結果ではないため記録しない
+		return results
+	}
+	fetchTimes := cleaner.FetchTimesFrom(ctx)
+	fetchedAt := time.Now()
+	fetched := make(map[string]string, len(results))
+	for _, r := range results {
+		fetched[r.URL] = r.Content
+		fetchTimes.Record(r.URL, fetchedAt)
+	}
+
+	s.cassette.mu.Lock()
+	defer s.cassette.mu.Unlock()
+	for _, u := range urls {
+		rec := pageRecord{URL: u}
+		if content, ok := fetched[u]; ok {
+			rec.Content = content
+			if at, ok := fetchTimes.At(u); ok {
+				rec.FetchedAt = &at
+			} else {
+				rec.FetchedAt = &fetchedAt
+			}
+		} else {
+			rec.Error = failedPageMessage
+		}
+		if err := s.cassette.save(pagesDir, hashKey(u), rec); err != nil {
+			slog.Warn("スクレイピング結果をカセットに記録できませんでした。", slog.String(logging.KeyURL, u), slog.String("error", err.Error()))
+		}
+	}
+	return results
+}
+
+func (s *Scraper) replay(ctx context.Context, urls []string) []extTypes.URLResult {
+	s.cassette.mu.Lock()
+	defer s.cassette.mu.Unlock()
+
+	fetchTimes := cleaner.FetchTimesFrom(ctx)
+	var results []extTypes.URLResult
+	for _, u := range urls {
+		var rec pageRecord
+		if err := s.cassette.load(pagesDir, hashKey(u), &rec); err != nil {
+			if isNotRecorded(err) {
+				slog.Warn("カセットに記録されていないURLのため、取得失敗として扱います。", slog.String(logging.KeyURL, u))
+			} else {
+				slog.Warn("カセットからスクレイピング結果を読み込めませんでした。", slog.String(logging.KeyURL, u), slog.String("error", err.Error()))
+			}
+			continue
+		}
+		if rec.Error != "" || rec.Content == "" {
+			slog.Info("記録時に取得に失敗したURLです。", slog.String(logging.KeyURL, u))
+			continue
+		}
+		if rec.FetchedAt != nil {
+			fetchTimes.Record(u, *rec.FetchedAt)
+		} else {
+			slog.Warn("取得日時が記録されていないURLです。再生時の日付が記録時と異なる場合、LLM リクエストが記録と一致しません。", slog.String(logging.KeyURL, u))
+		}
+		results = append(results, extTypes.URLResult{URL: u, Content: rec.Content})
+	}
+	return results
+}
@@ -0,0 +1,353 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 引用URL検証のポリシー
+const (
+	// CitationPolicyRemove は、ソースに存在しないURLを文書から削除し、削除した位置に削除済みのマークを残します
+	// (URLのみのリスト項目は行ごと削除します)。
+	CitationPolicyRemove = "remove"
+	// CitationPolicyFlag は、ソースに存在しないURLを残したまま警告マークを付与します。
+	CitationPolicyFlag = "flag"
+	// CitationPolicyOff は、引用URLの検証を行いません。
+	CitationPolicyOff = "off"
+)
+
+// DefaultCitationPolicy は、引用URL検証ポリシーの既定値です。文書の内容を黙って削除しないよう、警告マークの付与とします。
+const DefaultCitationPolicy = CitationPolicyFlag
+
+// 出典の表記方式
+const (
+	// CitationStyleSection は、各 `##` セクションの直後に関連URLリストを付与します (従来の方式)。
+	CitationStyleSection = "section"
+	// CitationStyleFootnote は、各主張に脚注マーカー ([^1]) を付与し、文末に参考文献セクションを生成します。
+	CitationStyleFootnote = "footnote"
+)
+
+// unverifiedCitationMark は、CitationPolicyFlag で未検証のURLに付与するマークです。
+const unverifiedCitationMark = " ⚠️(未検証の出典)"
+
+// removedCitationMark は、CitationPolicyRemove で未検証のURLや脚注マーカーを削除した位置に残すマークです。
+const removedCitationMark = " ⚠️(未検証の出典を削除)"
+
+var (
+	// citedURLPattern は、Markdown中のURLに一致します (末尾の句読点や括弧は replaceBareURLs で除去します)。
+	citedURLPattern = regexp.MustCompile(`https?://[^\s<>"'\x60\]]+`)
+	// markdownLinkPattern は、[テキスト](URL) 形式のリンクに一致します。
+	markdownLinkPattern = regexp.MustCompile(`\[([^\]]*)\]\((https?://[^)\s]+)\)`)
+	// urlOnlyListItemPattern は、URLのみを含むリスト項目の行に一致します。
+	urlOnlyListItemPattern = regexp.MustCompile(`^\s*[-*+]\s+<?(https?://\S+?)>?\s*$`)
+	// relatedURLHeadingPattern は、Reduce プロンプトが要求する "関連URL" 見出しの行に一致します。
+	relatedURLHeadingPattern = regexp.MustCompile(`^\s*(\*\*)?(#{1,6}\s*)?関連URL\s*(\*\*)?\s*:?\s*$`)
+	// headingPattern は、Markdownの見出し行に一致します。
+	headingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	// footnoteMarkerPattern は、本文中の脚注マーカー [^N] に一致します。
+	footnoteMarkerPattern = regexp.MustCompile(`\[\^([^\]\s]+)\]`)
+	// footnoteDefinitionPattern は、脚注定義 ([^N]: ...) の行に一致します。
+	footnoteDefinitionPattern = regexp.MustCompile(`^\s*\[\^[^\]]+\]:`)
+)
+
+// CitationReport は、Reduce 出力に含まれる引用URLの検証結果です。
+type CitationReport struct {
+	// Policy は適用した検証ポリシーです。
+	Policy string
+	// VerifiedCount は、ソースに存在することを確認できたURLの出現数です。
+	VerifiedCount int
+	// Unknown は、ソースに存在しないURLの出現です。
+	Unknown []UnknownCitation
+	// UncitedSections は、ソースURLを1件も引用していない `##` セクションの見出しです。
+	UncitedSections []string
+	// CitedSourceIDs は、脚注モードで本文から引用されたソースIDです (昇順)。
+	CitedSourceIDs []int
+}
+
+// UnknownCitation は、ソースに存在しないURLまたは脚注マーカーの出現1件です。
+type UnknownCitation struct {
+	Section string
+	URL     string
+	// Marker は、脚注モードで存在しないソースIDを指していたマーカー (例: "[^9]") です。
+	Marker string
+	// Action は適用した処理 ("removed" または "flagged") です。
+	Action string
+}
+
+// citationVerifier は、verifyCitations の走査中の状態を保持します。
+type citationVerifier struct {
+	known        map[string]struct{}
+	knownIDs     map[string]struct{}
+	footnotes    bool
+	citedIDs     map[int]struct{}
+	removeMode   bool
+	report       CitationReport
+	section      string
+	inSection    bool
+	sectionCited bool
+}
+
+// verifyCitations は、最終文書中のすべてのURLを取得済みソースのURLと照合し、ポリシーに従って削除または警告を付与します。
+// また、ソースURLを1件も引用していない `##` セクションを報告します。
+// footnotes が true の場合は、脚注マーカー [^N] も出典IDと照合し、モデルが出力した脚注定義を取り除きます。
+func verifyCitations(ctx context.Context, markdown string, sources []Source, policy string, footnotes bool) (string, CitationReport) {
+	if policy == CitationPolicyOff {
+		if footnotes {
+			markdown = stripFootnoteDefinitions(markdown)
+		}
+		return markdown, CitationReport{Policy: policy}
+	}
+
+	v := &citationVerifier{
+		known:      make(map[string]struct{}, len(sources)),
+		knownIDs:   make(map[string]struct{}, len(sources)),
+		footnotes:  footnotes,
+		citedIDs:   make(map[int]struct{}),
+		removeMode: policy == CitationPolicyRemove,
+		report:     CitationReport{Policy: policy},
+	}
+	for _, s := range sources {
+		v.known[normalizeCitedURL(s.URL)] = struct{}{}
+		v.knownIDs[strconv.Itoa(s.ID)] = struct{}{}
+	}
+
+	var out []string
+	inCodeFence := false
+	for _, line := range strings.Split(markdown, "\n") {
+		// コードブロック内のURLは引用ではないため検証しない (フェンスの判定は構造検証と共通)
+		if fenceLinePattern.MatchString(line) {
+			inCodeFence = !inCodeFence
+			out = append(out, line)
+			continue
+		}
+		if inCodeFence {
+			out = append(out, line)
+			continue
+		}
+
+		if m := headingPattern.FindStringSubmatch(line); m != nil && len(m[1]) <= 2 {
+			v.finishSection()
+			v.inSection = len(m[1]) == 2
+			v.section = strings.TrimSpace(m[2])
+			v.sectionCited = false
+			out = append(out, line)
+			continue
+		}
+
+		if processed, keep := v.processLine(line); keep {
+			out = append(out, processed)
+		}
+	}
+	v.finishSection()
+
+	for id := range v.citedIDs {
+		v.report.CitedSourceIDs = append(v.report.CitedSourceIDs, id)
+	}
+	sort.Ints(v.report.CitedSourceIDs)
+
+	result := strings.Join(removeEmptyRelatedURLHeadings(out), "\n")
+
+	if len(v.report.Unknown) > 0 {
+		slog.WarnContext(ctx, "ソースに存在しないURLまたは脚注が最終文書で引用されていました。",
+			slog.String("policy", policy), slog.Int("unknown", len(v.report.Unknown)))
+	}
+	if len(v.report.UncitedSections) > 0 {
+		slog.WarnContext(ctx, "ソースURLを引用していないセクションがあります。",
+			slog.Any("sections", v.report.UncitedSections))
+	}
+	return result, v.report
+}
+
+// processLine は、見出し以外の1行に含まれるURLを検証します。keep が false の場合、その行は削除されます。
+func (v *citationVerifier) processLine(line string) (processed string, keep bool) {
+	if v.footnotes {
+		// 脚注定義は参考文献セクションとして自動生成するため、モデルの出力は破棄する
+		if footnoteDefinitionPattern.MatchString(line) {
+			return "", false
+		}
+		line = footnoteMarkerPattern.ReplaceAllStringFunc(line, v.checkFootnote)
+	}
+
+	// URLのみのリスト項目 (関連URLリスト) は、行単位で判定する
+	if m := urlOnlyListItemPattern.FindStringSubmatch(line); m != nil {
+		if v.check(m[1]) {
+			return line, true
+		}
+		if v.removeMode {
+			return "", false
+		}
+		return strings.TrimRight(line, " ") + unverifiedCitationMark, true
+	}
+
+	// 本文中の [テキスト](URL) 形式のリンクは、リンク先 (href) のみを1件の引用として検証する。
+	// リンクのテキスト自体がURLの場合も、裸のURLとして重複して検証しない
+	var sb strings.Builder
+	last := 0
+	for _, m := range markdownLinkPattern.FindAllStringSubmatchIndex(line, -1) {
+		sb.WriteString(v.replaceBareURLs(line[last:m[0]]))
+		link, text, href := line[m[0]:m[1]], line[m[2]:m[3]], line[m[4]:m[5]]
+		switch {
+		case v.check(href):
+			sb.WriteString(link)
+		case v.removeMode:
+			// リンクを外してテキストのみを残す (テキストがURLの場合は、そのURLも未検証の出典として除く)
+			if citedURLPattern.MatchString(text) && strings.TrimSpace(citedURLPattern.ReplaceAllString(text, "")) == "" {
+				text = ""
+			}
+			if text = strings.TrimRight(text, " "); text == "" {
+				sb.WriteString(strings.TrimLeft(removedCitationMark, " "))
+			} else {
+				sb.WriteString(text + removedCitationMark)
+			}
+		default:
+			sb.WriteString(link + unverifiedCitationMark)
+		}
+		last = m[1]
+	}
+	sb.WriteString(v.replaceBareURLs(line[last:]))
+	return sb.String(), true
+}
+
+// replaceBareURLs は、Markdownリンクを含まないテキスト中の裸のURLを検証し、ポリシーに従って置換します。
+func (v *citationVerifier) replaceBareURLs(text string) string {
+	return replaceBareURLs(text, func(raw string) string {
+		if v.check(raw) {
+			return raw
+		}
+		if v.removeMode {
+			return strings.TrimLeft(removedCitationMark, " ")
+		}
+		return raw + unverifiedCitationMark
+	})
+}
+
+// check は、URLがソースに存在するかを判定し、結果をレポートに記録します。
+func (v *citationVerifier) check(raw string) bool {
+	if _, ok := v.known[normalizeCitedURL(raw)]; ok {
+		v.report.VerifiedCount++
+		v.sectionCited = true
+		return true
+	}
+
+	action := "flagged"
+	if v.removeMode {
+		action = "removed"
+	}
+	v.report.Unknown = append(v.report.Unknown, UnknownCitation{
+		Section: v.section,
+		URL:     normalizeCitedURL(raw),
+		Action:  action,
+	})
+	return false
+}
+
+// checkFootnote は、脚注マーカーが既知のソースIDを指しているかを判定し、ポリシーに従って置換後の文字列を返します。
+func (v *citationVerifier) checkFootnote(marker string) string {
+	id := footnoteMarkerPattern.FindStringSubmatch(marker)[1]
+	if _, ok := v.knownIDs[id]; ok {
+		n, _ := strconv.Atoi(id)
+		v.citedIDs[n] = struct{}{}
+		v.report.VerifiedCount++
+		v.sectionCited = true
+		return marker
+	}
+
+	action := "flagged"
+	if v.removeMode {
+		action = "removed"
+	}
+	v.report.Unknown = append(v.report.Unknown, UnknownCitation{
+		Section: v.section,
+		Marker:  marker,
+		Action:  action,
+	})
+	if v.removeMode {
+		return removedCitationMark
+	}
+	return marker + unverifiedCitationMark
+}
+
+// finishSection は、現在の `##` セクションがソースURLを引用していなければ記録します。
+func (v *citationVerifier) finishSection() {
+	if v.inSection && !v.sectionCited {
+		v.report.UncitedSections = append(v.report.UncitedSections, v.section)
+	}
+}
+
+// replaceBareURLs は、テキスト中の裸のURLに対して fn を適用します (末尾の句読点や括弧は置換の対象外です)。
+// Markdownリンクを含むテキストでは、呼び出し側でリンクを除いた部分にのみ適用してください。
+func replaceBareURLs(line string, fn func(string) string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range citedURLPattern.FindAllStringIndex(line, -1) {
+		raw := line[loc[0]:loc[1]]
+		trimmed := strings.TrimRight(raw, ".,;:!?)>、。）")
+		sb.WriteString(line[last:loc[0]])
+		sb.WriteString(fn(trimmed))
+		sb.WriteString(raw[len(trimmed):])
+		last = loc[1]
+	}
+	sb.WriteString(line[last:])
+	return sb.String()
+}
+
+// removeEmptyRelatedURLHeadings は、リスト項目がすべて削除された "関連URL" 見出しを取り除きます。
+func removeEmptyRelatedURLHeadings(lines []string) []string {
+	out := make([]string, 0, len(lines))
+	for i, line := range lines {
+		if relatedURLHeadingPattern.MatchString(line) && !hasListItemAfter(lines, i+1) {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+// hasListItemAfter は、start 行以降、空行を挟んで最初に現れる行がリスト項目かどうかを返します。
+func hasListItemAfter(lines []string, start int) bool {
+	for _, line := range lines[start:] {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		return strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* ") || strings.HasPrefix(trimmed, "+ ")
+	}
+	return false
+}
+
+// normalizeCitedURL は、照合のためにURLを正規化します (末尾の句読点・スラッシュとフラグメントを除去)。
+func normalizeCitedURL(raw string) string {
+	raw = strings.TrimRight(strings.TrimSpace(raw), ".,;:!?)>、。）")
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.Fragment = ""
+	u.Host = strings.ToLower(u.Host)
+	u.Scheme = strings.ToLower(u.Scheme)
+	return strings.TrimRight(u.String(), "/")
+}
+
+// ValidateCitationStyle は、出典の表記方式の値を検証します。
+func ValidateCitationStyle(style string) error {
+	switch style {
+	case CitationStyleSection, CitationStyleFootnote:
+		return nil
+	}
+	return fmt.Errorf("未対応の出典表記方式です: %q (section, footnote のいずれかを指定してください)", style)
+}
+
+// ValidateCitationPolicy は、引用URL検証ポリシーの値を検証します。
+func ValidateCitationPolicy(policy string) error {
+	switch policy {
+	case CitationPolicyRemove, CitationPolicyFlag, CitationPolicyOff:
+		return nil
+	}
+	return fmt.Errorf("未対応の引用検証ポリシーです: %q (remove, flag, off のいずれかを指定してください)", policy)
+}
@@ -0,0 +1,82 @@
+package cleaner
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestProcessLine(t *testing.T) {
+	tests := []struct {
+		name        string
+		line        string
+		remove      bool
+		footnotes   bool
+		want        string
+		wantDropped bool
+		wantUnknown int
+	}{
+		{name: "verified link", line: "詳細は[出典](https://example.com/a)を参照", want: "詳細は[出典](https://example.com/a)を参照"},
+		{name: "unknown link flagged", line: "詳細は[出典](https://bad.example/x)を参照", want: "詳細は[出典](https://bad.example/x) ⚠️(未検証の出典)を参照", wantUnknown: 1},
+		{name: "unknown link removed keeps text", line: "詳細は[出典](https://bad.example/x)を参照", remove: true, want: "詳細は出典 ⚠️(未検証の出典を削除)を参照", wantUnknown: 1},
+		{name: "unknown link with URL text removed", line: "[https://bad.example/x](https://bad.example/x)", remove: true, want: "⚠️(未検証の出典を削除)", wantUnknown: 1},
+		{name: "verified bare URL with punctuation", line: "参照: https://example.com/a/。", want: "参照: https://example.com/a/。"},
+		{name: "unknown bare URL flagged", line: "参照: https://bad.example/x.", want: "参照: https://bad.example/x ⚠️(未検証の出典).", wantUnknown: 1},
+		{name: "unknown bare URL removed", line: "参照: https://bad.example/x.", remove: true, want: "参照: ⚠️(未検証の出典を削除).", wantUnknown: 1},
+		{name: "verified URL-only list item", line: "- https://example.com/a#section", want: "- https://example.com/a#section"},
+		{name: "unknown URL-only list item flagged", line: "- <https://bad.example/x>", want: "- <https://bad.example/x> ⚠️(未検証の出典)", wantUnknown: 1},
+		{name: "unknown URL-only list item removed", line: "- <https://bad.example/x>", remove: true, wantDropped: true, wantUnknown: 1},
+		{name: "unknown footnote flagged", line: "主張です。[^1][^9]", footnotes: true, want: "主張です。[^1][^9] ⚠️(未検証の出典)", wantUnknown: 1},
+		{name: "unknown footnote removed", line: "主張です。[^1][^9]", footnotes: true, remove: true, want: "主張です。[^1] ⚠️(未検証の出典を削除)", wantUnknown: 1},
+		{name: "model footnote definition dropped", line: "[^1]: https://example.com/a", footnotes: true, wantDropped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &citationVerifier{
+				known:      map[string]struct{}{"https://example.com/a": {}},
+				knownIDs:   map[string]struct{}{"1": {}},
+				footnotes:  tt.footnotes,
+				citedIDs:   make(map[int]struct{}),
+				removeMode: tt.remove,
+			}
+			got, keep := v.processLine(tt.line)
+			if keep == tt.wantDropped || (keep && got != tt.want) {
+				t.Errorf("processLine(%q) = (%q, %v), want (%q, %v)", tt.line, got, keep, tt.want, !tt.wantDropped)
+			}
+			if len(v.report.Unknown) != tt.wantUnknown {
+				t.Errorf("Unknown = %+v, want %d entries", v.report.Unknown, tt.wantUnknown)
+			}
+		})
+	}
+}
+
+func TestVerifyCitationsSkipsCodeFences(t *testing.T) {
+	markdown := "# T\n\n## A\n\n[出典](https://example.com/a)\n\n~~~\ncurl https://bad.example/x\n~~~\n\n```\nhttps://bad.example/y\n```"
+	got, report := verifyCitations(context.Background(), markdown, []Source{{ID: 1, URL: "https://example.com/a"}}, CitationPolicyRemove, false)
+	if got != markdown || len(report.Unknown) != 0 || report.VerifiedCount != 1 {
+		t.Errorf("verifyCitations = %q, %+v, want the document unchanged with no unknown citations", got, report)
+	}
+}
+
+func TestRemoveEmptyRelatedURLHeadings(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines string
+		want  string
+	}{
+		{name: "heading with list kept", lines: "## A\n本文\n関連URL:\n\n- https://example.com/a", want: "## A\n本文\n関連URL:\n\n- https://example.com/a"},
+		{name: "heading followed by next section removed", lines: "## A\n**### 関連URL**\n\n## B", want: "## A\n\n## B"},
+		{name: "heading at the end removed", lines: "## A\n本文\n### 関連URL\n", want: "## A\n本文\n"},
+		{name: "heading followed by paragraph removed", lines: "関連URL\n本文", want: "本文"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(removeEmptyRelatedURLHeadings(strings.Split(tt.lines, "\n")), "\n")
+			if got != tt.want {
+				t.Errorf("removeEmptyRelatedURLHeadings(%q) = %q, want %q", tt.lines, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,7 +4,12 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
 
 	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
 )
@@ -13,10 +18,11 @@ import (
 type Cleaner struct {
 	builders PromptBuilders
 	executor LLMExecutor // LLMExecutor インターフェースに依存
+	cfg      CleanerConfig
 }
 
 // NewCleaner は新しい Cleaner インスタンスを作成し、PromptBuilderを一度だけ初期化します。
-func NewCleaner(builders PromptBuilders, executor LLMExecutor) (*Cleaner, error) {
+func NewCleaner(builders PromptBuilders, executor LLMExecutor, cfg CleanerConfig) (*Cleaner, error) {
 	if executor == nil {
 		return nil, fmt.Errorf("LLM Executor は nil にできません")
 	}
@@ -24,41 +30,211 @@ func NewCleaner(builders PromptBuilders, executor LLMExecutor) (*Cleaner, error)
 	return &Cleaner{
 		builders: builders,
 		executor: executor,
+		cfg:      cfg,
 	}, nil
 }
 
+// commonTemplateData は、Map/Reduce の両フェーズに渡す共通テンプレート変数を生成します。
+// FetchDate は、ソースの取得日時のうち最も新しい日付です (Map では各セグメントのソースの取得日で置き換えます)。
+func (c *Cleaner) commonTemplateData(sources []Source) prompts.CommonTemplateData {
+	return prompts.CommonTemplateData{
+		Language:             prompts.LanguageName(c.cfg.Language),
+		Topic:                c.cfg.Topic,
+		Query:                c.cfg.Query,
+		FootnoteCitations:    c.cfg.CitationStyle == CitationStyleFootnote,
+		NoRelevantInfoMarker: prompts.NoRelevantInfoMarker,
+		AnswerSummaryHeading: prompts.AnswerSummaryHeading(c.cfg.Language),
+		FetchDate:            latestFetchedAt(sources).Format(time.DateOnly),
+		SourceURLs:           sourceURLs(sources),
+		Vars:                 c.cfg.Vars,
+	}
+}
+
+// now は、設定された時刻関数 (未設定の場合は time.Now) の現在時刻を返します。
+// 取得日時が記録されていないソースの取得日時として使用します。
+func (c *Cleaner) now() time.Time {
+	if c.cfg.Clock != nil {
+		return c.cfg.Clock()
+	}
+	return time.Now()
+}
+
 // CleanAndStructureText は、MapReduce処理を実行し、最終的なクリーンアップと構造化を行います。
 // LLMExecutor に依存することで、APIキーの処理や並列実行の詳細から解放されています。
-func (c *Cleaner) CleanAndStructureText(ctx context.Context, results []extTypes.URLResult) (string, error) {
+func (c *Cleaner) CleanAndStructureText(ctx context.Context, results []extTypes.URLResult) (*Result, error) {
+	// 取得日時は、コンテンツ取得ステージが URL ごとに記録した日時を使用する (記録がない場合はクリーンアップの開始時刻)
+	sources := newSources(results, FetchTimesFrom(ctx), c.now())
+	common := c.commonTemplateData(sources)
+
+	// 全ソースが Reduce の入力に収まる場合は、Map を行わずに単一パスで最終文書を生成する
+	if mode := c.resolveMode(ctx, results); mode == ModeSingle {
+		return c.singlePass(ctx, results, sources, common)
+	}
+
 	// 1. MapフェーズのためのURL単位のテキスト分割
 	var allSegments []Segment
-	for _, res := range results {
+	for i, res := range results {
 		// URLResultのContentを個別にセグメント分割
-		segments := segmentText(res.Content, MaxSegmentChars)
+		segments := segmentText(logging.With(ctx, slog.String(logging.KeyURL, res.URL)), res.Content, MaxSegmentChars)
 		for _, segText := range segments {
-			allSegments = append(allSegments, Segment{Text: segText, URL: res.URL})
+			allSegments = append(allSegments, Segment{Text: segText, URL: res.URL, Title: sources[i].Title, SourceID: sources[i].ID, FetchedAt: sources[i].FetchedAt})
 		}
 	}
+	segmentsPerURL := make(map[string]int)
+	for i := range allSegments {
+		allSegments[i].Index = i + 1
+		allSegments[i].Total = len(allSegments)
+		segmentsPerURL[allSegments[i].URL]++
+	}
 
-	slog.Info("コンテンツをURL単位でセグメントに分割しました。中間要約を開始します。",
-		slog.Int("total_segments", len(allSegments)))
+	// ルーティング：セグメントごとに Map のモデルを選択し、小さな文書は Map をスキップする
+	footnotes := c.cfg.CitationStyle == CitationStyleFootnote
+	mapSegments := make([]Segment, 0, len(allSegments))
+	var directResults []MapResult
+	directRoutes := make(map[int]string)
+	for _, seg := range allSegments {
+		decision, ok := routeSegment(c.cfg.Routing, seg, segmentsPerURL[seg.URL] == 1)
+		if !ok {
+			mapSegments = append(mapSegments, seg)
+			continue
+		}
+		slog.InfoContext(ctx, "ルーティングルールを適用しました。",
+			slog.Int(logging.KeySegment, seg.Index), slog.String(logging.KeyURL, seg.URL), slog.String("rule", decision.Rule),
+			slog.String("model", decision.Model), slog.Bool("skip_map", decision.SkipMap))
+		if decision.SkipMap {
+			directResults = append(directResults, MapResult{Index: seg.Index, URL: seg.URL, Summary: directSummary(seg, footnotes)})
+			directRoutes[seg.Index] = decision.Rule
+			continue
+		}
+		seg.Model = decision.Model
+		seg.Route = decision.Rule
+		mapSegments = append(mapSegments, seg)
+	}
+
+	slog.InfoContext(ctx, "コンテンツをURL単位でセグメントに分割しました。中間要約を開始します。",
+		slog.Int("total_segments", len(allSegments)), slog.Int("direct_to_reduce", len(directResults)))
 
 	// 2. Mapフェーズの実行（Executorに委譲）
-	intermediateSummaries, err := c.executor.ExecuteMap(ctx, allSegments, c.builders.MapBuilder)
-	if err != nil {
-		return "", fmt.Errorf("セグメント処理（Mapフェーズ）に失敗しました: %w", err)
+	var mapResults []MapResult
+	if len(mapSegments) > 0 {
+		var err error
+		mapResults, err = c.executor.ExecuteMap(ctx, mapSegments, c.builders.MapBuilder, common)
+		if err != nil {
+			return nil, fmt.Errorf("セグメント処理（Mapフェーズ）に失敗しました: %w", err)
+		}
+	}
+
+	mapRoutes := make(map[int]string, len(mapSegments))
+	for _, seg := range mapSegments {
+		mapRoutes[seg.Index] = seg.Route
+	}
+	allResults := append(mapResults, directResults...)
+	sort.Slice(allResults, func(i, j int) bool { return allResults[i].Index < allResults[j].Index })
+
+	report := Report{Mode: ModeMapReduce, Segments: make([]SegmentReport, 0, len(allResults))}
+	intermediateSummaries := make([]string, 0, len(allResults))
+	irrelevant := 0
+	for _, res := range allResults {
+		segReport := SegmentReport{
+			Index:      res.Index,
+			URL:        res.URL,
+			Issues:     res.Issues,
+			Reprompted: res.Reprompted,
+			Skipped:    res.Summary == "" && !res.Irrelevant,
+			Irrelevant: res.Irrelevant,
+			Model:      res.Model,
+			Route:      mapRoutes[res.Index],
+			Failures:   res.Failures,
+
+			FinishReason: res.FinishReason,
+			Recovery:     res.Recovery,
+			SkippedParts: res.SkippedParts,
+			Usage:        res.Usage,
+		}
+		if rule, ok := directRoutes[res.Index]; ok {
+			segReport.Route = rule
+			segReport.DirectToReduce = true
+		}
+		report.Segments = append(report.Segments, segReport)
+		if res.Irrelevant {
+			irrelevant++
+			continue
+		}
+		if res.Summary != "" {
+			intermediateSummaries = append(intermediateSummaries, res.Summary)
+		}
+	}
+	if failures := report.ParseFailures(); failures > 0 {
+		slog.WarnContext(ctx, "Map応答の形式に不備のあるセグメントがありました。",
+			slog.Int("segments_with_issues", failures), slog.Int("total_segments", len(report.Segments)))
+	}
+	// クエリ指定時に関連情報がないと判定されたセグメント (Map 応答の関連性フィールドが no) は、Reduce の入力から除外している
+	if irrelevant > 0 {
+		slog.InfoContext(ctx, "クエリに関連する情報がないセグメントを Reduce の入力から除外しました。",
+			slog.Int("skipped", irrelevant), slog.Int("remaining", len(intermediateSummaries)))
+	}
+	if len(intermediateSummaries) == 0 {
+		if irrelevant > 0 {
+			return nil, fmt.Errorf("クエリ %q に関連する情報が、取得したコンテンツから一件も見つかりませんでした", c.cfg.Query)
+		}
+		return nil, fmt.Errorf("すべてのセグメントでMap応答から本文を抽出できませんでした")
 	}
 
 	// 3. Reduceフェーズの準備：中間要約の結合
 	finalCombinedText := strings.Join(intermediateSummaries, "\n\n--- INTERMEDIATE SUMMARY END ---\n\n")
 
 	// 4. Reduceフェーズ：最終的な統合と構造化のためのLLM呼び出し（Executorに委譲）
-	slog.Info("中間要約の結合が完了しました。最終的な構造化（Reduceフェーズ）を開始します。")
+	slog.InfoContext(ctx, "中間要約の結合が完了しました。最終的な構造化（Reduceフェーズ）を開始します。")
+
+	return c.reduce(ctx, c.builders.ReduceBuilder, finalCombinedText, sources, common, report)
+}
 
-	finalResponseText, err := c.executor.ExecuteReduce(ctx, finalCombinedText, c.builders.ReduceBuilder)
+// reduce は、結合済みテキストから最終文書を生成し、構造の検証・引用の検証・参考文献の付与を行います。
+// MapReduce の Reduce フェーズと単一パスの両方で使用します。
+func (c *Cleaner) reduce(ctx context.Context, builder *prompts.PromptBuilder, combinedText string, sources []Source, common prompts.CommonTemplateData, report Report) (*Result, error) {
+	reduceRoute, _ := routeReduce(c.cfg.Routing, combinedText)
+	if reduceRoute.Rule != "" {
+		slog.InfoContext(ctx, "Reduce にルーティングルールを適用しました。",
+			slog.String("rule", reduceRoute.Rule), slog.String("model", reduceRoute.Model))
+	}
+	finalResponse, err := c.executor.ExecuteReduce(ctx, reduceRoute.Model, combinedText, builder, common)
 	if err != nil {
-		return "", fmt.Errorf("LLM最終構造化処理（Reduceフェーズ）に失敗しました: %w", err)
+		return nil, fmt.Errorf("LLM最終構造化処理（Reduceフェーズ）に失敗しました: %w", err)
+	}
+	report.Reduce = PhaseReport{
+		Model:        finalResponse.Model,
+		Route:        reduceRoute.Rule,
+		Failures:     finalResponse.Failures,
+		FinishReason: finalResponse.FinishReason,
+		Recovery:     finalResponse.Recovery,
+		Usage:        finalResponse.Usage,
+	}
+
+	// 5. 構造の検証と正規化：H1 の一意性や見出しレベルを検証し、違反時は修復プロンプトを実行する
+	structuredMarkdown, structure := c.enforceStructure(ctx, finalResponse.Model, finalResponse.Text, common)
+	report.Structure = structure
+
+	// 6. 引用URLの検証：ソースに存在しないURLの削除・警告と、引用のないセクションの報告
+	policy := c.cfg.CitationPolicy
+	if policy == "" {
+		policy = DefaultCitationPolicy
 	}
+	footnotes := c.cfg.CitationStyle == CitationStyleFootnote
+	finalMarkdown, citations := verifyCitations(ctx, structuredMarkdown, sources, policy, footnotes)
+	report.Citations = citations
 
-	return strings.TrimSpace(finalResponseText), nil
+	// 7. 脚注モードでは、ソースIDに対応する参考文献セクションを末尾に付与する
+	if footnotes {
+		finalMarkdown = strings.TrimSpace(finalMarkdown) + "\n\n" + buildReferences(sources, referenceLabelsFor(c.cfg.Language))
+	}
+
+	// 8. Map の応答を得られず除外したソースがある場合は、読者が欠落に気付けるよう末尾に注記を付与する
+	if notice := omittedNotice(report.Segments, c.cfg.Language); notice != "" {
+		finalMarkdown = strings.TrimSpace(finalMarkdown) + "\n\n" + notice
+	}
+
+	return &Result{
+		Markdown: strings.TrimSpace(finalMarkdown),
+		Report:   report,
+	}, nil
 }
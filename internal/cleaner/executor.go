@@ -4,73 +4,143 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sort"
 	"sync"
 	"time"
 
-	"action-perfect-get-on-go/internal/prompts"
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+	"github.com/shouni/action-perfect-get-on-go/internal/telemetry"
 
-	"github.com/shouni/go-ai-client/v2/pkg/ai/gemini"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // LLMExecutor は、LLMの実行能力を抽象化するインターフェースです。
 // これにより、Cleanerのコアロジックから API通信と並列実行の詳細を分離します。
 type LLMExecutor interface {
-	ExecuteMap(ctx context.Context, segments []Segment, builder *prompts.PromptBuilder) ([]string, error)
-	ExecuteReduce(ctx context.Context, combinedText string, builder *prompts.PromptBuilder) (string, error)
+	ExecuteMap(ctx context.Context, segments []Segment, builder *prompts.PromptBuilder, common prompts.CommonTemplateData) ([]MapResult, error)
+	// ExecuteReduce と ExecuteRepair の model は使用するモデルの上書きです (空の場合は Reduce の既定モデル)。
+	ExecuteReduce(ctx context.Context, model string, combinedText string, builder *prompts.PromptBuilder, common prompts.CommonTemplateData) (Generation, error)
+	ExecuteRepair(ctx context.Context, model string, document string, violations []string, builder *prompts.PromptBuilder, common prompts.CommonTemplateData) (Generation, error)
+}
+
+// Generation は、1回の LLM 生成の結果と、実際に応答を生成したモデルです。
+type Generation struct {
+	Text  string
+	Model string
+	Usage llm.Usage
+	// Failures は、フォールバックの途中で失敗したモデルの呼び出しです。
+	Failures []ModelFailure
+	// FinishReason は、最初の応答がブロック・途中終了・空だった場合の終了理由です (正常な場合は空)。
+	FinishReason string
+	// Recovery は、応答ポリシーにより適用したアクションです (適用しなかった場合は空)。
+	Recovery string
+}
+
+// MapProgress は、Mapフェーズの進捗 (完了セグメント数) を受け取るインターフェースです。
+type MapProgress interface {
+	Start(total int)
+	Advance()
+	Finish()
 }
 
 // LLMExecutorConfig は NewLLMConcurrentExecutor の設定をカプセル化します。
 type LLMExecutorConfig struct {
 	APIKeyOverride string
-	Concurrency    int
-	MapModel       string
-	ReduceModel    string
+	// Client は、使用する LLM クライアントです (nil の場合は APIKeyOverride または環境変数のAPIキーで Gemini クライアントを作成します)。
+	// 記録・再生用のクライアントを注入するために使用します。
+	Client      llm.GenerativeModel
+	Concurrency int
+	MapModel    string
+	ReduceModel string
+	// Progress は、Mapフェーズの進捗の通知先です (nil の場合は通知しません)。
+	Progress MapProgress
+	// ResponsePolicy は、ブロック・途中終了・空の応答に適用するアクションの順序です (空の場合は DefaultResponsePolicy)。
+	ResponsePolicy []string
+	// RateLimit は、Mapフェーズの LLM 呼び出しの開始間隔です (0 以下の場合は DefaultLLMRateLimit)。
+	RateLimit time.Duration
 }
 
 // LLMConcurrentExecutor は LLMExecutor の具体的な実装で、
 // Goroutine、セマフォ、レートリミッターを使用して並列実行を行います。
 type LLMConcurrentExecutor struct {
-	client      *gemini.Client
+	client      llm.GenerativeModel
 	concurrency int
 	mapModel    string
 	reduceModel string
+	progress    MapProgress
+	rateLimit   time.Duration
+	// responsePolicy は、ブロック・途中終了・空の応答に適用するアクションの順序です。
+	responsePolicy []string
 }
 
 // NewLLMConcurrentExecutor は新しい LLMConcurrentExecutor インスタンスを作成します。
 func NewLLMConcurrentExecutor(ctx context.Context, cfg LLMExecutorConfig) (*LLMConcurrentExecutor, error) {
-	var client *gemini.Client
-	var err error
-
-	if cfg.APIKeyOverride != "" {
-		client, err = gemini.NewClient(ctx, gemini.Config{APIKey: cfg.APIKeyOverride})
-	} else {
-		client, err = gemini.NewClientFromEnv(ctx)
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("LLMクライアントの初期化に失敗しました。APIキーを確認してください: %w", err)
+	client := cfg.Client
+	if client == nil {
+		geminiClient, err := llm.NewGeminiClientWithKey(ctx, cfg.APIKeyOverride)
+		if err != nil {
+			return nil, fmt.Errorf("LLMクライアントの初期化に失敗しました。APIキーを確認してください: %w", err)
+		}
+		client = geminiClient
 	}
 
 	if cfg.Concurrency < 1 {
 		cfg.Concurrency = 1
 	}
+	if len(cfg.ResponsePolicy) == 0 {
+		cfg.ResponsePolicy, _ = ParseResponsePolicy(DefaultResponsePolicy)
+	}
+	if cfg.RateLimit <= 0 {
+		cfg.RateLimit = DefaultLLMRateLimit
+	}
 
 	return &LLMConcurrentExecutor{
 		client:      client,
 		concurrency: cfg.Concurrency,
 		mapModel:    cfg.MapModel,
 		reduceModel: cfg.ReduceModel,
+		progress:    cfg.Progress,
+		rateLimit:   cfg.RateLimit,
+
+		responsePolicy: cfg.ResponsePolicy,
 	}, nil
 }
 
+// MaxMapReprompts は、Map の応答がエンベロープの形式に従っていなかった場合に再プロンプトする最大回数です。
+const MaxMapReprompts = 1
+
 // MapResult はセグメント処理の結果を保持します。
 type MapResult struct {
+	// Index はセグメントの位置 (1始まり) です。
+	Index int
+	URL   string
+	// Summary は正規化済みの中間要約です。本文を抽出できなかった場合と、Irrelevant の場合は空になります。
 	Summary string
-	Err     error
+	// Irrelevant は、クエリ指定時に、セグメントに関連する情報がないと Map の応答の関連性フィールドで判定されたかどうかです。
+	Irrelevant bool
+	// Issues は、応答の解析時に検出した形式上の問題です。
+	Issues     []string
+	Reprompted bool
+	// Model は、応答を生成したモデルです。
+	Model string
+	// Usage は、再プロンプトを含むこのセグメントのトークン消費量です。
+	Usage llm.Usage
+	// Failures は、フォールバックの途中で失敗したモデルの呼び出しです。
+	Failures []ModelFailure
+	// FinishReason は、応答がブロック・途中終了・空だった場合の終了理由です (正常な場合は空)。
+	FinishReason string
+	// Recovery は、応答ポリシーにより適用したアクション (retry, relax-safety, split, skip) です (適用しなかった場合は空)。
+	Recovery string
+	// SkippedParts は、split で分割した部分のうち、skip により除外された部分の数です。
+	SkippedParts int
+	Err          error
 }
 
 // ExecuteMap は Mapフェーズの並列処理を実行します。
-func (e *LLMConcurrentExecutor) ExecuteMap(ctx context.Context, allSegments []Segment, mapBuilder *prompts.PromptBuilder) ([]string, error) {
+func (e *LLMConcurrentExecutor) ExecuteMap(ctx context.Context, allSegments []Segment, mapBuilder *prompts.PromptBuilder, common prompts.CommonTemplateData) ([]MapResult, error) {
+	ctx = logging.With(ctx, slog.String(logging.KeyPhase, "map"))
 	var wg sync.WaitGroup
 	resultsChan := make(chan MapResult, len(allSegments))
 
@@ -78,17 +148,28 @@ func (e *LLMConcurrentExecutor) ExecuteMap(ctx context.Context, allSegments []Se
 	sem := make(chan struct{}, e.concurrency)
 
 	// LLM APIのコール間隔を制御するレートリミッター
-	ticker := time.NewTicker(DefaultLLMRateLimit)
+	ticker := time.NewTicker(e.rateLimit)
 	defer ticker.Stop()
 	rateLimiter := ticker.C
 
-	slog.Info("セグメントの並列処理を開始します",
+	slog.InfoContext(ctx, "セグメントの並列処理を開始します",
 		slog.Int("total_segments", len(allSegments)),
 		slog.Int("max_parallel", e.concurrency),
-		slog.Duration("rate_limit", DefaultLLMRateLimit),
+		slog.Duration("rate_limit", e.rateLimit),
 		slog.String("model", e.mapModel))
 
+	if e.progress != nil {
+		e.progress.Start(len(allSegments))
+		defer e.progress.Finish()
+	}
+	// 中断時に完了済みのセグメントを書き出せるよう、収集先が設定されていれば完了したセグメントを記録する
+	partial := partialResultsFrom(ctx)
+	if partial != nil {
+		partial.start(len(allSegments))
+	}
+
 	for i, seg := range allSegments {
+		seg.withQuery = common.Query != ""
 		sem <- struct{}{} // セマフォ取得
 		wg.Add(1)
 
@@ -106,71 +187,216 @@ func (e *LLMConcurrentExecutor) ExecuteMap(ctx context.Context, allSegments []Se
 				return
 			}
 
-			mapData := prompts.MapTemplateData{
-				SegmentText: s.Text,
-				SourceURL:   s.URL,
+			// split アクションで分割したテキストからもプロンプトを生成できるよう、テキストを引数に取る
+			// FetchDate は、セグメントの由来となったソースの取得日とする
+			segCommon := common
+			if !s.FetchedAt.IsZero() {
+				segCommon.FetchDate = s.FetchedAt.Format(time.DateOnly)
 			}
-			prompt, err := mapBuilder.BuildMap(mapData)
-			if err != nil {
-				// エラー処理は resultsChan に集約
-				resultsChan <- MapResult{Err: fmt.Errorf("セグメント %d プロンプト生成失敗 (URL: %s): %w", index+1, s.URL, err)}
-				return
+			buildPrompt := func(text string) (string, error) {
+				return mapBuilder.BuildMap(prompts.MapTemplateData{
+					CommonTemplateData: segCommon,
+					SegmentText:        text,
+					SourceURL:          s.URL,
+					SourceTitle:        s.Title,
+					SourceID:           s.SourceID,
+					SegmentIndex:       s.Index,
+					SegmentTotal:       s.Total,
+				})
 			}
 
-			response, err := e.client.GenerateContent(ctx, prompt, e.mapModel)
+			segCtx := logging.With(ctx, slog.Int(logging.KeySegment, index+1), slog.String(logging.KeyURL, s.URL))
+			segCtx, span := telemetry.Start(segCtx, "map.segment",
+				attribute.Int("segment.index", index+1), attribute.String("url", s.URL), attribute.Int("segment.chars", len(s.Text)))
+			result, err := e.generateMapSummary(segCtx, buildPrompt, index, s, 0)
 			if err != nil {
+				telemetry.End(span, err)
+				telemetry.RecordSegment(ctx, "error")
 				// エラー処理は resultsChan に集約
-				resultsChan <- MapResult{Err: fmt.Errorf("セグメント %d 処理失敗 (URL: %s): %w", index+1, s.URL, err)}
+				resultsChan <- MapResult{Err: err}
 				return
 			}
-			slog.Info(
+			span.SetAttributes(attribute.String("model", result.Model), attribute.String("finish_reason", result.FinishReason), attribute.String("recovery", result.Recovery))
+			telemetry.End(span, nil)
+			telemetry.RecordSegment(ctx, "ok")
+			if partial != nil {
+				partial.add(result)
+			}
+			if e.progress != nil {
+				e.progress.Advance()
+			}
+			slog.InfoContext(segCtx,
 				"セグメント処理成功",
-				"index", index+1,
-				"url", s.URL,
-				"summary_len", len(response.Text),
-				"model", e.mapModel,
+				"summary_len", len(result.Summary),
+				"model", result.Model,
 			)
 
-			resultsChan <- MapResult{Summary: response.Text, Err: nil}
+			resultsChan <- result
 		}(i, seg)
 	}
 
 	wg.Wait()
 	close(resultsChan)
 
-	var summaries []string
+	results := make([]MapResult, 0, len(allSegments))
 	for res := range resultsChan {
 		if res.Err != nil {
 			return nil, res.Err
 		}
-		summaries = append(summaries, res.Summary)
+		results = append(results, res)
+	}
+
+	// 完了順ではなくセグメント順に並べ替え、Reduce の入力順を安定させる
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+
+	return results, nil
+}
+
+// generateMapSummary は、1セグメント分の Map を実行し、応答がブロック・途中終了・空だった場合は応答ポリシーを適用します。
+// depth は split アクションによる分割の深さです (元のセグメントは 0)。
+func (e *LLMConcurrentExecutor) generateMapSummary(ctx context.Context, buildPrompt func(text string) (string, error), index int, s Segment, depth int) (MapResult, error) {
+	result := MapResult{Index: index + 1, URL: s.URL}
+	prompt, err := buildPrompt(s.Text)
+	if err != nil {
+		return MapResult{}, fmt.Errorf("セグメント %d プロンプト生成失敗 (URL: %s): %w", index+1, s.URL, err)
 	}
 
-	return summaries, nil
+	err = e.summarize(ctx, &result, prompt, index, s)
+	if err == nil {
+		return result, nil
+	}
+	if class := llm.Classify(err); isRecoverableResponse(class) {
+		return e.recoverMap(ctx, result, buildPrompt, index, s, depth, class, err)
+	}
+	return MapResult{}, fmt.Errorf("セグメント %d 処理失敗 (URL: %s): %w", index+1, s.URL, err)
+}
+
+// summarize は、1セグメント分の LLM 呼び出しと応答の解析を行い、結果を result に書き込みます。
+// エンベロープから本文を抽出できない場合は、再指示を付けて MaxMapReprompts 回まで再プロンプトします。
+// それでも抽出できない場合は、エラーにはせず Summary を空にします (呼び出し側で除外されます)。
+// opts は、応答ポリシーによる再試行時の生成設定の上書きです。
+func (e *LLMConcurrentExecutor) summarize(ctx context.Context, result *MapResult, prompt string, index int, s Segment, opts ...llm.GenerateOption) error {
+	model := e.mapModel
+	if s.Model != "" {
+		model = s.Model
+	}
+
+	currentPrompt := prompt
+	for attempt := 0; ; attempt++ {
+		response, usedModel, failures, err := e.generateWithFallback(ctx, "Map", model, currentPrompt, nil, opts...)
+		result.Failures = append(result.Failures, failures...)
+		if err != nil {
+			return err
+		}
+		result.Model = usedModel
+		result.Usage = addUsage(result.Usage, response.Usage)
+
+		out, parseErr := parseMapResponse(response.Text, s.URL, s.withQuery)
+		result.Issues = append(result.Issues, out.Issues...)
+		if parseErr == nil && out.Irrelevant {
+			result.Summary = ""
+			result.Irrelevant = true
+			slog.DebugContext(ctx, "クエリに関連する情報がないと判定されました。",
+				slog.Int(logging.KeySegment, index+1), slog.String(logging.KeyURL, s.URL))
+			return nil
+		}
+		if parseErr == nil {
+			result.Summary = out.Summary(s.URL)
+			if len(out.Issues) > 0 {
+				slog.WarnContext(ctx, "Map応答の形式に不備があったため修復しました。",
+					slog.Int(logging.KeySegment, index+1), slog.String(logging.KeyURL, s.URL), slog.Any("issues", out.Issues))
+			}
+			return nil
+		}
+
+		result.Issues = append(result.Issues, parseErr.Error())
+		if attempt >= MaxMapReprompts {
+			slog.WarnContext(ctx, "Map応答から本文を抽出できなかったため、このセグメントを除外します。",
+				slog.Int(logging.KeySegment, index+1), slog.String(logging.KeyURL, s.URL), slog.Any("issues", result.Issues))
+			return nil
+		}
+
+		slog.WarnContext(ctx, "Map応答から本文を抽出できませんでした。再プロンプトします。",
+			slog.Int(logging.KeySegment, index+1), slog.String(logging.KeyURL, s.URL), slog.Int("attempt", attempt+1))
+		result.Reprompted = true
+		currentPrompt = prompt + mapRepromptSuffix
+	}
 }
 
 // ExecuteReduce は ReduceフェーズのAPI呼び出しを実行します。
-func (e *LLMConcurrentExecutor) ExecuteReduce(ctx context.Context, combinedText string, reduceBuilder *prompts.PromptBuilder) (string, error) {
-	slog.Info("最終的な構造化（Reduceフェーズ）を開始します。", slog.String("model", e.reduceModel))
+func (e *LLMConcurrentExecutor) ExecuteReduce(ctx context.Context, model string, combinedText string, reduceBuilder *prompts.PromptBuilder, common prompts.CommonTemplateData) (gen Generation, err error) {
+	if model == "" {
+		model = e.reduceModel
+	}
+	ctx = logging.With(ctx, slog.String(logging.KeyPhase, "reduce"))
+	ctx, span := telemetry.Start(ctx, "reduce", attribute.String("model", model), attribute.Int("input.chars", len(combinedText)))
+	defer func() { telemetry.End(span, err) }()
+	slog.InfoContext(ctx, "最終的な構造化（Reduceフェーズ）を開始します。", slog.String("model", model))
 
 	reduceData := prompts.ReduceTemplateData{
-		CombinedText: combinedText,
+		CommonTemplateData: common,
+		CombinedText:       combinedText,
 	}
 
 	finalPrompt, err := reduceBuilder.BuildReduce(reduceData)
 	if err != nil {
-		return "", fmt.Errorf("最終 Reduce プロンプトの生成に失敗しました: %w", err)
+		return Generation{}, fmt.Errorf("最終 Reduce プロンプトの生成に失敗しました: %w", err)
 	}
 
-	finalResponse, err := e.client.GenerateContent(ctx, finalPrompt, e.reduceModel)
+	// プレビューの表示先が設定されている場合は、生成されたテキストを到着順に表示する (最終文書には影響しない)
+	stream := reduceStreamFrom(ctx)
+	finalResponse, usedModel, failures, err := e.generateWithFallback(ctx, "Reduce", model, finalPrompt, stream)
+	gen = Generation{Failures: failures}
 	if err != nil {
-		return "", fmt.Errorf("LLM最終構造化処理（Reduceフェーズ）に失敗しました: %w", err)
+		gen.FinishReason = finishReasonOf(err)
+		finalResponse, usedModel, err = e.retryReduce(ctx, &gen, model, finalPrompt, stream, err)
+		if err != nil {
+			return gen, fmt.Errorf("LLM最終構造化処理（Reduceフェーズ）に失敗しました: %w", err)
+		}
 	}
 
-	slog.Info(
+	slog.InfoContext(ctx,
 		"Reduce処理成功",
-		"model", e.reduceModel,
+		"model", usedModel,
 	)
 
-	return finalResponse.Text, nil
+	gen.Text, gen.Model, gen.Usage = finalResponse.Text, usedModel, finalResponse.Usage
+	return gen, nil
+}
+
+// ExecuteRepair は、構造ルールに違反した Reduce 出力を修復するためのAPI呼び出しを実行します。
+// model が空の場合は Reduce の既定モデル (フォールバックのリスト) を使用します。
+func (e *LLMConcurrentExecutor) ExecuteRepair(ctx context.Context, model string, document string, violations []string, repairBuilder *prompts.PromptBuilder, common prompts.CommonTemplateData) (gen Generation, err error) {
+	if model == "" {
+		model = e.reduceModel
+	}
+	ctx = logging.With(ctx, slog.String(logging.KeyPhase, "repair"))
+	ctx, span := telemetry.Start(ctx, "repair", attribute.String("model", model), attribute.Int("violations", len(violations)))
+	defer func() { telemetry.End(span, err) }()
+	slog.InfoContext(ctx, "最終文書の構造修復を開始します。", slog.String("model", model), slog.Int("violations", len(violations)))
+
+	repairPrompt, err := repairBuilder.BuildRepair(prompts.RepairTemplateData{
+		CommonTemplateData: common,
+		Document:           document,
+		Violations:         violations,
+	})
+	if err != nil {
+		return Generation{}, fmt.Errorf("修復プロンプトの生成に失敗しました: %w", err)
+	}
+
+	response, usedModel, failures, err := e.generateWithFallback(ctx, "Repair", model, repairPrompt, nil)
+	if err != nil {
+		return Generation{Failures: failures}, fmt.Errorf("LLMによる最終文書の構造修復に失敗しました: %w", err)
+	}
+
+	return Generation{Text: response.Text, Model: usedModel, Usage: response.Usage, Failures: failures}, nil
+}
+
+// addUsage は、2つのトークン消費量を合算します。
+func addUsage(a, b llm.Usage) llm.Usage {
+	return llm.Usage{
+		PromptTokens: a.PromptTokens + b.PromptTokens,
+		OutputTokens: a.OutputTokens + b.OutputTokens,
+		TotalTokens:  a.TotalTokens + b.TotalTokens,
+	}
 }
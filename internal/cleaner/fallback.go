@@ -0,0 +1,116 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ModelFailure は、フォールバックの途中で失敗したモデルの呼び出し1件です。
+type ModelFailure struct {
+	Model string
+	// Class は失敗の分類 (quota, safety, truncated, empty, error) です。
+	Class string
+	// FinishReason は、応答がブロック・途中終了した場合の終了理由 (例: SAFETY, MAX_TOKENS) です。
+	FinishReason string
+	Error        string
+}
+
+// ParseModelChain は、カンマ区切りのモデル指定 (例: "gemini-2.5-pro,gemini-2.5-flash") を
+// フォールバック順のモデル名のリストに分解します。
+func ParseModelChain(spec string) []string {
+	var models []string
+	for _, m := range strings.Split(spec, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// ValidateModelChain は、モデル指定が1つ以上のモデル名を含み、空の要素がないことを検証します。
+func ValidateModelChain(spec string) error {
+	parts := strings.Split(spec, ",")
+	for _, p := range parts {
+		if strings.TrimSpace(p) == "" {
+			return fmt.Errorf("モデルの指定に空の要素があります: %q", spec)
+		}
+	}
+	return nil
+}
+
+// generateWithFallback は、モデルのリストを先頭から順に試し、最初に成功した応答とそのモデルを返します。
+// 失敗は llm.Classify で分類され、キャンセル以外の失敗 (エラー、クォータ、セーフティブロックなど) の場合に次のモデルへ進みます。
+// stream (逐次書き込み先) が nil でない場合はストリーミング生成を使用し、途中で失敗したモデルの出力の後に区切りを書き込みます。
+// opts は、すべてのモデルの呼び出しに適用する生成設定の上書きです。
+func (e *LLMConcurrentExecutor) generateWithFallback(ctx context.Context, phase string, spec string, prompt string, stream io.Writer, opts ...llm.GenerateOption) (*llm.Response, string, []ModelFailure, error) {
+	models := ParseModelChain(spec)
+	if len(models) == 0 {
+		return nil, "", nil, fmt.Errorf("%s: 使用するモデルが指定されていません", phase)
+	}
+
+	var failures []ModelFailure
+	for i, model := range models {
+		resp, err := e.generate(ctx, phase, model, prompt, stream, opts)
+		if err == nil {
+			if len(failures) > 0 {
+				slog.InfoContext(ctx, "フォールバック先のモデルで応答を生成しました。",
+					slog.String("model", model), slog.Int("failed_models", len(failures)))
+			}
+			return resp, model, failures, nil
+		}
+
+		class := llm.Classify(err)
+		if class == llm.FailureCanceled {
+			return nil, model, failures, err
+		}
+		failures = append(failures, ModelFailure{Model: model, Class: string(class), FinishReason: finishReasonOf(err), Error: err.Error()})
+
+		if i == len(models)-1 {
+			return nil, model, failures, err
+		}
+		next := models[i+1]
+		slog.WarnContext(ctx, "モデルの呼び出しに失敗したため、次のモデルにフォールバックします。",
+			slog.String("model", model), slog.String("next_model", next),
+			slog.String("class", string(class)), slog.String("finish_reason", finishReasonOf(err)), slog.String("error", err.Error()))
+
+		if stream != nil {
+			// 途中まで表示した内容と区別できるよう、フォールバック先の出力の前に区切りを入れる
+			fmt.Fprintf(stream, "\n\n--- %s の応答が中断されたため、%s で再生成します ---\n\n", model, next)
+		}
+	}
+	return nil, "", failures, fmt.Errorf("%s: 使用できるモデルがありません", phase)
+}
+
+// generate は、1つのモデルで1回の生成を行います。stream が nil でない場合はストリーミング生成を使用します。
+// 呼び出しは llm.generate スパンとして記録し、所要時間・トークン数・失敗の分類をメトリクスに記録します。
+func (e *LLMConcurrentExecutor) generate(ctx context.Context, phase string, model string, prompt string, stream io.Writer, opts []llm.GenerateOption) (resp *llm.Response, err error) {
+	ctx, span := telemetry.Start(ctx, "llm.generate", attribute.String("phase", phase), attribute.String("model", model))
+	start := time.Now()
+	defer func() {
+		telemetry.RecordLLMCall(ctx, phase, model, time.Since(start), resp, err)
+		if resp != nil {
+			span.SetAttributes(
+				attribute.Int("tokens.prompt", resp.Usage.PromptTokens),
+				attribute.Int("tokens.output", resp.Usage.OutputTokens),
+				attribute.String("finish_reason", resp.FinishReason))
+		}
+		telemetry.End(span, err)
+	}()
+
+	if stream == nil {
+		return e.client.GenerateContent(ctx, prompt, model, opts...)
+	}
+	return e.client.GenerateContentStream(ctx, prompt, model, func(text string) error {
+		_, err := io.WriteString(stream, text)
+		return err
+	}, opts...)
+}
@@ -0,0 +1,59 @@
+package cleaner
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+)
+
+// referenceLabels は、参考文献セクションの見出しと取得日の表記です。
+type referenceLabels struct {
+	Heading  string
+	Accessed string
+}
+
+// referenceLabelsFor は、出力言語に応じた参考文献セクションの表記を返します (prompts.ReferenceLabels を参照)。
+func referenceLabelsFor(lang string) referenceLabels {
+	heading, accessed := prompts.ReferenceLabels(lang)
+	return referenceLabels{Heading: heading, Accessed: accessed}
+}
+
+// buildReferences は、各ソースの脚注定義 (タイトル、URL、取得日) を並べた参考文献セクションを生成します。
+// 脚注の番号はソースIDと一致するため、Map/Reduce を通じて付与されたマーカーがそのまま対応します。
+func buildReferences(sources []Source, labels referenceLabels) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "## %s\n", labels.Heading)
+	for _, s := range sources {
+		title := s.Title
+		if title == "" {
+			title = hostOf(s.URL)
+		}
+		fmt.Fprintf(&sb, "\n[^%d]: %s — <%s> (%s: %s)\n", s.ID, title, s.URL, labels.Accessed, s.FetchedAt.Format(time.DateOnly))
+	}
+	return sb.String()
+}
+
+// hostOf は、URLのホスト名を返します。パースできない場合はURLをそのまま返します。
+func hostOf(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	return u.Host
+}
+
+// stripFootnoteDefinitions は、モデルが出力した脚注定義の行を取り除きます。
+// 参考文献セクションはソース一覧から生成するため、モデルによる定義は使用しません。
+func stripFootnoteDefinitions(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !footnoteDefinitionPattern.MatchString(line) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
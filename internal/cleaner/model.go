@@ -3,7 +3,7 @@ package cleaner
 import (
 	"time"
 
-	"action-perfect-get-on-go/internal/prompts"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
 )
 
 // DefaultSeparator は、一般的な段落区切りに使用される標準的な区切り文字です。
@@ -20,12 +20,61 @@ const DefaultLLMRateLimit = 2 * time.Second
 
 // Segment は、LLMに渡すテキストと、それが由来する元のURLを保持します。
 type Segment struct {
-	Text string
-	URL  string
+	Text  string
+	URL   string
+	Title string
+	// SourceID は、セグメントの由来となったソースの安定した識別子です (脚注マーカーの番号に使用)。
+	SourceID int
+	// FetchedAt は、セグメントの由来となったソースの取得日時です。
+	FetchedAt time.Time
+	// Index と Total は、全セグメント中の位置 (1始まり) と総数です。
+	Index int
+	Total int
+	// Model は、ルーティングで選択された Map のモデルです (空の場合は Map の既定モデル)。
+	Model string
+	// Route は、適用されたルーティングルールの識別名です (適用されていない場合は空)。
+	Route string
+
+	// withQuery は、調査クエリが指定されているかどうかです (Map 応答の関連性フィールドを読み取ります)。
+	withQuery bool
+}
+
+// CleanerConfig は NewCleaner の設定をカプセル化します。
+type CleanerConfig struct {
+	// Language は最終文書の出力言語 (言語コードまたは言語名) です。空の場合は prompts.DefaultLanguage を使用します。
+	Language string
+	// Topic はユーザーが指定した文書のトピックです。
+	Topic string
+	// Query は調査クエリです。指定された場合、関連情報のみを抽出し回答中心の文書を生成します。
+	Query string
+	// CitationPolicy は、Reduce 出力の引用URL検証ポリシー (flag, remove, off) です。空の場合は DefaultCitationPolicy を使用します。
+	CitationPolicy string
+	// Mode は処理モード (auto, mapreduce, single) です。空の場合は auto を使用します。
+	Mode string
+	// SinglePassMaxChars は、auto モードで単一パスを選択する全ソースの合計文字数の上限です
+	// (0 の場合は ReduceModel の入力トークン数の上限から算出します。SinglePassLimit を参照)。
+	SinglePassMaxChars int
+	// ReduceModel は、Reduce に使用するモデルの指定 (カンマ区切りのフォールバック順) です。単一パスの上限の算出に使用します。
+	ReduceModel string
+	// CustomPrompts は、カスタムの Map/Reduce プロンプトが指定されているかどうかです。
+	// true の場合、auto モードは単一パスを選択せず MapReduce で処理します。
+	CustomPrompts bool
+	// Routing は、セグメントと Reduce 入力に適用するモデルのルーティングルールです (定義順に評価)。
+	Routing []RoutingRule
+	// CitationStyle は出典の表記方式 (section, footnote) です。空の場合は section を使用します。
+	CitationStyle string
+	// Vars は Map/Reduce の両テンプレートに渡す任意のユーザー変数です。
+	Vars map[string]string
+	// Clock は、取得日時が記録されていないソースの取得日時の算出に使用する時刻関数です。nil の場合は time.Now を使用します。
+	Clock func() time.Time
 }
 
 // PromptBuilders は Cleaner が依存する PromptBuilder をまとめています。
 type PromptBuilders struct {
 	MapBuilder    *prompts.PromptBuilder
 	ReduceBuilder *prompts.PromptBuilder
+	// SinglePassBuilder は、単一パスで最終文書を生成するプロンプトを構築します (nil の場合は組み込みテンプレート)。
+	SinglePassBuilder *prompts.PromptBuilder
+	// RepairBuilder は、Reduce 出力が構造ルールに違反していた場合の修復プロンプトを構築します。
+	RepairBuilder *prompts.PromptBuilder
 }
@@ -0,0 +1,71 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// partialResultsKey は、Mapフェーズの部分結果の収集先をコンテキストに格納するためのキーです。
+type partialResultsKey struct{}
+
+// PartialResults は、Mapフェーズで完了したセグメントの中間要約を完了順に収集します。
+// 実行が中断された場合に、それまでに完了したセグメントの結果を書き出すために使用します。
+type PartialResults struct {
+	mu      sync.Mutex
+	total   int
+	results []MapResult
+}
+
+// WithPartialResults は、Mapフェーズで完了したセグメントの結果を pr に記録するようコンテキストに設定します。
+func WithPartialResults(ctx context.Context, pr *PartialResults) context.Context {
+	return context.WithValue(ctx, partialResultsKey{}, pr)
+}
+
+// partialResultsFrom は、コンテキストに設定された部分結果の収集先を返します (未設定の場合は nil)。
+func partialResultsFrom(ctx context.Context) *PartialResults {
+	pr, _ := ctx.Value(partialResultsKey{}).(*PartialResults)
+	return pr
+}
+
+func (pr *PartialResults) start(total int) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.total = total
+}
+
+func (pr *PartialResults) add(result MapResult) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.results = append(pr.results, result)
+}
+
+// Len は、完了したセグメント数を返します。
+func (pr *PartialResults) Len() int {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return len(pr.results)
+}
+
+// Markdown は、完了したセグメントの中間要約をセグメント順に並べた Markdown を返します。
+// 本文を抽出できなかったセグメントは除外します。
+func (pr *PartialResults) Markdown() string {
+	pr.mu.Lock()
+	results := append([]MapResult(nil), pr.results...)
+	total := pr.total
+	pr.mu.Unlock()
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+
+	var sb strings.Builder
+	sb.WriteString("# 中断された実行の部分結果\n\n")
+	fmt.Fprintf(&sb, "実行は完了前に中断されました。以下は Map フェーズで完了したセグメントの中間要約です (%d / %d セグメント)。\n", len(results), total)
+	for _, r := range results {
+		if r.Summary == "" {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n## セグメント %d: %s\n\n%s\n", r.Index, r.URL, strings.TrimSpace(r.Summary))
+	}
+	return sb.String()
+}
@@ -0,0 +1,30 @@
+package cleaner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPartialResultsMarkdown(t *testing.T) {
+	pr := &PartialResults{}
+	pr.start(3)
+	pr.add(MapResult{Index: 2, URL: "https://example.com/b", Summary: "後のセグメント\n"})
+	pr.add(MapResult{Index: 1, URL: "https://example.com/a", Summary: "前のセグメント"})
+	pr.add(MapResult{Index: 3, URL: "https://example.com/c"})
+
+	if pr.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", pr.Len())
+	}
+	got := pr.Markdown()
+	if !strings.Contains(got, "(3 / 3 セグメント)") {
+		t.Errorf("Markdown() lacks the segment count:\n%s", got)
+	}
+	first := strings.Index(got, "## セグメント 1: https://example.com/a\n\n前のセグメント\n")
+	second := strings.Index(got, "## セグメント 2: https://example.com/b\n\n後のセグメント\n")
+	if first < 0 || second < 0 || first > second {
+		t.Errorf("Markdown() does not list the segments in order:\n%s", got)
+	}
+	if strings.Contains(got, "セグメント 3") {
+		t.Errorf("Markdown() contains the segment without a summary:\n%s", got)
+	}
+}
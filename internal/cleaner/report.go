@@ -0,0 +1,124 @@
+package cleaner
+
+import "github.com/shouni/action-perfect-get-on-go/internal/llm"
+
+// Report は、1回のクリーンアップ処理で得られた診断情報をまとめたものです。
+type Report struct {
+	// Mode は、実際に使用した処理モード (mapreduce, single) です。
+	Mode string
+	// Segments はセグメントごとの処理結果です (セグメント順)。
+	Segments []SegmentReport
+	// Citations は、Reduce 出力に含まれる引用URLの検証結果です。
+	Citations CitationReport
+	// Structure は、最終文書の構造検証と正規化の結果です。
+	Structure StructureReport
+	// Reduce は、Reduceフェーズで使用したモデルとルーティングの結果です。
+	Reduce PhaseReport
+}
+
+// PhaseReport は、1回の LLM 呼び出しで使用したモデルと適用されたルーティングルールです。
+type PhaseReport struct {
+	Model string
+	// Route は、適用されたルーティングルールの識別名です (既定のモデルを使用した場合は空)。
+	Route string
+	// Failures は、フォールバックの途中で失敗したモデルの呼び出しです。
+	Failures []ModelFailure
+	// FinishReason は、応答がブロック・途中終了・空だった場合の終了理由です (正常な場合は空)。
+	FinishReason string
+	// Recovery は、応答ポリシーにより適用したアクションです (適用しなかった場合は空)。
+	Recovery string
+	// Usage は、この呼び出し (再試行を含む) で消費したトークン数です。
+	Usage llm.Usage
+}
+
+// SegmentReport は、Mapフェーズにおける1セグメント分の処理結果です。
+type SegmentReport struct {
+	Index int
+	URL   string
+	// Issues は、応答の解析時に検出した形式上の問題です (修復済みのものを含む)。
+	Issues []string
+	// Reprompted は、形式不備のために再プロンプトを行ったかどうかです。
+	Reprompted bool
+	// Skipped は、再プロンプト後も本文を抽出できなかったか、応答ポリシーの skip により、Reduce の入力から除外されたかどうかです。
+	Skipped bool
+	// Irrelevant は、クエリに関連する情報がないと Map が判定し、Reduce の入力から除外されたかどうかです。
+	Irrelevant bool
+	// Model は、Map に使用したモデルです (Map をスキップした場合は空)。
+	Model string
+	// Route は、適用されたルーティングルールの識別名です (既定のモデルを使用した場合は空)。
+	Route string
+	// DirectToReduce は、ルーティングにより Map をスキップし、本文をそのまま Reduce に渡したかどうかです。
+	DirectToReduce bool
+	// Failures は、フォールバックの途中で失敗したモデルの呼び出しです。
+	Failures []ModelFailure
+	// FinishReason は、応答がブロック・途中終了・空だった場合の終了理由です (正常な場合は空)。
+	FinishReason string
+	// Recovery は、応答ポリシーにより適用したアクション (retry, relax-safety, split, skip) です (適用しなかった場合は空)。
+	Recovery string
+	// SkippedParts は、split で分割した部分のうち、skip により除外された部分の数です。
+	SkippedParts int
+	// Usage は、再プロンプトを含むこのセグメントのトークン消費量です。
+	Usage llm.Usage
+}
+
+// TotalUsage は、Map・Reduce・構造修復のトークン消費量の合計を返します。
+func (r Report) TotalUsage() llm.Usage {
+	total := addUsage(r.Reduce.Usage, r.Structure.RepairUsage)
+	for _, seg := range r.Segments {
+		total = addUsage(total, seg.Usage)
+	}
+	return total
+}
+
+// ModelFailures は、フォールバックの途中で失敗したモデルの呼び出しの総数を返します。
+func (r Report) ModelFailures() int {
+	count := len(r.Reduce.Failures)
+	for _, seg := range r.Segments {
+		count += len(seg.Failures)
+	}
+	return count
+}
+
+// RecoveredResponses は、応答ポリシーが適用された LLM 呼び出し (Map のセグメントと Reduce) の数を返します。
+func (r Report) RecoveredResponses() int {
+	count := 0
+	if r.Reduce.Recovery != "" {
+		count++
+	}
+	for _, seg := range r.Segments {
+		if seg.Recovery != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// RoutedSegments は、ルーティングルールが適用されたセグメント数を返します。
+func (r Report) RoutedSegments() int {
+	count := 0
+	for _, seg := range r.Segments {
+		if seg.Route != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// Result は、CleanAndStructureText の最終結果です。
+type Result struct {
+	// Markdown は最終的な構造化Markdownテキストです。
+	Markdown string
+	// Report は処理全体の診断情報です。
+	Report Report
+}
+
+// ParseFailures は、形式上の問題が検出されたセグメント数を返します。
+func (r Report) ParseFailures() int {
+	count := 0
+	for _, seg := range r.Segments {
+		if len(seg.Issues) > 0 || (seg.Skipped && seg.Recovery != ResponseActionSkip) {
+			count++
+		}
+	}
+	return count
+}
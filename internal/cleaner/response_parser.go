@@ -0,0 +1,145 @@
+package cleaner
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+)
+
+// Map プロンプト (map_segment_prompt.md) が応答に要求するエンベロープのマーカーです。
+const (
+	cleanupStartMarker = "<CLEANUP_START>"
+	cleanupEndMarker   = "<CLEANUP_END>"
+)
+
+// mapRepromptSuffix は、Map の応答がエンベロープの形式に従っていなかった場合に、元のプロンプトへ追記する再指示です。
+const mapRepromptSuffix = `
+
+## ⚠️ 再指示 (RETRY)
+
+前回の応答は、指定された出力形式に従っていなかったため処理できませんでした。
+クリーンアップされたMarkdownテキストを必ず <CLEANUP_START> と <CLEANUP_END> の間に出力し、
+マーカーの外側には [元記事URL: ...] の行 (調査クエリがある場合は [RELEVANT: ...] の行も) 以外を一切出力しないでください。`
+
+// sourceURLLinePattern は、エンベロープの直後に付与される "[元記事URL: ...]" 行に一致します。
+var sourceURLLinePattern = regexp.MustCompile(`\[元記事URL:\s*([^\]\s]*)\s*\]`)
+
+// relevanceLinePattern は、クエリ指定時にエンベロープの外側 (URL行の後) に付与される関連性フィールド "[RELEVANT: yes|no]" に一致します。
+var relevanceLinePattern = regexp.MustCompile(`(?i)\[RELEVANT:\s*(yes|no)\s*\]`)
+
+// errEmptyEnvelope は、応答からクリーンアップ済みテキストを取り出せなかったことを示します。
+var errEmptyEnvelope = errors.New("応答からクリーンアップ済みテキストを抽出できませんでした")
+
+// mapOutput は、Map の応答を解析した結果です。
+type mapOutput struct {
+	// Body はエンベロープ内のMarkdownテキストです。
+	Body string
+	// Irrelevant は、クエリに関連する情報がセグメントにないとモデルが判定したかどうかです。
+	Irrelevant bool
+	// Issues は、解析中に検出し修復した形式上の問題です。
+	Issues []string
+}
+
+// Summary は、Reduce の入力として使用する正規化済みの中間要約を返します。
+// URL行は、モデルの出力ではなく実際のセグメントのURLで常に再生成します。
+func (o mapOutput) Summary(sourceURL string) string {
+	return fmt.Sprintf("%s\n\n[元記事URL: %s]", o.Body, sourceURL)
+}
+
+// parseMapResponse は、Map の応答からエンベロープ内のテキストを抽出し、URL行を検証します。
+// マーカーの欠落やコードフェンス、URL行の不備などは可能な範囲で修復し、Issues に記録します。
+// withQuery が true の場合は、エンベロープの外側の関連性フィールド [RELEVANT: yes|no] を読み取ります
+// (本文中の文字列は判定に使用しません)。関連性が no の場合は本文が空でも成功とします。
+// 本文を取り出せない場合は errEmptyEnvelope を返します。
+func parseMapResponse(text, expectedURL string, withQuery bool) (mapOutput, error) {
+	var out mapOutput
+	body := text
+
+	// 1. エンベロープの抽出
+	start := strings.Index(body, cleanupStartMarker)
+	if start == -1 {
+		out.Issues = append(out.Issues, "開始マーカー <CLEANUP_START> がありません")
+	} else {
+		if chatter := strings.TrimSpace(body[:start]); chatter != "" {
+			out.Issues = append(out.Issues, "開始マーカーの前に余分なテキストがあります")
+		}
+		body = body[start+len(cleanupStartMarker):]
+	}
+
+	end := strings.Index(body, cleanupEndMarker)
+	trailer := ""
+	if end == -1 {
+		out.Issues = append(out.Issues, "終了マーカー <CLEANUP_END> がありません")
+		// 終了マーカーがない場合は、URL行の手前までを本文とみなす
+		if loc := sourceURLLinePattern.FindStringIndex(body); loc != nil {
+			trailer = body[loc[0]:]
+			body = body[:loc[0]]
+		}
+	} else {
+		trailer = body[end+len(cleanupEndMarker):]
+		body = body[:end]
+	}
+
+	// 2. URL行の検証 (値はセグメントのURLで置き換えるため、ここでは記録のみ)
+	match := sourceURLLinePattern.FindStringSubmatch(trailer)
+	switch {
+	case match == nil:
+		out.Issues = append(out.Issues, "[元記事URL: ...] 行がありません")
+	case match[1] != expectedURL:
+		out.Issues = append(out.Issues, fmt.Sprintf("[元記事URL: ...] 行のURLが一致しません (応答: %q)", match[1]))
+	}
+	if match != nil {
+		trailer = strings.Replace(trailer, match[0], "", 1)
+	}
+	if relevance := relevanceLinePattern.FindStringSubmatch(trailer); relevance != nil {
+		out.Irrelevant = strings.EqualFold(relevance[1], "no")
+		trailer = strings.Replace(trailer, relevance[0], "", 1)
+	} else if withQuery {
+		out.Issues = append(out.Issues, "[RELEVANT: ...] 行がありません")
+	}
+	if strings.TrimSpace(trailer) != "" {
+		out.Issues = append(out.Issues, "終了マーカーの後に余分なテキストがあります")
+	}
+
+	// 3. 本文の正規化 (残存マーカー・URL行・コードフェンスの除去)
+	body = strings.ReplaceAll(body, cleanupStartMarker, "")
+	body = strings.ReplaceAll(body, cleanupEndMarker, "")
+	body = sourceURLLinePattern.ReplaceAllString(body, "")
+	if unfenced, ok := stripCodeFence(body); ok {
+		out.Issues = append(out.Issues, "本文がコードフェンスで囲まれています")
+		body = unfenced
+	}
+
+	out.Body = strings.TrimSpace(body)
+	if withQuery && out.Body == prompts.NoRelevantInfoMarker {
+		// 関連性フィールドに対応していない (旧形式の) カスタムテンプレートでは、本文全体がマーカーの場合のみ関連情報なしとみなす
+		out.Irrelevant = true
+	}
+	if out.Irrelevant {
+		out.Body = ""
+		return out, nil
+	}
+	if out.Body == "" {
+		return out, errEmptyEnvelope
+	}
+	return out, nil
+}
+
+// stripCodeFence は、テキスト全体が ``` で囲まれている場合にフェンスを取り除きます。
+func stripCodeFence(text string) (string, bool) {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "```") || !strings.HasSuffix(trimmed, "```") || len(trimmed) < 6 {
+		return text, false
+	}
+
+	// 開始フェンスの行 (```markdown など) を丸ごと取り除く
+	firstLineEnd := strings.Index(trimmed, "\n")
+	if firstLineEnd == -1 {
+		return text, false
+	}
+	inner := trimmed[firstLineEnd+1 : len(trimmed)-3]
+	return inner, true
+}
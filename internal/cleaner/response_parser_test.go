@@ -0,0 +1,131 @@
+package cleaner
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseMapResponse(t *testing.T) {
+	const url = "https://example.com/a"
+	tests := []struct {
+		name           string
+		text           string
+		withQuery      bool
+		wantBody       string
+		wantIrrelevant bool
+		wantIssues     []string
+		wantErr        error
+	}{
+		{
+			name:     "well-formed envelope",
+			text:     "<CLEANUP_START>\n## 概要\n本文\n<CLEANUP_END>\n[元記事URL: https://example.com/a]",
+			wantBody: "## 概要\n本文",
+		},
+		{
+			name:       "missing start marker",
+			text:       "## 概要\n本文\n<CLEANUP_END>\n[元記事URL: https://example.com/a]",
+			wantBody:   "## 概要\n本文",
+			wantIssues: []string{"開始マーカー"},
+		},
+		{
+			name:       "missing end marker with trailing URL line",
+			text:       "<CLEANUP_START>\n本文\n[元記事URL: https://example.com/a]",
+			wantBody:   "本文",
+			wantIssues: []string{"終了マーカー <CLEANUP_END> がありません"},
+		},
+		{
+			name:       "chatter before and after the envelope",
+			text:       "以下が結果です。\n<CLEANUP_START>\n本文\n<CLEANUP_END>\n[元記事URL: https://example.com/a]\n以上です。",
+			wantBody:   "本文",
+			wantIssues: []string{"開始マーカーの前に余分なテキスト", "終了マーカーの後に余分なテキスト"},
+		},
+		{
+			name:       "mismatched URL",
+			text:       "<CLEANUP_START>\n本文\n<CLEANUP_END>\n[元記事URL: https://example.com/other]",
+			wantBody:   "本文",
+			wantIssues: []string{"URLが一致しません"},
+		},
+		{
+			name:       "body wrapped in a markdown fence",
+			text:       "<CLEANUP_START>\n```markdown\n## 概要\n本文\n```\n<CLEANUP_END>\n[元記事URL: https://example.com/a]",
+			wantBody:   "## 概要\n本文",
+			wantIssues: []string{"コードフェンス"},
+		},
+		{
+			name:           "irrelevant with empty body",
+			text:           "<CLEANUP_START>\n<CLEANUP_END>\n[元記事URL: https://example.com/a]\n[RELEVANT: no]",
+			withQuery:      true,
+			wantIrrelevant: true,
+		},
+		{
+			name:       "missing relevance field with query",
+			text:       "<CLEANUP_START>\n本文\n<CLEANUP_END>\n[元記事URL: https://example.com/a]",
+			withQuery:  true,
+			wantBody:   "本文",
+			wantIssues: []string{"[RELEVANT: ...] 行がありません"},
+		},
+		{
+			name:           "bare no-relevant-info marker body",
+			text:           "<CLEANUP_START>\n<NO_RELEVANT_INFO>\n<CLEANUP_END>\n[元記事URL: https://example.com/a]\n[RELEVANT: yes]",
+			withQuery:      true,
+			wantIrrelevant: true,
+		},
+		{
+			name:    "empty body",
+			text:    "<CLEANUP_START>\n\n<CLEANUP_END>\n[元記事URL: https://example.com/a]",
+			wantErr: errEmptyEnvelope,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := parseMapResponse(tt.text, url, tt.withQuery)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("parseMapResponse error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMapResponse: %v", err)
+			}
+			if out.Body != tt.wantBody || out.Irrelevant != tt.wantIrrelevant {
+				t.Errorf("parseMapResponse = {Body: %q, Irrelevant: %v}, want {Body: %q, Irrelevant: %v}", out.Body, out.Irrelevant, tt.wantBody, tt.wantIrrelevant)
+			}
+			issues := strings.Join(out.Issues, "\n")
+			for _, want := range tt.wantIssues {
+				if !strings.Contains(issues, want) {
+					t.Errorf("Issues = %q, want containing %q", out.Issues, want)
+				}
+			}
+			if len(tt.wantIssues) == 0 && len(out.Issues) != 0 {
+				t.Errorf("Issues = %q, want none", out.Issues)
+			}
+		})
+	}
+}
+
+func TestStripCodeFence(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		want   string
+		wantOK bool
+	}{
+		{name: "markdown fence", text: "```markdown\n本文\n```", want: "本文\n", wantOK: true},
+		{name: "bare fence with surrounding space", text: "\n```\n本文\n```\n", want: "本文\n", wantOK: true},
+		{name: "fence only at the start", text: "```\n本文", want: "```\n本文"},
+		{name: "single-line fence", text: "```本文```", want: "```本文```"},
+		{name: "no fence", text: "本文", want: "本文"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := stripCodeFence(tt.text)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("stripCodeFence(%q) = (%q, %v), want (%q, %v)", tt.text, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+)
+
+// 応答ポリシーのアクション。ブロック・途中終了・空の応答に対して、指定された順に適用します。
+const (
+	// ResponseActionRetry は、応答の失敗の種類に応じて設定を変えて再試行します
+	// (途中終了: 最大出力トークン数の拡大、ブロック・空: 温度 0)。セーフティ設定は変更しません。
+	ResponseActionRetry = "retry"
+	// ResponseActionRelaxSafety は、セーフティブロックの場合に限り、セーフティ設定を緩和 (高リスクのみブロック) し温度 0 で再試行します。
+	// コンテンツの安全性の判定を緩めるため、既定のポリシーには含めず、明示的に指定した場合のみ適用します。
+	ResponseActionRelaxSafety = "relax-safety"
+	// ResponseActionSplit は、セグメントを半分に分割してそれぞれを Map します (Mapフェーズのみ)。
+	ResponseActionSplit = "split"
+	// ResponseActionSkip は、警告を記録してセグメントを Reduce の入力から除外します (Mapフェーズのみ)。
+	// 除外したソースは、最終文書の末尾の注記に列挙します。
+	ResponseActionSkip = "skip"
+	// ResponseActionFail は、エラーとして処理を中断します。
+	ResponseActionFail = "fail"
+)
+
+// DefaultResponsePolicy は、応答ポリシーの既定値です。
+const DefaultResponsePolicy = "retry,split,skip"
+
+// MaxSplitDepth は、split アクションでセグメントを再帰的に分割する最大の深さです。
+const MaxSplitDepth = 2
+
+// minSplitChars は、split アクションで分割するセグメントの最小文字数です。これより短いセグメントは分割しません。
+const minSplitChars = 400
+
+// retryMaxOutputTokens は、途中終了した応答を再試行する際の最大出力トークン数です。
+const retryMaxOutputTokens = 65536
+
+// ParseResponsePolicy は、カンマ区切りの応答ポリシー (例: "retry,split,skip") をアクションのリストに分解して検証します。
+// 空の場合は DefaultResponsePolicy を使用します。
+func ParseResponsePolicy(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		spec = DefaultResponsePolicy
+	}
+	var actions []string
+	for _, a := range strings.Split(spec, ",") {
+		a = strings.ToLower(strings.TrimSpace(a))
+		switch a {
+		case ResponseActionRetry, ResponseActionRelaxSafety, ResponseActionSplit, ResponseActionSkip, ResponseActionFail:
+			actions = append(actions, a)
+		default:
+			return nil, fmt.Errorf("不明な応答ポリシーのアクションです: %q (%s, %s, %s, %s, %s のいずれかを指定してください)",
+				a, ResponseActionRetry, ResponseActionRelaxSafety, ResponseActionSplit, ResponseActionSkip, ResponseActionFail)
+		}
+	}
+	return actions, nil
+}
+
+// isRecoverableResponse は、失敗が応答ポリシーの対象 (ブロック・途中終了・空の応答) かどうかを返します。
+func isRecoverableResponse(class llm.FailureClass) bool {
+	return class == llm.FailureSafety || class == llm.FailureTruncated || class == llm.FailureEmpty
+}
+
+// retryOptions は、再試行のアクション (retry, relax-safety) と失敗の種類に応じた再試行時の生成設定を返します。
+// アクションが失敗の種類に適用できない場合 (セーフティブロック以外に対する relax-safety) は ok が false になります。
+func retryOptions(action string, class llm.FailureClass) (opts []llm.GenerateOption, ok bool) {
+	if action == ResponseActionRelaxSafety {
+		if class != llm.FailureSafety {
+			return nil, false
+		}
+		return []llm.GenerateOption{llm.WithRelaxedSafety(), llm.WithTemperature(0)}, true
+	}
+	if class == llm.FailureTruncated {
+		return []llm.GenerateOption{llm.WithMaxOutputTokens(retryMaxOutputTokens)}, true
+	}
+	return []llm.GenerateOption{llm.WithTemperature(0)}, true
+}
+
+// finishReasonOf は、エラーに含まれる応答の終了理由 (例: SAFETY, MAX_TOKENS) を返します。
+// プロンプト自体がブロックされた場合は "PROMPT_BLOCKED" を返します。
+func finishReasonOf(err error) string {
+	var respErr *llm.ResponseError
+	if !errors.As(err, &respErr) {
+		return ""
+	}
+	if respErr.FinishReason == "" && respErr.Blocked {
+		return "PROMPT_BLOCKED"
+	}
+	return respErr.FinishReason
+}
+
+// splitSegmentText は、テキストを中央付近の段落の区切りで2つに分割します。
+// 段落の区切りがない場合は改行、それもない場合は文字数の中央で分割します。
+// 分割するには短すぎる場合は ok が false になります。
+func splitSegmentText(text string) (first, second string, ok bool) {
+	runes := []rune(text)
+	if len(runes) < minSplitChars {
+		return "", "", false
+	}
+	mid := len(string(runes[:len(runes)/2]))
+
+	splitAt := -1
+	for _, sep := range []string{DefaultSeparator, "\n"} {
+		// 中央をまたぐ区切りも見つけられるよう、前方の探索範囲を区切りの長さ分だけ広げる
+		before := strings.LastIndex(text[:min(len(text), mid+len(sep)-1)], sep)
+		after := strings.Index(text[mid:], sep)
+		switch {
+		case before > 0 && (after == -1 || max(mid-before, 0) <= after):
+			splitAt = before + len(sep)
+		case after != -1:
+			splitAt = mid + after + len(sep)
+		}
+		if splitAt > 0 && splitAt < len(text) {
+			break
+		}
+		splitAt = -1
+	}
+	if splitAt == -1 {
+		splitAt = mid
+	}
+
+	first, second = strings.TrimSpace(text[:splitAt]), strings.TrimSpace(text[splitAt:])
+	if first == "" || second == "" {
+		return "", "", false
+	}
+	return first, second, true
+}
+
+// recoverMap は、ブロック・途中終了・空の応答で失敗したセグメントに、応答ポリシーのアクションを順に適用します。
+// result には失敗までに記録したフォールバックの失敗とトークン消費量が含まれます。
+func (e *LLMConcurrentExecutor) recoverMap(ctx context.Context, result MapResult, buildPrompt func(text string) (string, error), index int, s Segment, depth int, class llm.FailureClass, cause error) (MapResult, error) {
+	result.FinishReason = finishReasonOf(cause)
+	slog.WarnContext(ctx, "Map応答がブロック・途中終了・空のため、応答ポリシーを適用します。",
+		slog.Int(logging.KeySegment, index+1), slog.String(logging.KeyURL, s.URL), slog.String("class", string(class)),
+		slog.String("finish_reason", result.FinishReason), slog.Any("policy", e.responsePolicy))
+
+	for _, action := range e.responsePolicy {
+		switch action {
+		case ResponseActionRetry, ResponseActionRelaxSafety:
+			opts, ok := retryOptions(action, class)
+			if !ok {
+				continue
+			}
+			prompt, err := buildPrompt(s.Text)
+			if err != nil {
+				return MapResult{}, fmt.Errorf("セグメント %d プロンプト生成失敗 (URL: %s): %w", index+1, s.URL, err)
+			}
+			retried := result
+			err = e.summarize(ctx, &retried, prompt, index, s, opts...)
+			if err == nil {
+				retried.Recovery = action
+				slog.InfoContext(ctx, "設定を変えた再試行で Map 応答を生成しました。",
+					slog.Int(logging.KeySegment, index+1), slog.String(logging.KeyURL, s.URL), slog.String("class", string(class)),
+					slog.String("action", action))
+				return retried, nil
+			}
+			class = llm.Classify(err)
+			if !isRecoverableResponse(class) {
+				return MapResult{}, fmt.Errorf("セグメント %d 処理失敗 (URL: %s): %w", index+1, s.URL, err)
+			}
+			result.Failures, result.Usage = retried.Failures, retried.Usage
+			result.FinishReason, cause = finishReasonOf(err), err
+
+		case ResponseActionSplit:
+			if depth >= MaxSplitDepth {
+				continue
+			}
+			first, second, ok := splitSegmentText(s.Text)
+			if !ok {
+				continue
+			}
+			slog.InfoContext(ctx, "セグメントを分割して Map を再実行します。",
+				slog.Int(logging.KeySegment, index+1), slog.String(logging.KeyURL, s.URL), slog.Int("depth", depth+1))
+			return e.mapSplit(ctx, result, buildPrompt, index, s, depth, []string{first, second})
+
+		case ResponseActionSkip:
+			result.Summary = ""
+			result.Recovery = ResponseActionSkip
+			slog.WarnContext(ctx, "応答を回復できなかったため、このセグメントを除外します。",
+				slog.Int(logging.KeySegment, index+1), slog.String(logging.KeyURL, s.URL), slog.String("finish_reason", result.FinishReason))
+			return result, nil
+
+		case ResponseActionFail:
+			return MapResult{}, fmt.Errorf("セグメント %d 処理失敗 (URL: %s, 終了理由: %s): %w", index+1, s.URL, result.FinishReason, cause)
+		}
+	}
+	return MapResult{}, fmt.Errorf("セグメント %d の応答を応答ポリシーで回復できませんでした (URL: %s, 終了理由: %s): %w", index+1, s.URL, result.FinishReason, cause)
+}
+
+// mapSplit は、分割したテキストをそれぞれ Map し、得られた要約を1つの結果にまとめます。
+// 分割後のテキストにも応答ポリシーが適用されるため、除外された部分は結果に含まれません。
+func (e *LLMConcurrentExecutor) mapSplit(ctx context.Context, result MapResult, buildPrompt func(text string) (string, error), index int, s Segment, depth int, parts []string) (MapResult, error) {
+	result.Recovery = ResponseActionSplit
+	urlLine := mapOutput{}.Summary(s.URL)
+	var bodies []string
+	irrelevantParts := 0
+	for _, part := range parts {
+		sub := s
+		sub.Text = part
+		partResult, err := e.generateMapSummary(ctx, buildPrompt, index, sub, depth+1)
+		if err != nil {
+			return MapResult{}, err
+		}
+		result.Issues = append(result.Issues, partResult.Issues...)
+		result.Reprompted = result.Reprompted || partResult.Reprompted
+		result.Usage = addUsage(result.Usage, partResult.Usage)
+		result.Failures = append(result.Failures, partResult.Failures...)
+		if partResult.Irrelevant {
+			irrelevantParts++
+		}
+		// 除外された部分の数は、最終文書の注記に反映する
+		result.SkippedParts += partResult.SkippedParts
+		if partResult.Recovery == ResponseActionSkip {
+			result.SkippedParts++
+		}
+		if partResult.Summary != "" {
+			result.Model = partResult.Model
+			bodies = append(bodies, strings.TrimSpace(strings.TrimSuffix(partResult.Summary, urlLine)))
+		}
+	}
+
+	result.Summary = ""
+	if len(bodies) > 0 {
+		result.Summary = mapOutput{Body: strings.Join(bodies, DefaultSeparator)}.Summary(s.URL)
+	}
+	// すべての部分が関連情報なしと判定された場合は、セグメント全体を関連情報なしとする
+	result.Irrelevant = irrelevantParts == len(parts)
+	return result, nil
+}
+
+// retryReduce は、Reduce の応答がブロック・途中終了・空だった場合に、応答ポリシーの retry と relax-safety のアクションを適用します。
+// Reduce は分割や除外ができないため、split と skip は無視し、再試行で回復できなければ cause を返します。
+func (e *LLMConcurrentExecutor) retryReduce(ctx context.Context, gen *Generation, model string, prompt string, stream io.Writer, cause error) (*llm.Response, string, error) {
+	class := llm.Classify(cause)
+	if !isRecoverableResponse(class) {
+		return nil, "", cause
+	}
+	for _, action := range e.responsePolicy {
+		if action == ResponseActionFail {
+			break
+		}
+		if action != ResponseActionRetry && action != ResponseActionRelaxSafety {
+			continue
+		}
+		opts, ok := retryOptions(action, class)
+		if !ok {
+			continue
+		}
+		slog.WarnContext(ctx, "Reduce応答がブロック・途中終了・空のため、設定を変えて再試行します。",
+			slog.String("class", string(class)), slog.String("finish_reason", gen.FinishReason), slog.String("action", action))
+		if stream != nil {
+			// 途中まで表示した内容と区別できるよう、再試行の出力の前に区切りを入れる
+			fmt.Fprintf(stream, "\n\n--- 応答が中断されたため (%s)、設定を変えて再生成します ---\n\n", gen.FinishReason)
+		}
+		resp, usedModel, failures, err := e.generateWithFallback(ctx, "Reduce", model, prompt, stream, opts...)
+		gen.Failures = append(gen.Failures, failures...)
+		if err == nil {
+			gen.Recovery = action
+			return resp, usedModel, nil
+		}
+		if class = llm.Classify(err); !isRecoverableResponse(class) {
+			return nil, "", err
+		}
+		gen.FinishReason, cause = finishReasonOf(err), err
+	}
+	return nil, "", fmt.Errorf("応答ポリシーで回復できませんでした (終了理由: %s): %w", gen.FinishReason, cause)
+}
+
+// omittedLabels は、最終文書の末尾に付与する除外ソースの注記の表記です。
+type omittedLabels struct {
+	Intro        string
+	Segment      string
+	Partial      string
+	FinishReason string
+}
+
+// omittedLabelsFor は、出力言語に応じた除外ソースの注記の表記を返します。
+// 日本語以外の言語では英語表記を使用します (言語の判定は prompts.LanguageCode を使用します)。
+func omittedLabelsFor(lang string) omittedLabels {
+	if prompts.LanguageCode(lang) == "ja" {
+		return omittedLabels{
+			Intro:        "⚠️ 次のソースの一部は、LLM の応答がブロック・途中終了・空または解析できなかったため、この文書に含まれていません。",
+			Segment:      "セグメント",
+			Partial:      "一部",
+			FinishReason: "終了理由",
+		}
+	}
+	return omittedLabels{
+		Intro:        "⚠️ Parts of the following sources are not included in this document because the LLM response was blocked, truncated, empty or could not be parsed.",
+		Segment:      "segment",
+		Partial:      "partially",
+		FinishReason: "finish reason",
+	}
+}
+
+// omittedNotice は、Reduce の入力から除外された (Irrelevant を除く) セグメントを列挙する引用ブロックの注記を返します。
+// 除外されたセグメントがない場合は空文字列を返します。
+func omittedNotice(segments []SegmentReport, lang string) string {
+	labels := omittedLabelsFor(lang)
+	var items []string
+	for _, seg := range segments {
+		if !seg.Skipped && seg.SkippedParts == 0 {
+			continue
+		}
+		detail := fmt.Sprintf("%s %d", labels.Segment, seg.Index)
+		if !seg.Skipped {
+			detail += ", " + labels.Partial
+		}
+		if seg.FinishReason != "" {
+			detail += fmt.Sprintf(", %s: %s", labels.FinishReason, seg.FinishReason)
+		}
+		items = append(items, fmt.Sprintf("> - <%s> (%s)", seg.URL, detail))
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	return "> " + labels.Intro + "\n>\n" + strings.Join(items, "\n")
+}
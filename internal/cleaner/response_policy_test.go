@@ -0,0 +1,118 @@
+package cleaner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+)
+
+func TestParseResponsePolicy(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: "", want: "retry,split,skip"},
+		{spec: " Retry , relax-safety,FAIL ", want: "retry,relax-safety,fail"},
+		{spec: "retry,ignore", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseResponsePolicy(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParseResponsePolicy(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+		}
+		if !tt.wantErr && strings.Join(got, ",") != tt.want {
+			t.Errorf("ParseResponsePolicy(%q) = %v, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestRetryOptions(t *testing.T) {
+	tests := []struct {
+		action   string
+		class    llm.FailureClass
+		wantOpts int
+		wantOK   bool
+	}{
+		{action: ResponseActionRetry, class: llm.FailureTruncated, wantOpts: 1, wantOK: true},
+		{action: ResponseActionRetry, class: llm.FailureSafety, wantOpts: 1, wantOK: true},
+		{action: ResponseActionRetry, class: llm.FailureEmpty, wantOpts: 1, wantOK: true},
+		{action: ResponseActionRelaxSafety, class: llm.FailureSafety, wantOpts: 2, wantOK: true},
+		{action: ResponseActionRelaxSafety, class: llm.FailureTruncated},
+	}
+	for _, tt := range tests {
+		opts, ok := retryOptions(tt.action, tt.class)
+		if ok != tt.wantOK || len(opts) != tt.wantOpts {
+			t.Errorf("retryOptions(%s, %s) = %d options, %v, want %d, %v", tt.action, tt.class, len(opts), ok, tt.wantOpts, tt.wantOK)
+		}
+	}
+}
+
+func TestSplitSegmentText(t *testing.T) {
+	para := strings.Repeat("あ", 150)
+	tests := []struct {
+		name       string
+		text       string
+		wantOK     bool
+		wantFirst  string
+		wantSecond string
+	}{
+		{name: "too short", text: strings.Repeat("あ", minSplitChars-1)},
+		{
+			name:       "paragraph boundary nearest the middle",
+			text:       para + DefaultSeparator + para + DefaultSeparator + para + DefaultSeparator + para,
+			wantOK:     true,
+			wantFirst:  para + DefaultSeparator + para,
+			wantSecond: para + DefaultSeparator + para,
+		},
+		{
+			name:       "line boundary without paragraphs",
+			text:       strings.Repeat("い", 300) + "\n" + strings.Repeat("う", 200),
+			wantOK:     true,
+			wantFirst:  strings.Repeat("い", 300),
+			wantSecond: strings.Repeat("う", 200),
+		},
+		{
+			name:       "rune middle without separators",
+			text:       strings.Repeat("え", 500),
+			wantOK:     true,
+			wantFirst:  strings.Repeat("え", 250),
+			wantSecond: strings.Repeat("え", 250),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second, ok := splitSegmentText(tt.text)
+			if ok != tt.wantOK {
+				t.Fatalf("splitSegmentText() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if first != tt.wantFirst || second != tt.wantSecond {
+				t.Errorf("splitSegmentText() = (%d runes, %d runes), want (%d runes, %d runes)",
+					len([]rune(first)), len([]rune(second)), len([]rune(tt.wantFirst)), len([]rune(tt.wantSecond)))
+			}
+		})
+	}
+}
+
+func TestOmittedNotice(t *testing.T) {
+	segments := []SegmentReport{
+		{Index: 1, URL: "https://example.com/ok"},
+		{Index: 2, URL: "https://example.com/blocked", Skipped: true, Recovery: ResponseActionSkip, FinishReason: "SAFETY"},
+		{Index: 3, URL: "https://example.com/split", Recovery: ResponseActionSplit, SkippedParts: 1},
+		{Index: 4, URL: "https://example.com/irrelevant", Irrelevant: true},
+	}
+	got := omittedNotice(segments, "ja")
+	for _, want := range []string{"<https://example.com/blocked> (セグメント 2, 終了理由: SAFETY)", "<https://example.com/split> (セグメント 3, 一部)"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("omittedNotice() does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "/ok") || strings.Contains(got, "/irrelevant") {
+		t.Errorf("omittedNotice() lists segments that were not omitted:\n%s", got)
+	}
+	if got := omittedNotice(segments[:1], "en"); got != "" {
+		t.Errorf("omittedNotice() = %q, want empty", got)
+	}
+}
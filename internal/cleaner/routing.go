@@ -0,0 +1,231 @@
+package cleaner
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ルーティングルールの対象フェーズ
+const (
+	// RoutePhaseMap は、セグメント単位で Map のモデル選択または Map のスキップを行うルールです。
+	RoutePhaseMap = "map"
+	// RoutePhaseReduce は、Reduce 入力全体の長さに応じて Reduce のモデルを選択するルールです。
+	RoutePhaseReduce = "reduce"
+)
+
+// RoutingRule は、条件に一致したセグメント (または Reduce 入力) に適用するモデル選択のルールです。
+// ルールは定義順に評価され、最初に一致したルールのみが適用されます。
+type RoutingRule struct {
+	// Name はルールの識別名です (実行レポートとログに記録されます)。
+	Name string
+	// Phase は対象フェーズ (map, reduce) です。空の場合は map です。
+	Phase string
+	// Domains は対象とするソースのドメインです (サブドメインを含めて一致)。空の場合はすべてのドメインに一致します。
+	// Reduce のルールでは使用できません。
+	Domains []string
+	// MinChars と MaxChars は、対象テキスト (Map: セグメント, Reduce: 結合済み中間要約) の文字数の範囲です。
+	// 0 の場合は制限しません。
+	MinChars int
+	MaxChars int
+	// Model は、一致した場合に使用するモデル名です。カンマ区切りでフォールバックのリストを指定できます。
+	Model string
+	// SkipMap が true の場合、一致したセグメントは Map を実行せず、本文をそのまま Reduce の入力に含めます。
+	// 1つのセグメントに収まるソース (小さな文書) にのみ適用されます。
+	SkipMap bool
+}
+
+// ParseRoutingRule は、"key=value,key=value" 形式のルール指定を解析します。
+// 使用できるキーは name, phase, domains (| 区切り), min_chars, max_chars, model (| 区切りでフォールバック), skip_map です。
+// 例: "name=docs,domains=docs.example.com|example.org,model=gemini-2.5-pro"
+func ParseRoutingRule(spec string) (RoutingRule, error) {
+	var rule RoutingRule
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return RoutingRule{}, fmt.Errorf("ルーティングルールの形式が不正です (key=value 形式で指定してください): %q", field)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		var err error
+		switch key {
+		case "name":
+			rule.Name = value
+		case "phase":
+			rule.Phase = value
+		case "domains":
+			for _, d := range strings.Split(value, "|") {
+				if d = strings.TrimSpace(d); d != "" {
+					rule.Domains = append(rule.Domains, d)
+				}
+			}
+		case "min_chars":
+			rule.MinChars, err = strconv.Atoi(value)
+		case "max_chars":
+			rule.MaxChars, err = strconv.Atoi(value)
+		case "model":
+			// フォールバックのリストは、ルール指定の区切り (カンマ) と区別するため | で区切る
+			rule.Model = strings.ReplaceAll(value, "|", ",")
+		case "skip_map":
+			rule.SkipMap, err = strconv.ParseBool(value)
+		default:
+			return RoutingRule{}, fmt.Errorf("ルーティングルールに未知のキーがあります: %q", key)
+		}
+		if err != nil {
+			return RoutingRule{}, fmt.Errorf("ルーティングルールの %s の値が不正です: %q", key, value)
+		}
+	}
+
+	if err := rule.Validate(); err != nil {
+		return RoutingRule{}, fmt.Errorf("ルーティングルール %q: %w", spec, err)
+	}
+	return rule, nil
+}
+
+// Validate は、ルールの設定が一貫しているかを検証します。
+func (r RoutingRule) Validate() error {
+	switch r.Phase {
+	case "", RoutePhaseMap:
+	case RoutePhaseReduce:
+		if len(r.Domains) > 0 {
+			return fmt.Errorf("reduce のルールには domains を指定できません")
+		}
+		if r.SkipMap {
+			return fmt.Errorf("reduce のルールには skip_map を指定できません")
+		}
+	default:
+		return fmt.Errorf("未対応のフェーズです: %q (map, reduce のいずれかを指定してください)", r.Phase)
+	}
+	if r.MinChars < 0 || r.MaxChars < 0 || (r.MaxChars > 0 && r.MinChars > r.MaxChars) {
+		return fmt.Errorf("文字数の範囲が不正です (min_chars=%d, max_chars=%d)", r.MinChars, r.MaxChars)
+	}
+	if r.Model == "" && !r.SkipMap {
+		return fmt.Errorf("model または skip_map=true のいずれかを指定してください")
+	}
+	if r.Model != "" && r.SkipMap {
+		return fmt.Errorf("model と skip_map=true は同時に指定できません")
+	}
+	if r.Model != "" {
+		if err := ValidateModelChain(r.Model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// label は、ログと実行レポートに記録するルールの識別名を返します。
+func (r RoutingRule) label(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("rule#%d", index+1)
+}
+
+// matchesLength は、文字数が範囲内にあるかを判定します。
+func (r RoutingRule) matchesLength(chars int) bool {
+	if r.MinChars > 0 && chars < r.MinChars {
+		return false
+	}
+	if r.MaxChars > 0 && chars > r.MaxChars {
+		return false
+	}
+	return true
+}
+
+// matchesDomain は、URLのホストがルールのドメインに一致するかを判定します。
+func (r RoutingRule) matchesDomain(rawURL string) bool {
+	if len(r.Domains) == 0 {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, d := range r.Domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeDecision は、ルーティングの結果です。
+type routeDecision struct {
+	Rule    string
+	Model   string
+	SkipMap bool
+}
+
+// routeSegment は、セグメントに最初に一致した map ルールを返します。
+// singleSegment は、セグメントのソースが1つのセグメントに収まっているかどうかで、偽の場合 skip_map のルールは一致しません。
+func routeSegment(rules []RoutingRule, s Segment, singleSegment bool) (routeDecision, bool) {
+	chars := len([]rune(s.Text))
+	for i, r := range rules {
+		if r.Phase == RoutePhaseReduce {
+			continue
+		}
+		if r.SkipMap && !singleSegment {
+			continue
+		}
+		if r.matchesDomain(s.URL) && r.matchesLength(chars) {
+			return routeDecision{Rule: r.label(i), Model: r.Model, SkipMap: r.SkipMap}, true
+		}
+	}
+	return routeDecision{}, false
+}
+
+// routeReduce は、Reduce 入力に最初に一致した reduce ルールを返します。
+func routeReduce(rules []RoutingRule, combinedText string) (routeDecision, bool) {
+	chars := len([]rune(combinedText))
+	for i, r := range rules {
+		if r.Phase != RoutePhaseReduce {
+			continue
+		}
+		if r.matchesLength(chars) {
+			return routeDecision{Rule: r.label(i), Model: r.Model}, true
+		}
+	}
+	return routeDecision{}, false
+}
+
+// directSummary は、Map をスキップしたセグメントの本文を、中間要約と同じ形式に整えます。
+// 脚注モードでは、Reduce が出典を引き継げるよう各段落 (リストは1つのまとまり) の末尾に出典マーカーを1つ付与します。
+// 見出し、コードフェンスの内側、表の行にはマーカーを付与しません。
+func directSummary(s Segment, footnotes bool) string {
+	body := strings.TrimSpace(s.Text)
+	if footnotes {
+		lines := strings.Split(body, "\n")
+		marker := fmt.Sprintf(" [^%d]", s.SourceID)
+		inFence := false
+		last := -1 // 現在の段落の最後の行
+		closeParagraph := func() {
+			if last >= 0 {
+				lines[last] += marker
+				last = -1
+			}
+		}
+		for i, line := range lines {
+			trimmed := strings.TrimSpace(line)
+			switch {
+			case fenceLinePattern.MatchString(line):
+				closeParagraph()
+				inFence = !inFence
+			case inFence:
+			case trimmed == "", strings.HasPrefix(trimmed, "#"), strings.HasPrefix(trimmed, "|"):
+				closeParagraph()
+			default:
+				last = i
+			}
+		}
+		closeParagraph()
+		body = strings.Join(lines, "\n")
+	}
+	return mapOutput{Body: body}.Summary(s.URL)
+}
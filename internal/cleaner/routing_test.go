@@ -0,0 +1,107 @@
+package cleaner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRoutingRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    RoutingRule
+		wantErr string
+	}{
+		{
+			name: "domains and model fallback",
+			spec: "name=docs,domains=docs.example.com|example.org,model=gemini-2.5-pro|gemini-2.5-flash",
+			want: RoutingRule{Name: "docs", Domains: []string{"docs.example.com", "example.org"}, Model: "gemini-2.5-pro,gemini-2.5-flash"},
+		},
+		{
+			name: "skip map for short documents",
+			spec: "max_chars=3000,skip_map=true",
+			want: RoutingRule{MaxChars: 3000, SkipMap: true},
+		},
+		{
+			name: "reduce rule",
+			spec: "phase=reduce,min_chars=100000,model=gemini-2.5-pro",
+			want: RoutingRule{Phase: RoutePhaseReduce, MinChars: 100000, Model: "gemini-2.5-pro"},
+		},
+		{name: "missing value separator", spec: "model", wantErr: "key=value"},
+		{name: "unknown key", spec: "color=red,model=m", wantErr: "未知のキー"},
+		{name: "invalid number", spec: "max_chars=many,model=m", wantErr: "max_chars"},
+		{name: "neither model nor skip_map", spec: "domains=example.com", wantErr: "model または skip_map"},
+		{name: "model with skip_map", spec: "model=m,skip_map=true", wantErr: "同時に指定できません"},
+		{name: "reduce with domains", spec: "phase=reduce,domains=example.com,model=m", wantErr: "domains"},
+		{name: "inverted range", spec: "min_chars=10,max_chars=5,model=m", wantErr: "文字数の範囲"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRoutingRule(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseRoutingRule(%q) error = %v, want containing %q", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRoutingRule(%q): %v", tt.spec, err)
+			}
+			if got.Name != tt.want.Name || got.Phase != tt.want.Phase || got.Model != tt.want.Model ||
+				got.MinChars != tt.want.MinChars || got.MaxChars != tt.want.MaxChars || got.SkipMap != tt.want.SkipMap ||
+				strings.Join(got.Domains, "|") != strings.Join(tt.want.Domains, "|") {
+				t.Errorf("ParseRoutingRule(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteSegment(t *testing.T) {
+	rules := []RoutingRule{
+		{Name: "reduce", Phase: RoutePhaseReduce, Model: "reduce-model"},
+		{Name: "small", MaxChars: 10, SkipMap: true},
+		{Name: "docs", Domains: []string{"example.org"}, Model: "docs-model"},
+		{Name: "long", MinChars: 20, Model: "long-model"},
+	}
+	tests := []struct {
+		name          string
+		url           string
+		text          string
+		singleSegment bool
+		want          routeDecision
+		wantOK        bool
+	}{
+		{name: "short single segment skips map", url: "https://example.com/a", text: "短い本文", singleSegment: true,
+			want: routeDecision{Rule: "small", SkipMap: true}, wantOK: true},
+		{name: "skip_map ignored for split sources", url: "https://example.com/a", text: "短い本文", singleSegment: false},
+		{name: "subdomain matches domain", url: "https://docs.example.org/b", text: strings.Repeat("あ", 15), singleSegment: true,
+			want: routeDecision{Rule: "docs", Model: "docs-model"}, wantOK: true},
+		{name: "lookalike domain does not match", url: "https://notexample.org/b", text: strings.Repeat("あ", 15)},
+		{name: "length counted in runes", url: "https://example.com/c", text: strings.Repeat("あ", 20),
+			want: routeDecision{Rule: "long", Model: "long-model"}, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := routeSegment(rules, Segment{URL: tt.url, Text: tt.text}, tt.singleSegment)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("routeSegment() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	if got, ok := routeReduce(rules, "結合済み中間要約"); !ok || got.Model != "reduce-model" {
+		t.Errorf("routeReduce() = %+v, %v, want the reduce rule", got, ok)
+	}
+}
+
+func TestDirectSummaryFootnotes(t *testing.T) {
+	text := "# 見出し\n\n段落1行目\n段落2行目\n\n- 項目1\n- 項目2\n\n```\nコード\n```\n\n| a | b |\n|---|---|\n| 1 | 2 |"
+	got := directSummary(Segment{URL: "https://example.com/", SourceID: 3, Text: text}, true)
+
+	want := "# 見出し\n\n段落1行目\n段落2行目 [^3]\n\n- 項目1\n- 項目2 [^3]\n\n```\nコード\n```\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n[元記事URL: https://example.com/]"
+	if got != want {
+		t.Errorf("directSummary() =\n%s\nwant\n%s", got, want)
+	}
+}
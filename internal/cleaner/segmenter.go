@@ -1,13 +1,14 @@
 package cleaner
 
 import (
+	"context"
 	"log/slog"
 	"strings"
 )
 
 // segmentText は、結合されたテキストを、安全な最大文字数を超えないように分割します。
-// これは純粋な関数であり、外部の状態に依存しません。
-func segmentText(text string, maxChars int) []string {
+// これは純粋な関数であり、外部の状態に依存しません (ctx はログの出力にのみ使用します)。
+func segmentText(ctx context.Context, text string, maxChars int) []string {
 	var segments []string
 	current := []rune(text)
 
@@ -35,8 +36,7 @@ func segmentText(text string, maxChars int) []string {
 			splitIndex += separatorLen
 		} else {
 			// 安全な区切りが見つからない場合は、そのまま最大文字数で切り、警告を出す
-			// 修正: log.Printf -> slog.Warn (構造化ロギング)
-			slog.Warn("⚠️ 分割点で適切な区切りが見つかりませんでした。強制的に分割します。",
+			slog.WarnContext(ctx, "⚠️ 分割点で適切な区切りが見つかりませんでした。強制的に分割します。",
 				slog.Int("forced_chars", maxChars))
 			splitIndex = maxChars
 		}
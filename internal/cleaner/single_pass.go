@@ -0,0 +1,115 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// 処理モード
+const (
+	// ModeAuto は、全ソースの文字数が単一パスの上限以下の場合に単一パス、それ以外は MapReduce で処理します。
+	// カスタムの Map/Reduce プロンプトやルーティングルールが設定されている場合は、それらを適用するため常に MapReduce で処理します。
+	ModeAuto = "auto"
+	// ModeMapReduce は、常にセグメントごとの Map と Reduce の2段階で処理します。
+	ModeMapReduce = "mapreduce"
+	// ModeSingle は、Map を行わず、全ソースの未加工テキストを1回の呼び出しで最終文書にします。
+	ModeSingle = "single"
+)
+
+// singlePassInputShare は、auto モードの単一パスの上限を Reduce モデルの入力トークン数の上限から算出する際の割合の逆数です。
+// 日本語は概ね1文字1トークン以下のため、上限 (トークン) の 1/4 を文字数の上限とし、プロンプトとテンプレートの余裕を残します。
+const singlePassInputShare = 4
+
+// sourceSeparator は、単一パスの入力でソース同士を区切る行です。
+const sourceSeparator = "\n\n--- SOURCE END ---\n\n"
+
+// ValidateMode は、処理モードの値を検証します。
+func ValidateMode(mode string) error {
+	switch mode {
+	case ModeAuto, ModeMapReduce, ModeSingle:
+		return nil
+	}
+	return fmt.Errorf("未対応の処理モードです: %q (auto, mapreduce, single のいずれかを指定してください)", mode)
+}
+
+// SinglePassLimit は、auto モードで単一パスを選択する全ソースの合計文字数の上限を返します。
+// maxChars が正の場合はその値を、それ以外は Reduce のモデル (フォールバックを含む) の入力トークン数の上限の最小値から算出します。
+func SinglePassLimit(maxChars int, reduceModel string) int {
+	if maxChars > 0 {
+		return maxChars
+	}
+	limit := 0
+	for _, model := range ParseModelChain(reduceModel) {
+		if l := llm.InputTokenLimit(model); limit == 0 || l < limit {
+			limit = l
+		}
+	}
+	if limit == 0 {
+		limit = llm.DefaultInputTokenLimit
+	}
+	return limit / singlePassInputShare
+}
+
+// resolveMode は、設定と全ソースの合計文字数から、実際に使用する処理モードを決定します。
+func (c *Cleaner) resolveMode(ctx context.Context, results []extTypes.URLResult) string {
+	total := 0
+	for _, res := range results {
+		total += len([]rune(res.Content))
+	}
+	limit := SinglePassLimit(c.cfg.SinglePassMaxChars, c.cfg.ReduceModel)
+
+	switch c.cfg.Mode {
+	case ModeMapReduce:
+		return ModeMapReduce
+	case ModeSingle:
+		if total > limit {
+			slog.WarnContext(ctx, "全ソースの文字数が単一パスの上限を超えていますが、single モードが指定されているため単一パスで処理します。",
+				slog.Int("total_chars", total), slog.Int("limit", limit))
+		}
+		return ModeSingle
+	}
+
+	// 単一パスでは Map のプロンプトとルーティングが使われないため、指定されている場合は MapReduce で処理する
+	if c.cfg.CustomPrompts || len(c.cfg.Routing) > 0 {
+		slog.InfoContext(ctx, "カスタムプロンプトまたはルーティングルールが指定されているため、MapReduce で処理します。",
+			slog.Bool("custom_prompts", c.cfg.CustomPrompts), slog.Int("routes", len(c.cfg.Routing)))
+		return ModeMapReduce
+	}
+	if total <= limit {
+		slog.InfoContext(ctx, "全ソースが Reduce の入力に収まるため、Map をスキップして単一パスで処理します。",
+			slog.Int("total_chars", total), slog.Int("limit", limit))
+		return ModeSingle
+	}
+	return ModeMapReduce
+}
+
+// singlePass は、全ソースの未加工テキストを単一パス用のプロンプトで1回だけ LLM に渡し、最終文書を生成します。
+func (c *Cleaner) singlePass(ctx context.Context, results []extTypes.URLResult, sources []Source, common prompts.CommonTemplateData) (*Result, error) {
+	builder := c.builders.SinglePassBuilder
+	if builder == nil {
+		builder = prompts.NewSinglePassPromptBuilder()
+	}
+	if err := builder.Err(); err != nil {
+		return nil, fmt.Errorf("単一パス Prompt Builderの初期化に失敗しました: %w", err)
+	}
+
+	texts := make([]string, 0, len(results))
+	for i, res := range results {
+		texts = append(texts, formatSourceText(sources[i], res.Content))
+	}
+
+	slog.InfoContext(ctx, "単一パスでの最終的な構造化を開始します。", slog.Int("sources", len(results)))
+	return c.reduce(ctx, builder, strings.Join(texts, sourceSeparator), sources, common, Report{Mode: ModeSingle})
+}
+
+// formatSourceText は、単一パスの入力として、出典IDとURLを付与したソースのテキストを返します。
+func formatSourceText(s Source, content string) string {
+	return fmt.Sprintf("[出典ID: %d]\n[元記事URL: %s]\n\n%s", s.ID, s.URL, strings.TrimSpace(content))
+}
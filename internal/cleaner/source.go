@@ -0,0 +1,125 @@
+package cleaner
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// extractedTitlePrefix は、go-web-exact の抽出結果の先頭行に付与されるページタイトルの接頭辞です。
+const extractedTitlePrefix = "【記事タイトル】"
+
+// Source は、取得済みコンテンツ1件分のメタデータです。
+type Source struct {
+	// ID は入力順に振られる1始まりの安定した識別子です。
+	ID    int
+	URL   string
+	Title string
+	// FetchedAt は、このURLのコンテンツを取得した日時です。
+	FetchedAt time.Time
+}
+
+// newSources は、スクレイピング結果から Source のリストを生成します。
+// 取得日時は times に記録された URL ごとの日時を使用し、記録がない場合は fallback を使用します。
+func newSources(results []extTypes.URLResult, times *FetchTimes, fallback time.Time) []Source {
+	sources := make([]Source, 0, len(results))
+	for i, res := range results {
+		fetchedAt, ok := times.At(res.URL)
+		if !ok {
+			fetchedAt = fallback
+		}
+		sources = append(sources, Source{
+			ID:        i + 1,
+			URL:       res.URL,
+			Title:     extractTitle(res.Content),
+			FetchedAt: fetchedAt,
+		})
+	}
+	return sources
+}
+
+// latestFetchedAt は、ソースの取得日時のうち最も新しいものを返します。
+func latestFetchedAt(sources []Source) time.Time {
+	var latest time.Time
+	for _, s := range sources {
+		if s.FetchedAt.After(latest) {
+			latest = s.FetchedAt
+		}
+	}
+	return latest
+}
+
+// extractTitle は、抽出済み本文の先頭にあるページタイトル行からタイトルを取り出します。
+// タイトル行がない場合は空文字を返します。
+func extractTitle(content string) string {
+	firstLine, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if !strings.HasPrefix(firstLine, extractedTitlePrefix) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(firstLine, extractedTitlePrefix))
+}
+
+// sourceURLs は、Source のリストからURLのみを取り出します。
+func sourceURLs(sources []Source) []string {
+	urls := make([]string, 0, len(sources))
+	for _, s := range sources {
+		urls = append(urls, s.URL)
+	}
+	return urls
+}
+
+// ----------------------------------------------------------------
+// URL ごとの取得日時
+// ----------------------------------------------------------------
+
+// fetchTimesKey は、URL ごとの取得日時の記録先をコンテキストに格納するためのキーです。
+type fetchTimesKey struct{}
+
+// FetchTimes は、URL ごとのコンテンツの取得日時を記録します。
+// スクレイピング結果 (extTypes.URLResult) は取得日時を持たないため、コンテンツ取得ステージで
+// 結果を受け取った日時 (カセットの再生時は記録時の日時) を記録し、クリーンアップで参照します。
+type FetchTimes struct {
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+// WithFetchTimes は、URL ごとの取得日時を ft に記録・参照するようコンテキストに設定します。
+func WithFetchTimes(ctx context.Context, ft *FetchTimes) context.Context {
+	return context.WithValue(ctx, fetchTimesKey{}, ft)
+}
+
+// FetchTimesFrom は、コンテキストに設定された取得日時の記録先を返します (未設定の場合は nil)。
+func FetchTimesFrom(ctx context.Context) *FetchTimes {
+	ft, _ := ctx.Value(fetchTimesKey{}).(*FetchTimes)
+	return ft
+}
+
+// Record は、url の取得日時を記録します。既に記録されている場合は上書きしません。
+// ft が nil の場合は何もしません。
+func (ft *FetchTimes) Record(url string, at time.Time) {
+	if ft == nil {
+		return
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.times == nil {
+		ft.times = make(map[string]time.Time)
+	}
+	if _, ok := ft.times[url]; !ok {
+		ft.times[url] = at
+	}
+}
+
+// At は、url の取得日時を返します。記録されていない場合 (ft が nil の場合を含む) は false を返します。
+func (ft *FetchTimes) At(url string) (time.Time, bool) {
+	if ft == nil {
+		return time.Time{}, false
+	}
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	at, ok := ft.times[url]
+	return at, ok
+}
@@ -0,0 +1,22 @@
+package cleaner
+
+import (
+	"context"
+	"io"
+)
+
+// reduceStreamKey は、Reduce 出力の逐次書き込み先をコンテキストに格納するためのキーです。
+type reduceStreamKey struct{}
+
+// WithReduceStream は、Reduceフェーズの応答を生成中に逐次書き出す Writer をコンテキストに設定します。
+// LLMExecutor はこの Writer が設定されている場合、ストリーミング生成を使用します。
+// 書き込まれるのはフォールバックや再試行の区切りを含む生の応答で、構造の検証・引用の検証による補正は反映されません。
+func WithReduceStream(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, reduceStreamKey{}, w)
+}
+
+// reduceStreamFrom は、コンテキストに設定された Reduce 出力の逐次書き込み先を返します (未設定の場合は nil)。
+func reduceStreamFrom(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(reduceStreamKey{}).(io.Writer)
+	return w
+}
@@ -0,0 +1,231 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+)
+
+// minRepairedLengthRatio は、修復後の文書を採用するための元の文書に対する最小の長さの比率です。
+// 修復プロンプトが本文を大幅に欠落させた場合は、修復結果を破棄して機械的な修正のみを行います。
+const minRepairedLengthRatio = 0.5
+
+var (
+	// strayMarkerTokens は、最終文書に残ってはならない処理用のマーカーです。
+	strayMarkerTokens = []string{cleanupStartMarker, cleanupEndMarker, "<FINAL_START>", "<FINAL_END>", prompts.NoRelevantInfoMarker}
+	// strayMarkerLinePattern は、プロンプト由来の区切り行に一致します。
+	strayMarkerLinePattern = regexp.MustCompile(`^\s*--- (INTERMEDIATE SUMMARY END|最終出力|修復後の文書) ---\s*$`)
+	// fenceLinePattern は、コードフェンスの行に一致します。
+	fenceLinePattern = regexp.MustCompile("^\\s*(```|~~~)")
+	// excessBlankLinesPattern は、3行以上連続する空行に一致します。
+	excessBlankLinesPattern = regexp.MustCompile(`\n{3,}`)
+)
+
+// StructureReport は、最終文書の構造検証と正規化の結果です。
+type StructureReport struct {
+	// Normalized は、正規化で機械的に取り除いた問題です (コードフェンス、マーカーなど)。
+	Normalized []string
+	// Violations は、Reduce 出力で検出された構造ルール違反です。
+	Violations []string
+	// Repaired は、違反を修復するために修復プロンプトの結果を採用したかどうかです。
+	Repaired bool
+	// Remaining は、修復と機械的な修正の後も残った違反です。
+	Remaining []string
+	// RepairUsage は、修復プロンプトで消費したトークン数です (修復を実行しなかった場合はゼロ)。
+	RepairUsage llm.Usage
+}
+
+// enforceStructure は、Reduce 出力を正規化し、構造ルール (H1 が1つ、見出しレベルの飛びなし) を検証します。
+// 違反がある場合は修復プロンプトを1回実行し、なお残る見出しの問題は機械的に修正します。
+// model は修復に使用するモデルで、Reduce の応答を生成したモデルを渡します。
+func (c *Cleaner) enforceStructure(ctx context.Context, model string, text string, common prompts.CommonTemplateData) (string, StructureReport) {
+	var report StructureReport
+
+	markdown, fixes := normalizeMarkdown(text)
+	report.Normalized = fixes
+	report.Violations = lintMarkdown(markdown)
+	violations := report.Violations
+
+	if len(violations) > 0 && c.builders.RepairBuilder != nil {
+		slog.WarnContext(ctx, "最終文書が構造ルールに違反しています。修復プロンプトを実行します。", slog.Any("violations", violations))
+
+		repaired, err := c.executor.ExecuteRepair(ctx, model, markdown, violations, c.builders.RepairBuilder, common)
+		if err != nil {
+			slog.WarnContext(ctx, "最終文書の構造修復に失敗しました。機械的な修正のみを行います。", slog.String("error", err.Error()))
+		} else {
+			report.RepairUsage = repaired.Usage
+			repairedMarkdown, repairedFixes := normalizeMarkdown(repaired.Text)
+			if float64(len(repairedMarkdown)) < float64(len(markdown))*minRepairedLengthRatio {
+				slog.WarnContext(ctx, "修復後の文書が元の文書より大幅に短いため、修復結果を破棄します。",
+					slog.Int("original_len", len(markdown)), slog.Int("repaired_len", len(repairedMarkdown)))
+			} else {
+				markdown = repairedMarkdown
+				report.Normalized = append(report.Normalized, repairedFixes...)
+				report.Repaired = true
+				violations = lintMarkdown(markdown)
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		markdown = fixHeadingLevels(markdown)
+		report.Remaining = lintMarkdown(markdown)
+		if len(report.Remaining) > 0 {
+			slog.WarnContext(ctx, "最終文書の構造ルール違反を解消できませんでした。", slog.Any("violations", report.Remaining))
+		}
+	}
+	return markdown, report
+}
+
+// normalizeMarkdown は、最終文書から文書全体を囲むコードフェンス、対応の取れないフェンス行、
+// 処理用のマーカーや区切り行を取り除き、連続する空行をまとめます。取り除いた問題の説明を返します。
+func normalizeMarkdown(text string) (string, []string) {
+	var fixes []string
+	markdown := strings.TrimSpace(text)
+
+	if unfenced, ok := stripCodeFence(markdown); ok {
+		fixes = append(fixes, "文書全体を囲むコードフェンスを除去しました")
+		markdown = strings.TrimSpace(unfenced)
+	}
+
+	for _, token := range strayMarkerTokens {
+		if strings.Contains(markdown, token) {
+			fixes = append(fixes, fmt.Sprintf("マーカー %s を除去しました", token))
+			markdown = strings.ReplaceAll(markdown, token, "")
+		}
+	}
+
+	lines := strings.Split(markdown, "\n")
+	kept := make([]string, 0, len(lines))
+	var fenceLines []int
+	for _, line := range lines {
+		if strayMarkerLinePattern.MatchString(line) {
+			fixes = append(fixes, fmt.Sprintf("区切り行 %q を除去しました", strings.TrimSpace(line)))
+			continue
+		}
+		if sourceURLLinePattern.MatchString(line) {
+			line = sourceURLLinePattern.ReplaceAllString(line, "")
+			fixes = append(fixes, "[元記事URL: ...] 行を除去しました")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+		}
+		if fenceLinePattern.MatchString(line) {
+			fenceLines = append(fenceLines, len(kept))
+		}
+		kept = append(kept, line)
+	}
+
+	// 対応の取れないコードフェンスは、以降の文書全体をコードブロックにしてしまうため取り除く
+	if len(fenceLines)%2 == 1 {
+		stray := fenceLines[len(fenceLines)-1]
+		if firstContentLine(kept) == fenceLines[0] {
+			stray = fenceLines[0]
+		}
+		fixes = append(fixes, "対応の取れないコードフェンスを除去しました")
+		kept = append(kept[:stray], kept[stray+1:]...)
+	}
+
+	markdown = excessBlankLinesPattern.ReplaceAllString(strings.Join(kept, "\n"), "\n\n")
+	return strings.TrimSpace(markdown), fixes
+}
+
+// firstContentLine は、最初の空行でない行の位置を返します。すべて空行の場合は -1 を返します。
+func firstContentLine(lines []string) int {
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// heading は、コードブロック外の見出し1件です。
+type heading struct {
+	Line  int
+	Level int
+	Text  string
+}
+
+// scanHeadings は、コードブロック外の見出しを出現順に返します。
+func scanHeadings(lines []string) []heading {
+	var headings []heading
+	inFence := false
+	for i, line := range lines {
+		if fenceLinePattern.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+		if m := headingPattern.FindStringSubmatch(line); m != nil {
+			headings = append(headings, heading{Line: i, Level: len(m[1]), Text: strings.TrimSpace(m[2])})
+		}
+	}
+	return headings
+}
+
+// lintMarkdown は、最終文書の構造ルール違反を検出します。
+// ルール: 文書は最上位見出し (#) で始まり、H1 はちょうど1つで、見出しレベルを飛ばさないこと。
+func lintMarkdown(markdown string) []string {
+	var violations []string
+	lines := strings.Split(markdown, "\n")
+	headings := scanHeadings(lines)
+
+	h1Count := 0
+	for _, h := range headings {
+		if h.Level == 1 {
+			h1Count++
+		}
+	}
+	switch {
+	case h1Count == 0:
+		violations = append(violations, "最上位見出し (#) がありません")
+	case h1Count > 1:
+		violations = append(violations, fmt.Sprintf("最上位見出し (#) が %d 個あります (1つのみ許可されます)", h1Count))
+	}
+
+	if first := firstContentLine(lines); first != -1 && (len(headings) == 0 || headings[0].Line != first || headings[0].Level != 1) {
+		violations = append(violations, "文書が最上位見出し (#) で始まっていません")
+	}
+
+	prev := 0
+	for _, h := range headings {
+		if prev > 0 && h.Level > prev+1 {
+			violations = append(violations, fmt.Sprintf("見出しレベルが飛んでいます: %q (H%d の後に H%d)", h.Text, prev, h.Level))
+		}
+		prev = h.Level
+	}
+	return violations
+}
+
+// fixHeadingLevels は、見出しの問題を機械的に修正します。
+// 2つ目以降の H1 は H2 に下げ、レベルの飛んだ見出しは直前の見出しの1つ下のレベルに揃えます。
+func fixHeadingLevels(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	seenH1 := false
+	prev := 0
+	for _, h := range scanHeadings(lines) {
+		level := h.Level
+		if level == 1 {
+			if seenH1 {
+				level = 2
+			}
+			seenH1 = true
+		}
+		if prev > 0 && level > prev+1 {
+			level = prev + 1
+		}
+		prev = level
+		if level != h.Level {
+			lines[h.Line] = strings.Repeat("#", level) + " " + h.Text
+		}
+	}
+	return strings.Join(lines, "\n")
+}
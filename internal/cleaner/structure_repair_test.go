@@ -0,0 +1,78 @@
+package cleaner_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/fakes"
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// TestEnforceStructureRepair は、構造ルールに違反した最終文書の修復結果の採用と、
+// 大幅に短い修復結果 (minRepairedLengthRatio 未満) の破棄を検証します。
+func TestEnforceStructureRepair(t *testing.T) {
+	body := strings.Repeat("ゴルーチンとチャネルで並行処理を記述します。", 20)
+	tests := []struct {
+		name         string
+		repaired     string
+		wantRepaired bool
+	}{
+		{
+			name:         "repaired document adopted",
+			repaired:     "```markdown\n# Go の並行処理\n\n## 概要\n\n" + body + "\n```",
+			wantRepaired: true,
+		},
+		{
+			name:     "too short repair discarded and fixed mechanically",
+			repaired: "# Go の並行処理\n\n## 概要",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := fakes.NewModel()
+			model.Respond = func(call fakes.Call) (*llm.Response, error) {
+				switch call.Phase {
+				case fakes.PhaseSinglePass:
+					return &llm.Response{Text: "# Go の並行処理\n\n# 概要\n\n" + body, FinishReason: "STOP"}, nil
+				case fakes.PhaseRepair:
+					return &llm.Response{Text: tt.repaired, FinishReason: "STOP"}, nil
+				}
+				t.Errorf("unexpected %q call", call.Phase)
+				return nil, nil
+			}
+			executor, err := cleaner.NewLLMConcurrentExecutor(context.Background(), cleaner.LLMExecutorConfig{Client: model, MapModel: "map-model", ReduceModel: "reduce-model"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := cleaner.NewCleaner(cleaner.PromptBuilders{RepairBuilder: prompts.NewRepairPromptBuilder()}, executor, cleaner.CleanerConfig{
+				Language:       prompts.DefaultLanguage,
+				Mode:           cleaner.ModeSingle,
+				CitationPolicy: cleaner.CitationPolicyOff,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := c.CleanAndStructureText(context.Background(), []extTypes.URLResult{{URL: "https://example.com/a", Content: body}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := result.Report.Structure
+			if report.Repaired != tt.wantRepaired || len(report.Violations) == 0 || len(report.Remaining) != 0 {
+				t.Errorf("structure report = %+v, want Repaired %v with violations and none remaining", report, tt.wantRepaired)
+			}
+			if !strings.HasPrefix(result.Markdown, "# Go の並行処理\n\n## 概要\n\n"+body) {
+				t.Errorf("markdown = %q, want the second H1 demoted and the body kept", result.Markdown)
+			}
+			if calls := model.Calls(); len(calls) != 2 || calls[1].Phase != fakes.PhaseRepair || calls[1].Model != "reduce-model" {
+				t.Errorf("calls = %+v, want one repair call with the reduce model", calls)
+			}
+		})
+	}
+}
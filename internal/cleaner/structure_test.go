@@ -0,0 +1,111 @@
+package cleaner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeMarkdown(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		want      string
+		wantFixes []string
+	}{
+		{name: "already normalized", text: "# T\n\n本文", want: "# T\n\n本文"},
+		{
+			name:      "whole document fenced",
+			text:      "```markdown\n# T\n\n本文\n```",
+			want:      "# T\n\n本文",
+			wantFixes: []string{"文書全体を囲むコードフェンス"},
+		},
+		{
+			name:      "unmatched fence at the start",
+			text:      "```\n# T\n\n本文",
+			want:      "# T\n\n本文",
+			wantFixes: []string{"対応の取れないコードフェンス"},
+		},
+		{
+			name:      "unmatched fence at the end",
+			text:      "# T\n\n```go\nfmt.Println()\n```\n\n本文\n~~~",
+			want:      "# T\n\n```go\nfmt.Println()\n```\n\n本文",
+			wantFixes: []string{"対応の取れないコードフェンス"},
+		},
+		{
+			name:      "stray markers and separator lines",
+			text:      "<FINAL_START>\n# T\n\n本文<CLEANUP_END>\n[元記事URL: https://example.com/a]\n--- 最終出力 ---\n\n\n\n続き\n<FINAL_END>",
+			want:      "# T\n\n本文\n\n続き",
+			wantFixes: []string{"マーカー <FINAL_START>", "マーカー <CLEANUP_END>", "[元記事URL: ...] 行", "区切り行 \"--- 最終出力 ---\""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, fixes := normalizeMarkdown(tt.text)
+			if got != tt.want {
+				t.Errorf("normalizeMarkdown(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			joined := strings.Join(fixes, "\n")
+			for _, want := range tt.wantFixes {
+				if !strings.Contains(joined, want) {
+					t.Errorf("fixes = %q, want containing %q", fixes, want)
+				}
+			}
+			if len(tt.wantFixes) == 0 && len(fixes) != 0 {
+				t.Errorf("fixes = %q, want none", fixes)
+			}
+		})
+	}
+}
+
+func TestLintAndFixHeadingLevels(t *testing.T) {
+	tests := []struct {
+		name           string
+		markdown       string
+		wantViolations []string
+		wantFixed      string
+	}{
+		{name: "valid structure", markdown: "# T\n\n## A\n\n### B\n\n## C", wantFixed: "# T\n\n## A\n\n### B\n\n## C"},
+		{
+			name:           "multiple H1s demoted to H2",
+			markdown:       "# T\n\n# A\n\n## B",
+			wantViolations: []string{"最上位見出し (#) が 2 個あります (1つのみ許可されます)"},
+			wantFixed:      "# T\n\n## A\n\n## B",
+		},
+		{
+			name:           "skipped levels",
+			markdown:       "# T\n\n### A\n\n#### B",
+			wantViolations: []string{"見出しレベルが飛んでいます: \"A\" (H1 の後に H3)"},
+			wantFixed:      "# T\n\n## A\n\n### B",
+		},
+		{
+			name:           "document not starting with H1",
+			markdown:       "前置き\n\n# T\n\n## A",
+			wantViolations: []string{"文書が最上位見出し (#) で始まっていません"},
+			wantFixed:      "前置き\n\n# T\n\n## A",
+		},
+		{
+			name:           "no H1",
+			markdown:       "## A",
+			wantViolations: []string{"最上位見出し (#) がありません", "文書が最上位見出し (#) で始まっていません"},
+			wantFixed:      "## A",
+		},
+		{
+			name:      "headings inside code fences ignored",
+			markdown:  "# T\n\n```sh\n# comment\n### x\n```\n\n~~~\n# other\n~~~\n\n## A",
+			wantFixed: "# T\n\n```sh\n# comment\n### x\n```\n\n~~~\n# other\n~~~\n\n## A",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := lintMarkdown(tt.markdown)
+			if strings.Join(violations, "\n") != strings.Join(tt.wantViolations, "\n") {
+				t.Errorf("lintMarkdown(%q) = %q, want %q", tt.markdown, violations, tt.wantViolations)
+			}
+			if got := fixHeadingLevels(tt.markdown); got != tt.wantFixed {
+				t.Errorf("fixHeadingLevels(%q) = %q, want %q", tt.markdown, got, tt.wantFixed)
+			}
+		})
+	}
+}
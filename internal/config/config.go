@@ -0,0 +1,361 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix は、フラグを上書きする環境変数の接頭辞です。
+// 例: --map-model は APG_MAP_MODEL で上書きできます。
+const EnvPrefix = "APG_"
+
+// ----------------------------------------------------------------
+// 設定ファイル構造体
+// ----------------------------------------------------------------
+
+// Settings は run コマンドの設定値です。各フィールドは同名 (ハイフン区切り) の CLI フラグに対応します。
+// ゼロ値のフィールドは「未設定」として扱われ、フラグのデフォルト値が維持されます。
+type Settings struct {
+	APIKey             string            `yaml:"api_key" toml:"api_key"`
+	URLFile            string            `yaml:"url_file" toml:"url_file"`
+	Output             string            `yaml:"output" toml:"output"`
+	Format             string            `yaml:"format" toml:"format"`
+	LLMTimeout         string            `yaml:"llm_timeout" toml:"llm_timeout"`
+	ScraperTimeout     string            `yaml:"scraper_timeout" toml:"scraper_timeout"`
+	Parallel           int               `yaml:"parallel" toml:"parallel"`
+	MapModel           string            `yaml:"map_model" toml:"map_model"`
+	ReduceModel        string            `yaml:"reduce_model" toml:"reduce_model"`
+	MapConcurrency     int               `yaml:"map_concurrency" toml:"map_concurrency"`
+	MapPrompt          string            `yaml:"map_prompt" toml:"map_prompt"`
+	ReducePrompt       string            `yaml:"reduce_prompt" toml:"reduce_prompt"`
+	Lang               string            `yaml:"lang" toml:"lang"`
+	Topic              string            `yaml:"topic" toml:"topic"`
+	Query              string            `yaml:"query" toml:"query"`
+	CitationPolicy     string            `yaml:"citation_policy" toml:"citation_policy"`
+	CitationStyle      string            `yaml:"citation_style" toml:"citation_style"`
+	Vars               map[string]string `yaml:"vars" toml:"vars"`
+	Mode               string            `yaml:"mode" toml:"mode"`
+	SinglePassMaxChars int               `yaml:"single_pass_max_chars" toml:"single_pass_max_chars"`
+	Routing            []RouteRule       `yaml:"routing" toml:"routing"`
+	ResponsePolicy     string            `yaml:"response_policy" toml:"response_policy"`
+}
+
+// RouteRule は、モデルのルーティングルール1件の宣言です。--route フラグの1つの値に対応します。
+type RouteRule struct {
+	Name     string   `yaml:"name" toml:"name"`
+	Phase    string   `yaml:"phase" toml:"phase"`
+	Domains  []string `yaml:"domains" toml:"domains"`
+	MinChars int      `yaml:"min_chars" toml:"min_chars"`
+	MaxChars int      `yaml:"max_chars" toml:"max_chars"`
+	Model    string   `yaml:"model" toml:"model"`
+	SkipMap  bool     `yaml:"skip_map" toml:"skip_map"`
+}
+
+// Spec は、ルールを --route フラグの "key=value,..." 形式に変換します。
+func (r RouteRule) Spec() string {
+	var fields []string
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, key+"="+value)
+		}
+	}
+	add("name", r.Name)
+	add("phase", r.Phase)
+	add("domains", strings.Join(r.Domains, "|"))
+	if r.MinChars != 0 {
+		add("min_chars", strconv.Itoa(r.MinChars))
+	}
+	if r.MaxChars != 0 {
+		add("max_chars", strconv.Itoa(r.MaxChars))
+	}
+	add("model", strings.ReplaceAll(r.Model, ",", "|"))
+	if r.SkipMap {
+		add("skip_map", "true")
+	}
+	return strings.Join(fields, ",")
+}
+
+// File は設定ファイル (YAML/TOML) 全体の構造です。
+type File struct {
+	// Profile は、--profile が指定されなかった場合に使用するプロファイル名です。
+	Profile string `yaml:"profile" toml:"profile"`
+	// Run は run コマンドの基本設定です。
+	Run Settings `yaml:"run" toml:"run"`
+	// Profiles は名前付きプロファイルです。組み込みプロファイルと同名の場合は項目単位で上書きします。
+	Profiles map[string]Settings `yaml:"profiles" toml:"profiles"`
+}
+
+// BuiltinProfiles は設定ファイルなしで利用できる組み込みプロファイルです。
+var BuiltinProfiles = map[string]Settings{
+	// fast: 速度とコストを優先し、Map/Reduce ともに軽量モデルを使用
+	"fast": {
+		MapModel:       "gemini-2.5-flash",
+		ReduceModel:    "gemini-2.5-flash",
+		MapConcurrency: 3,
+	},
+	// quality: 品質を優先し、Map/Reduce ともに高性能モデルを使用 (失敗時は軽量モデルにフォールバック)
+	"quality": {
+		MapModel:       "gemini-2.5-pro,gemini-2.5-flash",
+		ReduceModel:    "gemini-2.5-pro,gemini-2.5-flash",
+		MapConcurrency: 1,
+	},
+}
+
+// ----------------------------------------------------------------
+// 読み込み
+// ----------------------------------------------------------------
+
+// Load は設定ファイルを読み込みます。拡張子 (.yaml/.yml/.toml) で形式を判定します。
+// 未知のキーはタイプミスとみなしてエラーにします。
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("設定ファイルの読み込みに失敗しました: %w", err)
+	}
+
+	var f File
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil {
+			return nil, fmt.Errorf("YAML設定ファイル '%s' のパースに失敗しました: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), &f)
+		if err != nil {
+			return nil, fmt.Errorf("TOML設定ファイル '%s' のパースに失敗しました: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("TOML設定ファイル '%s' に未知のキーが含まれています: %v", path, undecoded)
+		}
+	default:
+		return nil, fmt.Errorf("未対応の設定ファイル形式です: %s (.yaml, .yml, .toml のいずれかを使用してください)", path)
+	}
+	return &f, nil
+}
+
+// ----------------------------------------------------------------
+// フラグへの適用
+// ----------------------------------------------------------------
+
+// Resolve は、基本設定にプロファイルを重ねた最終的な設定値を返します。
+// profile が空の場合は File.Profile を使用し、それも空ならプロファイルは適用しません。
+func Resolve(f *File, profile string) (Settings, error) {
+	var base Settings
+	if f != nil {
+		base = f.Run
+		if profile == "" {
+			profile = f.Profile
+		}
+	}
+	if profile == "" {
+		return base, nil
+	}
+
+	builtin, hasBuiltin := BuiltinProfiles[profile]
+	var custom Settings
+	hasCustom := false
+	if f != nil {
+		custom, hasCustom = f.Profiles[profile]
+	}
+	if !hasBuiltin && !hasCustom {
+		return Settings{}, fmt.Errorf("プロファイル '%s' が見つかりません (利用可能: %s)", profile, strings.Join(profileNames(f), ", "))
+	}
+
+	return merge(merge(base, builtin), custom), nil
+}
+
+// Apply は、コマンドラインで明示されていないフラグに対し、環境変数 (APG_*) と設定値を適用します。
+// fs に含まれるフラグのみが対象です (ヘルプフラグは除きます)。優先順位は「フラグ > 環境変数 > プロファイル > 設定ファイルの基本設定 > フラグのデフォルト値」です。
+func Apply(fs *pflag.FlagSet, s Settings, getenv func(string) string) error {
+	values := s.flagValues()
+
+	var applyErr error
+	fs.VisitAll(func(fl *pflag.Flag) {
+		if applyErr != nil || fl.Changed || fl.Name == "help" {
+			return
+		}
+
+		source := "環境変数 " + EnvName(fl.Name)
+		var candidates []string
+		if env := getenv(EnvName(fl.Name)); env != "" {
+			candidates = []string{env}
+		} else {
+			candidates = values[fl.Name]
+			source = "設定ファイル"
+		}
+
+		// 配列型のフラグ (--var など) は、値ごとに Set を呼び出して追加する
+		for _, value := range candidates {
+			if value == "" {
+				continue
+			}
+			if err := fs.Set(fl.Name, value); err != nil {
+				applyErr = fmt.Errorf("%s の値 '%s' を --%s に適用できません: %w", source, value, fl.Name, err)
+				return
+			}
+		}
+	})
+	return applyErr
+}
+
+// EnvName は、フラグ名に対応する環境変数名を返します (例: map-model -> APG_MAP_MODEL)。
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// flagValues は、設定済み (非ゼロ) の項目をフラグ名と文字列値のマップに変換します。
+// 配列型のフラグに対応するため、値はスライスで保持します。
+func (s Settings) flagValues() map[string][]string {
+	values := map[string][]string{
+		"api-key":         {s.APIKey},
+		"url-file":        {s.URLFile},
+		"output":          {s.Output},
+		"format":          {s.Format},
+		"llm-timeout":     {s.LLMTimeout},
+		"scraper-timeout": {s.ScraperTimeout},
+		"map-model":       {s.MapModel},
+		"reduce-model":    {s.ReduceModel},
+		"map-prompt":      {s.MapPrompt},
+		"reduce-prompt":   {s.ReducePrompt},
+		"lang":            {s.Lang},
+		"topic":           {s.Topic},
+		"query":           {s.Query},
+		"citation-policy": {s.CitationPolicy},
+		"citation-style":  {s.CitationStyle},
+		"mode":            {s.Mode},
+		"response-policy": {s.ResponsePolicy},
+	}
+	if s.Parallel != 0 {
+		values["parallel"] = []string{strconv.Itoa(s.Parallel)}
+	}
+	if s.MapConcurrency != 0 {
+		values["map-concurrency"] = []string{strconv.Itoa(s.MapConcurrency)}
+	}
+	if s.SinglePassMaxChars != 0 {
+		values["single-pass-max-chars"] = []string{strconv.Itoa(s.SinglePassMaxChars)}
+	}
+	if len(s.Vars) > 0 {
+		keys := make([]string, 0, len(s.Vars))
+		for k := range s.Vars {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			values["var"] = append(values["var"], k+"="+s.Vars[k])
+		}
+	}
+	for _, r := range s.Routing {
+		values["route"] = append(values["route"], r.Spec())
+	}
+	return values
+}
+
+// merge は、override の非ゼロ項目で base を上書きした Settings を返します。
+func merge(base, override Settings) Settings {
+	if override.APIKey != "" {
+		base.APIKey = override.APIKey
+	}
+	if override.URLFile != "" {
+		base.URLFile = override.URLFile
+	}
+	if override.Output != "" {
+		base.Output = override.Output
+	}
+	if override.Format != "" {
+		base.Format = override.Format
+	}
+	if override.LLMTimeout != "" {
+		base.LLMTimeout = override.LLMTimeout
+	}
+	if override.ScraperTimeout != "" {
+		base.ScraperTimeout = override.ScraperTimeout
+	}
+	if override.Parallel != 0 {
+		base.Parallel = override.Parallel
+	}
+	if override.MapModel != "" {
+		base.MapModel = override.MapModel
+	}
+	if override.ReduceModel != "" {
+		base.ReduceModel = override.ReduceModel
+	}
+	if override.MapConcurrency != 0 {
+		base.MapConcurrency = override.MapConcurrency
+	}
+	if override.MapPrompt != "" {
+		base.MapPrompt = override.MapPrompt
+	}
+	if override.ReducePrompt != "" {
+		base.ReducePrompt = override.ReducePrompt
+	}
+	if override.Lang != "" {
+		base.Lang = override.Lang
+	}
+	if override.Topic != "" {
+		base.Topic = override.Topic
+	}
+	if override.Query != "" {
+		base.Query = override.Query
+	}
+	if override.CitationPolicy != "" {
+		base.CitationPolicy = override.CitationPolicy
+	}
+	if override.CitationStyle != "" {
+		base.CitationStyle = override.CitationStyle
+	}
+	if len(override.Vars) > 0 {
+		// ユーザー変数はキー単位でマージする
+		vars := make(map[string]string, len(base.Vars)+len(override.Vars))
+		for k, v := range base.Vars {
+			vars[k] = v
+		}
+		for k, v := range override.Vars {
+			vars[k] = v
+		}
+		base.Vars = vars
+	}
+	if override.Mode != "" {
+		base.Mode = override.Mode
+	}
+	if override.SinglePassMaxChars != 0 {
+		base.SinglePassMaxChars = override.SinglePassMaxChars
+	}
+	if override.ResponsePolicy != "" {
+		base.ResponsePolicy = override.ResponsePolicy
+	}
+	if len(override.Routing) > 0 {
+		// ルーティングルールは評価順に意味があるため、リスト全体を置き換える
+		base.Routing = override.Routing
+	}
+	return base
+}
+
+// profileNames は、組み込みと設定ファイルのプロファイル名をソートして返します。
+func profileNames(f *File) []string {
+	seen := make(map[string]struct{})
+	for name := range BuiltinProfiles {
+		seen[name] = struct{}{}
+	}
+	if f != nil {
+		for name := range f.Profiles {
+			seen[name] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
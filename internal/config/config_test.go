@@ -0,0 +1,260 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const testConfigYAML = `
+profile: nightly
+run:
+  map_model: run-map
+  reduce_model: run-reduce
+  topic: run-topic
+  map_concurrency: 2
+  vars:
+    audience: run
+    tone: formal
+profiles:
+  nightly:
+    map_model: nightly-map
+    vars:
+      audience: nightly
+  fast:
+    reduce_model: custom-fast-reduce
+`
+
+// newTestFlagSet は、run コマンドの一部のフラグを持つ FlagSet を作成します。
+func newTestFlagSet() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("run", pflag.ContinueOnError)
+	fs.String("map-model", "default-map", "")
+	fs.String("reduce-model", "default-reduce", "")
+	fs.String("topic", "", "")
+	fs.Int("map-concurrency", 1, "")
+	fs.StringArray("var", nil, "")
+	fs.StringArray("route", nil, "")
+	return fs
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyPrecedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		profile string
+		env     map[string]string
+		args    []string
+		want    map[string]string
+	}{
+		{
+			name: "flag defaults",
+			want: map[string]string{"map-model": "default-map", "reduce-model": "default-reduce", "map-concurrency": "1", "var": "[]"},
+		},
+		{
+			name:   "config file run settings",
+			config: "run:\n  map_model: run-map\n  map_concurrency: 2\n",
+			want:   map[string]string{"map-model": "run-map", "reduce-model": "default-reduce", "map-concurrency": "2"},
+		},
+		{
+			name:   "profile selected by the config file over run settings",
+			config: testConfigYAML,
+			want:   map[string]string{"map-model": "nightly-map", "reduce-model": "run-reduce", "topic": "run-topic", "var": "[audience=nightly,tone=formal]"},
+		},
+		{
+			name:    "explicit profile merged over the builtin profile",
+			config:  testConfigYAML,
+			profile: "fast",
+			want:    map[string]string{"map-model": "gemini-2.5-flash", "reduce-model": "custom-fast-reduce", "map-concurrency": "3", "var": "[audience=run,tone=formal]"},
+		},
+		{
+			name:    "builtin profile without a config file",
+			profile: "quality",
+			want:    map[string]string{"map-model": "gemini-2.5-pro,gemini-2.5-flash", "map-concurrency": "1"},
+		},
+		{
+			name:   "environment over profile",
+			config: testConfigYAML,
+			env:    map[string]string{"APG_MAP_MODEL": "env-map", "APG_MAP_CONCURRENCY": "5", "APG_VAR": "audience=env"},
+			want:   map[string]string{"map-model": "env-map", "reduce-model": "run-reduce", "map-concurrency": "5", "var": "[audience=env]"},
+		},
+		{
+			name:   "flag over environment",
+			config: testConfigYAML,
+			env:    map[string]string{"APG_MAP_MODEL": "env-map", "APG_TOPIC": "env-topic"},
+			args:   []string{"--map-model", "cli-map", "--var", "tone=casual"},
+			want:   map[string]string{"map-model": "cli-map", "topic": "env-topic", "var": "[tone=casual]"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var file *File
+			if tt.config != "" {
+				var err error
+				if file, err = Load(writeConfig(t, "apg.yaml", tt.config)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			settings, err := Resolve(file, tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs := newTestFlagSet()
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := Apply(fs, settings, func(key string) string { return tt.env[key] }); err != nil {
+				t.Fatalf("Apply: %v", err)
+			}
+			for name, want := range tt.want {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("--%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyInvalidValue(t *testing.T) {
+	fs := newTestFlagSet()
+	err := Apply(fs, Settings{}, func(key string) string {
+		if key == "APG_MAP_CONCURRENCY" {
+			return "many"
+		}
+		return ""
+	})
+	if err == nil || !strings.Contains(err.Error(), "環境変数 APG_MAP_CONCURRENCY の値 'many' を --map-concurrency に適用できません") {
+		t.Errorf("Apply error = %v, want the environment variable named", err)
+	}
+}
+
+// TestApplySubcommandLocalFlags は、サブコマンド自身のフラグのみに環境変数が適用され、
+// ルートの永続フラグとヘルプフラグは APG_* で上書きされないことを検証します。
+func TestApplySubcommandLocalFlags(t *testing.T) {
+	root := &cobra.Command{Use: "root"}
+	logFormat := root.PersistentFlags().String("log-format", "text", "")
+	var mapModel string
+	run := &cobra.Command{Use: "run", RunE: func(cmd *cobra.Command, args []string) error {
+		env := map[string]string{"APG_LOG_FORMAT": "json", "APG_HELP": "true", "APG_MAP_MODEL": "env-map"}
+		return Apply(cmd.LocalNonPersistentFlags(), Settings{}, func(key string) string { return env[key] })
+	}}
+	run.Flags().StringVar(&mapModel, "map-model", "default-map", "")
+	root.AddCommand(run)
+	root.SetArgs([]string{"run"})
+
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if mapModel != "env-map" || *logFormat != "text" {
+		t.Errorf("map-model = %q, log-format = %q, want the environment applied only to the subcommand flag", mapModel, *logFormat)
+	}
+	if help := run.Flags().Lookup("help"); help != nil && help.Changed {
+		t.Error("--help was set from APG_HELP")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    Settings
+		wantErr string
+	}{
+		{
+			name:    "yaml",
+			file:    "apg.yml",
+			content: "run:\n  map_model: m\n  routing:\n    - name: docs\n      domains: [example.com]\n      model: r\n",
+			want:    Settings{MapModel: "m", Routing: []RouteRule{{Name: "docs", Domains: []string{"example.com"}, Model: "r"}}},
+		},
+		{
+			name:    "toml",
+			file:    "apg.toml",
+			content: "[run]\nmap_model = \"m\"\n[run.vars]\naudience = \"dev\"\n",
+			want:    Settings{MapModel: "m", Vars: map[string]string{"audience": "dev"}},
+		},
+		{name: "unknown yaml key", file: "apg.yaml", content: "run:\n  map_modle: m\n", wantErr: "map_modle"},
+		{name: "unknown toml key", file: "apg.toml", content: "[run]\nmap_modle = \"m\"\n", wantErr: "未知のキー"},
+		{name: "unsupported extension", file: "apg.json", content: "{}", wantErr: "未対応の設定ファイル形式"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Load(writeConfig(t, tt.file, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := f.Run.flagValues(), tt.want.flagValues(); !equalValues(got, want) {
+				t.Errorf("Load = %+v, want %+v", f.Run, tt.want)
+			}
+		})
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Load of a missing file succeeded, want an error")
+	}
+}
+
+func TestResolveUnknownProfile(t *testing.T) {
+	_, err := Resolve(&File{Profiles: map[string]Settings{"nightly": {}}}, "weekly")
+	if err == nil || !strings.Contains(err.Error(), "利用可能: fast, nightly, quality") {
+		t.Errorf("Resolve error = %v, want the available profiles listed", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := Settings{
+		MapModel: "base-map",
+		Topic:    "base-topic",
+		Vars:     map[string]string{"a": "1", "b": "2"},
+		Routing:  []RouteRule{{Name: "r1", Model: "m1"}, {Name: "r2", Model: "m2"}},
+	}
+	got := merge(base, Settings{
+		MapModel: "override-map",
+		Vars:     map[string]string{"b": "3"},
+		Routing:  []RouteRule{{Name: "r3", Model: "m3"}},
+	})
+	want := Settings{
+		MapModel: "override-map",
+		Topic:    "base-topic",
+		Vars:     map[string]string{"a": "1", "b": "3"},
+		Routing:  []RouteRule{{Name: "r3", Model: "m3"}},
+	}
+	if !equalValues(got.flagValues(), want.flagValues()) {
+		t.Errorf("merge = %+v, want %+v", got, want)
+	}
+	if base.Vars["b"] != "2" {
+		t.Error("merge modified the base vars")
+	}
+}
+
+func equalValues(a, b map[string][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if strings.Join(v, "\x00") != strings.Join(b[k], "\x00") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,5 @@
+// Package fakes は、外部サービス (Gemini API、Webスクレイピング、GCS、ローカルファイル) に依存せずに
+// パイプライン全体をオフラインで実行するためのフェイク実装を提供します。
+// builder.BuildPipeline の関数オプション (WithModel など) と組み合わせて、エンドツーエンドのテストに使用します。
+// Model は LLM クライアントの層を置き換えるため、クリーンアップの処理 (分割・ルーティング・フォールバック・応答ポリシー・応答の解析) は実際に実行されます。
+package fakes
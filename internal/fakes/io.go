@@ -0,0 +1,91 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+)
+
+// InputReader は、登録済みのファイル内容を返す pipeline.InputReader のフェイクです。
+// パスはローカルパスとGCS URIを区別せず、そのままキーとして扱います。
+type InputReader struct {
+	// Files は、パスごとのファイル内容です。
+	Files map[string]string
+}
+
+var _ pipeline.InputReader = (*InputReader)(nil)
+
+// NewInputReader は、files を返す InputReader を作成します。
+func NewInputReader(files map[string]string) *InputReader {
+	return &InputReader{Files: files}
+}
+
+// Open は、登録済みのファイル内容を返します。登録されていない場合は os.ErrNotExist をラップしたエラーを返します。
+func (f *InputReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	content, ok := f.Files[path]
+	if !ok {
+		return nil, fmt.Errorf("フェイクに未登録のファイルです '%s': %w", path, os.ErrNotExist)
+	}
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+// Writer は、書き込まれた内容をメモリに保持する pipeline.Writer のフェイクです。
+// GCSへの書き込みは "gs://bucket/path"、ローカルへの書き込みはパスをキーとして記録します。
+type Writer struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+var _ pipeline.Writer = (*Writer)(nil)
+
+// NewWriter は、空の Writer を作成します。
+func NewWriter() *Writer {
+	return &Writer{files: make(map[string][]byte)}
+}
+
+// WriteToGCS は、内容を "gs://bucket/path" として記録します。
+func (f *Writer) WriteToGCS(ctx context.Context, bucket, path string, content io.Reader, contentType string) error {
+	return f.write(fmt.Sprintf("gs://%s/%s", bucket, path), content)
+}
+
+// WriteToLocal は、内容をパスとして記録します。同じパスへの再書き込みは上書きします。
+func (f *Writer) WriteToLocal(ctx context.Context, path string, content io.Reader) error {
+	return f.write(path, content)
+}
+
+func (f *Writer) write(key string, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return fmt.Errorf("フェイクへの書き込み内容の読み込みに失敗しました '%s': %w", key, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[key] = data
+	return nil
+}
+
+// File は、パスに書き込まれた内容を返します。
+func (f *Writer) File(path string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.files[path]
+	return string(data), ok
+}
+
+// Paths は、書き込まれたパスをソートして返します。
+func (f *Writer) Paths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	paths := make([]string, 0, len(f.files))
+	for p := range f.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
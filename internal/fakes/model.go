@@ -0,0 +1,268 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+)
+
+// mapSummaryMaxRunes は、既定の Map 要約に含めるセグメント先頭の最大文字数です。
+const mapSummaryMaxRunes = 200
+
+// Model が判定するプロンプトの種類 (Call.Phase) です。
+const (
+	PhaseMap        = "map"
+	PhaseReduce     = "reduce"
+	PhaseSinglePass = "single"
+	PhaseRepair     = "repair"
+)
+
+// 組み込みテンプレートのプロンプトから、種類と入力を取り出すための見出しです。
+const (
+	mapInputHeading    = "## 📝 入力セグメント"
+	mapOutputHeading   = "## ✅ クリーンアップされたMarkdownテキストを出力してください:"
+	reduceInputHeading = "### 【中間要約結合テキスト】"
+	singleInputHeading = "### 【ソーステキスト】"
+	repairInputHeading = "### 【修復対象文書】"
+	reduceInputEnd     = "--- 最終出力 ---"
+	repairInputEnd     = "--- 修復後の文書 ---"
+)
+
+var (
+	// promptURLLinePattern は、Map プロンプト末尾の元記事URL行に一致します。
+	promptURLLinePattern = regexp.MustCompile(`(?m)^\[元記事URL: (\S+?)\]$`)
+	// promptQueryPattern は、Map プロンプトに調査クエリが含まれる場合の関連性フィールドの指示に一致します。
+	promptQueryPattern = regexp.MustCompile(`\[RELEVANT: yes\] または \[RELEVANT: no\]`)
+	// promptTopicPattern は、Reduce・単一パスのプロンプトで指定されたトピック名に一致します。
+	promptTopicPattern = regexp.MustCompile(`トピック名には「(.+?)」を使用してください`)
+	// sourceURLLinePattern は、中間要約・ソーステキスト中の元記事URL行に一致します。
+	sourceURLLinePattern = regexp.MustCompile(`^\[元記事URL: (\S+?)\]$`)
+	// blockSeparatorPattern は、Reduce 入力の中間要約・ソースの区切りに一致します。
+	blockSeparatorPattern = regexp.MustCompile(`(?m)^--- (INTERMEDIATE SUMMARY|SOURCE) END ---$`)
+)
+
+// Call は、Model への1回の呼び出しです。
+type Call struct {
+	// Model は、呼び出しで指定されたモデル名です。
+	Model string
+	// Prompt は、送信されたプロンプトです。
+	Prompt string
+	// Phase は、組み込みテンプレートの見出しから判定したプロンプトの種類です (判定できない場合は空)。
+	Phase string
+	// Stream は、ストリーミング生成で呼び出されたかどうかです。
+	Stream bool
+	// Options は、呼び出しごとの生成設定の上書きの数です (応答ポリシーによる再試行の確認に使用します)。
+	Options int
+}
+
+// Model は、Gemini API を呼び出さずに決定的な応答を返す llm.GenerativeModel のフェイクです。
+// builder.WithModel と組み合わせると、セグメント分割・ルーティング・フォールバック・応答ポリシー・Map 応答の解析を含む
+// 実際の LLMConcurrentExecutor をオフラインで実行できます。
+// 既定では、組み込みテンプレートのプロンプトから入力を取り出し、Map にはセグメントの先頭段落を <CLEANUP_START> / <CLEANUP_END> で囲んだ応答、
+// Reduce と単一パスには中間要約 (ソース) ごとに `##` セクションと関連URLを持つ文書、修復には対象文書をそのまま返します。
+// Respond を設定すると応答を差し替えられます。
+type Model struct {
+	// Respond は、呼び出しに対する応答を返します。nil の Response と nil のエラーを返した場合は既定の応答を使用します。
+	// llm.NewResponseError を返すと、セーフティブロックや途中終了を再現できます。
+	Respond func(call Call) (*llm.Response, error)
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+var _ llm.GenerativeModel = (*Model)(nil)
+
+// NewModel は、既定の応答を返す Model を作成します。
+func NewModel() *Model {
+	return &Model{}
+}
+
+// Calls は、これまでの呼び出しを呼び出し順に返します。
+func (m *Model) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// GenerateContent は、プロンプトに対する決定的な応答を返します。
+func (m *Model) GenerateContent(ctx context.Context, prompt string, modelName string, opts ...llm.GenerateOption) (*llm.Response, error) {
+	return m.generate(ctx, Call{Model: modelName, Prompt: prompt, Phase: phaseOf(prompt), Options: len(opts)})
+}
+
+// GenerateContentStream は、GenerateContent と同じ応答を行単位で onChunk に渡します。
+func (m *Model) GenerateContentStream(ctx context.Context, prompt string, modelName string, onChunk func(text string) error, opts ...llm.GenerateOption) (*llm.Response, error) {
+	resp, err := m.generate(ctx, Call{Model: modelName, Prompt: prompt, Phase: phaseOf(prompt), Stream: true, Options: len(opts)})
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range strings.SplitAfter(resp.Text, "\n") {
+		if chunk == "" {
+			continue
+		}
+		if err := onChunk(chunk); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (m *Model) generate(ctx context.Context, call Call) (*llm.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.calls = append(m.calls, call)
+	m.mu.Unlock()
+
+	if m.Respond != nil {
+		resp, err := m.Respond(call)
+		if err != nil || resp != nil {
+			return resp, err
+		}
+	}
+	text, err := defaultResponse(call)
+	if err != nil {
+		return nil, err
+	}
+	return &llm.Response{Text: text, FinishReason: "STOP", Usage: usageOf(call.Prompt, text)}, nil
+}
+
+// phaseOf は、組み込みテンプレートの見出しからプロンプトの種類を判定します。
+func phaseOf(prompt string) string {
+	switch {
+	case strings.Contains(prompt, repairInputHeading):
+		return PhaseRepair
+	case strings.Contains(prompt, mapInputHeading):
+		return PhaseMap
+	case strings.Contains(prompt, reduceInputHeading):
+		return PhaseReduce
+	case strings.Contains(prompt, singleInputHeading):
+		return PhaseSinglePass
+	}
+	return ""
+}
+
+// defaultResponse は、プロンプトの種類に応じた既定の応答を返します。
+func defaultResponse(call Call) (string, error) {
+	switch call.Phase {
+	case PhaseMap:
+		return mapResponse(call.Prompt), nil
+	case PhaseReduce:
+		return reduceDocument(section(call.Prompt, reduceInputHeading, reduceInputEnd), topicOf(call.Prompt)), nil
+	case PhaseSinglePass:
+		return reduceDocument(section(call.Prompt, singleInputHeading, reduceInputEnd), topicOf(call.Prompt)), nil
+	case PhaseRepair:
+		return section(call.Prompt, repairInputHeading, repairInputEnd), nil
+	}
+	return "", fmt.Errorf("フェイクの Model が判定できないプロンプトです (Respond で応答を指定してください)")
+}
+
+// mapResponse は、Map プロンプトのセグメント本文の先頭段落をエンベロープで囲み、URL行 (クエリ指定時は関連性フィールド) を付与した応答を返します。
+func mapResponse(prompt string) string {
+	body := section(prompt, mapInputHeading, mapOutputHeading)
+	// 見出し直後の「（ソース: ...）」の行はセグメント本文ではない
+	if _, rest, ok := strings.Cut(body, "\n\n"); ok && strings.HasPrefix(body, "（ソース:") {
+		body = rest
+	}
+	url := ""
+	if m := promptURLLinePattern.FindStringSubmatch(prompt); m != nil {
+		url = m[1]
+	}
+
+	text := fmt.Sprintf("<CLEANUP_START>\n%s\n<CLEANUP_END>\n[元記事URL: %s]", firstParagraph(body, mapSummaryMaxRunes), url)
+	if promptQueryPattern.MatchString(prompt) {
+		text += "\n[RELEVANT: yes]"
+	}
+	return text
+}
+
+// section は、プロンプトの見出し heading から end までのテキストを返します。
+func section(prompt, heading, end string) string {
+	_, rest, ok := strings.Cut(prompt, heading)
+	if !ok {
+		return ""
+	}
+	if i := strings.LastIndex(rest, end); i != -1 {
+		rest = rest[:i]
+	}
+	rest = strings.TrimSpace(rest)
+	// Reduce・単一パスの入力の前にある「（情報取得日: ...）」の行は入力に含めない
+	if strings.HasPrefix(rest, "（情報取得日:") {
+		if _, after, ok := strings.Cut(rest, "\n\n"); ok {
+			rest = after
+		}
+	}
+	return strings.TrimSpace(rest)
+}
+
+// topicOf は、プロンプトで指定されたトピック名を返します (指定がない場合は空)。
+func topicOf(prompt string) string {
+	if m := promptTopicPattern.FindStringSubmatch(prompt); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// reduceDocument は、結合済みテキストの各ブロック (中間要約またはソース) を `##` セクションとし、
+// 元記事URLを関連URLとして付与した文書を生成します。topic が空の場合は既定の題名を使用します。
+func reduceDocument(combinedText string, topic string) string {
+	title := topic
+	if title == "" {
+		title = "統合レポート"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s\n", title)
+
+	section := 0
+	for _, block := range blockSeparatorPattern.Split(combinedText, -1) {
+		var sourceURL string
+		var body []string
+		for _, line := range strings.Split(strings.TrimSpace(block), "\n") {
+			line = strings.TrimSpace(line)
+			if m := sourceURLLinePattern.FindStringSubmatch(line); m != nil {
+				sourceURL = m[1]
+				continue
+			}
+			if strings.HasPrefix(line, "[出典ID:") {
+				continue
+			}
+			body = append(body, line)
+		}
+		text := firstParagraph(strings.Join(body, "\n"), mapSummaryMaxRunes)
+		if text == "" {
+			continue
+		}
+
+		section++
+		fmt.Fprintf(&sb, "\n## %d. ソース %d\n\n", section, section)
+		if sourceURL != "" {
+			fmt.Fprintf(&sb, "### 関連URL\n* %s\n\n", sourceURL)
+		}
+		sb.WriteString(text + "\n")
+	}
+	return sb.String()
+}
+
+// firstParagraph は、テキストの最初の空でない段落を、最大 maxRunes 文字で返します。
+func firstParagraph(text string, maxRunes int) string {
+	for _, p := range strings.Split(strings.TrimSpace(text), "\n\n") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if runes := []rune(p); len(runes) > maxRunes {
+			p = string(runes[:maxRunes])
+		}
+		return p
+	}
+	return ""
+}
+
+// usageOf は、プロンプトと応答の文字数を疑似的なトークン消費量として返します。
+func usageOf(prompt, output string) llm.Usage {
+	in, out := len([]rune(prompt)), len([]rune(output))
+	return llm.Usage{PromptTokens: in, OutputTokens: out, TotalTokens: in + out}
+}
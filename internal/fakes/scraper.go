@@ -0,0 +1,61 @@
+package fakes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// Scraper は、Webにアクセスせずに登録済みの本文を返す pipeline.ScraperRunner のフェイクです。
+// ReliableScraper と同様に、取得に成功した結果のみを入力順に返します。
+type Scraper struct {
+	// Pages は、URLごとの抽出済み本文です。登録されていないURLは取得失敗として扱います。
+	Pages map[string]string
+
+	mu        sync.Mutex
+	requested []string
+	failed    []extTypes.URLResult
+}
+
+var _ pipeline.ScraperRunner = (*Scraper)(nil)
+
+// NewScraper は、pages を返す Scraper を作成します。
+func NewScraper(pages map[string]string) *Scraper {
+	return &Scraper{Pages: pages}
+}
+
+// ScrapeInParallel は、登録済みの本文を持つURLの結果を返します。
+func (f *Scraper) ScrapeInParallel(ctx context.Context, urls []string) []extTypes.URLResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var results []extTypes.URLResult
+	for _, u := range urls {
+		f.requested = append(f.requested, u)
+		content, ok := f.Pages[u]
+		if !ok || ctx.Err() != nil {
+			f.failed = append(f.failed, extTypes.URLResult{URL: u, Error: fmt.Errorf("フェイクに未登録のURLです: %s", u)})
+			continue
+		}
+		results = append(results, extTypes.URLResult{URL: u, Content: content})
+	}
+	return results
+}
+
+// Requested は、これまでに取得を要求されたURLを返します。
+func (f *Scraper) Requested() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requested...)
+}
+
+// Failed は、取得に失敗した (未登録の) URLの結果を返します。
+func (f *Scraper) Failed() []extTypes.URLResult {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]extTypes.URLResult(nil), f.failed...)
+}
@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/genai"
+)
+
+// FailureClass は、LLM 呼び出しの失敗の分類です。フォールバックや再試行の判断に使用します。
+type FailureClass string
+
+const (
+	// FailureQuota は、レート制限やクォータの枯渇 (HTTP 429) です。
+	FailureQuota FailureClass = "quota"
+	// FailureSafety は、セーフティフィルタなどによる応答のブロックです。
+	FailureSafety FailureClass = "safety"
+	// FailureTruncated は、最大出力トークン数に達したことによる応答の途中終了です。
+	FailureTruncated FailureClass = "truncated"
+	// FailureEmpty は、エラーなく終了したもののテキストが空だった応答です。
+	FailureEmpty FailureClass = "empty"
+	// FailureCanceled は、コンテキストのキャンセルまたはタイムアウトです。フォールバックの対象になりません。
+	FailureCanceled FailureClass = "canceled"
+	// FailureError は、上記以外のエラー (サーバーエラー、不正なモデル名など) です。
+	FailureError FailureClass = "error"
+)
+
+// Classify は、LLM 呼び出しのエラーを分類します。
+func Classify(err error) FailureClass {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return FailureCanceled
+	}
+
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		switch genai.FinishReason(respErr.FinishReason) {
+		case genai.FinishReasonSafety, genai.FinishReasonBlocklist, genai.FinishReasonProhibitedContent,
+			genai.FinishReasonSPII, genai.FinishReasonRecitation, genai.FinishReasonImageSafety:
+			return FailureSafety
+		case genai.FinishReasonMaxTokens:
+			return FailureTruncated
+		}
+		if respErr.Blocked {
+			return FailureSafety
+		}
+		return FailureEmpty
+	}
+
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests {
+		return FailureQuota
+	}
+	return FailureError
+}
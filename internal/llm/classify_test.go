@@ -0,0 +1,37 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want FailureClass
+	}{
+		{name: "canceled", err: fmt.Errorf("呼び出し失敗: %w", context.Canceled), want: FailureCanceled},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, want: FailureCanceled},
+		{name: "safety finish reason", err: NewResponseError("SAFETY", false, "blocked"), want: FailureSafety},
+		{name: "recitation", err: NewResponseError("RECITATION", false, "blocked"), want: FailureSafety},
+		{name: "prompt blocked", err: NewResponseError("", true, "prompt blocked"), want: FailureSafety},
+		{name: "max tokens", err: fmt.Errorf("wrapped: %w", NewResponseError("MAX_TOKENS", false, "truncated")), want: FailureTruncated},
+		{name: "empty text", err: NewResponseError("STOP", false, "empty"), want: FailureEmpty},
+		{name: "quota", err: fmt.Errorf("wrapped: %w", genai.APIError{Code: 429, Message: "RESOURCE_EXHAUSTED"}), want: FailureQuota},
+		{name: "server error", err: genai.APIError{Code: 500}, want: FailureError},
+		{name: "other error", err: errors.New("connection reset"), want: FailureError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
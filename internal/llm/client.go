@@ -0,0 +1,327 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/shouni/go-ai-client/v2/pkg/ai/gemini"
+	"github.com/shouni/go-utils/retry"
+	"google.golang.org/genai"
+)
+
+// 既定値と初期化設定は github.com/shouni/go-ai-client の gemini パッケージのものをそのまま使用し、
+// 上流と設定の意味がずれないようにします。
+const (
+	// DefaultTemperature は、モデルの応答温度のデフォルト値です。
+	DefaultTemperature = gemini.DefaultTemperature
+	// DefaultMaxRetries は、一時的なエラーに対するデフォルトのリトライ回数です。
+	DefaultMaxRetries = gemini.DefaultMaxRetries
+	// DefaultInitialDelay は、指数バックオフの初期間隔のデフォルト値です。
+	DefaultInitialDelay = gemini.DefaultInitialDelay
+	// DefaultMaxDelay は、指数バックオフの最大間隔のデフォルト値です。
+	DefaultMaxDelay = gemini.DefaultMaxDelay
+)
+
+// GenerativeModel は、LLM によるテキスト生成を抽象化するインターフェースです。
+// GenerateContentStream は、生成されたテキストを到着した順に onChunk へ渡します。
+// opts で、呼び出しごとに生成設定 (応答温度、最大出力トークン数、セーフティ設定) を上書きできます。
+type GenerativeModel interface {
+	GenerateContent(ctx context.Context, prompt string, modelName string, opts ...GenerateOption) (*Response, error)
+	GenerateContentStream(ctx context.Context, prompt string, modelName string, onChunk func(text string) error, opts ...GenerateOption) (*Response, error)
+}
+
+// Response は、1回のテキスト生成の結果です。
+type Response struct {
+	Text string
+	// FinishReason は、モデルが生成を終了した理由です (例: "STOP", "MAX_TOKENS", "SAFETY")。
+	FinishReason string
+	// Usage は、この呼び出しで消費したトークン数です。
+	Usage Usage
+}
+
+// Usage は、1回の呼び出しで消費したトークン数です。
+type Usage struct {
+	PromptTokens int
+	OutputTokens int
+	TotalTokens  int
+}
+
+// ResponseError は、API呼び出し自体は成功したものの、応答を利用できなかったことを示すエラーです
+// (セーフティブロック、途中終了、空の応答など)。リトライの対象にはなりません。
+type ResponseError struct {
+	FinishReason string
+	// Blocked は、プロンプト自体がブロックされたかどうかです (候補が返されなかった場合)。
+	Blocked bool
+	msg     string
+}
+
+func (e *ResponseError) Error() string { return e.msg }
+
+// NewResponseError は、記録済みの応答 (カセットなど) から ResponseError を復元します。
+func NewResponseError(finishReason string, blocked bool, msg string) *ResponseError {
+	return &ResponseError{FinishReason: finishReason, Blocked: blocked, msg: msg}
+}
+
+// Config は GeminiClient の初期化設定です (go-ai-client の gemini.Config と同一です)。
+type Config = gemini.Config
+
+// GeminiClient は、genai SDK を使用した GenerativeModel の具象実装です。
+// go-ai-client の gemini.Client (v2.0.7) はテキストのみを返し、ストリーミング、呼び出しごとの生成設定、
+// 終了理由とトークン数の取得に対応していないため、これらを必要とする部分だけを genai SDK で直接実装しています。
+// 初期化設定・既定値・APIキーの環境変数は上流と共通です。上流がこれらに対応した場合は、上流のクライアントのラッパーに置き換えてください。
+type GeminiClient struct {
+	client      *genai.Client
+	temperature float32
+	retryConfig retry.Config
+}
+
+// NewGeminiClient は新しい GeminiClient を作成します。
+func NewGeminiClient(ctx context.Context, cfg Config) (*GeminiClient, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("Geminiクライアントの初期化にはAPIキーが必要です")
+	}
+
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: cfg.APIKey})
+	if err != nil {
+		return nil, fmt.Errorf("Geminiクライアントの作成に失敗しました: %w", err)
+	}
+
+	temperature := DefaultTemperature
+	if cfg.Temperature != nil {
+		if *cfg.Temperature < 0.0 || *cfg.Temperature > 1.0 {
+			return nil, fmt.Errorf("temperature は 0.0 から 1.0 の範囲で指定してください: %f", *cfg.Temperature)
+		}
+		temperature = *cfg.Temperature
+	}
+
+	retryCfg := retry.Config{
+		MaxRetries:      DefaultMaxRetries,
+		InitialInterval: DefaultInitialDelay,
+		MaxInterval:     DefaultMaxDelay,
+	}
+	if cfg.MaxRetries > 0 {
+		retryCfg.MaxRetries = cfg.MaxRetries
+	}
+	if cfg.InitialDelay > 0 {
+		retryCfg.InitialInterval = cfg.InitialDelay
+	}
+	if cfg.MaxDelay > 0 {
+		retryCfg.MaxInterval = cfg.MaxDelay
+	}
+
+	return &GeminiClient{
+		client:      client,
+		temperature: temperature,
+		retryConfig: retryCfg,
+	}, nil
+}
+
+// NewGeminiClientWithKey は、apiKey が空でなければそれを、空の場合は環境変数のAPIキーを使用して GeminiClient を作成します。
+func NewGeminiClientWithKey(ctx context.Context, apiKey string) (*GeminiClient, error) {
+	if apiKey != "" {
+		return NewGeminiClient(ctx, Config{APIKey: apiKey})
+	}
+	return NewGeminiClientFromEnv(ctx)
+}
+
+// NewGeminiClientFromEnv は、環境変数 GEMINI_API_KEY (または GOOGLE_API_KEY) のAPIキーでクライアントを作成します。
+func NewGeminiClientFromEnv(ctx context.Context) (*GeminiClient, error) {
+	apiKey := os.Getenv("GEMINI_API_KEY")
+	if apiKey == "" {
+		apiKey = os.Getenv("GOOGLE_API_KEY")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("環境変数 GEMINI_API_KEY または GOOGLE_API_KEY が設定されていません")
+	}
+	return NewGeminiClient(ctx, Config{APIKey: apiKey})
+}
+
+// GenerateContent は、プロンプトを送信して応答全体を一度に受け取ります。一時的なエラーはリトライします。
+func (c *GeminiClient) GenerateContent(ctx context.Context, prompt string, modelName string, opts ...GenerateOption) (*Response, error) {
+	if prompt == "" {
+		return nil, errors.New("プロンプトが空です")
+	}
+
+	var result *Response
+	op := func() error {
+		resp, err := c.client.Models.GenerateContent(ctx, modelName, promptToContents(prompt), c.generateConfig(opts))
+		if err != nil {
+			return err
+		}
+
+		var acc accumulator
+		acc.add(resp)
+		result, err = acc.response()
+		return err
+	}
+
+	if err := retry.Do(ctx, c.retryConfig, fmt.Sprintf("Gemini API (%s) の呼び出し", modelName), op, isRetryable); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GenerateContentStream は、プロンプトを送信し、生成されたテキストを到着順に onChunk へ渡します。
+// 一時的なエラーは、まだテキストを1件も受け取っていない場合に限りリトライします。
+// onChunk がエラーを返した場合は、生成を中断してそのエラーを返します。
+func (c *GeminiClient) GenerateContentStream(ctx context.Context, prompt string, modelName string, onChunk func(text string) error, opts ...GenerateOption) (*Response, error) {
+	if prompt == "" {
+		return nil, errors.New("プロンプトが空です")
+	}
+
+	var result *Response
+	emitted := false
+	op := func() error {
+		var acc accumulator
+		for resp, err := range c.client.Models.GenerateContentStream(ctx, modelName, promptToContents(prompt), c.generateConfig(opts)) {
+			if err != nil {
+				return err
+			}
+			text := acc.add(resp)
+			if text == "" {
+				continue
+			}
+			emitted = true
+			if err := onChunk(text); err != nil {
+				return &chunkError{err: err}
+			}
+		}
+
+		var err error
+		result, err = acc.response()
+		return err
+	}
+
+	shouldRetry := func(err error) bool {
+		// 一部のテキストを出力済みの場合、再試行すると出力が重複するためリトライしない
+		return !emitted && isRetryable(err)
+	}
+
+	if err := retry.Do(ctx, c.retryConfig, fmt.Sprintf("Gemini API (%s) のストリーミング呼び出し", modelName), op, shouldRetry); err != nil {
+		var ce *chunkError
+		if errors.As(err, &ce) {
+			return nil, ce.err
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// generateConfig は、クライアントの既定値にオプションを適用した生成設定を返します。
+func (c *GeminiClient) generateConfig(opts []GenerateOption) *genai.GenerateContentConfig {
+	temperature := c.temperature
+	cfg := &genai.GenerateContentConfig{Temperature: &temperature}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// chunkError は、onChunk コールバックが返したエラーです。リトライの対象にはなりません。
+type chunkError struct {
+	err error
+}
+
+func (e *chunkError) Error() string { return e.err.Error() }
+
+// accumulator は、1回または複数回 (ストリーミング) に分かれて届く応答を集約します。
+type accumulator struct {
+	text         strings.Builder
+	finishReason genai.FinishReason
+	usage        *genai.GenerateContentResponseUsageMetadata
+	candidates   bool
+	blockReason  string
+}
+
+// add は応答を1件取り込み、その応答に含まれるテキストを返します。
+func (a *accumulator) add(resp *genai.GenerateContentResponse) string {
+	if resp == nil {
+		return ""
+	}
+	if resp.UsageMetadata != nil {
+		a.usage = resp.UsageMetadata
+	}
+	if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		a.blockReason = string(resp.PromptFeedback.BlockReason)
+	}
+	if len(resp.Candidates) == 0 {
+		return ""
+	}
+	a.candidates = true
+	if reason := resp.Candidates[0].FinishReason; reason != "" {
+		a.finishReason = reason
+	}
+	text := resp.Text()
+	a.text.WriteString(text)
+	return text
+}
+
+// response は、集約した応答を検証して Response を返します。
+// 正常終了 (STOP) 以外の終了理由や空の応答は ResponseError として返します。
+func (a *accumulator) response() (*Response, error) {
+	if !a.candidates {
+		if a.blockReason != "" {
+			return nil, &ResponseError{
+				Blocked: true,
+				msg:     fmt.Sprintf("プロンプトがブロックされました。理由: %s", a.blockReason),
+			}
+		}
+		return nil, &ResponseError{msg: "Gemini APIから空または無効なレスポンスが返されました"}
+	}
+
+	reason := string(a.finishReason)
+	if a.finishReason != "" && a.finishReason != genai.FinishReasonUnspecified && a.finishReason != genai.FinishReasonStop {
+		return nil, &ResponseError{
+			FinishReason: reason,
+			msg:          fmt.Sprintf("APIレスポンスがブロックされたか、途中で終了しました。理由: %s", reason),
+		}
+	}
+	if a.text.Len() == 0 {
+		return nil, &ResponseError{FinishReason: reason, msg: "Gemini レスポンスのテキストが空です"}
+	}
+
+	res := &Response{Text: a.text.String(), FinishReason: reason}
+	if a.usage != nil {
+		res.Usage = Usage{
+			PromptTokens: int(a.usage.PromptTokenCount),
+			OutputTokens: int(a.usage.CandidatesTokenCount),
+			TotalTokens:  int(a.usage.TotalTokenCount),
+		}
+	}
+	return res, nil
+}
+
+// promptToContents は、文字列のプロンプトを単一のユーザーメッセージに変換します。
+func promptToContents(text string) []*genai.Content {
+	return []*genai.Content{genai.NewContentFromText(text, genai.RoleUser)}
+}
+
+// isRetryable は、エラーが一時的なもの (レート制限、サーバーエラー) かどうかを判定します。
+func isRetryable(err error) bool {
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		return false
+	}
+	var ce *chunkError
+	if errors.As(err, &ce) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package llm
+
+import "strings"
+
+// DefaultInputTokenLimit は、入力トークン数の上限が不明なモデルに対して仮定する上限です。
+// 未知のモデルで入力が上限を超えないよう、控えめな値にしています。
+const DefaultInputTokenLimit = 128000
+
+// inputTokenLimits は、モデル名の接頭辞ごとの入力トークン数の上限です (Gemini API のモデル情報の inputTokenLimit)。
+// 長い接頭辞から順に照合するため、より具体的な名前を先に並べています。
+var inputTokenLimits = []struct {
+	prefix string
+	limit  int
+}{
+	{"gemini-2.5-pro", 1048576},
+	{"gemini-2.5-flash-lite", 1048576},
+	{"gemini-2.5-flash", 1048576},
+	{"gemini-2.0-flash-lite", 1048576},
+	{"gemini-2.0-flash", 1048576},
+	{"gemini-1.5-pro", 2097152},
+	{"gemini-1.5-flash", 1048576},
+}
+
+// InputTokenLimit は、モデルの入力トークン数の上限を返します。
+// モデル名は "models/" の接頭辞やバージョン付きの名前 (例: gemini-2.5-flash-preview-05-20) でも構いません。
+// 不明なモデルの場合は DefaultInputTokenLimit を返します。
+func InputTokenLimit(model string) int {
+	name := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(model)), "models/")
+	for _, m := range inputTokenLimits {
+		if strings.HasPrefix(name, m.prefix) {
+			return m.limit
+		}
+	}
+	return DefaultInputTokenLimit
+}
@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"encoding/json"
+
+	"google.golang.org/genai"
+)
+
+// GenerateOption は、1回の生成呼び出しの設定を上書きするオプションです。
+// 応答がブロック・途中終了した場合に、設定を変えて再試行するために使用します。
+type GenerateOption func(*genai.GenerateContentConfig)
+
+// WithTemperature は、応答温度を上書きします。
+func WithTemperature(t float32) GenerateOption {
+	return func(c *genai.GenerateContentConfig) {
+		c.Temperature = &t
+	}
+}
+
+// WithMaxOutputTokens は、最大出力トークン数を指定します。
+func WithMaxOutputTokens(n int32) GenerateOption {
+	return func(c *genai.GenerateContentConfig) {
+		c.MaxOutputTokens = n
+	}
+}
+
+// WithRelaxedSafety は、主要なハームカテゴリのブロックしきい値を「高リスクのみブロック」に緩和します。
+// 技術文書などが誤ってブロックされた場合の再試行に使用します。
+func WithRelaxedSafety() GenerateOption {
+	return func(c *genai.GenerateContentConfig) {
+		categories := []genai.HarmCategory{
+			genai.HarmCategoryHarassment,
+			genai.HarmCategoryHateSpeech,
+			genai.HarmCategorySexuallyExplicit,
+			genai.HarmCategoryDangerousContent,
+		}
+		c.SafetySettings = make([]*genai.SafetySetting, 0, len(categories))
+		for _, category := range categories {
+			c.SafetySettings = append(c.SafetySettings, &genai.SafetySetting{
+				Category:  category,
+				Threshold: genai.HarmBlockThresholdBlockOnlyHigh,
+			})
+		}
+	}
+}
+
+// OptionsKey は、オプションを適用した生成設定の差分を、比較可能な文字列 (JSON) として返します。
+// オプションが指定されていない場合は空文字を返します。記録した応答の照合に使用します。
+func OptionsKey(opts ...GenerateOption) string {
+	if len(opts) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(ApplyOptions(opts...))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// ApplyOptions は、オプションを空の生成設定に適用した結果を返します。
+// GenerativeModel を Gemini 以外の実装に変換する際に、上書きされた設定を参照するために使用します。
+func ApplyOptions(opts ...GenerateOption) *genai.GenerateContentConfig {
+	cfg := &genai.GenerateContentConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
@@ -0,0 +1,216 @@
+// Package logging は、CLI 全体で使用する slog のハンドラーの設定と、
+// ログ集約で使用する安定した構造化キーを提供します。
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ログ集約で使用する構造化キーです。メッセージ (日本語) は変更されることがあるため、
+// 集計や検索にはこれらのキーを使用してください。
+const (
+	// KeyRunID は、実行 (run, batch のジョブ, serve のジョブ) を識別するIDです。
+	KeyRunID = "run_id"
+	// KeyJobID は、batch と serve のジョブIDです。
+	KeyJobID = "job_id"
+	// KeyPhase は、パイプラインのステージ (urls, fetch, cleanup, publish) または LLM 呼び出しのフェーズ (map, reduce, repair) です。
+	KeyPhase = "phase"
+	// KeyURL は、処理対象のソースURLです。
+	KeyURL = "url"
+	// KeySegment は、Mapフェーズのセグメント番号 (1始まり) です。
+	KeySegment = "segment"
+)
+
+// ログの出力形式です。
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config は、ログの出力設定です。
+type Config struct {
+	// Format は出力形式 (text, json) です。空の場合は text です。
+	Format string
+	// Level は出力する最低レベル (debug, info, warn, error) です。空の場合は info です。
+	Level string
+	// File はログの出力先ファイルです (追記)。空の場合は標準エラー出力です。
+	File string
+	// AddSource は、ログにソースコードの位置を含めるかどうかです。
+	AddSource bool
+}
+
+// currentFormat は、Setup で設定されたログの出力形式です。
+var currentFormat = FormatText
+
+// Setup は、cfg に従って単一の slog ハンドラーを構築し、slog のデフォルトロガーに設定します。
+// 標準の log パッケージの出力も同じハンドラーに送られます。
+// ログファイルはプロセスの終了まで開いたままにします (書き込みはバッファリングされません)。
+func Setup(cfg Config) error {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stderr
+	if cfg.File != "" {
+		if dir := filepath.Dir(cfg.File); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return fmt.Errorf("ログファイルのディレクトリの作成に失敗しました: %w", err)
+			}
+		}
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("ログファイル '%s' を開けませんでした: %w", cfg.File, err)
+		}
+		w = f
+	}
+	handler, err := NewHandler(w, cfg.Format, &slog.HandlerOptions{Level: level, AddSource: cfg.AddSource})
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	currentFormat = strings.ToLower(strings.TrimSpace(cfg.Format))
+	return nil
+}
+
+// IsJSON は、Setup でログの出力形式に json が設定されているかどうかを返します。
+// 進捗表示など、ログと同じ出力先に人向けのテキストを書き出す処理の抑止に使用します。
+func IsJSON() bool {
+	return currentFormat == FormatJSON
+}
+
+// NewHandler は、指定された形式で w に書き出すハンドラーを作成します。
+// 作成したハンドラーは、With で context に設定した属性をログに付与します。
+func NewHandler(w io.Writer, format string, opts *slog.HandlerOptions) (slog.Handler, error) {
+	var inner slog.Handler
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case FormatText, "":
+		inner = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		inner = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("ログの出力形式が不正です: %q (text, json のいずれかを指定してください)", format)
+	}
+	return &contextHandler{inner: inner, bound: map[string]bool{}}, nil
+}
+
+// ParseLevel は、ログレベルの名前 (debug, info, warn, error) を slog.Level に変換します。空の場合は info です。
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("ログレベルが不正です: %q (debug, info, warn, error のいずれかを指定してください)", s)
+}
+
+// ----------------------------------------------------------------
+// context に設定した属性の付与
+// ----------------------------------------------------------------
+
+type attrsKey struct{}
+
+// With は、以降のログ (slog.InfoContext などの Context 付きの呼び出し) に付与する属性を設定した context を返します。
+// 同じキーが既に設定されている場合は、新しい値で置き換えます。
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev := attrsFrom(ctx)
+	merged := make([]slog.Attr, 0, len(prev)+len(attrs))
+	for _, a := range prev {
+		if !hasKey(attrs, a.Key) {
+			merged = append(merged, a)
+		}
+	}
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, attrsKey{}, merged)
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+func hasKey(attrs []slog.Attr, key string) bool {
+	for _, a := range attrs {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// contextHandler は、context に設定された属性をレコードに付与するハンドラーです。
+// 呼び出し時に指定された属性と Logger.With で設定された属性が、context の属性より優先されます
+// (例: LLM 呼び出しのログの phase=map は、ステージの phase=cleanup を置き換えます)。
+type contextHandler struct {
+	inner slog.Handler
+	// bound は、WithAttrs で設定済みのトップレベルのキーです。
+	bound map[string]bool
+	// grouped は、WithGroup が呼ばれたかどうかです (以降の属性はグループ内に入るため、context の属性は付与しません)。
+	grouped bool
+}
+
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := attrsFrom(ctx)
+	if len(attrs) == 0 || h.grouped {
+		return h.inner.Handle(ctx, r)
+	}
+	own := make(map[string]bool, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		own[a.Key] = true
+		return true
+	})
+	extra := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if !own[a.Key] && !h.bound[a.Key] {
+			extra = append(extra, a)
+		}
+	}
+	if len(extra) == 0 {
+		return h.inner.Handle(ctx, r)
+	}
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	out.AddAttrs(extra...)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(a)
+		return true
+	})
+	return h.inner.Handle(ctx, out)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	bound := h.bound
+	if !h.grouped {
+		bound = make(map[string]bool, len(h.bound)+len(attrs))
+		for k := range h.bound {
+			bound[k] = true
+		}
+		for _, a := range attrs {
+			bound[a.Key] = true
+		}
+	}
+	return &contextHandler{inner: h.inner.WithAttrs(attrs), bound: bound, grouped: h.grouped}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &contextHandler{inner: h.inner.WithGroup(name), bound: h.bound, grouped: true}
+}
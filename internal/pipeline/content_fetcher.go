@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
 
 	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
 	"github.com/shouni/web-text-pipe-go/pkg/runner"
@@ -29,12 +32,19 @@ func NewWebContentFetcherImpl(scraperRunner ScraperRunner) *WebContentFetcherImp
 
 // Fetch は、URLリストに対してスクレイピング処理を実行者に委譲します。
 // リトライ、遅延、分類のロジックは ScraperRunner (ReliableScraper) 側で完結します。
+// ctx に取得日時の記録先 (cleaner.WithFetchTimes) が設定されている場合は、取得できた URL ごとに
+// 結果を受け取った日時を記録します (ScraperRunner が記録済みの URL は上書きしません)。
 func (w *WebContentFetcherImpl) Fetch(ctx context.Context, opts CmdOptions, urls []string) ([]extTypes.URLResult, error) {
 	// ログメッセージの参照名を修正
-	slog.Info("Webコンテンツの抽出処理を ScraperRunner に委譲します。", slog.Int("total_urls", len(urls)))
+	slog.InfoContext(ctx, "Webコンテンツの抽出処理を ScraperRunner に委譲します。", slog.Int("total_urls", len(urls)))
 
 	// 注入された ScraperRunner (ReliableScraper) が、並列実行とリトライの両方を処理します。
 	successfulResults := w.scraperRunner.ScrapeInParallel(ctx, urls)
+	fetchTimes := cleaner.FetchTimesFrom(ctx)
+	fetchedAt := time.Now()
+	for _, r := range successfulResults {
+		fetchTimes.Record(r.URL, fetchedAt)
+	}
 
 	if len(successfulResults) == 0 {
 		return nil, fmt.Errorf("処理可能なWebコンテンツを一件も取得できませんでした。URLを確認してください。")
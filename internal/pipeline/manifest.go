@@ -0,0 +1,347 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// ----------------------------------------------------------------
+// 実行記録 (run manifest)
+// ----------------------------------------------------------------
+
+const (
+	// ManifestStatusSucceeded と ManifestStatusFailed は、実行記録に記録する実行結果です。
+	ManifestStatusSucceeded = "succeeded"
+	ManifestStatusFailed    = "failed"
+	// ManifestStatusCanceled は、シグナルなどにより実行が中断されたことを示します。
+	ManifestStatusCanceled = "canceled"
+
+	// manifestSuffix・partialSuffix・streamSuffix は、出力パスから実行記録・部分結果・Reduce の逐次書き込み先の
+	// パスを導出する際に付与する接尾辞です。
+	manifestSuffix = ".manifest.json"
+	partialSuffix  = ".partial.md"
+	streamSuffix   = ".stream.md"
+	// redacted は、実行記録に記録しない秘密情報の代わりに記録する値です。
+	redacted = "[REDACTED]"
+)
+
+// ArtifactPublisher は、最終文書以外の成果物 (実行記録、中断時の部分結果) を出力先へ書き出せる Publisher です。
+type ArtifactPublisher interface {
+	// PublishArtifact は、data を path (ローカルまたはGCS URI) へ書き出します。
+	PublishArtifact(ctx context.Context, path string, contentType string, data []byte) error
+}
+
+// RunManifest は、1回の実行を監査・再現するための機械可読な記録です。
+// 実行の成否にかかわらず、実行の終了時に JSON として書き出されます。
+type RunManifest struct {
+	RunID      string    `json:"run_id"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Options は、実行に使用したオプションです (APIキーは記録しません)。
+	Options ManifestOptions `json:"options"`
+	// Prompts は、使用したプロンプトテンプレートの名前とダイジェストです。
+	Prompts []ManifestPrompt `json:"prompts"`
+	Models  ManifestModels   `json:"models"`
+	// URLs は、URLリストの各URLと取得結果です (入力順)。
+	URLs []ManifestURL `json:"urls"`
+	// Segments は、Mapフェーズのセグメントごとの処理結果です。
+	Segments []ManifestSegment `json:"segments"`
+	// Usage は、Map・Reduce・構造修復のトークン消費量の合計です。
+	Usage ManifestUsage `json:"usage"`
+	// Phases は、各ステージの所要時間です (実行したステージのみ)。
+	Phases  []ManifestPhase `json:"phases"`
+	Outputs ManifestOutputs `json:"outputs"`
+}
+
+// ManifestOptions は、実行記録に記録する CmdOptions です。期間は Go の time.Duration 形式の文字列で記録します。
+type ManifestOptions struct {
+	LLMAPIKey          string                `json:"llm_api_key,omitempty"`
+	LLMTimeout         string                `json:"llm_timeout"`
+	ScraperTimeout     string                `json:"scraper_timeout"`
+	URLFile            string                `json:"url_file"`
+	OutputFilePath     string                `json:"output"`
+	OutputFormat       string                `json:"format,omitempty"`
+	MaxScraperParallel int                   `json:"parallel"`
+	MapModel           string                `json:"map_model"`
+	ReduceModel        string                `json:"reduce_model"`
+	MapConcurrency     int                   `json:"map_concurrency"`
+	MapPromptPath      string                `json:"map_prompt,omitempty"`
+	ReducePromptPath   string                `json:"reduce_prompt,omitempty"`
+	Language           string                `json:"lang,omitempty"`
+	Topic              string                `json:"topic,omitempty"`
+	Query              string                `json:"query,omitempty"`
+	CitationPolicy     string                `json:"citation_policy,omitempty"`
+	CitationStyle      string                `json:"citation_style,omitempty"`
+	RoutingRules       []cleaner.RoutingRule `json:"routing_rules,omitempty"`
+	Mode               string                `json:"mode,omitempty"`
+	SinglePassMaxChars int                   `json:"single_pass_max_chars,omitempty"`
+	ResponsePolicy     []string              `json:"response_policy,omitempty"`
+	RecordDir          string                `json:"record,omitempty"`
+	ReplayDir          string                `json:"replay,omitempty"`
+	TemplateVars       map[string]string     `json:"vars,omitempty"`
+}
+
+// ManifestPrompt は、1つのフェーズで使用したプロンプトテンプレートです。
+type ManifestPrompt struct {
+	Phase string `json:"phase"`
+	// Name は、組み込みテンプレートの識別名、またはカスタムテンプレートのパスです。
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestModels は、実際に使用した処理モードとモデルです (指定されたモデルは Options に記録します)。
+type ManifestModels struct {
+	// Mode は、実際に使用した処理モード (mapreduce, single) です。
+	Mode string `json:"mode,omitempty"`
+	// Map と Reduce は、実際に使用したモデルです (フォールバックやルーティングの結果を含む)。
+	Map    []string `json:"map,omitempty"`
+	Reduce string   `json:"reduce,omitempty"`
+}
+
+// ManifestURL は、URLリストの1つのURLと取得結果です。
+type ManifestURL struct {
+	URL string `json:"url"`
+	// Status は、取得に成功した場合は "fetched"、失敗した場合は "failed" です。
+	Status string `json:"status"`
+	// Chars は、取得した本文の文字数です。
+	Chars int `json:"chars,omitempty"`
+	// FetchedAt は、取得した日時です (取得に失敗した場合は記録しません)。
+	FetchedAt *time.Time `json:"fetched_at,omitempty"`
+}
+
+// ManifestSegment は、Mapフェーズの1セグメント分の処理結果です。
+type ManifestSegment struct {
+	Index          int           `json:"index"`
+	URL            string        `json:"url"`
+	Model          string        `json:"model,omitempty"`
+	Route          string        `json:"route,omitempty"`
+	DirectToReduce bool          `json:"direct_to_reduce,omitempty"`
+	Skipped        bool          `json:"skipped,omitempty"`
+	Irrelevant     bool          `json:"irrelevant,omitempty"`
+	Reprompted     bool          `json:"reprompted,omitempty"`
+	FinishReason   string        `json:"finish_reason,omitempty"`
+	Recovery       string        `json:"recovery,omitempty"`
+	SkippedParts   int           `json:"skipped_parts,omitempty"`
+	Usage          ManifestUsage `json:"usage"`
+}
+
+// ManifestUsage は、トークン消費量です。
+type ManifestUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// ManifestPhase は、1つのステージの所要時間です。
+type ManifestPhase struct {
+	// Name は、ステージの識別名 (urls, fetch, cleanup, publish) です。
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// ManifestOutputs は、実行で書き出したファイルのパスです。
+type ManifestOutputs struct {
+	Document string `json:"document"`
+	Format   string `json:"format,omitempty"`
+	Manifest string `json:"manifest"`
+	// Partial は、中断時に書き出した部分結果のパスです (書き出していない場合は空)。
+	Partial string `json:"partial,omitempty"`
+	// Stream は、Reduce の生の応答を逐次書き込んだファイルのパスです (書き込んでいない場合は空)。
+	Stream string `json:"stream,omitempty"`
+}
+
+// NewRunID は、実行を識別するためのランダムなIDを生成します。
+func NewRunID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// DefaultManifestPath は、出力パスの拡張子を置き換えた実行記録のパスを返します (例: output.md → output.manifest.json)。
+// 出力パスが空 (プレビュー) または標準出力の場合は空を返します。
+func DefaultManifestPath(outputFilePath string) string {
+	return siblingPath(outputFilePath, manifestSuffix)
+}
+
+// DefaultPartialPath は、出力パスの拡張子を置き換えた部分結果のパスを返します (例: output.md → output.partial.md)。
+// 出力パスが空 (プレビュー) または標準出力の場合は空を返します。
+func DefaultPartialPath(outputFilePath string) string {
+	return siblingPath(outputFilePath, partialSuffix)
+}
+
+// DefaultStreamPath は、出力パスの拡張子を置き換えた Reduce の応答の逐次書き込み先のパスを返します (例: output.md → output.stream.md)。
+// 出力パスが空 (プレビュー) または標準出力の場合は空を返します。
+func DefaultStreamPath(outputFilePath string) string {
+	return siblingPath(outputFilePath, streamSuffix)
+}
+
+func siblingPath(outputFilePath, suffix string) string {
+	if outputFilePath == "" || outputFilePath == StdoutPath {
+		return ""
+	}
+	return strings.TrimSuffix(outputFilePath, path.Ext(outputFilePath)) + suffix
+}
+
+// NewRunManifest は、実行開始前に確定している情報 (ID、オプション、プロンプト) を記録した RunManifest を作成します。
+func NewRunManifest(opts CmdOptions, builders cleaner.PromptBuilders) *RunManifest {
+	m := &RunManifest{
+		RunID:   opts.RunID,
+		Options: newManifestOptions(opts),
+		Outputs: ManifestOutputs{
+			Document: opts.OutputFilePath,
+			Format:   opts.OutputFormat,
+			Manifest: opts.RunManifestPath,
+		},
+	}
+	if m.RunID == "" {
+		m.RunID = NewRunID()
+	}
+	for _, p := range []struct {
+		phase   string
+		builder *prompts.PromptBuilder
+	}{
+		{"map", builders.MapBuilder},
+		{"reduce", builders.ReduceBuilder},
+		{"single", builders.SinglePassBuilder},
+		{"repair", builders.RepairBuilder},
+	} {
+		if p.builder == nil {
+			continue
+		}
+		m.Prompts = append(m.Prompts, ManifestPrompt{Phase: p.phase, Name: p.builder.Name(), SHA256: p.builder.Digest()})
+	}
+	return m
+}
+
+// newManifestOptions は、APIキーを伏せた ManifestOptions を作成します。
+func newManifestOptions(opts CmdOptions) ManifestOptions {
+	apiKey := ""
+	if opts.LLMAPIKey != "" {
+		apiKey = redacted
+	}
+	return ManifestOptions{
+		LLMAPIKey:          apiKey,
+		LLMTimeout:         opts.LLMTimeout.String(),
+		ScraperTimeout:     opts.ScraperTimeout.String(),
+		URLFile:            opts.URLFile,
+		OutputFilePath:     opts.OutputFilePath,
+		OutputFormat:       opts.OutputFormat,
+		MaxScraperParallel: opts.MaxScraperParallel,
+		MapModel:           opts.MapModel,
+		ReduceModel:        opts.ReduceModel,
+		MapConcurrency:     opts.MapConcurrency,
+		MapPromptPath:      opts.MapPromptPath,
+		ReducePromptPath:   opts.ReducePromptPath,
+		Language:           opts.Language,
+		Topic:              opts.Topic,
+		Query:              opts.Query,
+		CitationPolicy:     opts.CitationPolicy,
+		CitationStyle:      opts.CitationStyle,
+		RoutingRules:       opts.RoutingRules,
+		Mode:               opts.Mode,
+		SinglePassMaxChars: opts.SinglePassMaxChars,
+		ResponsePolicy:     opts.ResponsePolicy,
+		RecordDir:          opts.RecordDir,
+		ReplayDir:          opts.ReplayDir,
+		TemplateVars:       opts.TemplateVars,
+	}
+}
+
+// recordPhase は、ステージの所要時間を記録します。
+func (m *RunManifest) recordPhase(name string, elapsed time.Duration) {
+	m.Phases = append(m.Phases, ManifestPhase{Name: name, Seconds: elapsed.Seconds()})
+}
+
+// recordURLs は、URLリストの各URLについて、取得に成功したかどうか、本文の文字数と取得日時を記録します。
+func (m *RunManifest) recordURLs(urls []string, results []extTypes.URLResult, times *cleaner.FetchTimes) {
+	fetched := make(map[string]int, len(results))
+	for _, r := range results {
+		fetched[r.URL] = len([]rune(r.Content))
+	}
+	m.URLs = make([]ManifestURL, 0, len(urls))
+	for _, u := range urls {
+		if chars, ok := fetched[u]; ok {
+			rec := ManifestURL{URL: u, Status: "fetched", Chars: chars}
+			if at, ok := times.At(u); ok {
+				rec.FetchedAt = &at
+			}
+			m.URLs = append(m.URLs, rec)
+		} else {
+			m.URLs = append(m.URLs, ManifestURL{URL: u, Status: "failed"})
+		}
+	}
+}
+
+// recordReport は、クリーンアップの診断情報からモデル、セグメント、トークン消費量を記録します。
+func (m *RunManifest) recordReport(report cleaner.Report) {
+	m.Models.Mode = report.Mode
+	m.Models.Reduce = report.Reduce.Model
+	seen := make(map[string]bool)
+	m.Segments = make([]ManifestSegment, 0, len(report.Segments))
+	for _, seg := range report.Segments {
+		if seg.Model != "" && !seen[seg.Model] {
+			seen[seg.Model] = true
+			m.Models.Map = append(m.Models.Map, seg.Model)
+		}
+		m.Segments = append(m.Segments, ManifestSegment{
+			Index:          seg.Index,
+			URL:            seg.URL,
+			Model:          seg.Model,
+			Route:          seg.Route,
+			DirectToReduce: seg.DirectToReduce,
+			Skipped:        seg.Skipped,
+			Irrelevant:     seg.Irrelevant,
+			Reprompted:     seg.Reprompted,
+			FinishReason:   seg.FinishReason,
+			Recovery:       seg.Recovery,
+			SkippedParts:   seg.SkippedParts,
+			Usage:          newManifestUsage(seg.Usage),
+		})
+	}
+	m.Usage = newManifestUsage(report.TotalUsage())
+}
+
+// finish は、実行結果と終了時刻を記録します。canceled は、実行が中断されたかどうかです。
+func (m *RunManifest) finish(err error, canceled bool) {
+	m.FinishedAt = time.Now()
+	switch {
+	case err == nil:
+		m.Status = ManifestStatusSucceeded
+	case canceled:
+		m.Status = ManifestStatusCanceled
+		m.Error = err.Error()
+	default:
+		m.Status = ManifestStatusFailed
+		m.Error = err.Error()
+	}
+}
+
+// Marshal は、実行記録をインデント付きの JSON に変換します。
+func (m *RunManifest) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("実行記録の JSON 変換に失敗しました: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+func newManifestUsage(u llm.Usage) ManifestUsage {
+	return ManifestUsage{PromptTokens: u.PromptTokens, OutputTokens: u.OutputTokens, TotalTokens: u.TotalTokens}
+}
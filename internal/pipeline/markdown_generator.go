@@ -0,0 +1,70 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// ----------------------------------------------------------------
+// 依存関係インターフェースの定義 (DIのため)
+// ----------------------------------------------------------------
+
+// ContentCleaner はLLMによるクリーンアップ処理の抽象化です。
+type ContentCleaner interface {
+	CleanAndStructureText(ctx context.Context, results []extTypes.URLResult) (*cleaner.Result, error)
+}
+
+// ----------------------------------------------------------------
+// 具象実装
+// ----------------------------------------------------------------
+
+// LLMMarkdownGeneratorImpl は MarkdownGenerator インターフェースの具象実装です。
+// LLMによるクリーンアップのみを責務とし、出力処理は Publisher に委ねます。
+type LLMMarkdownGeneratorImpl struct {
+	contentCleaner ContentCleaner
+}
+
+// NewLLMMarkdownGeneratorImpl は LLMMarkdownGeneratorImpl の新しいインスタンスを作成します。
+func NewLLMMarkdownGeneratorImpl(contentCleaner ContentCleaner) *LLMMarkdownGeneratorImpl {
+	return &LLMMarkdownGeneratorImpl{
+		contentCleaner: contentCleaner,
+	}
+}
+
+// Generate は、取得したコンテンツをLLMでクリーンアップ・構造化し、Markdownテキストと診断情報を返します。
+func (l *LLMMarkdownGeneratorImpl) Generate(ctx context.Context, opts CmdOptions, successfulResults []extTypes.URLResult) (*cleaner.Result, error) {
+	slog.InfoContext(ctx, "フェーズ2 - 抽出結果を基に、AIクリーンアップと構造化を開始します。", slog.Int("count", len(successfulResults)))
+
+	// AIクリーンアップフェーズ (LLM) (注入されたcontentCleanerを使用)
+	slog.InfoContext(ctx, "フェーズ3 - LLMによるテキストのクリーンアップと構造化を開始します (Go-AI-Client利用)。")
+
+	result, err := l.contentCleaner.CleanAndStructureText(ctx, successfulResults)
+	if err != nil {
+		return nil, fmt.Errorf("LLMクリーンアップ処理に失敗しました: %w", err)
+	}
+
+	slog.InfoContext(ctx, "LLMによる構造化が完了しました。",
+		slog.Int("markdown_len", len(result.Markdown)),
+		slog.Int("segments_with_issues", result.Report.ParseFailures()),
+		slog.Int("verified_citations", result.Report.Citations.VerifiedCount),
+		slog.Int("unknown_citations", len(result.Report.Citations.Unknown)),
+		slog.Int("uncited_sections", len(result.Report.Citations.UncitedSections)),
+		slog.Int("structure_violations", len(result.Report.Structure.Violations)),
+		slog.Bool("structure_repaired", result.Report.Structure.Repaired),
+		slog.String("mode", result.Report.Mode),
+		slog.Int("routed_segments", result.Report.RoutedSegments()),
+		slog.String("reduce_model", result.Report.Reduce.Model),
+		slog.Int("model_failures", result.Report.ModelFailures()),
+		slog.String("reduce_route", result.Report.Reduce.Route),
+		slog.Int("recovered_responses", result.Report.RecoveredResponses()),
+		slog.String("reduce_finish_reason", result.Report.Reduce.FinishReason))
+	return result, nil
+}
+
+// 型アサーションチェック
+var _ ContentCleaner = (*cleaner.Cleaner)(nil)
@@ -2,8 +2,18 @@ package pipeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+	"github.com/shouni/action-perfect-get-on-go/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ----------------------------------------------------------------
@@ -13,29 +23,244 @@ import (
 const (
 	PhaseURLs    = "URL生成フェーズ"
 	PhaseContent = "コンテンツ取得フェーズ"
-	PhaseCleanUp = "AIクリーンアップと出力フェーズ"
+	PhaseCleanUp = "AIクリーンアップフェーズ"
+	PhasePublish = "出力フェーズ"
 )
 
 // Execute はアプリケーションの主要な処理フローを、注入されたステージを通じて実行します。
 // (元の App.Execute のロジックを再構成)
-func (p *Pipeline) Execute(ctx context.Context) error {
+// 全体と各ステージの処理は、それぞれ OpenTelemetry のスパンとして記録されます。
+// Manifest が設定されている場合は、成否にかかわらず終了時に実行記録を書き出します。
+// Publisher が StreamingPublisher を実装している場合、Reduce の生の応答を生成しながら出力先へ逐次書き出します
+// (ローカルファイルは隣のファイル (DefaultStreamPath) に書き出し、出力先には検証・正規化済みの最終文書を書き出します。
+// 標準出力の場合は生の応答が最終出力となり、最終文書は再出力しません)。
+// ctx がキャンセルされた場合、実行中の LLM 呼び出しとスクレイピングは中断されます。キャンセルがクリーンアップ
+// ステージの実行中であれば、Mapフェーズで完了していたセグメントの中間要約を出力先の隣の部分結果ファイル
+// (DefaultPartialPath) に書き出します。それ以外のステージでのキャンセルや、ctx の期限切れ (DeadlineExceeded)
+// による失敗では部分結果は書き出しません (期限切れは中断ではなく失敗として実行記録に記録されます)。
+func (p *Pipeline) Execute(ctx context.Context) (err error) {
+	ctx, span := telemetry.Start(ctx, "pipeline.execute")
+	defer func() { telemetry.End(span, err) }()
+	if runID := p.runID(); runID != "" {
+		ctx = logging.With(ctx, slog.String(logging.KeyRunID, runID))
+	}
+	if p.Manifest != nil {
+		p.Manifest.StartedAt = time.Now()
+		defer func() { err = p.writeManifest(ctx, err) }()
+	}
+
 	// 1. URL生成ステージ
-	urls, err := p.URLGen.Generate(ctx, p.Options)
+	stageCtx, st := p.startStage(ctx, PhaseURLs, "urls")
+	urls, err := p.URLGen.Generate(stageCtx, p.Options)
+	p.endStage(st, err)
 	if err != nil {
 		return fmt.Errorf("%sでエラーが発生しました: %w", PhaseURLs, err)
 	}
-	slog.Info("Perfect Get On 処理を開始します。", slog.Int("target_urls", len(urls)))
+	slog.InfoContext(ctx, "Perfect Get On 処理を開始します。", slog.Int("target_urls", len(urls)))
 
-	// 2. コンテンツ取得ステージ
-	successfulResults, err := p.Fetcher.Fetch(ctx, p.Options, urls)
+	// 2. コンテンツ取得ステージ (URL ごとの取得日時は、クリーンアップで出典の取得日として参照する)
+	fetchTimes := &cleaner.FetchTimes{}
+	ctx = cleaner.WithFetchTimes(ctx, fetchTimes)
+	stageCtx, st = p.startStage(ctx, PhaseContent, "fetch")
+	successfulResults, err := p.Fetcher.Fetch(stageCtx, p.Options, urls)
+	st.span.SetAttributes(attribute.Int("urls", len(urls)), attribute.Int("fetched", len(successfulResults)))
+	if p.Manifest != nil {
+		p.Manifest.recordURLs(urls, successfulResults, fetchTimes)
+	}
+	p.endStage(st, err)
 	if err != nil {
 		return fmt.Errorf("%sでエラーが発生しました: %w", PhaseContent, err)
 	}
 
-	// 3. AIクリーンアップと出力ステージ
-	if err := p.OutputGen.Generate(ctx, p.Options, successfulResults); err != nil {
+	// 3. AIクリーンアップステージ (出力先が対応していれば Reduce の応答を逐次書き出し、プレビューの表示先があれば逐次表示する)
+	stageCtx, st = p.startStage(ctx, PhaseCleanUp, "cleanup")
+	stream, err := p.openReduceStream(stageCtx)
+	if err != nil {
+		p.endStage(st, err)
+		return fmt.Errorf("%sでエラーが発生しました: %w", PhasePublish, err)
+	}
+	partial := &cleaner.PartialResults{}
+	cleanupCtx := cleaner.WithPartialResults(stageCtx, partial)
+	if stream != nil {
+		cleanupCtx = cleaner.WithReduceStream(cleanupCtx, stream)
+	}
+	result, err := p.MarkdownGen.Generate(cleanupCtx, p.Options, successfulResults)
+	if stream != nil {
+		if closeErr := stream.close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("Reduce の応答の逐次書き込みに失敗しました: %w", closeErr)
+		}
+		if p.Manifest != nil && stream.written > 0 && p.Options.OutputFilePath != StdoutPath {
+			p.Manifest.Outputs.Stream = DefaultStreamPath(p.Options.OutputFilePath)
+		}
+	}
+	if p.Manifest != nil && result != nil {
+		p.Manifest.recordReport(result.Report)
+	}
+	p.endStage(st, err)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			p.writePartial(stageCtx, partial)
+		}
 		return fmt.Errorf("%sでエラーが発生しました: %w", PhaseCleanUp, err)
 	}
-	slog.Info("処理が正常に完了しました。")
+
+	// 4. 出力ステージ (検証・正規化済みの最終文書を書き出す)
+	stageCtx, st = p.startStage(ctx, PhasePublish, "publish")
+	if stream != nil && stream.written > 0 && p.Options.OutputFilePath == StdoutPath {
+		// 標準出力へは Reduce の生の応答を逐次出力済みのため再出力しない (検証・正規化による補正は反映されない)
+		slog.InfoContext(stageCtx, "Reduce の応答を標準出力へ逐次出力しました。検証・正規化済みの最終文書が必要な場合はファイルへ出力してください。")
+	} else if err := p.Publisher.Publish(stageCtx, p.Options, result.Markdown); err != nil {
+		p.endStage(st, err)
+		return fmt.Errorf("%sでエラーが発生しました: %w", PhasePublish, err)
+	}
+	p.endStage(st, nil)
+	slog.InfoContext(ctx, "処理が正常に完了しました。")
 	return nil
 }
+
+// reduceStream は、Reduce の応答の逐次書き込み先 (出力先とプレビューの表示先) です。
+type reduceStream struct {
+	// out は出力先への逐次書き込み、preview はプレビューの表示先です (それぞれ nil の場合は書き込まない)。
+	out     io.WriteCloser
+	preview io.Writer
+	// written は、出力先へ書き込んだバイト数です。
+	written int64
+}
+
+// openReduceStream は、Publisher が StreamingPublisher を実装していれば出力先への逐次書き込みを開き、
+// ReducePreview と合わせた書き込み先を返します。どちらもない場合は nil を返します。
+// 標準出力へ逐次出力する場合は、同じ端末に重複して表示しないようプレビューを表示しません。
+func (p *Pipeline) openReduceStream(ctx context.Context) (*reduceStream, error) {
+	s := &reduceStream{preview: p.ReducePreview}
+	if sp, ok := p.Publisher.(StreamingPublisher); ok {
+		out, err := sp.OpenStream(ctx, p.Options)
+		if err != nil {
+			return nil, err
+		}
+		s.out = out
+	}
+	if s.out != nil && p.Options.OutputFilePath == StdoutPath {
+		s.preview = nil
+	}
+	if s.out == nil && s.preview == nil {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// Write は、応答の断片を出力先とプレビューの表示先に書き込みます。プレビューの表示の失敗は無視します。
+func (s *reduceStream) Write(b []byte) (int, error) {
+	if s.out != nil {
+		n, err := s.out.Write(b)
+		s.written += int64(n)
+		if err != nil {
+			return n, err
+		}
+	}
+	if s.preview != nil {
+		_, _ = s.preview.Write(b)
+	}
+	return len(b), nil
+}
+
+// close は、プレビューの表示を終え、出力先への逐次書き込みを閉じます。
+func (s *reduceStream) close() error {
+	if f, ok := s.preview.(interface{ Finish() }); ok {
+		f.Finish()
+	}
+	if s.out == nil {
+		return nil
+	}
+	return s.out.Close()
+}
+
+// writePartial は、中断された実行で Mapフェーズに完了していたセグメントの中間要約を部分結果として書き出します。
+// 書き出しは ctx のキャンセルの影響を受けません。書き出しに失敗した場合は警告を記録します。
+func (p *Pipeline) writePartial(ctx context.Context, partial *cleaner.PartialResults) {
+	if partial.Len() == 0 {
+		slog.InfoContext(ctx, "中断されましたが、Mapフェーズで完了したセグメントがないため部分結果は書き出しません。")
+		return
+	}
+	path := DefaultPartialPath(p.Options.OutputFilePath)
+	if path == "" {
+		slog.WarnContext(ctx, "中断されましたが、出力先がファイルではないため部分結果を書き出せません。", slog.Int("segments", partial.Len()))
+		return
+	}
+	ap, ok := p.Publisher.(ArtifactPublisher)
+	if !ok {
+		slog.WarnContext(ctx, "中断されましたが、出力先が部分結果の書き出しに対応していません。")
+		return
+	}
+	if err := ap.PublishArtifact(context.WithoutCancel(ctx), path, "text/markdown; charset=utf-8", []byte(partial.Markdown())); err != nil {
+		slog.WarnContext(ctx, "部分結果の書き出しに失敗しました。", slog.String("path", path), slog.Any("error", err))
+		return
+	}
+	if p.Manifest != nil {
+		p.Manifest.Outputs.Partial = path
+	}
+	slog.WarnContext(ctx, "中断されたため、Mapフェーズで完了したセグメントの中間要約を部分結果として書き出しました。",
+		slog.String("path", path), slog.Int("segments", partial.Len()))
+}
+
+// writeManifest は、実行結果を記録した実行記録を Options.RunManifestPath へ書き出します。
+// 中断された場合も書き出せるよう、書き出しは ctx のキャンセルの影響を受けません。
+// 実行自体が失敗している場合は、書き出しの失敗を警告として記録し、実行のエラーをそのまま返します。
+func (p *Pipeline) writeManifest(ctx context.Context, runErr error) error {
+	p.Manifest.finish(runErr, errors.Is(ctx.Err(), context.Canceled))
+	writeErr := p.publishManifest(context.WithoutCancel(ctx))
+	if writeErr == nil {
+		slog.InfoContext(ctx, "実行記録を書き出しました。", slog.String("path", p.Options.RunManifestPath))
+		return runErr
+	}
+	if runErr != nil {
+		slog.WarnContext(ctx, "実行記録の書き出しに失敗しました。", slog.String("path", p.Options.RunManifestPath), slog.Any("error", writeErr))
+		return runErr
+	}
+	return fmt.Errorf("実行記録の書き出しに失敗しました: %w", writeErr)
+}
+
+func (p *Pipeline) publishManifest(ctx context.Context) error {
+	ap, ok := p.Publisher.(ArtifactPublisher)
+	if !ok {
+		return fmt.Errorf("出力先が実行記録の書き出しに対応していません")
+	}
+	data, err := p.Manifest.Marshal()
+	if err != nil {
+		return err
+	}
+	return ap.PublishArtifact(ctx, p.Options.RunManifestPath, "application/json", data)
+}
+
+// runID は、ログに付与する実行IDを返します (実行記録が有効な場合は実行記録のID)。
+func (p *Pipeline) runID() string {
+	if p.Manifest != nil {
+		return p.Manifest.RunID
+	}
+	return p.Options.RunID
+}
+
+// stage は、実行中のステージのスパンと開始時刻です。
+type stage struct {
+	name  string
+	span  trace.Span
+	start time.Time
+}
+
+// startStage は、OnPhase が設定されている場合にフェーズの開始を通知し、ステージのスパン (pipeline.<name>) を開始します。
+// 返す context には、ステージ名をログの phase として設定します。
+func (p *Pipeline) startStage(ctx context.Context, phase, name string) (context.Context, stage) {
+	if p.OnPhase != nil {
+		p.OnPhase(phase)
+	}
+	ctx = logging.With(ctx, slog.String(logging.KeyPhase, name))
+	ctx, span := telemetry.Start(ctx, "pipeline."+name)
+	return ctx, stage{name: name, span: span, start: time.Now()}
+}
+
+// endStage は、ステージのスパンを終了し、実行記録が有効な場合は所要時間を記録します。
+func (p *Pipeline) endStage(st stage, err error) {
+	if p.Manifest != nil {
+		p.Manifest.recordPhase(st.name, time.Since(st.start))
+	}
+	telemetry.End(st.span, err)
+}
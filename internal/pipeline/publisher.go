@@ -0,0 +1,332 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shouni/go-remote-io/pkg/remoteio"
+	"github.com/shouni/go-utils/iohandler"
+)
+
+const (
+	previewLines = 10
+)
+
+// StdoutPath は、最終結果の全文を標準出力に書き出すことを示す出力パスです。
+const StdoutPath = "-"
+
+// 出力形式の定義
+const (
+	// FormatAuto は出力先から形式を自動判定します (GCS または .html/.htm は HTML、それ以外は Markdown)。
+	FormatAuto = "auto"
+	// FormatMarkdown は Markdown をそのまま出力します。
+	FormatMarkdown = "markdown"
+	// FormatHTML は Markdown を完全なHTMLドキュメントに変換して出力します。
+	FormatHTML = "html"
+)
+
+// ----------------------------------------------------------------
+// 依存関係インターフェースの定義 (DIのため)
+// ----------------------------------------------------------------
+
+// MdToHtmlRunner は、github.com/shouni/go-text-format/pkg/runner.MarkdownToHtmlRunner インターフェースと一致するよう定義します。
+type MdToHtmlRunner interface {
+	Run(ctx context.Context, title string, markdown []byte) (*bytes.Buffer, error)
+}
+
+// ----------------------------------------------------------------
+// 具象実装
+// ----------------------------------------------------------------
+
+// UniversalPublisherImpl は Publisher インターフェースの具象実装です。
+// 出力先 (GCS/ローカル/標準出力) と出力形式 (Markdown/HTML) の振り分けを担当します。
+type UniversalPublisherImpl struct {
+	universalWriter Writer
+	htmlRunner      MdToHtmlRunner
+}
+
+// NewUniversalPublisherImpl は UniversalPublisherImpl の新しいインスタンスを作成します。
+func NewUniversalPublisherImpl(writer Writer, htmlRunner MdToHtmlRunner) *UniversalPublisherImpl {
+	return &UniversalPublisherImpl{
+		universalWriter: writer,
+		htmlRunner:      htmlRunner,
+	}
+}
+
+// Publish は、構造化済みのMarkdownを opts.OutputFilePath と opts.OutputFormat に従って出力します。
+// GCS出力が指定された場合、ローカル出力はスキップされます。
+func (p *UniversalPublisherImpl) Publish(ctx context.Context, opts CmdOptions, markdown string) error {
+	outputFilePath := opts.OutputFilePath
+
+	// 出力先が空の場合は標準出力へのプレビューのみを行う
+	if outputFilePath == "" {
+		if err := p.outputPreview(markdown); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "標準出力へのプレビューが完了しました。")
+		return nil
+	}
+
+	format, err := ResolveOutputFormat(outputFilePath, opts.OutputFormat)
+	if err != nil {
+		return err
+	}
+
+	content, contentType, err := p.render(ctx, format, markdown)
+	if err != nil {
+		return err
+	}
+
+	if outputFilePath == StdoutPath {
+		if err := iohandler.WriteOutput("", content); err != nil {
+			return fmt.Errorf("標準出力への最終結果の出力に失敗しました: %w", err)
+		}
+		slog.InfoContext(ctx, "標準出力への出力が完了しました。", slog.String("format", format))
+		return nil
+	}
+
+	if remoteio.IsGCSURI(outputFilePath) {
+		// GCSへの出力パス
+		bucket, path, err := remoteio.ParseGCSURI(outputFilePath)
+		if err != nil {
+			return fmt.Errorf("GCS URIのパースに失敗しました: %w", err)
+		}
+
+		if err := p.writeToGCS(ctx, bucket, path, bytes.NewReader(content), contentType); err != nil {
+			return fmt.Errorf("GCSへの最終結果の出力に失敗しました: %w", err)
+		}
+
+		// GCSへの書き込みが完了したら、ローカル出力/標準出力の処理をスキップして終了
+		slog.InfoContext(ctx, "GCSへの出力が完了しました。", slog.String("uri", outputFilePath), slog.String("format", format))
+		return nil
+	}
+
+	if err := p.writeToLocal(ctx, outputFilePath, bytes.NewReader(content)); err != nil {
+		return fmt.Errorf("ローカルファイルへの最終結果の出力に失敗しました: %w", err)
+	}
+	slog.InfoContext(ctx, "ローカルファイルへの出力が完了しました。", slog.String("file", outputFilePath), slog.String("format", format))
+
+	return nil
+}
+
+// OpenStream は、Reduce の応答を生成中に逐次書き出すための Writer を返します。
+// 標準出力 (StdoutPath) に Markdown を出力する場合は標準出力へ、ローカルファイルに出力する場合は出力先の隣のファイル
+// (DefaultStreamPath) へ、フォールバックや再試行の区切りを含む生の応答をそのまま書き出します。
+// ローカルファイルの出力先には、Publish で検証・正規化済みの最終文書を書き出します。
+// プレビュー (出力先が空) と GCS の場合は nil を返します。
+func (p *UniversalPublisherImpl) OpenStream(ctx context.Context, opts CmdOptions) (io.WriteCloser, error) {
+	outputFilePath := opts.OutputFilePath
+	if outputFilePath == "" || remoteio.IsGCSURI(outputFilePath) {
+		return nil, nil
+	}
+	if outputFilePath == StdoutPath {
+		format, err := ResolveOutputFormat(outputFilePath, opts.OutputFormat)
+		if err != nil {
+			return nil, err
+		}
+		if format != FormatMarkdown {
+			return nil, nil
+		}
+		return nopWriteCloser{Writer: os.Stdout}, nil
+	}
+
+	localWriter, ok := p.universalWriter.(remoteio.LocalOutputWriter)
+	if !ok {
+		return nil, fmt.Errorf("内部エラー: 注入された Writer は LocalOutputWriter インターフェースを満たしていません")
+	}
+	path := DefaultStreamPath(outputFilePath)
+	slog.InfoContext(ctx, "Reduce の応答を生成しながらファイルへ逐次書き込みます。検証・正規化済みの最終文書は完了後に出力先へ書き出します。",
+		slog.String("file", path), slog.String("output", outputFilePath))
+	// 中断された場合もそれまでの応答を残せるよう、書き込みは ctx のキャンセルの影響を受けない
+	return &localStream{ctx: context.WithoutCancel(ctx), writer: localWriter, path: path}, nil
+}
+
+// nopWriteCloser は、Close で何もしない io.WriteCloser です (標準出力用)。
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// localStream は、LocalOutputWriter へパイプを介して逐次書き込む io.WriteCloser です。
+// Writer は io.Reader からの書き込みのみを受け付けるため、最初の Write でパイプと書き込み処理を開始します。
+// 一度も書き込まれなかった場合 (Reduce に到達する前の失敗など)、ファイルは作成されません。
+type localStream struct {
+	ctx    context.Context
+	writer remoteio.LocalOutputWriter
+	path   string
+	pw     *io.PipeWriter
+	done   chan error
+}
+
+func (s *localStream) Write(b []byte) (int, error) {
+	if s.pw == nil {
+		pr, pw := io.Pipe()
+		s.pw = pw
+		s.done = make(chan error, 1)
+		go func() {
+			err := s.writer.WriteToLocal(s.ctx, s.path, pr)
+			pr.CloseWithError(err)
+			s.done <- err
+		}()
+	}
+	return s.pw.Write(b)
+}
+
+// Close は、パイプを閉じて書き込み処理の完了を待ちます。
+func (s *localStream) Close() error {
+	if s.pw == nil {
+		return nil
+	}
+	if err := s.pw.Close(); err != nil {
+		return err
+	}
+	return <-s.done
+}
+
+// PublishArtifact は、実行記録や部分結果などの成果物を path (ローカルファイルまたはGCS URI) へ書き出します。
+func (p *UniversalPublisherImpl) PublishArtifact(ctx context.Context, path string, contentType string, data []byte) error {
+	if remoteio.IsGCSURI(path) {
+		bucket, object, err := remoteio.ParseGCSURI(path)
+		if err != nil {
+			return fmt.Errorf("GCS URIのパースに失敗しました: %w", err)
+		}
+		gcsWriter, ok := p.universalWriter.(remoteio.GCSOutputWriter)
+		if !ok {
+			return fmt.Errorf("内部エラー: 注入された Writer は GCSOutputWriter インターフェースを満たしていません")
+		}
+		if err := gcsWriter.WriteToGCS(ctx, bucket, object, bytes.NewReader(data), contentType); err != nil {
+			return fmt.Errorf("GCSバケット '%s' パス '%s' への書き込みに失敗しました: %w", bucket, object, err)
+		}
+		return nil
+	}
+	localWriter, ok := p.universalWriter.(remoteio.LocalOutputWriter)
+	if !ok {
+		return fmt.Errorf("内部エラー: 注入された Writer は LocalOutputWriter インターフェースを満たしていません")
+	}
+	if err := localWriter.WriteToLocal(ctx, path, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("ローカルファイル '%s' への書き込みに失敗しました: %w", path, err)
+	}
+	return nil
+}
+
+// ResolveOutputFormat は、出力パスと指定された形式から実際の出力形式を決定します。
+func ResolveOutputFormat(outputFilePath, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case FormatMarkdown, "md":
+		return FormatMarkdown, nil
+	case FormatHTML:
+		return FormatHTML, nil
+	case FormatAuto, "":
+		// GCSへの出力は従来通りHTMLドキュメントとして扱う
+		if remoteio.IsGCSURI(outputFilePath) {
+			return FormatHTML, nil
+		}
+		switch strings.ToLower(filepath.Ext(outputFilePath)) {
+		case ".html", ".htm":
+			return FormatHTML, nil
+		}
+		return FormatMarkdown, nil
+	default:
+		return "", fmt.Errorf("未対応の出力形式です: %q (auto, markdown, html のいずれかを指定してください)", format)
+	}
+}
+
+// render は、出力形式に応じてコンテンツとContent-Typeを生成します。
+func (p *UniversalPublisherImpl) render(ctx context.Context, format, markdown string) ([]byte, string, error) {
+	if format != FormatHTML {
+		return []byte(markdown), "text/markdown; charset=utf-8", nil
+	}
+
+	slog.InfoContext(ctx, "MarkdownをHTMLドキュメントに変換します。")
+	htmlBuffer, err := p.htmlRunner.Run(ctx, "", []byte(markdown))
+	if err != nil {
+		return nil, "", fmt.Errorf("MarkdownからHTMLへの変換に失敗しました: %w", err)
+	}
+	return htmlBuffer.Bytes(), "text/html; charset=utf-8", nil
+}
+
+// writeToGCS は、注入されたWriterを使ってGCSへ内容を書き出します。
+func (p *UniversalPublisherImpl) writeToGCS(ctx context.Context, bucket, path string, contentReader io.Reader, contentType string) error {
+	slog.InfoContext(ctx, "最終生成結果をGCSに書き込みます", slog.String("bucket", bucket), slog.String("path", path))
+
+	// 注入された Writer が remoteio.GCSOutputWriter を満たすことを確認
+	gcsWriter, ok := p.universalWriter.(remoteio.GCSOutputWriter)
+	if !ok {
+		return fmt.Errorf("内部エラー: 注入された Writer は GCSOutputWriter インターフェースを満たしていません")
+	}
+
+	if err := gcsWriter.WriteToGCS(ctx, bucket, path, contentReader, contentType); err != nil {
+		return fmt.Errorf("GCSバケット '%s' パス '%s' への書き込みに失敗しました: %w", bucket, path, err)
+	}
+
+	slog.InfoContext(ctx, "最終生成完了 - GCSに書き込みました", slog.String("uri", fmt.Sprintf("gs://%s/%s", bucket, path)))
+	return nil
+}
+
+// writeToLocal ローカルファイルへの書き込み
+func (p *UniversalPublisherImpl) writeToLocal(ctx context.Context, path string, contentReader io.Reader) error {
+	slog.InfoContext(ctx, "最終生成結果をローカルファイルに書き込みます", slog.String("path", path))
+
+	// 注入された Writer が remoteio.LocalOutputWriter を満たすことを確認
+	localWriter, ok := p.universalWriter.(remoteio.LocalOutputWriter)
+	if !ok {
+		return fmt.Errorf("内部エラー: 注入された Writer は LocalOutputWriter インターフェースを満たしていません")
+	}
+
+	if err := localWriter.WriteToLocal(ctx, path, contentReader); err != nil {
+		return fmt.Errorf("ローカルファイル '%s' への書き込みに失敗しました: %w", path, err)
+	}
+
+	slog.InfoContext(ctx, "最終生成完了 - ローカルファイルに書き込みました", slog.String("file", path))
+	return nil
+}
+
+// outputPreview は、標準出力にプレビューを書き出します。
+func (p *UniversalPublisherImpl) outputPreview(content string) error {
+	// 標準出力にファイルの冒頭10行を表示
+	lines := strings.Split(content, "\n")
+	previewContent := ""
+	if len(lines) > 0 {
+		// 最初の10行（または行数全て）を抽出
+		end := previewLines
+		if len(lines) < previewLines {
+			end = len(lines)
+		}
+		previewContent = strings.Join(lines[:end], "\n")
+	}
+
+	slog.Info("最終生成結果を標準出力にプレビュー表示します (冒頭10行)。")
+	// iohandler.WriteOutputString は string を受け取るため、ここではそのまま利用
+	return iohandler.WriteOutputString("", previewContent)
+}
+
+// ReadMarkdown は、InputReader を使って既存のMarkdownファイル (ローカル/GCS) を読み込みます。
+// publish サブコマンドが、生成済みの結果を再出力する際に使用します。
+func ReadMarkdown(ctx context.Context, reader InputReader, path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("入力Markdownファイルのパスを指定してください")
+	}
+
+	rc, err := reader.Open(ctx, path)
+	if err != nil {
+		return "", fmt.Errorf("入力Markdownファイルのオープンに失敗しました: %w", err)
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return "", fmt.Errorf("入力Markdownファイルの読み込みに失敗しました: %w", err)
+	}
+
+	if strings.TrimSpace(string(content)) == "" {
+		return "", fmt.Errorf("入力Markdownファイルが空です: %s", path)
+	}
+	return string(content), nil
+}
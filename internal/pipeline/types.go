@@ -5,6 +5,8 @@ import (
 	"io"
 	"time"
 
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+
 	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
 )
 
@@ -19,9 +21,32 @@ type CmdOptions struct {
 	ScraperTimeout     time.Duration
 	URLFile            string
 	OutputFilePath     string
+	OutputFormat       string
 	MaxScraperParallel int
 	MapModel           string
 	ReduceModel        string
+	MapConcurrency     int
+	MapPromptPath      string
+	ReducePromptPath   string
+	Language           string
+	Topic              string
+	Query              string
+	CitationPolicy     string
+	CitationStyle      string
+	RoutingRules       []cleaner.RoutingRule
+	Mode               string
+	SinglePassMaxChars int
+	// ResponsePolicy は、ブロック・途中終了・空の応答に適用するアクションの順序です。
+	ResponsePolicy []string
+	// RecordDir は、スクレイピング結果と LLM のリクエスト・レスポンスを記録するカセットのディレクトリです (空の場合は記録しない)。
+	RecordDir string
+	// ReplayDir は、ネットワークにアクセスせずに再生するカセットのディレクトリです (空の場合は再生しない)。
+	ReplayDir    string
+	TemplateVars map[string]string
+	// RunID は、実行を識別するIDです。実行記録に記録されます (空の場合は自動生成します)。
+	RunID string
+	// RunManifestPath は、実行記録 (JSON) の書き出し先です (空の場合は書き出さない)。
+	RunManifestPath string
 }
 
 // ----------------------------------------------------------------
@@ -40,10 +65,23 @@ type ContentFetcher interface {
 	Fetch(ctx context.Context, opts CmdOptions, urls []string) ([]extTypes.URLResult, error)
 }
 
-// OutputGenerator は、取得したコンテンツをクリーンアップし、ファイルに出力するステージの契約です。
-type OutputGenerator interface {
-	// Generate はコンテンツを結合し、LLMで構造化し、最終結果をファイルに出力します。
-	Generate(ctx context.Context, opts CmdOptions, results []extTypes.URLResult) error
+// MarkdownGenerator は、取得したコンテンツをクリーンアップし、構造化Markdownを生成するステージの契約です。
+type MarkdownGenerator interface {
+	// Generate はコンテンツを結合し、LLMで構造化した最終Markdownと診断情報を返します。
+	Generate(ctx context.Context, opts CmdOptions, results []extTypes.URLResult) (*cleaner.Result, error)
+}
+
+// Publisher は、構造化済みのMarkdownを出力先 (ローカル/GCS/標準出力) へ書き出すステージの契約です。
+type Publisher interface {
+	// Publish は opts.OutputFilePath と opts.OutputFormat に従ってMarkdownを出力します。
+	Publish(ctx context.Context, opts CmdOptions, markdown string) error
+}
+
+// StreamingPublisher は、Reduce の応答を生成中に出力先へ逐次書き出せる Publisher です。
+type StreamingPublisher interface {
+	// OpenStream は、Reduce の生の応答の逐次書き込み先を返します。
+	// 出力先が逐次書き込みに対応していない場合は nil を返します。
+	OpenStream(ctx context.Context, opts CmdOptions) (io.WriteCloser, error)
 }
 
 // ScraperRunner は並列スクレイピングを実行する外部依存の抽象化です。
@@ -74,9 +112,19 @@ type Pipeline struct {
 	// Options はパイプライン実行全体で必要な設定値を保持します。
 	Options CmdOptions
 	// DIされるステージ実装
-	URLGen    URLGenerator
-	Fetcher   ContentFetcher
-	OutputGen OutputGenerator
+	URLGen      URLGenerator
+	Fetcher     ContentFetcher
+	MarkdownGen MarkdownGenerator
+	Publisher   Publisher
+	// OnPhase は、各ステージの開始時にフェーズ名 (PhaseURLs など) を受け取る任意のコールバックです。
+	// サーバーモードでのジョブの進捗表示に使用します (nil の場合は呼び出しません)。
+	OnPhase func(phase string)
+	// Manifest は、実行記録です。設定されている場合、Execute は実行の終了時に
+	// Options.RunManifestPath へ書き出します (nil の場合は記録しません)。
+	Manifest *RunManifest
+	// ReducePreview は、Reduce の応答を生成中に逐次表示する書き込み先です (nil の場合は表示しません)。
+	// 表示は経過の確認用です。Reduce の応答を標準出力へ逐次出力する場合は表示しません。
+	ReducePreview io.Writer
 }
 
 // NewPipeline は CmdOptions とステージの具象実装を受け取り、Pipelineインスタンスを構築します。
@@ -84,12 +132,14 @@ func NewPipeline(
 	opts CmdOptions,
 	urlGen URLGenerator,
 	fetcher ContentFetcher,
-	outputGen OutputGenerator,
+	markdownGen MarkdownGenerator,
+	publisher Publisher,
 ) *Pipeline {
 	return &Pipeline{
-		Options:   opts,
-		URLGen:    urlGen,
-		Fetcher:   fetcher,
-		OutputGen: outputGen,
+		Options:     opts,
+		URLGen:      urlGen,
+		Fetcher:     fetcher,
+		MarkdownGen: markdownGen,
+		Publisher:   publisher,
 	}
 }
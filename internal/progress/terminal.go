@@ -0,0 +1,123 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+)
+
+// Terminal は、Mapフェーズの進捗 (完了セグメント数/総数、経過時間、残り時間の見込み) を
+// 端末の1行に上書き表示します。cleaner.MapProgress インターフェースを満たします。
+type Terminal struct {
+	mu      sync.Mutex
+	out     io.Writer
+	label   string
+	total   int
+	done    int
+	started time.Time
+	now     func() time.Time
+}
+
+// NewTerminal は、out に進捗を表示する Terminal を作成します。
+func NewTerminal(out io.Writer, label string) *Terminal {
+	return &Terminal{out: out, label: label, now: time.Now}
+}
+
+// IsTerminal は、f が端末 (キャラクターデバイス) に接続されているかどうかを返します。
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Interactive は、標準エラー出力に進捗やプレビューを表示してよいかどうかを返します。
+// 標準エラー出力が端末でない場合と、ログの出力形式が json の場合 (ログの行と混ざるため) は false です。
+func Interactive() bool {
+	return IsTerminal(os.Stderr) && !logging.IsJSON()
+}
+
+// Start は、総数 total で進捗表示を開始します。
+func (t *Terminal) Start(total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total = total
+	t.done = 0
+	t.started = t.now()
+	t.render()
+}
+
+// Advance は、完了数を1つ進めて表示を更新します。
+func (t *Terminal) Advance() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done++
+	t.render()
+}
+
+// Finish は、進捗表示の行を確定して改行します。
+func (t *Terminal) Finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.render()
+	fmt.Fprintln(t.out)
+}
+
+// render は、現在の進捗で表示行を書き換えます。呼び出し側でロックを保持している必要があります。
+func (t *Terminal) render() {
+	elapsed := t.now().Sub(t.started).Round(time.Second)
+	percent := 0
+	if t.total > 0 {
+		percent = t.done * 100 / t.total
+	}
+
+	eta := "--"
+	if t.done > 0 && t.done < t.total {
+		remaining := elapsed / time.Duration(t.done) * time.Duration(t.total-t.done)
+		eta = remaining.Round(time.Second).String()
+	} else if t.done >= t.total {
+		eta = "0s"
+	}
+
+	// \r で行頭に戻り、\033[K で行末までを消去してから書き直す
+	fmt.Fprintf(t.out, "\r\033[K%s: %d/%d セグメント完了 (%d%%) 経過 %s 残り約 %s",
+		t.label, t.done, t.total, percent, elapsed, eta)
+}
+
+// Preview は、生成中の応答を逐次表示する io.Writer です。最初の書き込みの前に見出しの行を表示します。
+type Preview struct {
+	mu      sync.Mutex
+	out     io.Writer
+	label   string
+	started bool
+}
+
+// NewPreview は、out に応答を逐次表示する Preview を作成します。
+func NewPreview(out io.Writer, label string) *Preview {
+	return &Preview{out: out, label: label}
+}
+
+// Write は、応答の断片をそのまま表示します。
+func (p *Preview) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.started {
+		p.started = true
+		fmt.Fprintf(p.out, "--- %s のプレビュー (最終文書は検証・正規化の後に出力先へ書き出されます) ---\n", p.label)
+	}
+	return p.out.Write(b)
+}
+
+// Finish は、プレビューを表示していた場合に改行して表示を終えます。
+func (p *Preview) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.started {
+		fmt.Fprintln(p.out)
+	}
+}
@@ -1,7 +1,9 @@
 package prompts
 
 import (
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"text/template"
@@ -13,19 +15,84 @@ var MapSegmentPromptTemplate string
 //go:embed reduce_final_prompt.md
 var ReduceFinalPromptTemplate string
 
+//go:embed single_pass_prompt.md
+var SinglePassPromptTemplate string
+
+//go:embed reduce_repair_prompt.md
+var ReduceRepairPromptTemplate string
+
+// NoRelevantInfoMarker は、クエリ指定時に Map がセグメント内に関連情報を見つけられなかったことを示すマーカーです。
+// 出力言語に依存しないよう、記号的なトークンとして定義しています。関連性の判定には Map 応答の関連性フィールド
+// ([RELEVANT: yes|no]) を使用し、このマーカーは関連性フィールドに対応していないカスタムテンプレートのために、
+// 本文全体がマーカーの場合のみ「関連情報なし」とみなします。
+const NoRelevantInfoMarker = "<NO_RELEVANT_INFO>"
+
 // ----------------------------------------------------------------
 // テンプレート構造体
 // ----------------------------------------------------------------
 
+// CommonTemplateData は Map/Reduce の両フェーズで共通に参照できるテンプレート変数です。
+// MapTemplateData と ReduceTemplateData に埋め込まれるため、テンプレートからは {{.Language}} のように参照できます。
+type CommonTemplateData struct {
+	// Language は文書を記述する言語の表示名です (例: "日本語", "英語 (English)")。
+	Language string
+	// Topic はユーザーが指定した文書のトピックです (未指定の場合は空)。
+	Topic string
+	// Query はユーザーが指定した調査クエリです。指定された場合、Map は関連情報のみを抽出し、
+	// Reduce はクエリへの回答を中心とした文書を生成します。
+	Query string
+	// NoRelevantInfoMarker は、Map がクエリに関連する情報を見つけられなかった場合に本文として出力できるマーカーです。
+	NoRelevantInfoMarker string
+	// AnswerSummaryHeading は、クエリ指定時に最終文書の冒頭に置く「回答の要約」セクションの、出力言語での見出しです。
+	AnswerSummaryHeading string
+	// FootnoteCitations は、脚注 ([^N]) による出典表記モードが有効かどうかです。
+	FootnoteCitations bool
+	// FetchDate はWebコンテンツを取得した日付 (YYYY-MM-DD) です。Map ではセグメントの由来となったソースの取得日、
+	// それ以外のフェーズではソースの取得日のうち最も新しい日付です。
+	FetchDate string
+	// SourceURLs は、取得に成功したすべてのソースURLです (入力順)。
+	SourceURLs []string
+	// Vars は --var key=value で指定された任意のユーザー変数です。{{.Vars.key}} で参照します。
+	Vars map[string]string
+}
+
 type MapTemplateData struct {
+	CommonTemplateData
 	SegmentText string
 	SourceURL   string
+	// SourceTitle は、セグメントの由来となったページのタイトルです (取得できない場合は空)。
+	SourceTitle string
+	// SourceID は、ソースごとに固定された1始まりの出典IDです (脚注マーカー [^N] の番号)。
+	SourceID int
+	// SegmentIndex と SegmentTotal は、全セグメント中の位置 (1始まり) と総数です。
+	SegmentIndex int
+	SegmentTotal int
 }
 
 type ReduceTemplateData struct {
+	CommonTemplateData
 	CombinedText string
 }
 
+// RepairTemplateData は、Reduce 出力の構造修復プロンプトに渡すデータです。
+type RepairTemplateData struct {
+	CommonTemplateData
+	// Document は修復対象の最終文書です。
+	Document string
+	// Violations は、検出された構造ルール違反の説明です。
+	Violations []string
+}
+
+// sampleCommonData は、カスタムテンプレート検証時のドライランに使用する共通データです。
+var sampleCommonData = CommonTemplateData{
+	Language:             LanguageName(DefaultLanguage),
+	NoRelevantInfoMarker: NoRelevantInfoMarker,
+	AnswerSummaryHeading: AnswerSummaryHeading(DefaultLanguage),
+	FetchDate:            "2006-01-02",
+	SourceURLs:           []string{"https://example.com/"},
+	Vars:                 map[string]string{},
+}
+
 // ----------------------------------------------------------------
 // ビルダー実装
 // ----------------------------------------------------------------
@@ -34,20 +101,86 @@ type ReduceTemplateData struct {
 type PromptBuilder struct {
 	tmpl *template.Template
 	err  error
+	// digest は、テンプレート本文の SHA-256 です (実行記録で使用したテンプレートを特定するために使用します)。
+	digest string
+}
+
+// newPromptBuilder は、テンプレート本文のダイジェストを記録した PromptBuilder を作成します。
+func newPromptBuilder(tmpl *template.Template, text string, err error) *PromptBuilder {
+	sum := sha256.Sum256([]byte(text))
+	return &PromptBuilder{tmpl: tmpl, err: err, digest: hex.EncodeToString(sum[:])}
 }
 
 // NewMapPromptBuilder は Mapフェーズ用の PromptBuilder を初期化します。
 // パースに失敗した場合は、内部にエラーを保持したPromptBuilderを返します。
 func NewMapPromptBuilder() *PromptBuilder {
 	tmpl, err := template.New("map_segment").Parse(MapSegmentPromptTemplate)
-	return &PromptBuilder{tmpl: tmpl, err: err}
+	return newPromptBuilder(tmpl, MapSegmentPromptTemplate, err)
 }
 
 // NewReducePromptBuilder は Reduceフェーズ用の PromptBuilder を初期化します。
 // パースに失敗した場合は、内部にエラーを保持したPromptBuilderを返します。
 func NewReducePromptBuilder() *PromptBuilder {
 	tmpl, err := template.New("reduce_final").Parse(ReduceFinalPromptTemplate)
-	return &PromptBuilder{tmpl: tmpl, err: err}
+	return newPromptBuilder(tmpl, ReduceFinalPromptTemplate, err)
+}
+
+// NewSinglePassPromptBuilder は、Map を行わずに未加工のソーステキストから最終文書を生成する
+// 単一パス用の PromptBuilder を初期化します。データには ReduceTemplateData を使用し、
+// CombinedText に全ソースのテキストを渡します。
+func NewSinglePassPromptBuilder() *PromptBuilder {
+	tmpl, err := template.New("single_pass").Parse(SinglePassPromptTemplate)
+	return newPromptBuilder(tmpl, SinglePassPromptTemplate, err)
+}
+
+// NewRepairPromptBuilder は Reduce 出力の構造修復用の PromptBuilder を初期化します。
+// パースに失敗した場合は、内部にエラーを保持したPromptBuilderを返します。
+func NewRepairPromptBuilder() *PromptBuilder {
+	tmpl, err := template.New("reduce_repair").Parse(ReduceRepairPromptTemplate)
+	return newPromptBuilder(tmpl, ReduceRepairPromptTemplate, err)
+}
+
+// NewMapPromptBuilderFromTemplate は、ユーザー定義のテンプレート文字列から Mapフェーズ用の PromptBuilder を初期化します。
+// テンプレートが MapTemplateData に存在しないフィールドを参照している場合や、
+// {{.SegmentText}} を参照していない場合は、内部にエラーを保持したPromptBuilderを返します。
+func NewMapPromptBuilderFromTemplate(name, text string) *PromptBuilder {
+	tmpl, err := template.New(name).Parse(text)
+	if err == nil {
+		err = validateTemplate(tmpl, MapTemplateData{
+			CommonTemplateData: sampleCommonData,
+			SegmentText:        "sample",
+			SourceURL:          "https://example.com/",
+			SourceTitle:        "sample",
+			SourceID:           1,
+			SegmentIndex:       1,
+			SegmentTotal:       1,
+		}, "SegmentText")
+	}
+	return newPromptBuilder(tmpl, text, err)
+}
+
+// NewReducePromptBuilderFromTemplate は、ユーザー定義のテンプレート文字列から Reduceフェーズ用の PromptBuilder を初期化します。
+// テンプレートが ReduceTemplateData に存在しないフィールドを参照している場合や、
+// {{.CombinedText}} を参照していない場合は、内部にエラーを保持したPromptBuilderを返します。
+func NewReducePromptBuilderFromTemplate(name, text string) *PromptBuilder {
+	tmpl, err := template.New(name).Parse(text)
+	if err == nil {
+		err = validateTemplate(tmpl, ReduceTemplateData{CommonTemplateData: sampleCommonData, CombinedText: "sample"}, "CombinedText")
+	}
+	return newPromptBuilder(tmpl, text, err)
+}
+
+// Name は、テンプレート名 (組み込みテンプレートの識別名、またはカスタムテンプレートのパス) を返します。
+func (b *PromptBuilder) Name() string {
+	if b.tmpl == nil {
+		return ""
+	}
+	return b.tmpl.Name()
+}
+
+// Digest は、テンプレート本文の SHA-256 (16進数) を返します。
+func (b *PromptBuilder) Digest() string {
+	return b.digest
 }
 
 // Err は PromptBuilder の初期化（テンプレートパース）時に発生したエラーを返します。
@@ -90,3 +223,21 @@ func (b *PromptBuilder) BuildReduce(data ReduceTemplateData) (string, error) {
 
 	return sb.String(), nil
 }
+
+// BuildRepair は RepairTemplateData を埋め込み、構造修復プロンプト文字列を完成させます。
+func (b *PromptBuilder) BuildRepair(data RepairTemplateData) (string, error) {
+	if b.tmpl == nil || b.err != nil {
+		return "", fmt.Errorf("Repair prompt template is not properly initialized: %w", b.err)
+	}
+
+	var sb strings.Builder
+	if err := b.tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("修復プロンプトの実行に失敗しました: %w", err)
+	}
+
+	if data.Document == "" {
+		return "", fmt.Errorf("修復プロンプト実行失敗: Documentが空です (template: %s)", b.tmpl.Name())
+	}
+
+	return sb.String(), nil
+}
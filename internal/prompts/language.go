@@ -0,0 +1,96 @@
+package prompts
+
+import "strings"
+
+// DefaultLanguage は、出力言語が指定されなかった場合に使用する言語コードです。
+const DefaultLanguage = "ja"
+
+// languageNames は、主要な言語コードとプロンプトに埋め込む表示名の対応表です。
+// プロンプト本文が日本語のため、日本語の名称に原語表記を併記しています。
+var languageNames = map[string]string{
+	"ja": "日本語",
+	"en": "英語 (English)",
+	"zh": "中国語 (简体中文)",
+	"ko": "韓国語 (한국어)",
+	"fr": "フランス語 (Français)",
+	"de": "ドイツ語 (Deutsch)",
+	"es": "スペイン語 (Español)",
+	"pt": "ポルトガル語 (Português)",
+	"it": "イタリア語 (Italiano)",
+}
+
+// documentLabels は、最終文書に機械的に挿入する見出しやラベルの、1言語分の表記です。
+type documentLabels struct {
+	// AnswerSummary は、クエリ指定時に最終文書の冒頭に置く「回答の要約」セクションの見出しです。
+	AnswerSummary string
+	// References と Accessed は、脚注形式の出典で文末に付与する参考文献セクションの見出しと、取得日のラベルです。
+	References string
+	Accessed   string
+}
+
+// labelsByLanguage は、言語コードごとの documentLabels です。
+var labelsByLanguage = map[string]documentLabels{
+	"ja": {AnswerSummary: "回答の要約", References: "参考文献", Accessed: "取得日"},
+	"en": {AnswerSummary: "Answer Summary", References: "References", Accessed: "accessed"},
+	"zh": {AnswerSummary: "回答摘要", References: "参考文献", Accessed: "访问日期"},
+	"ko": {AnswerSummary: "답변 요약", References: "참고 문헌", Accessed: "접속일"},
+	"fr": {AnswerSummary: "Résumé de la réponse", References: "Références", Accessed: "consulté"},
+	"de": {AnswerSummary: "Zusammenfassung der Antwort", References: "Quellen", Accessed: "abgerufen"},
+	"es": {AnswerSummary: "Resumen de la respuesta", References: "Referencias", Accessed: "consultado"},
+	"pt": {AnswerSummary: "Resumo da resposta", References: "Referências", Accessed: "acessado"},
+	"it": {AnswerSummary: "Riepilogo della risposta", References: "Riferimenti", Accessed: "consultato"},
+}
+
+// LanguageName は、言語コード (例: "en", "en-US") をプロンプトに埋め込む表示名に変換します。
+// 対応表にない値は、自由記述の言語名 (例: "Tiếng Việt") とみなしてそのまま返します。
+func LanguageName(lang string) string {
+	lang = strings.TrimSpace(lang)
+	if lang == "" {
+		lang = DefaultLanguage
+	}
+
+	if name, ok := languageNames[LanguageCode(lang)]; ok {
+		return name
+	}
+	return lang
+}
+
+// AnswerSummaryHeading は、出力言語に応じた「回答の要約」セクションの見出しを返します。
+// 対応表にない言語では英語の見出しを使用します。
+func AnswerSummaryHeading(lang string) string {
+	return labelsFor(lang).AnswerSummary
+}
+
+// ReferenceLabels は、出力言語に応じた参考文献セクションの見出しと取得日のラベルを返します。
+// 対応表にない言語では英語の表記を使用します。
+func ReferenceLabels(lang string) (heading, accessed string) {
+	labels := labelsFor(lang)
+	return labels.References, labels.Accessed
+}
+
+// labelsFor は、出力言語の documentLabels を返します。対応表にない言語では英語の表記を返します。
+func labelsFor(lang string) documentLabels {
+	if labels, ok := labelsByLanguage[LanguageCode(lang)]; ok {
+		return labels
+	}
+	return labelsByLanguage["en"]
+}
+
+// LanguageCode は、言語コード (例: "en-US") から地域を除いた小文字の言語コードを返します。空の場合は DefaultLanguage です。
+// 対応表の表示名 (例: "日本語") が指定された場合は、対応する言語コードを返します。
+func LanguageCode(lang string) string {
+	lang = strings.TrimSpace(lang)
+	if lang == "" {
+		lang = DefaultLanguage
+	}
+	for code, name := range languageNames {
+		if lang == name {
+			return code
+		}
+	}
+	code := strings.ToLower(lang)
+	if i := strings.IndexAny(code, "-_"); i > 0 {
+		code = code[:i]
+	}
+	return code
+}
@@ -0,0 +1,36 @@
+package prompts
+
+import "testing"
+
+func TestLanguageLabels(t *testing.T) {
+	tests := []struct {
+		lang         string
+		wantCode     string
+		wantSummary  string
+		wantHeading  string
+		wantAccessed string
+	}{
+		{lang: "", wantCode: "ja", wantSummary: "回答の要約", wantHeading: "参考文献", wantAccessed: "取得日"},
+		{lang: "日本語", wantCode: "ja", wantSummary: "回答の要約", wantHeading: "参考文献", wantAccessed: "取得日"},
+		{lang: "en-US", wantCode: "en", wantSummary: "Answer Summary", wantHeading: "References", wantAccessed: "accessed"},
+		{lang: "zh_CN", wantCode: "zh", wantSummary: "回答摘要", wantHeading: "参考文献", wantAccessed: "访问日期"},
+		{lang: "ko", wantCode: "ko", wantSummary: "답변 요약", wantHeading: "참고 문헌", wantAccessed: "접속일"},
+		{lang: " FR ", wantCode: "fr", wantSummary: "Résumé de la réponse", wantHeading: "Références", wantAccessed: "consulté"},
+		{lang: "de-AT", wantCode: "de", wantSummary: "Zusammenfassung der Antwort", wantHeading: "Quellen", wantAccessed: "abgerufen"},
+		{lang: "es", wantCode: "es", wantSummary: "Resumen de la respuesta", wantHeading: "Referencias", wantAccessed: "consultado"},
+		{lang: "pt-BR", wantCode: "pt", wantSummary: "Resumo da resposta", wantHeading: "Referências", wantAccessed: "acessado"},
+		{lang: "it", wantCode: "it", wantSummary: "Riepilogo della risposta", wantHeading: "Riferimenti", wantAccessed: "consultato"},
+		{lang: "Tiếng Việt", wantCode: "tiếng việt", wantSummary: "Answer Summary", wantHeading: "References", wantAccessed: "accessed"},
+	}
+	for _, tt := range tests {
+		if got := LanguageCode(tt.lang); got != tt.wantCode {
+			t.Errorf("LanguageCode(%q) = %q, want %q", tt.lang, got, tt.wantCode)
+		}
+		if got := AnswerSummaryHeading(tt.lang); got != tt.wantSummary {
+			t.Errorf("AnswerSummaryHeading(%q) = %q, want %q", tt.lang, got, tt.wantSummary)
+		}
+		if heading, accessed := ReferenceLabels(tt.lang); heading != tt.wantHeading || accessed != tt.wantAccessed {
+			t.Errorf("ReferenceLabels(%q) = (%q, %q), want (%q, %q)", tt.lang, heading, accessed, tt.wantHeading, tt.wantAccessed)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package prompts
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateVarKeyPattern は、テンプレート変数 (Vars) のキーとして許可する形式です (テンプレートのフィールド参照として有効な識別子)。
+var templateVarKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateVarKey は、テンプレート変数のキーが {{.Vars.key}} で参照できる識別子であるかを検証します。
+func ValidateVarKey(key string) error {
+	if !templateVarKeyPattern.MatchString(key) {
+		return fmt.Errorf("テンプレート変数のキー %q は英数字とアンダースコアのみ使用できます (テンプレートから {{.Vars.%s}} で参照するため)", key, key)
+	}
+	return nil
+}
+
+// ValidateVars は、すべてのテンプレート変数のキーを ValidateVarKey で検証します。不正なキーはキーの昇順で最初の1件を報告します。
+func ValidateVars(vars map[string]string) error {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := ValidateVarKey(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTemplate は、テンプレートが参照するフィールドが data の型に存在するかを検証し、
+// required で指定されたフィールドが少なくとも一度参照されていることを確認します。
+// 最後に data を使ったドライランを行い、実行時エラーが起きないことを確かめます。
+func validateTemplate(tmpl *template.Template, data any, required string) error {
+	v := &fieldValidator{referenced: make(map[string]bool)}
+	rootType := reflect.TypeOf(data)
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+		v.walk(t.Tree.Root, rootType)
+	}
+
+	if len(v.unknown) > 0 {
+		sort.Strings(v.unknown)
+		return fmt.Errorf("テンプレート '%s' が未定義のフィールドを参照しています: %s (利用可能: %s)",
+			tmpl.Name(), strings.Join(v.unknown, ", "), strings.Join(fieldNames(rootType), ", "))
+	}
+	if required != "" && !v.referenced[required] {
+		return fmt.Errorf("テンプレート '%s' は必須フィールド {{.%s}} を参照していません", tmpl.Name(), required)
+	}
+
+	if err := tmpl.Execute(io.Discard, data); err != nil {
+		return fmt.Errorf("テンプレート '%s' のドライランに失敗しました: %w", tmpl.Name(), err)
+	}
+	return nil
+}
+
+// fieldValidator は、パースツリーを走査してフィールド参照を収集します。
+type fieldValidator struct {
+	unknown    []string
+	referenced map[string]bool
+}
+
+// walk はノードを再帰的に走査します。dot は現在の "." の型で、不明な場合は nil です。
+func (v *fieldValidator) walk(node parse.Node, dot reflect.Type) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			v.walk(child, dot)
+		}
+	case *parse.ActionNode:
+		v.walk(n.Pipe, dot)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			for _, arg := range cmd.Args {
+				v.walk(arg, dot)
+			}
+		}
+	case *parse.IfNode:
+		v.walk(n.Pipe, dot)
+		v.walk(n.List, dot)
+		v.walk(n.ElseList, dot)
+	case *parse.RangeNode:
+		v.walk(n.Pipe, dot)
+		v.walk(n.List, elemType(v.pipeType(n.Pipe, dot)))
+		v.walk(n.ElseList, dot)
+	case *parse.WithNode:
+		v.walk(n.Pipe, dot)
+		v.walk(n.List, v.pipeType(n.Pipe, dot))
+		v.walk(n.ElseList, dot)
+	case *parse.TemplateNode:
+		v.walk(n.Pipe, dot)
+	case *parse.FieldNode:
+		v.checkField(n.Ident, dot)
+	}
+}
+
+// checkField は、フィールド参照 (.A.B) が dot の型で解決できるかを検証します。
+func (v *fieldValidator) checkField(idents []string, dot reflect.Type) reflect.Type {
+	t := dot
+	for i, ident := range idents {
+		t = derefType(t)
+		if t == nil || t.Kind() != reflect.Struct {
+			// マップや不明な型の場合は、以降の検証を行わない
+			return nil
+		}
+		field, ok := t.FieldByName(ident)
+		if !ok {
+			if _, isMethod := reflect.PointerTo(t).MethodByName(ident); !isMethod {
+				v.unknown = append(v.unknown, "."+strings.Join(idents[:i+1], "."))
+			}
+			return nil
+		}
+		if i == 0 {
+			v.referenced[ident] = true
+		}
+		t = field.Type
+	}
+	return t
+}
+
+// pipeType は、単一のフィールド参照からなるパイプの型を返します。それ以外は nil です。
+func (v *fieldValidator) pipeType(pipe *parse.PipeNode, dot reflect.Type) reflect.Type {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return nil
+	}
+	field, ok := pipe.Cmds[0].Args[0].(*parse.FieldNode)
+	if !ok {
+		return nil
+	}
+	t := dot
+	for _, ident := range field.Ident {
+		t = derefType(t)
+		if t == nil || t.Kind() != reflect.Struct {
+			return nil
+		}
+		f, ok := t.FieldByName(ident)
+		if !ok {
+			return nil
+		}
+		t = f.Type
+	}
+	return t
+}
+
+// elemType は、スライス・配列の要素型を返します。
+func elemType(t reflect.Type) reflect.Type {
+	t = derefType(t)
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return t.Elem()
+	}
+	return nil
+}
+
+// derefType は、ポインタ型を要素型に展開します。
+func derefType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+// fieldNames は、構造体の公開フィールド名 (埋め込みフィールドを展開) を返します。
+func fieldNames(t reflect.Type) []string {
+	t = derefType(t)
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous {
+			names = append(names, fieldNames(f.Type)...)
+			continue
+		}
+		names = append(names, f.Name)
+	}
+	return names
+}
@@ -0,0 +1,116 @@
+package prompts
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestCustomTemplateValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   func(name, text string) *PromptBuilder
+		text    string
+		wantErr []string
+	}{
+		{name: "valid map template", build: NewMapPromptBuilderFromTemplate, text: "{{.Topic}} ({{.SegmentIndex}}/{{.SegmentTotal}}): {{.SegmentText}}"},
+		{name: "valid reduce template", build: NewReducePromptBuilderFromTemplate, text: "{{range .SourceURLs}}- {{.}}\n{{end}}{{.CombinedText}}"},
+		{
+			name:    "unknown field with available fields",
+			build:   NewMapPromptBuilderFromTemplate,
+			text:    "{{.SegmentText}} {{.Segment}}",
+			wantErr: []string{"未定義のフィールド", ".Segment ", "利用可能: Language, Topic, Query,", "SegmentText, SourceURL"},
+		},
+		{
+			name:    "map field in reduce template",
+			build:   NewReducePromptBuilderFromTemplate,
+			text:    "{{.CombinedText}} {{.SourceURL}}",
+			wantErr: []string{"未定義のフィールドを参照しています: .SourceURL"},
+		},
+		{name: "missing SegmentText", build: NewMapPromptBuilderFromTemplate, text: "{{.Topic}}", wantErr: []string{"必須フィールド {{.SegmentText}}"}},
+		{name: "missing CombinedText", build: NewReducePromptBuilderFromTemplate, text: "{{.Topic}}", wantErr: []string{"必須フィールド {{.CombinedText}}"}},
+		{name: "required field only under a nested name", build: NewMapPromptBuilderFromTemplate, text: "{{with .CommonTemplateData}}{{.Topic}}{{end}}", wantErr: []string{"必須フィールド {{.SegmentText}}"}},
+		{
+			name:    "field under with resolved against the pipeline type",
+			build:   NewMapPromptBuilderFromTemplate,
+			text:    "{{.SegmentText}}{{with .CommonTemplateData}}{{.Topic}}{{.SegmentText}}{{end}}",
+			wantErr: []string{".SegmentText"},
+		},
+		{name: "arbitrary Vars key", build: NewReducePromptBuilderFromTemplate, text: "{{.Vars.anything}} {{index .Vars \"audience\"}} {{.CombinedText}}"},
+		{name: "syntax error", build: NewMapPromptBuilderFromTemplate, text: "{{.SegmentText", wantErr: []string{"unclosed action"}},
+		{
+			name:    "execution error caught by the dry run",
+			build:   NewMapPromptBuilderFromTemplate,
+			text:    "{{.SegmentText.Length}}",
+			wantErr: []string{"ドライランに失敗しました"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.build("custom.md", tt.text).Err()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("Err() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Err() = nil, want containing %q", tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Err() = %v, want containing %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+// rangeData は、range の要素型に対するフィールド解決を検証するためのデータです。
+type rangeData struct {
+	Items []*rangeItem
+	Body  string
+}
+
+type rangeItem struct {
+	Name string
+}
+
+func TestValidateTemplateRange(t *testing.T) {
+	data := rangeData{Items: []*rangeItem{{Name: "a"}}, Body: "sample"}
+	tests := []struct {
+		text    string
+		wantErr string
+	}{
+		{text: "{{range .Items}}{{.Name}}{{end}}{{.Body}}"},
+		{text: "{{range .Items}}{{.Title}}{{end}}{{.Body}}", wantErr: "未定義のフィールドを参照しています: .Title (利用可能: Items, Body)"},
+		{text: "{{range .Items}}{{.Name}}{{else}}{{.Name}}{{end}}{{.Body}}", wantErr: "未定義のフィールドを参照しています: .Name"},
+		{text: "{{range .Items}}{{.Body}}{{end}}", wantErr: "未定義のフィールドを参照しています: .Body"},
+	}
+	for _, tt := range tests {
+		tmpl := template.Must(template.New("range").Parse(tt.text))
+		err := validateTemplate(tmpl, data, "Body")
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("validateTemplate(%q) = %v, want containing %q", tt.text, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateVars(t *testing.T) {
+	tests := []struct {
+		vars    map[string]string
+		wantErr string
+	}{
+		{vars: map[string]string{"audience": "dev", "_tone2": "formal"}},
+		{vars: map[string]string{"audience": "dev", "target-audience": "dev"}, wantErr: `"target-audience"`},
+		{vars: map[string]string{"2nd": "x", "b.c": "y"}, wantErr: `"2nd"`},
+		{vars: map[string]string{"": "x"}, wantErr: `""`},
+	}
+	for _, tt := range tests {
+		err := ValidateVars(tt.vars)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("ValidateVars(%v) = %v, want containing %q", tt.vars, err, tt.wantErr)
+		}
+	}
+}
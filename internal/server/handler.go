@@ -0,0 +1,159 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+)
+
+// maxRequestBytes は、POST /jobs で受け付けるリクエスト本文の最大サイズです。
+const maxRequestBytes = 1 << 20
+
+// jobResult は、GET /jobs/{id}/result?format=json で返す結果です。
+type jobResult struct {
+	ID       string         `json:"id"`
+	Markdown string         `json:"markdown"`
+	Report   cleaner.Report `json:"report"`
+}
+
+// handler は、JobManager を HTTP API として公開します。
+type handler struct {
+	manager  *JobManager
+	renderer pipeline.MdToHtmlRunner
+}
+
+// NewHandler は、ジョブAPIの http.Handler を作成します。
+// すべてのリクエストに "Authorization: Bearer <token>" ヘッダーを要求し、一致しない場合は 401 を返します。
+//
+//	POST   /jobs              ジョブを登録し、ジョブIDを返す (202)
+//	GET    /jobs/{id}         ジョブの状態 (フェーズ、完了セグメント数) を返す
+//	GET    /jobs/{id}/result  最終結果を返す (?format=markdown|html|json)
+//	DELETE /jobs/{id}         ジョブをキャンセルする
+//
+// renderer は、format=html の場合に Markdown を HTML に変換するために使用します。
+func NewHandler(manager *JobManager, renderer pipeline.MdToHtmlRunner, token string) http.Handler {
+	h := &handler{manager: manager, renderer: renderer}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", h.submit)
+	mux.HandleFunc("GET /jobs/{id}", h.status)
+	mux.HandleFunc("GET /jobs/{id}/result", h.result)
+	mux.HandleFunc("DELETE /jobs/{id}", h.cancel)
+	return requireBearer(token, mux)
+}
+
+// requireBearer は、Authorization ヘッダーのベアラートークンが token と一致するリクエストのみを next に渡します。
+// token が空の場合はすべてのリクエストを拒否します。
+func requireBearer(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="jobs"`)
+			writeError(w, http.StatusUnauthorized, errors.New("認証トークンが指定されていないか、一致しません"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *handler) submit(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("リクエスト本文のJSONを解析できませんでした: %w", err))
+		return
+	}
+
+	job, err := h.manager.Submit(req)
+	switch {
+	case errors.Is(err, ErrInvalidRequest):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, ErrQueueFull), errors.Is(err, ErrShuttingDown):
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.id)
+	writeJSON(w, http.StatusAccepted, job.Status())
+}
+
+func (h *handler) status(w http.ResponseWriter, r *http.Request) {
+	job, err := h.manager.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job.Status())
+}
+
+func (h *handler) result(w http.ResponseWriter, r *http.Request) {
+	job, err := h.manager.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	result := job.Result()
+	if result == nil {
+		status := job.Status()
+		writeError(w, http.StatusConflict, fmt.Errorf("ジョブの結果はありません (status: %s)", status.Status))
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", pipeline.FormatMarkdown, "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(result.Markdown))
+	case pipeline.FormatHTML:
+		buf, err := h.renderer.Run(r.Context(), "", []byte(result.Markdown))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("HTMLへの変換に失敗しました: %w", err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	case "json":
+		writeJSON(w, http.StatusOK, jobResult{ID: job.id, Markdown: result.Markdown, Report: result.Report})
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("未対応の形式です: %q (markdown, html, json のいずれかを指定してください)", format))
+	}
+}
+
+func (h *handler) cancel(w http.ResponseWriter, r *http.Request) {
+	job, err := h.manager.Cancel(r.PathValue("id"))
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, ErrJobFinished):
+		writeError(w, http.StatusConflict, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job.Status())
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("レスポンスの書き込みに失敗しました。", slog.String("error", err.Error()))
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+)
+
+const testToken = "s3cret"
+
+func newTestServer(t *testing.T) (*httptest.Server, *JobManager) {
+	t.Helper()
+	m := newTestManager(t, t.Context(), Config{QueueSize: 4}, newTestScraper())
+	renderer, err := builder.NewHTMLRenderer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHandler(m, renderer, testToken))
+	t.Cleanup(srv.Close)
+	return srv, m
+}
+
+// do は、token をベアラートークンとしてリクエストを送信し、レスポンスのステータスと本文を返します。
+func do(t *testing.T, method, url, token, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestHandlerRequiresBearerToken(t *testing.T) {
+	srv, _ := newTestServer(t)
+	for _, token := range []string{"", "wrong"} {
+		if code, _ := do(t, http.MethodPost, srv.URL+"/jobs", token, `{"urls":["`+testURL+`"]}`); code != http.StatusUnauthorized {
+			t.Errorf("POST /jobs with token %q = %d, want 401", token, code)
+		}
+	}
+	empty := httptest.NewRecorder()
+	requireBearer("", http.NotFoundHandler()).ServeHTTP(empty, httptest.NewRequest(http.MethodGet, "/jobs/x", nil))
+	if empty.Code != http.StatusUnauthorized {
+		t.Errorf("request with an empty server token = %d, want 401", empty.Code)
+	}
+	if code, _ := do(t, http.MethodGet, srv.URL+"/jobs/unknown", testToken, ""); code != http.StatusNotFound {
+		t.Errorf("GET unknown job with the token = %d, want 404", code)
+	}
+}
+
+func TestHandlerJobLifecycle(t *testing.T) {
+	srv, m := newTestServer(t)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "malformed json", body: `{"urls":`, want: http.StatusBadRequest},
+		{name: "unknown field", body: `{"urls":["` + testURL + `"],"model":"x"}`, want: http.StatusBadRequest},
+		{name: "invalid request", body: `{"urls":[]}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, body := do(t, http.MethodPost, srv.URL+"/jobs", testToken, tt.body); code != tt.want {
+			t.Errorf("%s: POST /jobs = %d %s, want %d", tt.name, code, body, tt.want)
+		}
+	}
+
+	code, body := do(t, http.MethodPost, srv.URL+"/jobs", testToken, `{"urls":["`+testURL+`"],"topic":"Go の並行処理"}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("POST /jobs = %d %s, want 202", code, body)
+	}
+	var status JobStatus
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatal(err)
+	}
+	job, err := m.Get(status.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, job, StatusSucceeded)
+
+	if code, body := do(t, http.MethodGet, srv.URL+"/jobs/"+status.ID+"/result", testToken, ""); code != http.StatusOK || !strings.HasPrefix(body, "# Go の並行処理") {
+		t.Errorf("GET result = %d %q, want the markdown document", code, body)
+	}
+	if code, body := do(t, http.MethodGet, srv.URL+"/jobs/"+status.ID+"/result?format=json", testToken, ""); code != http.StatusOK || !strings.Contains(body, `"markdown"`) {
+		t.Errorf("GET result?format=json = %d %q, want the json result", code, body)
+	}
+	if code, _ := do(t, http.MethodGet, srv.URL+"/jobs/"+status.ID+"/result?format=pdf", testToken, ""); code != http.StatusBadRequest {
+		t.Errorf("GET result?format=pdf = %d, want 400", code)
+	}
+	if code, _ := do(t, http.MethodDelete, srv.URL+"/jobs/"+status.ID, testToken, ""); code != http.StatusConflict {
+		t.Errorf("DELETE finished job = %d, want 409", code)
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+)
+
+// ジョブの状態
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCanceled  = "canceled"
+)
+
+// JobRequest は、POST /jobs で受け付けるジョブの内容です。省略したフィールドにはサーバーの既定値を使用します。
+type JobRequest struct {
+	URLs []string `json:"urls"`
+	// MapModel と ReduceModel は使用するモデル名です (カンマ区切りでフォールバック順を指定可)。
+	MapModel    string `json:"map_model,omitempty"`
+	ReduceModel string `json:"reduce_model,omitempty"`
+	// MapPrompt と ReducePrompt は、カスタムプロンプトテンプレートの本文です (パスではありません)。
+	MapPrompt      string            `json:"map_prompt,omitempty"`
+	ReducePrompt   string            `json:"reduce_prompt,omitempty"`
+	Lang           string            `json:"lang,omitempty"`
+	Topic          string            `json:"topic,omitempty"`
+	Query          string            `json:"query,omitempty"`
+	Mode           string            `json:"mode,omitempty"`
+	CitationStyle  string            `json:"citation_style,omitempty"`
+	CitationPolicy string            `json:"citation_policy,omitempty"`
+	Vars           map[string]string `json:"vars,omitempty"`
+}
+
+// JobStatus は、GET /jobs/{id} で返すジョブの状態です。
+type JobStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Phase は、実行中または最後に実行したパイプラインのフェーズです。
+	Phase string `json:"phase,omitempty"`
+	// SegmentsDone と SegmentsTotal は、Mapフェーズの完了セグメント数と総数です。
+	SegmentsDone  int        `json:"segments_done"`
+	SegmentsTotal int        `json:"segments_total"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// Job は、1件のパイプライン実行です。状態は複数の Goroutine から参照されるため、mu で保護します。
+type Job struct {
+	id      string
+	urls    []string
+	options pipeline.CmdOptions
+	prompts cleaner.PromptBuilders
+
+	mu            sync.Mutex
+	status        string
+	phase         string
+	segmentsDone  int
+	segmentsTotal int
+	err           string
+	createdAt     time.Time
+	startedAt     time.Time
+	finishedAt    time.Time
+	cancel        context.CancelFunc
+	result        *cleaner.Result
+}
+
+// Status は、ジョブの現在の状態を返します。
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := JobStatus{
+		ID:            j.id,
+		Status:        j.status,
+		Phase:         j.phase,
+		SegmentsDone:  j.segmentsDone,
+		SegmentsTotal: j.segmentsTotal,
+		Error:         j.err,
+		CreatedAt:     j.createdAt,
+	}
+	if !j.startedAt.IsZero() {
+		t := j.startedAt
+		s.StartedAt = &t
+	}
+	if !j.finishedAt.IsZero() {
+		t := j.finishedAt
+		s.FinishedAt = &t
+	}
+	return s
+}
+
+// Result は、成功したジョブの最終結果を返します。完了していない場合は nil を返します。
+func (j *Job) Result() *cleaner.Result {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.result
+}
+
+// setPhase は、パイプラインのフェーズを記録します (Pipeline.OnPhase)。
+func (j *Job) setPhase(phase string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.phase = phase
+}
+
+// Start、Advance、Finish は cleaner.MapProgress を実装し、Mapフェーズの進捗を記録します。
+func (j *Job) Start(total int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.segmentsTotal = total
+	j.segmentsDone = 0
+}
+
+func (j *Job) Advance() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.segmentsDone++
+}
+
+func (j *Job) Finish() {}
+
+var _ cleaner.MapProgress = (*Job)(nil)
+
+// begin は、キューから取り出したジョブを実行中にします。キャンセル済みの場合は false を返します。
+func (j *Job) begin(cancel context.CancelFunc) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status != StatusQueued {
+		return false
+	}
+	j.status = StatusRunning
+	j.startedAt = time.Now()
+	j.cancel = cancel
+	return true
+}
+
+// finish は、実行結果を記録します。
+func (j *Job) finish(result *cleaner.Result, err error, canceled bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finishedAt = time.Now()
+	j.cancel = nil
+	switch {
+	case canceled:
+		j.status = StatusCanceled
+		j.err = "ジョブはキャンセルされました"
+	case err != nil:
+		j.status = StatusFailed
+		j.err = err.Error()
+	default:
+		j.status = StatusSucceeded
+		j.result = result
+	}
+}
+
+// finishedBefore は、ジョブが cutoff より前に完了したかどうかを返します。
+func (j *Job) finishedBefore(cutoff time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return !j.finishedAt.IsZero() && j.finishedAt.Before(cutoff)
+}
+
+// requestCancel は、ジョブのキャンセルを要求します。待機中のジョブは即座にキャンセル済みになり、
+// 実行中のジョブはコンテキストをキャンセルします。完了済みの場合は false を返します。
+func (j *Job) requestCancel() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch j.status {
+	case StatusQueued:
+		j.status = StatusCanceled
+		j.err = "ジョブはキャンセルされました"
+		j.finishedAt = time.Now()
+		return true
+	case StatusRunning:
+		if j.cancel != nil {
+			j.cancel()
+		}
+		return true
+	}
+	return false
+}
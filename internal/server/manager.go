@@ -0,0 +1,358 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/logging"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// ジョブのパイプラインに渡す仮想的なURLリストファイルのパスです (ジョブごとのメモリ上の InputReader から読み込みます)。
+const jobURLFile = "urls.txt"
+
+var (
+	// ErrInvalidRequest は、ジョブのリクエスト内容が不正な場合のエラーです。
+	ErrInvalidRequest = errors.New("リクエストが不正です")
+	// ErrQueueFull は、待機中のジョブ数が上限に達している場合のエラーです。
+	ErrQueueFull = errors.New("ジョブキューが満杯です")
+	// ErrJobNotFound は、指定されたIDのジョブが存在しない場合のエラーです。
+	ErrJobNotFound = errors.New("ジョブが見つかりません")
+	// ErrJobFinished は、完了済みのジョブをキャンセルしようとした場合のエラーです。
+	ErrJobFinished = errors.New("ジョブは既に完了しています")
+	// ErrShuttingDown は、サーバーの停止中にジョブを登録しようとした場合のエラーです。
+	ErrShuttingDown = errors.New("サーバーを停止しています")
+)
+
+// Config は JobManager の設定です。
+type Config struct {
+	// Workers は、同時に実行するジョブの最大数です。
+	Workers int
+	// QueueSize は、実行待ちにできるジョブの最大数です。
+	QueueSize int
+	// JobTimeout は、1件のジョブの最大実行時間です。
+	JobTimeout time.Duration
+	// JobTTL は、完了したジョブの状態と結果を保持する時間です。経過したジョブは次のジョブの登録時に削除されます。
+	JobTTL time.Duration
+	// Defaults は、リクエストで省略された項目に使用する CmdOptions です。
+	Defaults pipeline.CmdOptions
+	// BuildOptions は、ジョブごとの BuildPipeline に追加で渡す関数オプションです (テストでの依存関係の差し替えなど)。
+	BuildOptions []builder.Option
+}
+
+// JobManager は、ジョブを受け付け、上限付きのワーカープールで Pipeline.Execute を実行します。
+type JobManager struct {
+	cfg   Config
+	ctx   context.Context
+	queue chan *Job
+	wg    sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewJobManager は、cfg.Workers 個のワーカーを起動した JobManager を作成します。
+// ctx がキャンセルされると、実行中のジョブはキャンセルされ、ワーカーは終了します。
+func NewJobManager(ctx context.Context, cfg Config) (*JobManager, error) {
+	if cfg.Workers < 1 {
+		return nil, fmt.Errorf("ワーカー数には1以上の値を指定する必要があります: %d", cfg.Workers)
+	}
+	if cfg.QueueSize < 0 {
+		return nil, fmt.Errorf("キューの長さには0以上の値を指定する必要があります: %d", cfg.QueueSize)
+	}
+	if cfg.JobTimeout <= 0 {
+		return nil, fmt.Errorf("ジョブのタイムアウトには正の値を指定する必要があります: %s", cfg.JobTimeout)
+	}
+	if cfg.JobTTL <= 0 {
+		return nil, fmt.Errorf("完了したジョブの保持時間には正の値を指定する必要があります: %s", cfg.JobTTL)
+	}
+	m := &JobManager{
+		cfg:   cfg,
+		ctx:   ctx,
+		queue: make(chan *Job, cfg.QueueSize),
+		jobs:  make(map[string]*Job),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+	return m, nil
+}
+
+// Wait は、ctx のキャンセル後にすべてのワーカーが終了するまで待機し、実行されずに残った待機中のジョブをキャンセル済みにします。
+func (m *JobManager) Wait() {
+	m.wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		select {
+		case job := <-m.queue:
+			if job.requestCancel() {
+				slog.Info("サーバーの停止により、待機中のジョブをキャンセルしました。", slog.String(logging.KeyJobID, job.id))
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Submit は、リクエストを検証してジョブをキューに追加します。
+// 内容が不正な場合は ErrInvalidRequest、キューが満杯の場合は ErrQueueFull、停止中の場合は ErrShuttingDown をラップしたエラーを返します。
+// 登録時に、完了してから JobTTL が経過したジョブを削除します。
+func (m *JobManager) Submit(req JobRequest) (*Job, error) {
+	opts, builders, err := m.newJobOptions(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	// ログと実行記録の実行IDにはジョブIDを使用する
+	opts.RunID = id
+	job := &Job{
+		id:        id,
+		urls:      req.URLs,
+		options:   opts,
+		prompts:   builders,
+		status:    StatusQueued,
+		createdAt: time.Now(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil {
+		return nil, ErrShuttingDown
+	}
+	m.pruneLocked(time.Now().Add(-m.cfg.JobTTL))
+	select {
+	case m.queue <- job:
+	default:
+		return nil, ErrQueueFull
+	}
+	m.jobs[id] = job
+	slog.Info("ジョブを受け付けました。", slog.String(logging.KeyJobID, id), slog.Int("urls", len(req.URLs)))
+	return job, nil
+}
+
+// pruneLocked は、cutoff より前に完了したジョブを削除します。m.mu を保持して呼び出します。
+func (m *JobManager) pruneLocked(cutoff time.Time) {
+	for id, job := range m.jobs {
+		if job.finishedBefore(cutoff) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// Get は、IDに対応するジョブを返します。
+func (m *JobManager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// Cancel は、ジョブをキャンセルします。完了済みの場合は ErrJobFinished を返します。
+func (m *JobManager) Cancel(id string) (*Job, error) {
+	job, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !job.requestCancel() {
+		return nil, ErrJobFinished
+	}
+	slog.Info("ジョブのキャンセルを要求しました。", slog.String(logging.KeyJobID, id))
+	return job, nil
+}
+
+func (m *JobManager) worker() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case job := <-m.queue:
+			m.run(job)
+		}
+	}
+}
+
+// run は、ジョブ用の Pipeline を構築して実行します。
+// URLリストはメモリ上の InputReader から読み込み、結果は出力先に書き出さずにジョブに保持します。
+func (m *JobManager) run(job *Job) {
+	ctx, cancel := context.WithTimeout(m.ctx, m.cfg.JobTimeout)
+	defer cancel()
+	if !job.begin(cancel) {
+		return
+	}
+	ctx = logging.With(ctx, slog.String(logging.KeyJobID, job.id))
+	slog.InfoContext(ctx, "ジョブを開始します。")
+
+	result, err := m.execute(ctx, job)
+	canceled := errors.Is(ctx.Err(), context.Canceled)
+	job.finish(result, err, canceled)
+
+	status := job.Status()
+	if status.Status == StatusSucceeded {
+		slog.InfoContext(ctx, "ジョブが完了しました。")
+	} else {
+		slog.WarnContext(ctx, "ジョブが正常に完了しませんでした。", slog.String("status", status.Status), slog.String("error", status.Error))
+	}
+}
+
+func (m *JobManager) execute(ctx context.Context, job *Job) (*cleaner.Result, error) {
+	options := append([]builder.Option{
+		builder.WithReader(urlListReader(job.urls)),
+		builder.WithPrompts(job.prompts),
+		builder.WithMapProgress(job),
+	}, m.cfg.BuildOptions...)
+	p, closer, err := builder.BuildPipeline(ctx, job.options, options...)
+	if closer != nil {
+		defer closer()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("パイプラインの構築に失敗しました: %w", err)
+	}
+
+	gen := &capturingGenerator{inner: p.MarkdownGen}
+	p.MarkdownGen = gen
+	p.Publisher = discardPublisher{}
+	p.OnPhase = job.setPhase
+	if err := p.Execute(ctx); err != nil {
+		return nil, fmt.Errorf("パイプラインの実行中にエラーが発生しました: %w", err)
+	}
+	return gen.result, nil
+}
+
+// newJobOptions は、サーバーの既定値にリクエストの指定を上書きした CmdOptions と、カスタムプロンプトの PromptBuilder を返します。
+func (m *JobManager) newJobOptions(req JobRequest) (pipeline.CmdOptions, cleaner.PromptBuilders, error) {
+	if len(req.URLs) == 0 {
+		return pipeline.CmdOptions{}, cleaner.PromptBuilders{}, fmt.Errorf("urls に1件以上のURLを指定する必要があります")
+	}
+	for _, u := range req.URLs {
+		if strings.ContainsAny(u, "\r\n") {
+			return pipeline.CmdOptions{}, cleaner.PromptBuilders{}, fmt.Errorf("URLに改行を含めることはできません: %q", u)
+		}
+	}
+
+	opts := m.cfg.Defaults
+	opts.URLFile = jobURLFile
+	opts.OutputFilePath = ""
+	opts.OutputFormat = pipeline.FormatMarkdown
+	opts.MapPromptPath = ""
+	opts.ReducePromptPath = ""
+	opts.RecordDir = ""
+	opts.ReplayDir = ""
+	override(&opts.MapModel, req.MapModel)
+	override(&opts.ReduceModel, req.ReduceModel)
+	override(&opts.Language, req.Lang)
+	override(&opts.Topic, req.Topic)
+	override(&opts.Query, strings.TrimSpace(req.Query))
+	override(&opts.Mode, req.Mode)
+	override(&opts.CitationStyle, req.CitationStyle)
+	override(&opts.CitationPolicy, req.CitationPolicy)
+	if len(req.Vars) > 0 {
+		if err := prompts.ValidateVars(req.Vars); err != nil {
+			return pipeline.CmdOptions{}, cleaner.PromptBuilders{}, fmt.Errorf("vars: %w", err)
+		}
+		opts.TemplateVars = req.Vars
+	}
+
+	if err := cleaner.ValidateModelChain(opts.MapModel); err != nil {
+		return pipeline.CmdOptions{}, cleaner.PromptBuilders{}, fmt.Errorf("map_model: %w", err)
+	}
+	if err := cleaner.ValidateModelChain(opts.ReduceModel); err != nil {
+		return pipeline.CmdOptions{}, cleaner.PromptBuilders{}, fmt.Errorf("reduce_model: %w", err)
+	}
+	if err := cleaner.ValidateMode(opts.Mode); err != nil {
+		return pipeline.CmdOptions{}, cleaner.PromptBuilders{}, err
+	}
+	if err := cleaner.ValidateCitationStyle(opts.CitationStyle); err != nil {
+		return pipeline.CmdOptions{}, cleaner.PromptBuilders{}, err
+	}
+	if err := cleaner.ValidateCitationPolicy(opts.CitationPolicy); err != nil {
+		return pipeline.CmdOptions{}, cleaner.PromptBuilders{}, err
+	}
+
+	// カスタムプロンプトは受け付け時に検証し、不正なテンプレートのジョブをキューに入れない
+	var builders cleaner.PromptBuilders
+	if req.MapPrompt != "" {
+		b := prompts.NewMapPromptBuilderFromTemplate("map_prompt", req.MapPrompt)
+		if err := b.Err(); err != nil {
+			return pipeline.CmdOptions{}, cleaner.PromptBuilders{}, fmt.Errorf("map_prompt: %w", err)
+		}
+		builders.MapBuilder = b
+	}
+	if req.ReducePrompt != "" {
+		b := prompts.NewReducePromptBuilderFromTemplate("reduce_prompt", req.ReducePrompt)
+		if err := b.Err(); err != nil {
+			return pipeline.CmdOptions{}, cleaner.PromptBuilders{}, fmt.Errorf("reduce_prompt: %w", err)
+		}
+		builders.ReduceBuilder = b
+	}
+	return opts, builders, nil
+}
+
+// override は、value が空でない場合に dst を上書きします。
+func override(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("ジョブIDの生成に失敗しました: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// urlListReader は、ジョブのURLリストを jobURLFile として返す InputReader です。
+type urlListReader []string
+
+func (r urlListReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if path != jobURLFile {
+		return nil, fmt.Errorf("サーバーモードではファイルを読み込めません: %s", path)
+	}
+	return io.NopCloser(strings.NewReader(strings.Join(r, "\n"))), nil
+}
+
+// capturingGenerator は、MarkdownGenerator の結果 (最終文書と診断情報) をジョブの結果として保持します。
+type capturingGenerator struct {
+	inner  pipeline.MarkdownGenerator
+	result *cleaner.Result
+}
+
+func (g *capturingGenerator) Generate(ctx context.Context, opts pipeline.CmdOptions, results []extTypes.URLResult) (*cleaner.Result, error) {
+	result, err := g.inner.Generate(ctx, opts, results)
+	if err != nil {
+		return nil, err
+	}
+	g.result = result
+	return result, nil
+}
+
+// discardPublisher は、何も出力しない Publisher です。ジョブの結果は GET /jobs/{id}/result で取得します。
+type discardPublisher struct{}
+
+func (discardPublisher) Publish(ctx context.Context, opts pipeline.CmdOptions, markdown string) error {
+	return nil
+}
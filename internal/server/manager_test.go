@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/fakes"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+const testURL = "https://example.com/go"
+
+// newTestManager は、フェイクの LLM クライアントと scraper でジョブを実行する JobManager を作成します。
+func newTestManager(t *testing.T, ctx context.Context, cfg Config, scraper pipeline.ScraperRunner) *JobManager {
+	t.Helper()
+	if cfg.Workers == 0 {
+		cfg.Workers = 1
+	}
+	if cfg.JobTimeout == 0 {
+		cfg.JobTimeout = time.Minute
+	}
+	if cfg.JobTTL == 0 {
+		cfg.JobTTL = time.Hour
+	}
+	cfg.Defaults = pipeline.CmdOptions{
+		Language:       "ja",
+		CitationPolicy: cleaner.CitationPolicyFlag,
+		CitationStyle:  cleaner.CitationStyleSection,
+		Mode:           cleaner.ModeAuto,
+		MapModel:       "fake-map",
+		ReduceModel:    "fake-reduce",
+		MapConcurrency: 1,
+	}
+	cfg.BuildOptions = []builder.Option{
+		builder.WithModel(fakes.NewModel()),
+		builder.WithMapRateLimit(time.Millisecond),
+		builder.WithScraper(scraper),
+	}
+	m, err := NewJobManager(ctx, cfg)
+	if err != nil {
+		t.Fatalf("NewJobManager: %v", err)
+	}
+	return m
+}
+
+func newTestScraper() *fakes.Scraper {
+	return fakes.NewScraper(map[string]string{testURL: "ゴルーチンは軽量なスレッドです。\n\nチャネルで値を受け渡します。"})
+}
+
+// waitStatus は、ジョブが want の状態になるまで待機します。
+func waitStatus(t *testing.T, job *Job, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if job.Status().Status == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s status = %+v, want %s", job.id, job.Status(), want)
+}
+
+// blockingScraper は、コンテキストがキャンセルされるまで取得を終えない ScraperRunner です。
+type blockingScraper struct{}
+
+func (blockingScraper) ScrapeInParallel(ctx context.Context, urls []string) []extTypes.URLResult {
+	<-ctx.Done()
+	return nil
+}
+
+func TestJobManagerQueueCancelAndShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newTestManager(t, ctx, Config{QueueSize: 2}, blockingScraper{})
+	req := JobRequest{URLs: []string{testURL}}
+
+	running, err := m.Submit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, running, StatusRunning)
+	canceledInQueue, err := m.Submit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := m.Submit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Submit(req); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Submit() on a full queue error = %v, want ErrQueueFull", err)
+	}
+
+	if _, err := m.Cancel(canceledInQueue.id); err != nil {
+		t.Fatalf("Cancel(queued job): %v", err)
+	}
+	if got := canceledInQueue.Status().Status; got != StatusCanceled {
+		t.Errorf("canceled queued job status = %s, want %s", got, StatusCanceled)
+	}
+
+	cancel()
+	m.Wait()
+	for _, job := range []*Job{running, queued} {
+		if got := job.Status(); got.Status != StatusCanceled || got.FinishedAt == nil {
+			t.Errorf("job %s after shutdown = %+v, want canceled with finished_at", job.id, got)
+		}
+	}
+	if _, err := m.Submit(req); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("Submit() after shutdown error = %v, want ErrShuttingDown", err)
+	}
+}
+
+func TestJobManagerPrunesFinishedJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := newTestManager(t, ctx, Config{QueueSize: 1, JobTTL: time.Millisecond}, newTestScraper())
+	req := JobRequest{URLs: []string{testURL}}
+
+	first, err := m.Submit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitStatus(t, first, StatusSucceeded)
+	time.Sleep(5 * time.Millisecond)
+
+	second, err := m.Submit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get(first.id); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get(expired job) error = %v, want ErrJobNotFound", err)
+	}
+	if _, err := m.Get(second.id); err != nil {
+		t.Errorf("Get(new job): %v", err)
+	}
+	waitStatus(t, second, StatusSucceeded)
+}
+
+func TestJobManagerRejectsInvalidRequests(t *testing.T) {
+	m := newTestManager(t, t.Context(), Config{}, newTestScraper())
+	tests := []struct {
+		name string
+		req  JobRequest
+	}{
+		{name: "no urls", req: JobRequest{}},
+		{name: "newline in url", req: JobRequest{URLs: []string{"https://example.com/\nhttps://evil.example/"}}},
+		{name: "unknown mode", req: JobRequest{URLs: []string{testURL}, Mode: "fast"}},
+		{name: "broken template", req: JobRequest{URLs: []string{testURL}, MapPrompt: "{{.Unknown"}},
+		{name: "invalid var key", req: JobRequest{URLs: []string{testURL}, Vars: map[string]string{"target-audience": "dev"}}},
+	}
+	for _, tt := range tests {
+		if _, err := m.Submit(tt.req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: Submit() error = %v, want ErrInvalidRequest", tt.name, err)
+		}
+	}
+}
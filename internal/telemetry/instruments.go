@@ -0,0 +1,72 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// トレーサーと計器は、Setup より前に作成されても OpenTelemetry のグローバルプロバイダーを通じて設定後の実装に委譲されます。
+var (
+	tracer = otel.Tracer(instrumentationName)
+	meter  = otel.Meter(instrumentationName)
+
+	llmLatency, _ = meter.Float64Histogram("apg.llm.latency",
+		metric.WithUnit("s"),
+		metric.WithDescription("LLM 呼び出し1回あたりの所要時間"),
+		metric.WithExplicitBucketBoundaries(0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300))
+	llmTokens, _ = meter.Int64Counter("apg.llm.tokens",
+		metric.WithUnit("{token}"),
+		metric.WithDescription("LLM 呼び出しで消費したトークン数 (type: prompt, output)"))
+	llmErrors, _ = meter.Int64Counter("apg.llm.errors",
+		metric.WithUnit("{error}"),
+		metric.WithDescription("失敗した LLM 呼び出しの数 (class: quota, safety, truncated, empty, canceled, error)"))
+	mapSegments, _ = meter.Int64Counter("apg.map.segments",
+		metric.WithUnit("{segment}"),
+		metric.WithDescription("Mapフェーズで処理したセグメント数 (rate() でセグメント/秒を算出)"))
+	scrapes, _ = meter.Int64Counter("apg.scrape.pages",
+		metric.WithUnit("{page}"),
+		metric.WithDescription("スクレイピングしたページ数 (outcome: ok, failed)"))
+)
+
+// Start は、スパンを開始します。
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End は、err をスパンに記録してスパンを終了します。
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// RecordLLMCall は、1回の LLM 呼び出しの所要時間・トークン数・失敗の分類を記録します。
+func RecordLLMCall(ctx context.Context, phase, model string, elapsed time.Duration, resp *llm.Response, err error) {
+	attrs := []attribute.KeyValue{attribute.String("phase", phase), attribute.String("model", model)}
+	outcome := "ok"
+	if err != nil {
+		class := string(llm.Classify(err))
+		outcome = "error"
+		llmErrors.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.String("class", class))...))
+	}
+	llmLatency.Record(ctx, elapsed.Seconds(), metric.WithAttributes(append(attrs, attribute.String("outcome", outcome))...))
+	if resp != nil {
+		llmTokens.Add(ctx, int64(resp.Usage.PromptTokens), metric.WithAttributes(append(attrs, attribute.String("type", "prompt"))...))
+		llmTokens.Add(ctx, int64(resp.Usage.OutputTokens), metric.WithAttributes(append(attrs, attribute.String("type", "output"))...))
+	}
+}
+
+// RecordSegment は、Mapフェーズで処理を終えたセグメント1件を記録します。
+func RecordSegment(ctx context.Context, outcome string) {
+	mapSegments.Add(ctx, 1, metric.WithAttributes(attribute.String("outcome", outcome)))
+}
@@ -0,0 +1,64 @@
+package telemetry
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// ScraperRunner は、pipeline.ScraperRunner と同じ契約です (パッケージの循環参照を避けるため再定義しています)。
+type ScraperRunner interface {
+	ScrapeInParallel(ctx context.Context, urls []string) []extTypes.URLResult
+}
+
+// tracedScraper は、URLごとにスパンを記録する ScraperRunner です。
+type tracedScraper struct {
+	inner    ScraperRunner
+	parallel int
+}
+
+// WrapScraper は、URLごとに inner を呼び出して scrape スパンとページ数を記録する ScraperRunner を返します。
+// URLごとの呼び出しは最大 parallel 件まで並列に実行します。
+func WrapScraper(inner ScraperRunner, parallel int) ScraperRunner {
+	return &tracedScraper{inner: inner, parallel: max(parallel, 1)}
+}
+
+// ScrapeInParallel は、URLごとにスパンを記録しながら取得し、成功した結果を入力順に返します。
+func (s *tracedScraper) ScrapeInParallel(ctx context.Context, urls []string) []extTypes.URLResult {
+	sem := make(chan struct{}, s.parallel)
+	perURL := make([][]extTypes.URLResult, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			spanCtx, span := Start(ctx, "scrape", attribute.String("url", u))
+			perURL[i] = s.inner.ScrapeInParallel(spanCtx, []string{u})
+			outcome := "ok"
+			if len(perURL[i]) == 0 {
+				outcome = "failed"
+			}
+			span.SetAttributes(attribute.String("outcome", outcome))
+			span.End()
+			scrapes.Add(ctx, 1, metric.WithAttributes(attribute.String("outcome", outcome)))
+		}()
+	}
+	wg.Wait()
+
+	var results []extTypes.URLResult
+	for _, r := range perURL {
+		results = append(results, r...)
+	}
+	return results
+}
@@ -0,0 +1,143 @@
+// Package telemetry は、OpenTelemetry によるトレースとメトリクスを提供します。
+// Setup で有効にしない限り、スパンとメトリクスはすべて OpenTelemetry の既定の no-op 実装に記録されます。
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	promexporter "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// instrumentationName は、トレーサーとメーターの計装スコープ名です。
+const instrumentationName = "action-perfect-get-on-go"
+
+// otlpEndpointEnv は、OTLP エクスポーターの標準の環境変数です。設定されている場合は --otlp-endpoint を省略しても OTLP へ送信します。
+const otlpEndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+
+// enabled は、Setup によりトレースまたはメトリクスの送信先が設定されたかどうかです。
+var enabled atomic.Bool
+
+// Config は Setup の設定です。
+type Config struct {
+	// ServiceName は、リソース属性 service.name に設定するサービス名です。
+	ServiceName string
+	// OTLPEndpoint は、トレースとメトリクスを送信する OTLP/HTTP のエンドポイントURL (例: http://localhost:4318) です。
+	// 空の場合でも、環境変数 OTEL_EXPORTER_OTLP_ENDPOINT が設定されていればその値を使用します。
+	OTLPEndpoint string
+	// MetricsAddr は、Prometheus 形式のメトリクスを /metrics で公開するアドレス (例: :9464) です (空の場合は公開しない)。
+	MetricsAddr string
+}
+
+// Enabled は、Setup によりトレースまたはメトリクスの送信先が設定されているかどうかを返します。
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Setup は、cfg に従ってトレースとメトリクスのエクスポーターを設定し、送信中のデータを書き出して終了する関数を返します。
+// OTLP と Prometheus のどちらも指定されていない場合は何も設定せず (no-op のまま)、何もしない終了関数を返します。
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otlpEndpoint := cfg.OTLPEndpoint
+	useOTLP := otlpEndpoint != "" || os.Getenv(otlpEndpointEnv) != ""
+	if !useOTLP && cfg.MetricsAddr == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("テレメトリのリソースの作成に失敗しました: %w", err)
+	}
+
+	var shutdowns []func(context.Context) error
+	shutdown := func(ctx context.Context) error {
+		var errs []error
+		for i := len(shutdowns) - 1; i >= 0; i-- {
+			errs = append(errs, shutdowns[i](ctx))
+		}
+		return errors.Join(errs...)
+	}
+
+	var readers []sdkmetric.Option
+	if useOTLP {
+		var traceOpts []otlptracehttp.Option
+		var metricOpts []otlpmetrichttp.Option
+		if otlpEndpoint != "" {
+			traceOpts = append(traceOpts, otlptracehttp.WithEndpointURL(otlpEndpoint))
+			metricOpts = append(metricOpts, otlpmetrichttp.WithEndpointURL(otlpEndpoint))
+		}
+		traceExporter, err := otlptracehttp.New(ctx, traceOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("OTLPトレースエクスポーターの初期化に失敗しました: %w", err)
+		}
+		tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(traceExporter), sdktrace.WithResource(res))
+		otel.SetTracerProvider(tp)
+		shutdowns = append(shutdowns, tp.Shutdown)
+
+		metricExporter, err := otlpmetrichttp.New(ctx, metricOpts...)
+		if err != nil {
+			shutdown(ctx)
+			return nil, fmt.Errorf("OTLPメトリクスエクスポーターの初期化に失敗しました: %w", err)
+		}
+		readers = append(readers, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))
+	}
+
+	if cfg.MetricsAddr != "" {
+		registry := prometheus.NewRegistry()
+		exporter, err := promexporter.New(promexporter.WithRegisterer(registry))
+		if err != nil {
+			shutdown(ctx)
+			return nil, fmt.Errorf("Prometheusエクスポーターの初期化に失敗しました: %w", err)
+		}
+		readers = append(readers, sdkmetric.WithReader(exporter))
+
+		stop, err := serveMetrics(cfg.MetricsAddr, registry)
+		if err != nil {
+			shutdown(ctx)
+			return nil, err
+		}
+		shutdowns = append(shutdowns, stop)
+	}
+
+	mp := sdkmetric.NewMeterProvider(append(readers, sdkmetric.WithResource(res))...)
+	otel.SetMeterProvider(mp)
+	// メトリクスの最終値を書き出してから /metrics を停止できるよう、MeterProvider を先に終了する
+	shutdowns = append(shutdowns, mp.Shutdown)
+
+	enabled.Store(true)
+	slog.Info("テレメトリを有効にしました。", slog.Bool("otlp", useOTLP), slog.String("metrics_addr", cfg.MetricsAddr))
+	return shutdown, nil
+}
+
+// serveMetrics は、registry のメトリクスを addr の /metrics で公開し、HTTPサーバーを停止する関数を返します。
+func serveMetrics(addr string, registry *prometheus.Registry) (func(context.Context) error, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("メトリクスのアドレスで待ち受けできませんでした '%s': %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("メトリクスのHTTPサーバーが停止しました。", slog.String("error", err.Error()))
+		}
+	}()
+	slog.Info("Prometheus メトリクスを公開しました。", slog.String("endpoint", "http://"+ln.Addr().String()+"/metrics"))
+	return srv.Shutdown, nil
+}
@@ -1,6 +1,6 @@
 package main
 
-import "action-perfect-get-on-go/cmd"
+import "github.com/shouni/action-perfect-get-on-go/cmd"
 
 func main() {
 	// cmdパッケージで定義されたルートコマンドを実行します
@@ -0,0 +1,109 @@
+package perfectget
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+)
+
+// CleanerConfig は、NewCleaner の設定です。ゼロ値のフィールドには Summarize と同じ既定値を使用します。
+type CleanerConfig struct {
+	// Language は最終文書の出力言語 (例: "ja", "en") です。
+	Language string
+	// Topic は文書のトピック、Query は調査クエリです。
+	Topic string
+	Query string
+	// Mode は処理モード (ModeAuto, ModeMapReduce, ModeSingle) です。
+	Mode string
+	// SinglePassMaxChars は、ModeAuto で単一パスを選択する全ソースの合計文字数の上限です (0 の場合は ReduceModel から算出)。
+	SinglePassMaxChars int
+	// ReduceModel は、Reduce に使用するモデルの指定です。単一パスの上限の算出に使用します。
+	ReduceModel string
+	// CitationPolicy と CitationStyle は、引用URLの検証ポリシーと出典の表記方式です。
+	CitationPolicy string
+	CitationStyle  string
+	// Routing は、セグメントや Reduce に使用するモデルを選択するルールです (定義順に評価)。
+	Routing []RoutingRule
+	// Vars は、テンプレート変数 {{.Vars.key}} として参照できる任意の値です。
+	Vars map[string]string
+}
+
+// Result は、Cleaner による構造化の結果です。
+type Result struct {
+	// Markdown は、構造化された最終文書です。
+	Markdown string
+	// Report は、セグメントごとの処理結果や引用の検証結果などの診断情報です。
+	Report Report
+}
+
+// Cleaner は、取得したページをセグメントに分割し、Map と Reduce (または単一パス) で構造化した最終文書を生成します。
+// 生成した文書の構造の検証・修復と、引用URLの検証も行います。Cleaner は MarkdownGenerator を満たします。
+type Cleaner struct {
+	inner *cleaner.Cleaner
+}
+
+var _ MarkdownGenerator = (*Cleaner)(nil)
+
+// NewCleaner は、PromptBuilder と LLMExecutor を注入した Cleaner を作成します。
+// builders の nil のフィールドは組み込みテンプレートを使用します。
+func NewCleaner(builders PromptBuilders, executor LLMExecutor, cfg CleanerConfig) (*Cleaner, error) {
+	if executor == nil {
+		return nil, fmt.Errorf("LLMExecutor は nil にできません")
+	}
+	internalBuilders, err := builders.internal()
+	if err != nil {
+		return nil, fmt.Errorf("プロンプトテンプレートが不正です: %w", err)
+	}
+	internalCfg := cleaner.CleanerConfig{
+		Language:           withDefault(cfg.Language, prompts.DefaultLanguage),
+		Topic:              cfg.Topic,
+		Query:              cfg.Query,
+		CitationPolicy:     withDefault(cfg.CitationPolicy, CitationPolicyFlag),
+		CitationStyle:      withDefault(cfg.CitationStyle, CitationStyleSection),
+		Mode:               withDefault(cfg.Mode, ModeAuto),
+		SinglePassMaxChars: cfg.SinglePassMaxChars,
+		ReduceModel:        withDefault(cfg.ReduceModel, DefaultReduceModel),
+		CustomPrompts:      builders.Map != nil || builders.Reduce != nil,
+		Vars:               cfg.Vars,
+	}
+	if err := validateCleanerConfig(internalCfg); err != nil {
+		return nil, err
+	}
+	for i, rule := range cfg.Routing {
+		internal := rule.internal()
+		if err := internal.Validate(); err != nil {
+			return nil, fmt.Errorf("Routing[%d]: %w", i, err)
+		}
+		internalCfg.Routing = append(internalCfg.Routing, internal)
+	}
+	c, err := cleaner.NewCleaner(internalBuilders, internalExecutor(executor), internalCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Cleaner{inner: c}, nil
+}
+
+// Generate は、pages を構造化した最終文書と診断情報を返します。本文が空のページは除外します。
+func (c *Cleaner) Generate(ctx context.Context, pages []Page) (Result, error) {
+	result, err := c.inner.CleanAndStructureText(ctx, urlResults(pages))
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Markdown: result.Markdown, Report: newReport(result.Report)}, nil
+}
+
+// validateCleanerConfig は、処理モードと引用の設定を検証します。
+func validateCleanerConfig(cfg cleaner.CleanerConfig) error {
+	if err := cleaner.ValidateModelChain(cfg.ReduceModel); err != nil {
+		return fmt.Errorf("ReduceModel: %w", err)
+	}
+	if err := cleaner.ValidateMode(cfg.Mode); err != nil {
+		return err
+	}
+	if err := cleaner.ValidateCitationPolicy(cfg.CitationPolicy); err != nil {
+		return err
+	}
+	return cleaner.ValidateCitationStyle(cfg.CitationStyle)
+}
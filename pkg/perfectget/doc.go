@@ -0,0 +1,41 @@
+// Package perfectget は、Webコンテンツの取得と LLM による MapReduce 構造化を、
+// CLI を介さずに Go のサービスへ組み込むための公開 API です。
+//
+// 使い方は Summarize です。URLのリストを渡すと、コンテンツを取得し、
+// Map (セグメントごとの中間要約) と Reduce (統合と構造化) を実行した最終文書を返します。
+//
+//	doc, err := perfectget.Summarize(ctx, []string{"https://example.com/"}, perfectget.Options{
+//		Language: "ja",
+//		Query:    "主な変更点は？",
+//	})
+//
+// LLM (Model)、Webスクレイピング (Scraper)、プロンプトテンプレート (Prompts) は Options で差し替えられます。
+//
+// ファイルからのURLリストの読み込みや出力先への書き出しを含めて CLI の run と同じ処理を実行する場合は、
+// Build で Pipeline を構築して Execute を呼び出します。パイプラインの各ステージ (URLGenerator, ContentFetcher,
+// MarkdownGenerator, Publisher) と LLMExecutor, PromptBuilder, InputReader, Writer は Build の関数オプションで差し替えられ、
+// NewPipeline ではすべてのステージを指定して Pipeline を作成できます。構造化のみを行う Cleaner は NewCleaner で作成し、
+// NewLLMExecutor の既定の実装または独自の LLMExecutor を注入します。
+//
+//	p, closer, err := perfectget.Build(ctx, perfectget.PipelineOptions{
+//		Options:    perfectget.Options{Language: "ja"},
+//		URLFile:    "urls.txt",
+//		OutputPath: "report.md",
+//	}, perfectget.WithPublisher(myPublisher))
+//	if err != nil {
+//		return err
+//	}
+//	defer closer()
+//	err = p.Execute(ctx)
+//
+// # 互換性 (セマンティックバージョニング)
+//
+// このパッケージの型はすべてこのパッケージで定義しており、内部パッケージの型を公開していません。
+// エクスポートされた識別子は、メジャーバージョン (v1) の範囲で後方互換性を保ちます。
+// 関数・メソッドのシグネチャの変更、識別子の削除、インターフェースへのメソッド追加は、メジャーバージョンの更新でのみ行います。
+// 構造体へのフィールドの追加は互換な変更とみなすため、構造体はフィールド名を指定して初期化してください。
+// internal 以下のパッケージは公開 API ではなく、予告なく変更されます。
+package perfectget
+
+// Version は、公開 API のバージョンです。
+const Version = "1.0.0"
@@ -0,0 +1,61 @@
+package perfectget_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/shouni/action-perfect-get-on-go/pkg/perfectget"
+)
+
+// pages は、登録済みの本文を返す Scraper です。
+type pages map[string]string
+
+func (p pages) Scrape(ctx context.Context, urls []string) []perfectget.Page {
+	var results []perfectget.Page
+	for _, u := range urls {
+		if content, ok := p[u]; ok {
+			results = append(results, perfectget.Page{URL: u, Content: content})
+		}
+	}
+	return results
+}
+
+func ExampleSummarize() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	// 例ではネットワークにアクセスしないよう、Scraper と Model を注入する
+	scraper := pages{
+		"https://example.com/a": "ゴルーチンは軽量なスレッドです。",
+		"https://example.com/b": "チャネルはゴルーチン間で値を受け渡します。",
+	}
+	model := perfectget.ModelFunc(func(ctx context.Context, req perfectget.Request) (perfectget.Response, error) {
+		text := "# Go の並行処理\n\n## 概要\n\nゴルーチンとチャネルで並行処理を記述します。[出典](https://example.com/a)\n"
+		return perfectget.Response{Text: text, FinishReason: "STOP"}, nil
+	})
+
+	doc, err := perfectget.Summarize(context.Background(),
+		[]string{"https://example.com/a", "https://example.com/b", "https://example.com/missing"},
+		perfectget.Options{
+			Topic:   "Go の並行処理",
+			Mode:    perfectget.ModeSingle,
+			Model:   model,
+			Scraper: scraper,
+		})
+	if err != nil {
+		fmt.Println("error:", err)
+		return
+	}
+
+	fmt.Println(strings.SplitN(doc.Markdown, "\n", 2)[0])
+	fmt.Println("sources:", doc.Sources)
+	fmt.Println("failed:", doc.FailedURLs)
+	fmt.Println("mode:", doc.Report.Mode)
+	// Output:
+	// # Go の並行処理
+	// sources: [https://example.com/a https://example.com/b]
+	// failed: [https://example.com/missing]
+	// mode: single
+}
@@ -0,0 +1,270 @@
+package perfectget
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+)
+
+// LLMExecutor は、Map・Reduce・構造修復の LLM 呼び出しを実行する契約です。
+// 既定の実装は NewLLMExecutor で作成します。Cleaner や Build (WithExecutor) に独自の実装を注入できます。
+type LLMExecutor interface {
+	// ExecuteMap は、セグメントごとに builder で Map のプロンプトを生成して中間要約を作成し、セグメント順に返します。
+	ExecuteMap(ctx context.Context, segments []Segment, builder *PromptBuilder, data TemplateData) ([]MapResult, error)
+	// ExecuteReduce は、中間要約を結合した combinedText から最終文書を生成します。
+	// model は使用するモデルの上書きです (空の場合は Reduce の既定モデル)。
+	ExecuteReduce(ctx context.Context, model string, combinedText string, builder *PromptBuilder, data TemplateData) (Generation, error)
+	// ExecuteRepair は、構造ルールに違反した document を修復します。model の扱いは ExecuteReduce と同じです。
+	ExecuteRepair(ctx context.Context, model string, document string, violations []string, builder *PromptBuilder, data TemplateData) (Generation, error)
+}
+
+// Segment は、Map の入力となるテキストの断片です。
+type Segment struct {
+	Text  string
+	URL   string
+	Title string
+	// SourceID は、セグメントの由来となったソースの識別子です (脚注マーカーの番号に使用)。
+	SourceID int
+	// FetchedAt は、セグメントの由来となったソースの取得日時です。
+	FetchedAt time.Time
+	// Index と Total は、全セグメント中の位置 (1始まり) と総数です。
+	Index int
+	Total int
+	// Model は、ルーティングで選択された Map のモデルです (空の場合は Map の既定モデル)。
+	Model string
+	// Route は、適用されたルーティングルールの識別名です (適用されていない場合は空)。
+	Route string
+}
+
+// MapResult は、1セグメント分の Map の結果です。
+type MapResult struct {
+	// Index はセグメントの位置 (1始まり) です。
+	Index int
+	URL   string
+	// Summary は中間要約です。本文を抽出できなかった場合と、Irrelevant の場合は空です。
+	Summary string
+	// Irrelevant は、クエリに関連する情報がないと判定されたかどうかです。
+	Irrelevant bool
+	// Model は、応答を生成したモデルです。
+	Model string
+	// FinishReason は、応答がブロック・途中終了・空だった場合の終了理由です (正常な場合は空)。
+	FinishReason string
+	// Recovery は、応答ポリシーにより適用したアクションです (適用しなかった場合は空)。
+	Recovery string
+	Usage    Usage
+}
+
+// Generation は、1回の LLM 生成の結果です。
+type Generation struct {
+	Text string
+	// Model は、実際に応答を生成したモデルです。
+	Model string
+	// FinishReason は、最初の応答がブロック・途中終了・空だった場合の終了理由です (正常な場合は空)。
+	FinishReason string
+	// Recovery は、応答ポリシーにより適用したアクションです (適用しなかった場合は空)。
+	Recovery string
+	Usage    Usage
+}
+
+// LLMExecutorConfig は、NewLLMExecutor の設定です。ゼロ値のフィールドには Summarize と同じ既定値を使用します。
+type LLMExecutorConfig struct {
+	// APIKey は Gemini API キーです。空の場合は環境変数 GEMINI_API_KEY または GOOGLE_API_KEY を使用します。
+	APIKey string
+	// MapModel と ReduceModel は使用するモデル名です。カンマ区切りで失敗時のフォールバック順を指定できます。
+	MapModel    string
+	ReduceModel string
+	// Concurrency は Map の LLM 最大同時実行数です。
+	Concurrency int
+	// ResponsePolicy は、ブロック・途中終了・空の応答に適用するアクションの順序です。
+	ResponsePolicy []string
+	// Model は、Gemini の代わりに使用する実装です (nil の場合は Gemini)。
+	Model Model
+}
+
+// NewLLMExecutor は、Map を並列に実行し、モデルのフォールバックと応答ポリシーを適用する既定の LLMExecutor を作成します。
+func NewLLMExecutor(ctx context.Context, cfg LLMExecutorConfig) (LLMExecutor, error) {
+	mapModel := withDefault(cfg.MapModel, DefaultMapModel)
+	reduceModel := withDefault(cfg.ReduceModel, DefaultReduceModel)
+	if err := cleaner.ValidateModelChain(mapModel); err != nil {
+		return nil, err
+	}
+	if err := cleaner.ValidateModelChain(reduceModel); err != nil {
+		return nil, err
+	}
+	var policy []string
+	if len(cfg.ResponsePolicy) > 0 {
+		var err error
+		if policy, err = cleaner.ParseResponsePolicy(strings.Join(cfg.ResponsePolicy, ",")); err != nil {
+			return nil, err
+		}
+	}
+	internalCfg := cleaner.LLMExecutorConfig{
+		APIKeyOverride: cfg.APIKey,
+		Concurrency:    withDefault(cfg.Concurrency, cleaner.DefaultMaxMapConcurrency),
+		MapModel:       mapModel,
+		ReduceModel:    reduceModel,
+		ResponsePolicy: policy,
+	}
+	if cfg.Model != nil {
+		internalCfg.Client = modelClient{model: cfg.Model}
+	}
+	executor, err := cleaner.NewLLMConcurrentExecutor(ctx, internalCfg)
+	if err != nil {
+		return nil, err
+	}
+	return executorImpl{inner: executor}, nil
+}
+
+// executorImpl は、内部の LLMExecutor を公開の LLMExecutor として使用するアダプタです。
+type executorImpl struct {
+	inner cleaner.LLMExecutor
+}
+
+func (e executorImpl) ExecuteMap(ctx context.Context, segments []Segment, builder *PromptBuilder, data TemplateData) ([]MapResult, error) {
+	internal := make([]cleaner.Segment, len(segments))
+	for i, s := range segments {
+		internal[i] = s.internal()
+	}
+	results, err := e.inner.ExecuteMap(ctx, internal, builder.inner, data.internal())
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MapResult, len(results))
+	for i, r := range results {
+		out[i] = newMapResult(r)
+	}
+	return out, nil
+}
+
+func (e executorImpl) ExecuteReduce(ctx context.Context, model string, combinedText string, builder *PromptBuilder, data TemplateData) (Generation, error) {
+	gen, err := e.inner.ExecuteReduce(ctx, model, combinedText, builder.inner, data.internal())
+	return newGeneration(gen), err
+}
+
+func (e executorImpl) ExecuteRepair(ctx context.Context, model string, document string, violations []string, builder *PromptBuilder, data TemplateData) (Generation, error) {
+	gen, err := e.inner.ExecuteRepair(ctx, model, document, violations, builder.inner, data.internal())
+	return newGeneration(gen), err
+}
+
+// executorAdapter は、公開の LLMExecutor を内部の cleaner.LLMExecutor として使用するアダプタです。
+type executorAdapter struct {
+	executor LLMExecutor
+}
+
+var _ cleaner.LLMExecutor = executorAdapter{}
+
+// internalExecutor は、executor を内部の LLMExecutor に変換します。NewLLMExecutor の実装はそのまま取り出します。
+func internalExecutor(executor LLMExecutor) cleaner.LLMExecutor {
+	if impl, ok := executor.(executorImpl); ok {
+		return impl.inner
+	}
+	return executorAdapter{executor: executor}
+}
+
+func (a executorAdapter) ExecuteMap(ctx context.Context, segments []cleaner.Segment, builder *prompts.PromptBuilder, common prompts.CommonTemplateData) ([]cleaner.MapResult, error) {
+	public := make([]Segment, len(segments))
+	for i, s := range segments {
+		public[i] = newSegment(s)
+	}
+	results, err := a.executor.ExecuteMap(ctx, public, wrapBuilder(builder), newTemplateData(common))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]cleaner.MapResult, len(results))
+	for i, r := range results {
+		out[i] = r.internal()
+	}
+	return out, nil
+}
+
+func (a executorAdapter) ExecuteReduce(ctx context.Context, model string, combinedText string, builder *prompts.PromptBuilder, common prompts.CommonTemplateData) (cleaner.Generation, error) {
+	gen, err := a.executor.ExecuteReduce(ctx, model, combinedText, wrapBuilder(builder), newTemplateData(common))
+	return gen.internal(), err
+}
+
+func (a executorAdapter) ExecuteRepair(ctx context.Context, model string, document string, violations []string, builder *prompts.PromptBuilder, common prompts.CommonTemplateData) (cleaner.Generation, error) {
+	gen, err := a.executor.ExecuteRepair(ctx, model, document, violations, wrapBuilder(builder), newTemplateData(common))
+	return gen.internal(), err
+}
+
+func newSegment(s cleaner.Segment) Segment {
+	return Segment{
+		Text:      s.Text,
+		URL:       s.URL,
+		Title:     s.Title,
+		SourceID:  s.SourceID,
+		FetchedAt: s.FetchedAt,
+		Index:     s.Index,
+		Total:     s.Total,
+		Model:     s.Model,
+		Route:     s.Route,
+	}
+}
+
+func (s Segment) internal() cleaner.Segment {
+	return cleaner.Segment{
+		Text:      s.Text,
+		URL:       s.URL,
+		Title:     s.Title,
+		SourceID:  s.SourceID,
+		FetchedAt: s.FetchedAt,
+		Index:     s.Index,
+		Total:     s.Total,
+		Model:     s.Model,
+		Route:     s.Route,
+	}
+}
+
+func newMapResult(r cleaner.MapResult) MapResult {
+	return MapResult{
+		Index:        r.Index,
+		URL:          r.URL,
+		Summary:      r.Summary,
+		Irrelevant:   r.Irrelevant,
+		Model:        r.Model,
+		FinishReason: r.FinishReason,
+		Recovery:     r.Recovery,
+		Usage:        newUsage(r.Usage),
+	}
+}
+
+func (r MapResult) internal() cleaner.MapResult {
+	return cleaner.MapResult{
+		Index:        r.Index,
+		URL:          r.URL,
+		Summary:      r.Summary,
+		Irrelevant:   r.Irrelevant,
+		Model:        r.Model,
+		FinishReason: r.FinishReason,
+		Recovery:     r.Recovery,
+		Usage:        r.Usage.internal(),
+	}
+}
+
+func newGeneration(g cleaner.Generation) Generation {
+	return Generation{
+		Text:         g.Text,
+		Model:        g.Model,
+		FinishReason: g.FinishReason,
+		Recovery:     g.Recovery,
+		Usage:        newUsage(g.Usage),
+	}
+}
+
+func (g Generation) internal() cleaner.Generation {
+	return cleaner.Generation{
+		Text:         g.Text,
+		Model:        g.Model,
+		FinishReason: g.FinishReason,
+		Recovery:     g.Recovery,
+		Usage:        g.Usage.internal(),
+	}
+}
+
+func (u Usage) internal() llm.Usage {
+	return llm.Usage{PromptTokens: u.PromptTokens, OutputTokens: u.OutputTokens, TotalTokens: u.TotalTokens}
+}
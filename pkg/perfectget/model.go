@@ -0,0 +1,112 @@
+package perfectget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+
+	"google.golang.org/genai"
+)
+
+// ErrQuotaExceeded は、レート制限やクォータの枯渇を示すエラーです。
+// Model の実装がこのエラーをラップして返すと、Gemini の HTTP 429 と同様に、フォールバック先のモデルで再試行します。
+var ErrQuotaExceeded = errors.New("LLM のレート制限またはクォータの上限に達しました")
+
+// Model は、LLM によるテキスト生成の契約です。Gemini 以外の LLM やテスト用のフェイクを Options.Model に指定するために使用します。
+// 1回の Generate は、Map のセグメント・Reduce・構造修復のいずれか1つのプロンプトに対する生成です。
+// 応答がブロック・途中終了した場合は、エラーではなく Response の Blocked と FinishReason で返してください
+// (応答ポリシー Options.ResponsePolicy による再試行や分割の対象になります)。
+type Model interface {
+	Generate(ctx context.Context, req Request) (Response, error)
+}
+
+// ModelFunc は、関数を Model として使用するためのアダプタです。
+type ModelFunc func(ctx context.Context, req Request) (Response, error)
+
+// Generate は f(ctx, req) を呼び出します。
+func (f ModelFunc) Generate(ctx context.Context, req Request) (Response, error) {
+	return f(ctx, req)
+}
+
+// Request は、1回の生成のリクエストです。
+type Request struct {
+	// Model は、使用するモデル名です (MapModel・ReduceModel・ルーティングで指定したフォールバックの各モデル)。
+	Model string
+	// Prompt は、プロンプトテンプレートから生成したプロンプトです。
+	Prompt string
+	// Temperature は、応答温度の上書きです (nil の場合はモデルの既定値)。
+	Temperature *float32
+	// MaxOutputTokens は、最大出力トークン数の上書きです (0 の場合はモデルの既定値)。
+	MaxOutputTokens int
+	// RelaxedSafety は、応答ポリシーの relax-safety により、セーフティフィルタのしきい値の緩和が要求されたかどうかです。
+	RelaxedSafety bool
+}
+
+// Response は、1回の生成の結果です。
+type Response struct {
+	Text string
+	// FinishReason は、生成を終了した理由です (例: "STOP", "MAX_TOKENS", "SAFETY")。空の場合は正常終了とみなします。
+	FinishReason string
+	// Blocked は、プロンプト自体がブロックされたかどうかです。
+	Blocked bool
+	// Usage は、この生成で消費したトークン数です。
+	Usage Usage
+}
+
+// modelClient は、Model を内部の LLM クライアント (llm.GenerativeModel) として使用するアダプタです。
+type modelClient struct {
+	model Model
+}
+
+var _ llm.GenerativeModel = modelClient{}
+
+func (c modelClient) GenerateContent(ctx context.Context, prompt string, modelName string, opts ...llm.GenerateOption) (*llm.Response, error) {
+	cfg := llm.ApplyOptions(opts...)
+	req := Request{
+		Model:           modelName,
+		Prompt:          prompt,
+		Temperature:     cfg.Temperature,
+		MaxOutputTokens: int(cfg.MaxOutputTokens),
+		RelaxedSafety:   len(cfg.SafetySettings) > 0,
+	}
+	resp, err := c.model.Generate(ctx, req)
+	if err != nil {
+		if errors.Is(err, ErrQuotaExceeded) {
+			return nil, fmt.Errorf("%w: %w", genai.APIError{Code: http.StatusTooManyRequests, Message: err.Error()}, err)
+		}
+		return nil, err
+	}
+
+	switch {
+	case resp.Blocked:
+		return nil, llm.NewResponseError(resp.FinishReason, true, fmt.Sprintf("プロンプトがブロックされました (model: %s)", modelName))
+	case resp.FinishReason != "" && resp.FinishReason != string(genai.FinishReasonStop):
+		return nil, llm.NewResponseError(resp.FinishReason, false, fmt.Sprintf("応答がブロックされたか、途中で終了しました。理由: %s", resp.FinishReason))
+	case resp.Text == "":
+		return nil, llm.NewResponseError(resp.FinishReason, false, fmt.Sprintf("応答のテキストが空です (model: %s)", modelName))
+	}
+	return &llm.Response{
+		Text:         resp.Text,
+		FinishReason: resp.FinishReason,
+		Usage: llm.Usage{
+			PromptTokens: resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.OutputTokens,
+			TotalTokens:  resp.Usage.TotalTokens,
+		},
+	}, nil
+}
+
+// GenerateContentStream は、Model がストリーミングに対応しないため、生成したテキスト全体を1つのチャンクとして onChunk に渡します。
+func (c modelClient) GenerateContentStream(ctx context.Context, prompt string, modelName string, onChunk func(text string) error, opts ...llm.GenerateOption) (*llm.Response, error) {
+	resp, err := c.GenerateContent(ctx, prompt, modelName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := onChunk(resp.Text); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
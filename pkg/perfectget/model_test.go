@@ -0,0 +1,48 @@
+package perfectget
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+)
+
+func TestModelClientClassifiesResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		resp Response
+		err  error
+		want llm.FailureClass
+	}{
+		{name: "blocked prompt", resp: Response{Blocked: true}, want: llm.FailureSafety},
+		{name: "safety finish reason", resp: Response{Text: "途中", FinishReason: "SAFETY"}, want: llm.FailureSafety},
+		{name: "max tokens", resp: Response{Text: "途中", FinishReason: "MAX_TOKENS"}, want: llm.FailureTruncated},
+		{name: "empty text", resp: Response{FinishReason: "STOP"}, want: llm.FailureEmpty},
+		{name: "quota", err: fmt.Errorf("rate limited: %w", ErrQuotaExceeded), want: llm.FailureQuota},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := modelClient{model: ModelFunc(func(ctx context.Context, req Request) (Response, error) {
+				return tt.resp, tt.err
+			})}
+			_, err := client.GenerateContent(context.Background(), "プロンプト", "m")
+			if got := llm.Classify(err); got != tt.want {
+				t.Errorf("Classify(%v) = %s, want %s", err, got, tt.want)
+			}
+		})
+	}
+
+	var got Request
+	client := modelClient{model: ModelFunc(func(ctx context.Context, req Request) (Response, error) {
+		got = req
+		return Response{Text: "本文"}, nil
+	})}
+	if _, err := client.GenerateContent(context.Background(), "プロンプト", "m", llm.WithTemperature(0.5), llm.WithRelaxedSafety()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Model != "m" || got.Temperature == nil || *got.Temperature != 0.5 || !got.RelaxedSafety {
+		t.Errorf("Request = %+v, want the overridden settings", got)
+	}
+}
@@ -0,0 +1,279 @@
+package perfectget
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// 出力形式 (PipelineOptions.OutputFormat) です。
+const (
+	// FormatAuto は出力先から形式を自動判定します (GCS または .html/.htm は HTML、それ以外は Markdown)。
+	FormatAuto     = "auto"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+// StdoutPath は、最終文書を標準出力に書き出すことを示す出力パス (PipelineOptions.OutputPath) です。
+const StdoutPath = "-"
+
+// ----------------------------------------------------------------
+// パイプラインのステージ
+// ----------------------------------------------------------------
+
+// URLGenerator は、処理対象のURLリストを生成するステージの契約です。
+type URLGenerator interface {
+	Generate(ctx context.Context) ([]string, error)
+}
+
+// ContentFetcher は、URLリストのコンテンツを取得するステージの契約です。
+type ContentFetcher interface {
+	// Fetch は、取得に成功したページを返します。1件も取得できなかった場合はエラーを返します。
+	Fetch(ctx context.Context, urls []string) ([]Page, error)
+}
+
+// MarkdownGenerator は、取得したページから構造化された最終文書を生成するステージの契約です。Cleaner が満たします。
+type MarkdownGenerator interface {
+	Generate(ctx context.Context, pages []Page) (Result, error)
+}
+
+// Publisher は、最終文書を出力先へ書き出すステージの契約です。
+type Publisher interface {
+	Publish(ctx context.Context, markdown string) error
+}
+
+// InputReader は、URLリストとプロンプトテンプレートのファイル (ローカルまたは gs:// のURI) を開く契約です。
+type InputReader interface {
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// Writer は、最終文書をローカルファイルと GCS へ書き込む契約です。
+type Writer interface {
+	WriteToGCS(ctx context.Context, bucket, path string, content io.Reader, contentType string) error
+	WriteToLocal(ctx context.Context, path string, content io.Reader) error
+}
+
+// ----------------------------------------------------------------
+// Pipeline
+// ----------------------------------------------------------------
+
+// PipelineOptions は、Pipeline の実行設定です (CLI の run サブコマンドに対応します)。
+type PipelineOptions struct {
+	// Options は、構造化の設定です。Model・Scraper・Prompts は Build でのみ使用します。
+	Options
+	// URLFile は、処理対象のURLリストのファイル (ローカルまたは gs:// のURI) です。
+	URLFile string
+	// OutputPath は、最終文書の出力先 (ローカル、gs:// のURI、または標準出力の StdoutPath) です。
+	OutputPath string
+	// OutputFormat は出力形式 (FormatAuto, FormatMarkdown, FormatHTML) です。
+	OutputFormat string
+	// MapPromptPath と ReducePromptPath は、カスタムプロンプトテンプレートのファイルです (Options.Prompts より優先度は低い)。
+	MapPromptPath    string
+	ReducePromptPath string
+}
+
+// cmdOptions は、既定値を補完し、値を検証したパイプラインの実行設定を返します。
+func (o PipelineOptions) cmdOptions() (pipeline.CmdOptions, error) {
+	opts, err := o.Options.pipelineOptions()
+	if err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+	opts.URLFile = o.URLFile
+	opts.OutputFilePath = o.OutputPath
+	opts.OutputFormat = withDefault(o.OutputFormat, FormatAuto)
+	opts.MapPromptPath = o.MapPromptPath
+	opts.ReducePromptPath = o.ReducePromptPath
+	if _, err := pipeline.ResolveOutputFormat(opts.OutputFilePath, opts.OutputFormat); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+	return opts, nil
+}
+
+// Pipeline は、URL生成・コンテンツ取得・構造化・出力の各ステージを順に実行します。
+// Build (既定の実装) または NewPipeline (ステージを指定) で作成します。
+type Pipeline struct {
+	inner *pipeline.Pipeline
+}
+
+// NewPipeline は、ステージの実装を受け取り Pipeline を作成します。
+func NewPipeline(opts PipelineOptions, urlGen URLGenerator, fetcher ContentFetcher, markdownGen MarkdownGenerator, publisher Publisher) (*Pipeline, error) {
+	if urlGen == nil || fetcher == nil || markdownGen == nil || publisher == nil {
+		return nil, fmt.Errorf("パイプラインのステージは nil にできません")
+	}
+	cmdOpts, err := opts.cmdOptions()
+	if err != nil {
+		return nil, err
+	}
+	p := &pipeline.Pipeline{Options: cmdOpts}
+	stages{urlGen: urlGen, fetcher: fetcher, markdownGen: markdownGen, publisher: publisher}.apply(p)
+	return &Pipeline{inner: p}, nil
+}
+
+// Execute は、各ステージを順に実行します。
+// ctx がキャンセルされた場合、実行中の LLM 呼び出しとスクレイピングは中断されます。
+func (p *Pipeline) Execute(ctx context.Context) error {
+	return p.inner.Execute(ctx)
+}
+
+// ----------------------------------------------------------------
+// Build
+// ----------------------------------------------------------------
+
+// Option は、Build が構築する依存関係を差し替える関数オプションです。
+type Option func(*buildConfig)
+
+type buildConfig struct {
+	executor LLMExecutor
+	prompts  PromptBuilders
+	reader   InputReader
+	writer   Writer
+	stages   stages
+}
+
+// WithExecutor は、既定の LLMExecutor の代わりに使用する実装を指定します (Options.Model より優先します)。
+func WithExecutor(executor LLMExecutor) Option {
+	return func(c *buildConfig) { c.executor = executor }
+}
+
+// WithPromptBuilders は、使用する PromptBuilder を指定します。nil のフィールドは Options.Prompts、
+// PipelineOptions のテンプレートファイル、組み込みテンプレートの順に決定します。
+func WithPromptBuilders(builders PromptBuilders) Option {
+	return func(c *buildConfig) { c.prompts = builders }
+}
+
+// WithReader は、URLリストとプロンプトテンプレートの読み込みに使用する InputReader を指定します。
+func WithReader(reader InputReader) Option {
+	return func(c *buildConfig) { c.reader = reader }
+}
+
+// WithWriter は、最終文書の書き込みに使用する Writer を指定します。
+func WithWriter(writer Writer) Option {
+	return func(c *buildConfig) { c.writer = writer }
+}
+
+// WithURLGenerator は、URLリストのファイルを読み込む既定のステージの代わりに使用する URLGenerator を指定します。
+func WithURLGenerator(gen URLGenerator) Option {
+	return func(c *buildConfig) { c.stages.urlGen = gen }
+}
+
+// WithContentFetcher は、既定のステージ (Options.Scraper または Webスクレイパー) の代わりに使用する ContentFetcher を指定します。
+func WithContentFetcher(fetcher ContentFetcher) Option {
+	return func(c *buildConfig) { c.stages.fetcher = fetcher }
+}
+
+// WithMarkdownGenerator は、既定の Cleaner の代わりに使用する MarkdownGenerator を指定します。
+func WithMarkdownGenerator(gen MarkdownGenerator) Option {
+	return func(c *buildConfig) { c.stages.markdownGen = gen }
+}
+
+// WithPublisher は、OutputPath へ書き出す既定のステージの代わりに使用する Publisher を指定します。
+func WithPublisher(publisher Publisher) Option {
+	return func(c *buildConfig) { c.stages.publisher = publisher }
+}
+
+// Build は、既定の実装 (Gemini、Webスクレイパー、GCS/ローカルの入出力) で Pipeline を構築し、
+// リソースのクリーンアップ関数とともに返します。options で依存関係とステージを差し替えられます。
+func Build(ctx context.Context, opts PipelineOptions, options ...Option) (*Pipeline, func(), error) {
+	cmdOpts, err := opts.cmdOptions()
+	if err != nil {
+		return nil, func() {}, err
+	}
+	var cfg buildConfig
+	for _, opt := range options {
+		opt(&cfg)
+	}
+
+	buildOpts := opts.Options.buildOptions(cfg.prompts)
+	if cfg.executor != nil {
+		buildOpts = append(buildOpts, builder.WithExecutor(internalExecutor(cfg.executor)))
+	}
+	if cfg.reader != nil {
+		buildOpts = append(buildOpts, builder.WithReader(cfg.reader))
+	}
+	if cfg.writer != nil {
+		buildOpts = append(buildOpts, builder.WithWriter(cfg.writer))
+	}
+	p, closer, err := builder.BuildPipeline(ctx, cmdOpts, buildOpts...)
+	if err != nil {
+		return nil, closer, fmt.Errorf("パイプラインの構築に失敗しました: %w", err)
+	}
+	cfg.stages.apply(p)
+	return &Pipeline{inner: p}, closer, nil
+}
+
+// ----------------------------------------------------------------
+// ステージのアダプタ
+// ----------------------------------------------------------------
+
+// stages は、内部のパイプラインに設定する公開のステージです。nil のステージは設定しません。
+type stages struct {
+	urlGen      URLGenerator
+	fetcher     ContentFetcher
+	markdownGen MarkdownGenerator
+	publisher   Publisher
+}
+
+func (s stages) apply(p *pipeline.Pipeline) {
+	if s.urlGen != nil {
+		p.URLGen = urlGeneratorAdapter{gen: s.urlGen}
+	}
+	if s.fetcher != nil {
+		p.Fetcher = contentFetcherAdapter{fetcher: s.fetcher}
+	}
+	if c, ok := s.markdownGen.(*Cleaner); ok {
+		p.MarkdownGen = pipeline.NewLLMMarkdownGeneratorImpl(c.inner)
+	} else if s.markdownGen != nil {
+		p.MarkdownGen = markdownGeneratorAdapter{gen: s.markdownGen}
+	}
+	if s.publisher != nil {
+		p.Publisher = publisherAdapter{publisher: s.publisher}
+	}
+}
+
+type urlGeneratorAdapter struct {
+	gen URLGenerator
+}
+
+func (a urlGeneratorAdapter) Generate(ctx context.Context, _ pipeline.CmdOptions) ([]string, error) {
+	return a.gen.Generate(ctx)
+}
+
+type contentFetcherAdapter struct {
+	fetcher ContentFetcher
+}
+
+func (a contentFetcherAdapter) Fetch(ctx context.Context, _ pipeline.CmdOptions, urls []string) ([]extTypes.URLResult, error) {
+	pages, err := a.fetcher.Fetch(ctx, urls)
+	if err != nil {
+		return nil, err
+	}
+	return urlResults(pages), nil
+}
+
+// markdownGeneratorAdapter は、独自の MarkdownGenerator を内部のステージとして使用するアダプタです。
+// 診断情報のうち、実行記録に引き継ぐのは処理モードのみです。
+type markdownGeneratorAdapter struct {
+	gen MarkdownGenerator
+}
+
+func (a markdownGeneratorAdapter) Generate(ctx context.Context, _ pipeline.CmdOptions, results []extTypes.URLResult) (*cleaner.Result, error) {
+	result, err := a.gen.Generate(ctx, newPages(results))
+	if err != nil {
+		return nil, err
+	}
+	return &cleaner.Result{Markdown: result.Markdown, Report: cleaner.Report{Mode: result.Report.Mode}}, nil
+}
+
+type publisherAdapter struct {
+	publisher Publisher
+}
+
+func (a publisherAdapter) Publish(ctx context.Context, _ pipeline.CmdOptions, markdown string) error {
+	return a.publisher.Publish(ctx, markdown)
+}
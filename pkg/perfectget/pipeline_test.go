@@ -0,0 +1,135 @@
+package perfectget
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+const testDocument = "# Go の並行処理\n\n## 概要\n\nゴルーチンとチャネルで並行処理を記述します。[出典](https://example.com/a)\n"
+
+type staticURLs []string
+
+func (u staticURLs) Generate(ctx context.Context) ([]string, error) { return u, nil }
+
+type staticFetcher map[string]string
+
+func (f staticFetcher) Fetch(ctx context.Context, urls []string) ([]Page, error) {
+	var pages []Page
+	for _, u := range urls {
+		pages = append(pages, Page{URL: u, Content: f[u]})
+	}
+	return pages, nil
+}
+
+type capturePublisher struct{ markdown string }
+
+func (p *capturePublisher) Publish(ctx context.Context, markdown string) error {
+	p.markdown = markdown
+	return nil
+}
+
+// recordingExecutor は、Map で各セグメントのプロンプトを生成し、固定の中間要約と最終文書を返す LLMExecutor です。
+type recordingExecutor struct {
+	mapPrompts []string
+	reduceData ReducePromptData
+}
+
+func (e *recordingExecutor) ExecuteMap(ctx context.Context, segments []Segment, builder *PromptBuilder, data TemplateData) ([]MapResult, error) {
+	results := make([]MapResult, len(segments))
+	for i, s := range segments {
+		prompt, err := builder.BuildMap(MapPromptData{TemplateData: data, SegmentText: s.Text, SourceURL: s.URL, SegmentIndex: s.Index, SegmentTotal: s.Total})
+		if err != nil {
+			return nil, err
+		}
+		e.mapPrompts = append(e.mapPrompts, prompt)
+		results[i] = MapResult{Index: s.Index, URL: s.URL, Summary: "要約: " + s.URL, Model: "custom"}
+	}
+	return results, nil
+}
+
+func (e *recordingExecutor) ExecuteReduce(ctx context.Context, model string, combinedText string, builder *PromptBuilder, data TemplateData) (Generation, error) {
+	e.reduceData = ReducePromptData{TemplateData: data, CombinedText: combinedText}
+	return Generation{Text: testDocument, Model: "custom"}, nil
+}
+
+func (e *recordingExecutor) ExecuteRepair(ctx context.Context, model string, document string, violations []string, builder *PromptBuilder, data TemplateData) (Generation, error) {
+	return Generation{Text: document, Model: "custom"}, nil
+}
+
+func TestNewPipelineWithCustomStagesAndExecutor(t *testing.T) {
+	executor := &recordingExecutor{}
+	c, err := NewCleaner(PromptBuilders{
+		Map: NewMapPromptBuilderFromTemplate("map", "{{.Topic}}: {{.SegmentText}}"),
+	}, executor, CleanerConfig{Topic: "Go の並行処理", Mode: ModeMapReduce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := &capturePublisher{}
+	urls := staticURLs{"https://example.com/a", "https://example.com/b"}
+	p, err := NewPipeline(PipelineOptions{}, urls, staticFetcher{
+		"https://example.com/a": "ゴルーチンは軽量なスレッドです。",
+		"https://example.com/b": "チャネルで値を受け渡します。",
+	}, c, publisher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Execute(context.Background()); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	if !strings.HasPrefix(publisher.markdown, "# Go の並行処理") {
+		t.Errorf("published markdown = %q", publisher.markdown)
+	}
+	if len(executor.mapPrompts) != 2 || executor.mapPrompts[0] != "Go の並行処理: ゴルーチンは軽量なスレッドです。" {
+		t.Errorf("map prompts = %q, want the custom template rendered per segment", executor.mapPrompts)
+	}
+	if !strings.Contains(executor.reduceData.CombinedText, "要約: https://example.com/b") || executor.reduceData.Topic != "Go の並行処理" {
+		t.Errorf("reduce input = %+v", executor.reduceData)
+	}
+}
+
+func TestBuildReplacesStages(t *testing.T) {
+	model := ModelFunc(func(ctx context.Context, req Request) (Response, error) {
+		return Response{Text: testDocument, FinishReason: "STOP"}, nil
+	})
+	publisher := &capturePublisher{}
+	p, closer, err := Build(context.Background(), PipelineOptions{
+		Options: Options{Mode: ModeSingle, Model: model, Scraper: pages{"https://example.com/a": "ゴルーチンは軽量なスレッドです。"}},
+	}, WithURLGenerator(staticURLs{"https://example.com/a"}), WithPublisher(publisher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+	if err := p.Execute(context.Background()); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if !strings.HasPrefix(publisher.markdown, "# Go の並行処理") {
+		t.Errorf("published markdown = %q", publisher.markdown)
+	}
+
+	if _, _, err := Build(context.Background(), PipelineOptions{OutputFormat: "pdf"}); err == nil {
+		t.Error("Build with an unknown output format succeeded, want an error")
+	}
+}
+
+// pages は、登録済みの本文を返す Scraper です。
+type pages map[string]string
+
+func (p pages) Scrape(ctx context.Context, urls []string) []Page {
+	var results []Page
+	for _, u := range urls {
+		if content, ok := p[u]; ok {
+			results = append(results, Page{URL: u, Content: content})
+		}
+	}
+	return results
+}
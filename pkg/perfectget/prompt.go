@@ -0,0 +1,198 @@
+package perfectget
+
+import (
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+)
+
+// TemplateData は、Map・Reduce・構造修復のすべてのプロンプトテンプレートに渡す共通のテンプレート変数です。
+type TemplateData struct {
+	// Language は出力言語の名前です (例: "日本語")。
+	Language string
+	Topic    string
+	Query    string
+	// NoRelevantInfoMarker は、セグメントにクエリに関連する情報がない場合に Map が返すマーカーです。
+	NoRelevantInfoMarker string
+	// AnswerSummaryHeading は、クエリ指定時に最終文書の先頭に置く回答の見出しです。
+	AnswerSummaryHeading string
+	// FootnoteCitations は、出典を脚注形式で表記するかどうかです。
+	FootnoteCitations bool
+	// FetchDate は、ソースの取得日 (YYYY-MM-DD) です。
+	FetchDate string
+	// SourceURLs は、取得に成功したソースのURLです。
+	SourceURLs []string
+	// Vars は、テンプレート変数 {{.Vars.key}} として参照できる任意の値です。
+	Vars map[string]string
+}
+
+// MapPromptData は、Map のプロンプトテンプレートに渡すテンプレート変数です。
+type MapPromptData struct {
+	TemplateData
+	SegmentText  string
+	SourceURL    string
+	SourceTitle  string
+	SourceID     int
+	SegmentIndex int
+	SegmentTotal int
+}
+
+// ReducePromptData は、Reduce のプロンプトテンプレートに渡すテンプレート変数です。
+type ReducePromptData struct {
+	TemplateData
+	// CombinedText は、Map の中間要約を結合した Reduce の入力です。
+	CombinedText string
+}
+
+// RepairPromptData は、構造修復のプロンプトテンプレートに渡すテンプレート変数です。
+type RepairPromptData struct {
+	TemplateData
+	// Document は修復対象の文書、Violations は検出された構造ルール違反です。
+	Document   string
+	Violations []string
+}
+
+func newTemplateData(c prompts.CommonTemplateData) TemplateData {
+	return TemplateData{
+		Language:             c.Language,
+		Topic:                c.Topic,
+		Query:                c.Query,
+		NoRelevantInfoMarker: c.NoRelevantInfoMarker,
+		AnswerSummaryHeading: c.AnswerSummaryHeading,
+		FootnoteCitations:    c.FootnoteCitations,
+		FetchDate:            c.FetchDate,
+		SourceURLs:           c.SourceURLs,
+		Vars:                 c.Vars,
+	}
+}
+
+func (d TemplateData) internal() prompts.CommonTemplateData {
+	return prompts.CommonTemplateData{
+		Language:             d.Language,
+		Topic:                d.Topic,
+		Query:                d.Query,
+		NoRelevantInfoMarker: d.NoRelevantInfoMarker,
+		AnswerSummaryHeading: d.AnswerSummaryHeading,
+		FootnoteCitations:    d.FootnoteCitations,
+		FetchDate:            d.FetchDate,
+		SourceURLs:           d.SourceURLs,
+		Vars:                 d.Vars,
+	}
+}
+
+// PromptBuilder は、プロンプトテンプレートからプロンプト文字列を生成します。
+// 組み込みテンプレートまたは New...FromTemplate で作成します。
+type PromptBuilder struct {
+	inner *prompts.PromptBuilder
+}
+
+// NewMapPromptBuilder は、組み込みの Map 用 PromptBuilder を作成します。
+func NewMapPromptBuilder() *PromptBuilder {
+	return &PromptBuilder{inner: prompts.NewMapPromptBuilder()}
+}
+
+// NewReducePromptBuilder は、組み込みの Reduce 用 PromptBuilder を作成します。
+func NewReducePromptBuilder() *PromptBuilder {
+	return &PromptBuilder{inner: prompts.NewReducePromptBuilder()}
+}
+
+// NewMapPromptBuilderFromTemplate は、テンプレート文字列から Map 用 PromptBuilder を作成します。
+// テンプレートの不備 (構文エラー、存在しないフィールド、{{.SegmentText}} の欠落) は Err で確認できます。
+func NewMapPromptBuilderFromTemplate(name, text string) *PromptBuilder {
+	return &PromptBuilder{inner: prompts.NewMapPromptBuilderFromTemplate(name, text)}
+}
+
+// NewReducePromptBuilderFromTemplate は、テンプレート文字列から Reduce 用 PromptBuilder を作成します。
+// テンプレートの不備 (構文エラー、存在しないフィールド、{{.CombinedText}} の欠落) は Err で確認できます。
+func NewReducePromptBuilderFromTemplate(name, text string) *PromptBuilder {
+	return &PromptBuilder{inner: prompts.NewReducePromptBuilderFromTemplate(name, text)}
+}
+
+// Name は、テンプレートの名前を返します。
+func (b *PromptBuilder) Name() string {
+	return b.inner.Name()
+}
+
+// Err は、テンプレートの解析・検証で発生したエラーを返します。
+func (b *PromptBuilder) Err() error {
+	return b.inner.Err()
+}
+
+// BuildMap は、Map のプロンプトを生成します。
+func (b *PromptBuilder) BuildMap(data MapPromptData) (string, error) {
+	return b.inner.BuildMap(prompts.MapTemplateData{
+		CommonTemplateData: data.TemplateData.internal(),
+		SegmentText:        data.SegmentText,
+		SourceURL:          data.SourceURL,
+		SourceTitle:        data.SourceTitle,
+		SourceID:           data.SourceID,
+		SegmentIndex:       data.SegmentIndex,
+		SegmentTotal:       data.SegmentTotal,
+	})
+}
+
+// BuildReduce は、Reduce のプロンプトを生成します。
+func (b *PromptBuilder) BuildReduce(data ReducePromptData) (string, error) {
+	return b.inner.BuildReduce(prompts.ReduceTemplateData{
+		CommonTemplateData: data.TemplateData.internal(),
+		CombinedText:       data.CombinedText,
+	})
+}
+
+// BuildRepair は、構造修復のプロンプトを生成します。
+func (b *PromptBuilder) BuildRepair(data RepairPromptData) (string, error) {
+	return b.inner.BuildRepair(prompts.RepairTemplateData{
+		CommonTemplateData: data.TemplateData.internal(),
+		Document:           data.Document,
+		Violations:         data.Violations,
+	})
+}
+
+// PromptBuilders は、Cleaner が使用する Map・Reduce・単一パス・構造修復の PromptBuilder の組です。
+// nil のフィールドは組み込みテンプレートを使用します。
+type PromptBuilders struct {
+	Map        *PromptBuilder
+	Reduce     *PromptBuilder
+	SinglePass *PromptBuilder
+	Repair     *PromptBuilder
+}
+
+// internal は、nil のフィールドを組み込みテンプレートで補完した内部の PromptBuilders を返します。
+func (b PromptBuilders) internal() (cleaner.PromptBuilders, error) {
+	builders := b.overlay(cleaner.PromptBuilders{
+		MapBuilder:        prompts.NewMapPromptBuilder(),
+		ReduceBuilder:     prompts.NewReducePromptBuilder(),
+		SinglePassBuilder: prompts.NewSinglePassPromptBuilder(),
+		RepairBuilder:     prompts.NewRepairPromptBuilder(),
+	})
+	for _, pb := range []*prompts.PromptBuilder{builders.MapBuilder, builders.ReduceBuilder, builders.SinglePassBuilder, builders.RepairBuilder} {
+		if err := pb.Err(); err != nil {
+			return cleaner.PromptBuilders{}, err
+		}
+	}
+	return builders, nil
+}
+
+// overlay は、base の各フィールドを b の nil でないフィールドで置き換えた内部の PromptBuilders を返します。
+func (b PromptBuilders) overlay(base cleaner.PromptBuilders) cleaner.PromptBuilders {
+	if b.Map != nil {
+		base.MapBuilder = b.Map.inner
+	}
+	if b.Reduce != nil {
+		base.ReduceBuilder = b.Reduce.inner
+	}
+	if b.SinglePass != nil {
+		base.SinglePassBuilder = b.SinglePass.inner
+	}
+	if b.Repair != nil {
+		base.RepairBuilder = b.Repair.inner
+	}
+	return base
+}
+
+// wrapBuilder は、内部の PromptBuilder を公開の PromptBuilder として返します。
+func wrapBuilder(b *prompts.PromptBuilder) *PromptBuilder {
+	if b == nil {
+		return nil
+	}
+	return &PromptBuilder{inner: b}
+}
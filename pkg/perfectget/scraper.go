@@ -0,0 +1,54 @@
+package perfectget
+
+import (
+	"context"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+
+	extTypes "github.com/shouni/go-web-exact/v2/pkg/types"
+)
+
+// Scraper は、URLのリストから本文を取得する契約です。既定の Webスクレイパーの代わりに Options.Scraper に指定します。
+type Scraper interface {
+	// Scrape は、取得に成功したURLの本文を返します。取得できなかったURLは結果に含めません。
+	Scrape(ctx context.Context, urls []string) []Page
+}
+
+// Page は、1URL分の取得結果です。
+type Page struct {
+	URL string
+	// Content は、抽出済みの本文 (Markdown またはプレーンテキスト) です。
+	Content string
+}
+
+// scraperRunner は、Scraper を内部の pipeline.ScraperRunner として使用するアダプタです。
+type scraperRunner struct {
+	scraper Scraper
+}
+
+var _ pipeline.ScraperRunner = scraperRunner{}
+
+func (s scraperRunner) ScrapeInParallel(ctx context.Context, urls []string) []extTypes.URLResult {
+	return urlResults(s.scraper.Scrape(ctx, urls))
+}
+
+// urlResults は、本文が空でないページを内部の取得結果に変換します。
+func urlResults(pages []Page) []extTypes.URLResult {
+	results := make([]extTypes.URLResult, 0, len(pages))
+	for _, p := range pages {
+		if p.Content == "" {
+			continue
+		}
+		results = append(results, extTypes.URLResult{URL: p.URL, Content: p.Content})
+	}
+	return results
+}
+
+// newPages は、内部の取得結果をページに変換します。
+func newPages(results []extTypes.URLResult) []Page {
+	pages := make([]Page, len(results))
+	for i, r := range results {
+		pages[i] = Page{URL: r.URL, Content: r.Content}
+	}
+	return pages
+}
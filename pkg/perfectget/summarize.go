@@ -0,0 +1,221 @@
+package perfectget
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shouni/action-perfect-get-on-go/internal/builder"
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/pipeline"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+)
+
+// Summarize の既定値です。
+const (
+	DefaultMapModel        = "gemini-2.5-flash"
+	DefaultReduceModel     = "gemini-2.5-pro"
+	DefaultScraperTimeout  = 15 * time.Second
+	DefaultScraperParallel = 5
+	DefaultLLMTimeout      = 5 * time.Minute
+	DefaultTimeout         = 30 * time.Minute
+)
+
+// 処理モード (Options.Mode) です。
+const (
+	ModeAuto      = "auto"
+	ModeMapReduce = "mapreduce"
+	ModeSingle    = "single"
+)
+
+// 引用URLの検証ポリシー (Options.CitationPolicy) です。
+const (
+	CitationPolicyRemove = "remove"
+	CitationPolicyFlag   = "flag"
+	CitationPolicyOff    = "off"
+)
+
+// 出典の表記方式 (Options.CitationStyle) です。
+const (
+	CitationStyleSection  = "section"
+	CitationStyleFootnote = "footnote"
+)
+
+// Options は Summarize の設定です。ゼロ値のフィールドには既定値 (CLI の run サブコマンドと同じ) を使用します。
+type Options struct {
+	// APIKey は Gemini API キーです。空の場合は環境変数 GEMINI_API_KEY または GOOGLE_API_KEY を使用します。
+	APIKey string
+	// MapModel と ReduceModel は使用するモデル名です。カンマ区切りで失敗時のフォールバック順を指定できます。
+	MapModel    string
+	ReduceModel string
+	// MapConcurrency は Map の LLM 最大同時実行数です。
+	MapConcurrency int
+	// ScraperParallel と ScraperTimeout は、Webスクレイピングの最大同時リクエスト数と HTTP タイムアウトです。
+	ScraperParallel int
+	ScraperTimeout  time.Duration
+	// LLMTimeout は、Map と Reduce による構造化 (LLM 処理) のタイムアウトです。
+	LLMTimeout time.Duration
+	// Timeout は、取得から構造化までの処理全体のタイムアウトです。
+	Timeout time.Duration
+
+	// Language は最終文書の出力言語 (例: "ja", "en") です。
+	Language string
+	// Topic は文書のトピック、Query は調査クエリです (指定するとクエリへの回答を中心とした文書を生成します)。
+	Topic string
+	Query string
+	// Vars は、テンプレート変数 {{.Vars.key}} として参照できる任意の値です。
+	Vars map[string]string
+	// Mode は処理モード (ModeAuto, ModeMapReduce, ModeSingle) です。
+	Mode string
+	// CitationPolicy と CitationStyle は、引用URLの検証ポリシーと出典の表記方式です。
+	CitationPolicy string
+	CitationStyle  string
+	// Routing は、セグメントや Reduce に使用するモデルを選択するルールです (定義順に評価)。
+	Routing []RoutingRule
+	// ResponsePolicy は、ブロック・途中終了・空の応答に適用するアクション ("retry", "relax-safety", "split", "skip", "fail") の順序です。
+	ResponsePolicy []string
+
+	// Model と Scraper は、Gemini と Webスクレイパーの代わりに使用する実装です (nil の場合は既定の実装)。
+	Model   Model
+	Scraper Scraper
+	// Prompts は、組み込みの Map・Reduce のプロンプトテンプレートの代わりに使用するテンプレートです。
+	Prompts Prompts
+}
+
+// Document は Summarize の結果です。
+type Document struct {
+	// Markdown は、構造化された最終文書です。
+	Markdown string
+	// Sources は、コンテンツの取得に成功したURLです (入力順)。
+	Sources []string
+	// FailedURLs は、コンテンツを取得できなかったURLです (入力順)。
+	FailedURLs []string
+	// Report は、セグメントごとの処理結果や引用の検証結果などの診断情報です。
+	Report Report
+}
+
+// Summarize は、urls のコンテンツを取得し、LLM で統合・構造化した文書を返します。
+// 結果はファイルなどへは出力しません。
+func Summarize(ctx context.Context, urls []string, opts Options) (Document, error) {
+	if len(urls) == 0 {
+		return Document{}, fmt.Errorf("処理対象のURLを1件以上指定してください")
+	}
+	cmdOpts, err := opts.pipelineOptions()
+	if err != nil {
+		return Document{}, err
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	p, closer, err := builder.BuildPipeline(ctx, cmdOpts, opts.buildOptions(PromptBuilders{})...)
+	if err != nil {
+		return Document{}, fmt.Errorf("パイプラインの構築に失敗しました: %w", err)
+	}
+	defer closer()
+
+	results, err := p.Fetcher.Fetch(ctx, cmdOpts, urls)
+	if err != nil {
+		return Document{}, fmt.Errorf("%sでエラーが発生しました: %w", pipeline.PhaseContent, err)
+	}
+	llmCtx, llmCancel := context.WithTimeout(ctx, cmdOpts.LLMTimeout)
+	defer llmCancel()
+	result, err := p.MarkdownGen.Generate(llmCtx, cmdOpts, results)
+	if err != nil {
+		return Document{}, fmt.Errorf("%sでエラーが発生しました: %w", pipeline.PhaseCleanUp, err)
+	}
+
+	doc := Document{Markdown: result.Markdown, Report: newReport(result.Report)}
+	fetched := make(map[string]struct{}, len(results))
+	for _, r := range results {
+		fetched[r.URL] = struct{}{}
+	}
+	for _, u := range urls {
+		if _, ok := fetched[u]; ok {
+			doc.Sources = append(doc.Sources, u)
+		} else {
+			doc.FailedURLs = append(doc.FailedURLs, u)
+		}
+	}
+	return doc, nil
+}
+
+// pipelineOptions は、既定値を補完し、値を検証したパイプラインの実行設定を返します。
+func (o Options) pipelineOptions() (pipeline.CmdOptions, error) {
+	opts := pipeline.CmdOptions{
+		LLMAPIKey:          o.APIKey,
+		LLMTimeout:         withDefault(o.LLMTimeout, DefaultLLMTimeout),
+		ScraperTimeout:     withDefault(o.ScraperTimeout, DefaultScraperTimeout),
+		MaxScraperParallel: withDefault(o.ScraperParallel, DefaultScraperParallel),
+		MapModel:           withDefault(strings.TrimSpace(o.MapModel), DefaultMapModel),
+		ReduceModel:        withDefault(strings.TrimSpace(o.ReduceModel), DefaultReduceModel),
+		MapConcurrency:     withDefault(o.MapConcurrency, cleaner.DefaultMaxMapConcurrency),
+		Language:           withDefault(o.Language, prompts.DefaultLanguage),
+		Topic:              o.Topic,
+		Query:              strings.TrimSpace(o.Query),
+		CitationPolicy:     withDefault(o.CitationPolicy, CitationPolicyFlag),
+		CitationStyle:      withDefault(o.CitationStyle, CitationStyleSection),
+		RoutingRules:       make([]cleaner.RoutingRule, 0, len(o.Routing)),
+		Mode:               withDefault(o.Mode, ModeAuto),
+		ResponsePolicy:     o.ResponsePolicy,
+		TemplateVars:       o.Vars,
+	}
+
+	if err := cleaner.ValidateModelChain(opts.MapModel); err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("MapModel: %w", err)
+	}
+	if err := cleaner.ValidateModelChain(opts.ReduceModel); err != nil {
+		return pipeline.CmdOptions{}, fmt.Errorf("ReduceModel: %w", err)
+	}
+	if err := cleaner.ValidateMode(opts.Mode); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+	if err := cleaner.ValidateCitationPolicy(opts.CitationPolicy); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+	if err := cleaner.ValidateCitationStyle(opts.CitationStyle); err != nil {
+		return pipeline.CmdOptions{}, err
+	}
+	if len(o.ResponsePolicy) > 0 {
+		policy, err := cleaner.ParseResponsePolicy(strings.Join(o.ResponsePolicy, ","))
+		if err != nil {
+			return pipeline.CmdOptions{}, err
+		}
+		opts.ResponsePolicy = policy
+	}
+	for i, rule := range o.Routing {
+		internal := rule.internal()
+		if err := internal.Validate(); err != nil {
+			return pipeline.CmdOptions{}, fmt.Errorf("Routing[%d]: %w", i, err)
+		}
+		opts.RoutingRules = append(opts.RoutingRules, internal)
+	}
+	return opts, nil
+}
+
+// buildOptions は、Model・Scraper・Prompts を内部のビルダーの関数オプションに変換します。
+// overrides の nil でないフィールドは、Prompts より優先して使用します。
+func (o Options) buildOptions(overrides PromptBuilders) []builder.Option {
+	buildOpts := []builder.Option{builder.WithPrompts(overrides.overlay(o.Prompts.builders()))}
+	if o.Model != nil {
+		buildOpts = append(buildOpts, builder.WithModel(modelClient{model: o.Model}))
+	}
+	if o.Scraper != nil {
+		buildOpts = append(buildOpts, builder.WithScraper(scraperRunner{scraper: o.Scraper}))
+	}
+	return buildOpts
+}
+
+// withDefault は、v がゼロ値の場合に def を返します。
+func withDefault[T comparable](v, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+	return v
+}
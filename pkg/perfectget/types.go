@@ -0,0 +1,145 @@
+package perfectget
+
+import (
+	"github.com/shouni/action-perfect-get-on-go/internal/cleaner"
+	"github.com/shouni/action-perfect-get-on-go/internal/llm"
+	"github.com/shouni/action-perfect-get-on-go/internal/prompts"
+)
+
+// ----------------------------------------------------------------
+// 設定
+// ----------------------------------------------------------------
+
+// ルーティングルールの対象フェーズ (RoutingRule.Phase) です。
+const (
+	RoutePhaseMap    = "map"
+	RoutePhaseReduce = "reduce"
+)
+
+// RoutingRule は、セグメントや Reduce に使用するモデルを選択するルールです (CLI の --route と同じ意味です)。
+type RoutingRule struct {
+	// Name はルールの識別名です (Report に記録されます)。
+	Name string
+	// Phase は対象フェーズ (RoutePhaseMap, RoutePhaseReduce) です。空の場合は RoutePhaseMap です。
+	Phase string
+	// Domains は対象とするソースのドメインです (サブドメインを含めて一致)。空の場合はすべてのドメインに一致します。
+	Domains []string
+	// MinChars と MaxChars は、対象テキストの文字数の範囲です。0 の場合は制限しません。
+	MinChars int
+	MaxChars int
+	// Model は、一致した場合に使用するモデル名です。カンマ区切りでフォールバックのリストを指定できます。
+	Model string
+	// SkipMap が true の場合、一致したセグメントは Map を実行せず、本文をそのまま Reduce の入力に含めます。
+	SkipMap bool
+}
+
+func (r RoutingRule) internal() cleaner.RoutingRule {
+	return cleaner.RoutingRule{
+		Name:     r.Name,
+		Phase:    r.Phase,
+		Domains:  r.Domains,
+		MinChars: r.MinChars,
+		MaxChars: r.MaxChars,
+		Model:    r.Model,
+		SkipMap:  r.SkipMap,
+	}
+}
+
+// Prompts は、組み込みテンプレートの代わりに使用する Map・Reduce のプロンプトテンプレート (text/template の文字列) です。
+// 空のフィールドは組み込みテンプレートを使用します。テンプレートで使用できるフィールドは CLI の --map-prompt / --reduce-prompt と同じです。
+type Prompts struct {
+	Map    string
+	Reduce string
+}
+
+func (p Prompts) builders() cleaner.PromptBuilders {
+	var builders cleaner.PromptBuilders
+	if p.Map != "" {
+		builders.MapBuilder = prompts.NewMapPromptBuilderFromTemplate("Options.Prompts.Map", p.Map)
+	}
+	if p.Reduce != "" {
+		builders.ReduceBuilder = prompts.NewReducePromptBuilderFromTemplate("Options.Prompts.Reduce", p.Reduce)
+	}
+	return builders
+}
+
+// ----------------------------------------------------------------
+// 診断情報
+// ----------------------------------------------------------------
+
+// Report は、1回の Summarize の診断情報です。
+type Report struct {
+	// Mode は、実際に使用した処理モード (ModeMapReduce, ModeSingle) です。
+	Mode string
+	// ReduceModel は、Reduce (単一パスの場合は最終文書の生成) に使用したモデルです。
+	ReduceModel string
+	// Segments は、Map のセグメントごとの処理結果です (セグメント順)。
+	Segments []SegmentReport
+	// UnknownCitations は、最終文書に含まれていたソースに存在しない引用URLです (出現順)。
+	UnknownCitations []string
+	// StructureViolations は、修復後も最終文書に残った構造ルール違反です。
+	StructureViolations []string
+	// Usage は、Map・Reduce・構造修復のトークン消費量の合計です。
+	Usage Usage
+}
+
+// SegmentReport は、Map における1セグメント分の処理結果です。
+type SegmentReport struct {
+	Index int
+	URL   string
+	// Model は、Map に使用したモデルです (Map をスキップした場合は空)。
+	Model string
+	// Route は、適用されたルーティングルールの識別名です (既定のモデルを使用した場合は空)。
+	Route string
+	// DirectToReduce は、ルーティングにより Map をスキップし、本文をそのまま Reduce に渡したかどうかです。
+	DirectToReduce bool
+	// Skipped は、Reduce の入力から除外されたかどうかです。
+	Skipped bool
+	// Irrelevant は、クエリに関連する情報がないと判定され、Reduce の入力から除外されたかどうかです。
+	Irrelevant bool
+	// FinishReason は、応答がブロック・途中終了・空だった場合の終了理由です (正常な場合は空)。
+	FinishReason string
+	// Recovery は、応答ポリシーにより適用したアクションです (適用しなかった場合は空)。
+	Recovery string
+	// Usage は、このセグメントのトークン消費量です。
+	Usage Usage
+}
+
+// Usage は、トークン消費量です。
+type Usage struct {
+	PromptTokens int
+	OutputTokens int
+	TotalTokens  int
+}
+
+// newReport は、クリーンアップの診断情報から Report を作成します。
+func newReport(r cleaner.Report) Report {
+	report := Report{
+		Mode:                r.Mode,
+		ReduceModel:         r.Reduce.Model,
+		StructureViolations: r.Structure.Remaining,
+		Usage:               newUsage(r.TotalUsage()),
+	}
+	for _, seg := range r.Segments {
+		report.Segments = append(report.Segments, SegmentReport{
+			Index:          seg.Index,
+			URL:            seg.URL,
+			Model:          seg.Model,
+			Route:          seg.Route,
+			DirectToReduce: seg.DirectToReduce,
+			Skipped:        seg.Skipped,
+			Irrelevant:     seg.Irrelevant,
+			FinishReason:   seg.FinishReason,
+			Recovery:       seg.Recovery,
+			Usage:          newUsage(seg.Usage),
+		})
+	}
+	for _, c := range r.Citations.Unknown {
+		report.UnknownCitations = append(report.UnknownCitations, c.URL)
+	}
+	return report
+}
+
+func newUsage(u llm.Usage) Usage {
+	return Usage{PromptTokens: u.PromptTokens, OutputTokens: u.OutputTokens, TotalTokens: u.TotalTokens}
+}